@@ -19,22 +19,24 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
 	"github.com/shopspring/decimal"
-	"strconv"
+	"math/big"
 	"strings"
 )
 
 type ContractDecoder struct {
 	*openwallet.SmartContractDecoderBase
-	wm *WalletManager
+	wm        *WalletManager
+	txDecoder *TransactionDecoder
 }
 
 //NewContractDecoder 智能合约解析器
 func NewContractDecoder(wm *WalletManager) *ContractDecoder {
 	decoder := ContractDecoder{}
 	decoder.wm = wm
+	decoder.txDecoder = NewTransactionDecoder(wm)
 	return &decoder
 }
 
@@ -43,7 +45,13 @@ func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.Sma
 	var tokenBalanceList []*openwallet.TokenBalance
 
 	for i := 0; i < len(address); i++ {
-		balance, _ := decoder.wm.GetQRC20Balance(contract, address[i], decoder.wm.Config.isTestNet)
+		var balance decimal.Decimal
+		if isQRC721Contract(contract) {
+			//QRC721的余额为拥有的数量
+			balance, _ = decoder.wm.GetQRC721Balance(contract, address[i])
+		} else {
+			balance, _ = decoder.wm.GetQRC20Balance(contract, address[i])
+		}
 		//if err != nil {
 		//	log.Errorf("get address[%v] QRC20 token balance failed, err=%v", address[i], err)
 		//}
@@ -67,104 +75,123 @@ func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.Sma
 	return tokenBalanceList, nil
 }
 
-func AddressTo32bytesArg(address string, isTestNet bool) ([]byte, error) {
+//AddressTo32bytesArg 地址编码为32字节的合约参数
+func AddressTo32bytesArg(address string, params *ChainParams) ([]byte, error) {
 
-	var addressToHash160 []byte
-	if isTestNet {
-		addressToHash160, _ = addressEncoder.AddressDecode(address, addressEncoder.QTUM_testnetAddressP2PKH)
-	} else {
-		addressToHash160, _ = addressEncoder.AddressDecode(address, addressEncoder.QTUM_mainnetAddressP2PKH)
+	addr, err := qrc20AddressArg(address, params)
+	if err != nil {
+		return nil, err
 	}
 
-	//fmt.Printf("addressToHash160: %s\n",hex.EncodeToString(addressToHash160))
+	return abi.Encode([]abi.Type{abi.MustNewType("address")}, addr)
+}
+
+//qrc20AddressArg 地址解码为hash160，作为合约的address参数
+func qrc20AddressArg(address string, params *ChainParams) (abi.Address, error) {
 
-	to32bytesArg := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, addressToHash160[:]...)
-	//fmt.Printf("to32bytesArg: %s\n",hex.EncodeToString(to32bytesArg))
+	var addr abi.Address
+	addressToHash160, err := addressEncoder.AddressDecode(address, params.P2PKHAddressType())
+	if err != nil || len(addressToHash160) != len(addr) {
+		return addr, fmt.Errorf("invalid address: %s", address)
+	}
 
-	return to32bytesArg, nil
+	copy(addr[:], addressToHash160)
+	return addr, nil
 }
 
-// GetQRC20Balance 获取qrc20余额
-func (wm *WalletManager) GetQRC20Balance(token openwallet.SmartContract, address string, isTestNet bool) (decimal.Decimal, error) {
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getAddressTokenBalanceByExplorer(token, address)
-	} else {
-		return wm.GetQRC20UnspentByAddress(token.Address, address, token.Decimals, isTestNet)
+//tokenAmountToBigInt 代币数量按精度转为最小单位的整数
+func tokenAmountToBigInt(amount decimal.Decimal, tokenDecimal uint64) (*big.Int, error) {
+	if amount.IsNegative() {
+		return nil, fmt.Errorf("invalid token amount: %s", amount.String())
+	}
+	value, ok := new(big.Int).SetString(amount.Shift(int32(tokenDecimal)).Truncate(0).String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid token amount: %s", amount.String())
+	}
+	return value, nil
+}
+
+//qrc20TransferData transfer(address,uint256)的调用数据
+func qrc20TransferData(to string, amount decimal.Decimal, tokenDecimal uint64, params *ChainParams) (string, error) {
+
+	addr, err := qrc20AddressArg(to, params)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := tokenAmountToBigInt(amount, tokenDecimal)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := abi.QRC20Transfer.Pack(addr, value)
+	if err != nil {
+		return "", err
 	}
+
+	return hex.EncodeToString(data), nil
 }
 
-func (wm *WalletManager) GetQRC20UnspentByAddress(contractAddress, address string, tokenDecimal uint64, isTestNet bool) (decimal.Decimal, error) {
+// GetQRC20Balance 获取qrc20余额
+func (wm *WalletManager) GetQRC20Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return wm.chain().GetQRC20Balance(token, address)
+}
+
+func (wm *WalletManager) GetQRC20UnspentByAddress(contractAddress, address string, tokenDecimal uint64) (decimal.Decimal, error) {
 
 	trimContractAddr := strings.TrimPrefix(contractAddress, "0x")
 
-	to32bytesArg, err := AddressTo32bytesArg(address, isTestNet)
+	addr, err := qrc20AddressArg(address, wm.Config.Network)
 	if err != nil {
 		return decimal.New(0, 0), err
 	}
 
-	combineString := hex.EncodeToString(append([]byte{0x70, 0xa0, 0x82, 0x31}, to32bytesArg[:]...))
-	//fmt.Printf("combineString: %s\n",combineString)
-
-	request := []interface{}{
-		trimContractAddr,
-		combineString,
+	data, err := abi.QRC20BalanceOf.Pack(addr)
+	if err != nil {
+		return decimal.New(0, 0), err
 	}
+	combineString := hex.EncodeToString(data)
 
-	result, err := wm.WalletClient.Call("callcontract", request)
+	QRC20Utox, err := wm.CallContract(trimContractAddr, combineString, "")
 	if err != nil {
 		return decimal.New(0, 0), err
 	}
 
-	//fmt.Printf("Callcontract result: %s\n", result.String())
+	output, err := hex.DecodeString(QRC20Utox.Output)
+	if err != nil {
+		return decimal.New(0, 0), fmt.Errorf("callcontract returns invalid output: %s", QRC20Utox.Output)
+	}
 
-	QRC20Utox := NewQRC20Unspent(result)
+	values, err := abi.QRC20BalanceOf.UnpackOutput(output)
+	if err != nil {
+		return decimal.New(0, 0), err
+	}
 
-	sotashiUnspent, _ := strconv.ParseInt(QRC20Utox.Output, 16, 64)
-	sotashiUnspentDecimal, _ := decimal.NewFromString(common.NewString(sotashiUnspent).String())
-	unspent := sotashiUnspentDecimal.Div(decimal.New(1, int32(tokenDecimal)))
+	unspent := decimal.NewFromBigInt(values[0].(*big.Int), -int32(tokenDecimal))
 
 	return unspent, nil
 }
 
+//AmountTo32bytesArg 数量编码为32字节的合约参数，返回十六进制字符串
 func AmountTo32bytesArg(amount int64) (string, error) {
 
-	hexAmount := strconv.FormatInt(amount, 16)
-
-	defaultLen := 64
-	addLen := defaultLen - len(hexAmount)
-	var bytesArg string
-
-	for i := 0; i < addLen; i++ {
-		bytesArg = bytesArg + "0"
+	data, err := abi.Encode([]abi.Type{abi.MustNewType("uint256")}, amount)
+	if err != nil {
+		return "", err
 	}
 
-	bytesArg = bytesArg + hexAmount
-
-	return bytesArg, nil
+	return hex.EncodeToString(data), nil
 }
 
-func (wm *WalletManager) QRC20Transfer(contractAddress string, from string, to string, gasPrice string, amount decimal.Decimal, gasLimit int64, tokenDecimal uint64, isTestNet bool) (string, error) {
+func (wm *WalletManager) QRC20Transfer(contractAddress string, from string, to string, gasPrice string, amount decimal.Decimal, gasLimit int64, tokenDecimal uint64) (string, error) {
 
 	trimContractAddr := strings.TrimPrefix(contractAddress, "0x")
 
-	amountDecimal := amount.Mul(decimal.New(1, int32(tokenDecimal)))
-	sotashiAmount := amountDecimal.IntPart()
-
-	amountToArg, err := AmountTo32bytesArg(sotashiAmount)
+	dataHex, err := qrc20TransferData(to, amount, tokenDecimal, wm.Config.Network)
 	if err != nil {
 		return "", err
 	}
 
-	addressToArg, err := AddressTo32bytesArg(to, isTestNet)
-	if err != nil {
-		return "", err
-	}
-
-	combineString := hex.EncodeToString(append([]byte{0xa9, 0x05, 0x9c, 0xbb}, addressToArg[:]...))
-
-	dataHex := combineString + amountToArg
-	fmt.Printf("dataHex: %s\n", dataHex)
-
 	request := []interface{}{
 		trimContractAddr,
 		dataHex,
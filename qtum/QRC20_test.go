@@ -22,14 +22,10 @@ import (
 	"testing"
 )
 
-var (
-	isTestNet = true
-)
-
 func Test_addressTo32bytesArg(t *testing.T) {
 	address := "qdphfFinfJutJFvtnr2UaCwNAMxC3HbVxa"
 
-	to32bytesArg, err := AddressTo32bytesArg(address, isTestNet)
+	to32bytesArg, err := AddressTo32bytesArg(address, &TestNetParams)
 	if err != nil {
 		t.Errorf("To32bytesArg failed unexpected error: %v\n", err)
 	} else {
@@ -49,7 +45,7 @@ func Test_getUnspentByAddress(t *testing.T) {
 	//address := "qJ2HTPYoMF1DPBhgURjRqemun5WimD57Hy"
 	//var tokenDecimal uint64 = 4
 
-	unspent, err := tw.GetQRC20UnspentByAddress(contractAddress, address, tokenDecimal, isTestNet)
+	unspent, err := tw.GetQRC20UnspentByAddress(contractAddress, address, tokenDecimal)
 	if err != nil {
 		t.Errorf("GetUnspentByAddress failed unexpected error: %v\n", err)
 	}
@@ -92,7 +88,7 @@ func Test_QRC20Transfer(t *testing.T) {
 	var gasLimit int64 = 250000
 	var amount decimal.Decimal = decimal.NewFromFloat(100)
 
-	result, err := tw.QRC20Transfer(contractAddress, from, to, gasPrice, amount, gasLimit, tokenDecimal, isTestNet)
+	result, err := tw.QRC20Transfer(contractAddress, from, to, gasPrice, amount, gasLimit, tokenDecimal)
 	if err != nil {
 		t.Errorf("QRC20Transfer failed unexpected error: %v\n", err)
 	} else {
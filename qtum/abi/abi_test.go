@@ -0,0 +1,327 @@
+package abi
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func Test_method_id(t *testing.T) {
+	cases := map[string]string{
+		"transfer(address,uint256)":                 "a9059cbb",
+		"balanceOf(address)":                        "70a08231",
+		"approve(address,uint)":                     "095ea7b3",
+		"transferFrom(address, address, uint256)":   "23b872dd",
+		"safeTransferFrom(address,address,uint256)": "42842e0e",
+		"f(uint256,uint32[],bytes10,bytes)":         "8be65246",
+	}
+	for sig, want := range cases {
+		m, err := NewMethod(sig)
+		if err != nil {
+			t.Errorf("NewMethod(%s) failed: %v", sig, err)
+			continue
+		}
+		if got := hex.EncodeToString(m.ID()); got != want {
+			t.Errorf("%s selector = %s, want %s", m.Sig(), got, want)
+		}
+	}
+
+	topic, err := EventTopic("Transfer(address,address,uint256)")
+	if err != nil {
+		t.Fatalf("EventTopic failed: %v", err)
+	}
+	if got := hex.EncodeToString(topic); got != "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Errorf("Transfer topic = %s", got)
+	}
+}
+
+func Test_pack_transfer(t *testing.T) {
+	m := MustNewMethod("transfer(address,uint256)", "bool")
+
+	//18位精度的代币金额超出int64
+	amount, _ := new(big.Int).SetString("123456789000000000000000000", 10)
+	data, err := m.Pack("0xe2d88f8e2f8e1c8f6b4f1a3b2c4d5e6f70819203", amount)
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	want := "a9059cbb" +
+		"000000000000000000000000e2d88f8e2f8e1c8f6b4f1a3b2c4d5e6f70819203" +
+		"000000000000000000000000000000000000000000661efdf12d1653cf340000"
+	if got := hex.EncodeToString(data); got != want {
+		t.Fatalf("Pack = %s, want %s", got, want)
+	}
+
+	args, err := m.UnpackInput(data)
+	if err != nil {
+		t.Fatalf("UnpackInput failed: %v", err)
+	}
+	if args[0].(Address).Hex() != "e2d88f8e2f8e1c8f6b4f1a3b2c4d5e6f70819203" || args[1].(*big.Int).Cmp(amount) != 0 {
+		t.Errorf("UnpackInput = %v", args)
+	}
+
+	if _, err := m.UnpackInput(append([]byte{0x70, 0xa0, 0x82, 0x31}, data[4:]...)); err == nil {
+		t.Errorf("UnpackInput should reject other selectors")
+	}
+
+	out, err := m.UnpackOutput(leftPad([]byte{1}))
+	if err != nil || out[0].(bool) != true {
+		t.Errorf("UnpackOutput = %v, %v", out, err)
+	}
+}
+
+func Test_pack_dynamic(t *testing.T) {
+	//solidity文档的例子 f(uint,uint32[],bytes10,bytes)
+	m := MustNewMethod("f(uint,uint32[],bytes10,bytes)")
+	data, err := m.Pack(big.NewInt(0x123), []uint32{0x456, 0x789}, []byte("1234567890"), []byte("Hello, world!"))
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	want := "8be65246" +
+		"0000000000000000000000000000000000000000000000000000000000000123" +
+		"0000000000000000000000000000000000000000000000000000000000000080" +
+		"3132333435363738393000000000000000000000000000000000000000000000" +
+		"00000000000000000000000000000000000000000000000000000000000000e0" +
+		"0000000000000000000000000000000000000000000000000000000000000002" +
+		"0000000000000000000000000000000000000000000000000000000000000456" +
+		"0000000000000000000000000000000000000000000000000000000000000789" +
+		"000000000000000000000000000000000000000000000000000000000000000d" +
+		"48656c6c6f2c20776f726c642100000000000000000000000000000000000000"
+	if got := hex.EncodeToString(data); got != want {
+		t.Fatalf("Pack = %s, want %s", got, want)
+	}
+
+	args, err := m.UnpackInput(data)
+	if err != nil {
+		t.Fatalf("UnpackInput failed: %v", err)
+	}
+	list := args[1].([]interface{})
+	if len(list) != 2 || list[1].(*big.Int).Int64() != 0x789 || string(args[3].([]byte)) != "Hello, world!" {
+		t.Errorf("UnpackInput = %v", args)
+	}
+}
+
+func Test_pack_tuple(t *testing.T) {
+	types, err := NewTypes("(string,bool)[2]", "int8", "string")
+	if err != nil {
+		t.Fatalf("NewTypes failed: %v", err)
+	}
+	if types[0].String() != "(string,bool)[2]" {
+		t.Errorf("type string = %s", types[0].String())
+	}
+
+	tuples := [][]interface{}{{"a", true}, {"bc", false}}
+	data, err := Encode(types, tuples, -2, "qtum")
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	values, err := Decode(types, data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	second := values[0].([]interface{})[1].([]interface{})
+	if second[0].(string) != "bc" || second[1].(bool) != false {
+		t.Errorf("tuple = %v", second)
+	}
+	if values[1].(*big.Int).Int64() != -2 || values[2].(string) != "qtum" {
+		t.Errorf("values = %v", values)
+	}
+}
+
+func Test_pack_errors(t *testing.T) {
+	cases := []struct {
+		typ   string
+		value interface{}
+	}{
+		{"uint8", 256},
+		{"uint256", -1},
+		{"int8", 128},
+		{"address", "0x1234"},
+		{"bytes2", []byte{1, 2, 3}},
+		{"uint256[2]", []int{1}},
+		{"bool", 1},
+	}
+	for _, c := range cases {
+		if _, err := Encode([]Type{MustNewType(c.typ)}, c.value); err == nil {
+			t.Errorf("Encode(%s, %v) should fail", c.typ, c.value)
+		}
+	}
+
+	for _, s := range []string{"uint7", "uint264", "bytes33", "foo", "(uint256", "uint256[x]"} {
+		if _, err := NewType(s); err == nil {
+			t.Errorf("NewType(%s) should fail", s)
+		}
+	}
+
+	//截断和越界的数据
+	if _, err := Decode(mustNewTypes("uint256"), make([]byte, 31)); err == nil {
+		t.Errorf("Decode should reject short data")
+	}
+	bad, _ := hex.DecodeString("00000000000000000000000000000000000000000000000000000000000000ff")
+	if _, err := Decode(mustNewTypes("string"), bad); err == nil {
+		t.Errorf("Decode should reject out of range offset")
+	}
+}
+
+func mustNewTypes(types ...string) []Type {
+	ret, err := NewTypes(types...)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+func Test_qrc20_methods(t *testing.T) {
+	if hex.EncodeToString(QRC20Transfer.ID()) != "a9059cbb" || hex.EncodeToString(QRC20BalanceOf.ID()) != "70a08231" {
+		t.Errorf("unexpected QRC20 selectors")
+	}
+	if hex.EncodeToString(QRC20TransferEvent) != "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
+		t.Errorf("unexpected Transfer event topic")
+	}
+}
+
+func Test_qrc721_transfer_log(t *testing.T) {
+	if hex.EncodeToString(QRC721OwnerOf.ID()) != "6352211e" || hex.EncodeToString(QRC721SafeTransferFrom.ID()) != "42842e0e" {
+		t.Errorf("unexpected QRC721 selectors")
+	}
+
+	word := func(s string) []byte {
+		b, _ := hex.DecodeString(s)
+		return leftPad(b)
+	}
+	from := word("de48338aedfdbdf18b1425af208d6fde637821e1")
+	to := word("9ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5")
+
+	//QRC20: 数量在data中
+	log, err := DecodeTransferLog([][]byte{QRC20TransferEvent, from, to}, word("0de0b6b3a7640000"))
+	if err != nil {
+		t.Fatalf("DecodeTransferLog failed: %v", err)
+	}
+	if log.IsQRC721 || log.Value.String() != "1000000000000000000" || log.From.Hex() != "de48338aedfdbdf18b1425af208d6fde637821e1" {
+		t.Errorf("unexpected QRC20 log: %+v", log)
+	}
+
+	//QRC721: tokenId为第4个topic
+	log, err = DecodeTransferLog([][]byte{QRC721TransferEvent, from, to, word("2a")}, nil)
+	if err != nil {
+		t.Fatalf("DecodeTransferLog failed: %v", err)
+	}
+	if !log.IsQRC721 || log.Value.Int64() != 42 || log.To.Hex() != "9ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5" {
+		t.Errorf("unexpected QRC721 log: %+v", log)
+	}
+
+	invalid := [][][]byte{
+		{QRC20TransferEvent, from},
+		{word("01"), from, to},
+		{QRC20TransferEvent, from, to, word("01"), word("02")},
+		{QRC20TransferEvent, from[:20], to, word("01")},
+	}
+	for i, topics := range invalid {
+		if _, err := DecodeTransferLog(topics, nil); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+const testJSONABI = `[
+	{"type":"function","name":"transfer","inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"constructor","inputs":[{"name":"name","type":"string"},{"name":"supply","type":"uint256"}]},
+	{"type":"function","name":"deposit","inputs":[],"outputs":[]},
+	{"type":"function","name":"submit","inputs":[{"name":"orders","type":"tuple[]","components":[{"name":"to","type":"address"},{"name":"amounts","type":"uint256[]"}]}],"outputs":[]},
+	{"type":"function","name":"mint","inputs":[{"name":"to","type":"address"}],"outputs":[]},
+	{"type":"function","name":"mint","inputs":[{"name":"to","type":"address"},{"name":"id","type":"uint256"}],"outputs":[]},
+	{"type":"event","name":"Transfer","anonymous":false,"inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]}
+]`
+
+func Test_json_abi(t *testing.T) {
+	contract, err := JSON(testJSONABI)
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+
+	m, err := contract.MethodByName("submit")
+	if err != nil || m.Sig() != "submit((address,uint256[])[])" {
+		t.Fatalf("MethodByName(submit) = %v, %v", m, err)
+	}
+
+	if _, err := contract.MethodByName("mint"); err == nil {
+		t.Errorf("overloaded method should require the signature")
+	}
+	if m, err := contract.MethodByName("mint(address,uint256)"); err != nil || len(m.Inputs) != 2 {
+		t.Errorf("MethodByName(mint(address,uint256)) = %v, %v", m, err)
+	}
+
+	if contract.Constructor == nil || typeListString(contract.Constructor.Inputs) != "string,uint256" {
+		t.Errorf("unexpected constructor: %v", contract.Constructor)
+	}
+
+	e, err := contract.EventByID(QRC20TransferEvent)
+	if err != nil || e.Name != "Transfer" || !e.Indexed[0] || e.Indexed[2] {
+		t.Errorf("EventByID = %v, %v", e, err)
+	}
+
+	transfer, _ := contract.MethodByName("transfer")
+	args, err := ParseArgs(transfer.Inputs, []string{"0xe2d88f8e2f8e1c8f6b4f1a3b2c4d5e6f70819203", "1000000000000000000000"}, nil)
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if _, err := transfer.Pack(args...); err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	submit := m
+	args, err = ParseArgs(submit.Inputs, []string{`[["0xe2d88f8e2f8e1c8f6b4f1a3b2c4d5e6f70819203", [1, "2"]]]`}, nil)
+	if err != nil {
+		t.Fatalf("ParseArgs tuple failed: %v", err)
+	}
+	data, err := submit.Pack(args...)
+	if err != nil {
+		t.Fatalf("Pack tuple failed: %v", err)
+	}
+	decoded, err := submit.UnpackInput(data)
+	if err != nil {
+		t.Fatalf("UnpackInput failed: %v", err)
+	}
+
+	formatted := FormatValues(submit.Inputs, decoded, nil)
+	order := formatted[0].([]interface{})[0].([]interface{})
+	if order[0] != "e2d88f8e2f8e1c8f6b4f1a3b2c4d5e6f70819203" || order[1].([]interface{})[1] != "2" {
+		t.Errorf("FormatValues = %v", formatted)
+	}
+}
+
+func Test_event_unpack_log(t *testing.T) {
+	contract, err := JSON(`[{"type":"event","name":"Memo","anonymous":false,"inputs":[{"name":"sender","type":"address","indexed":true},{"name":"tag","type":"string","indexed":true},{"name":"id","type":"uint256","indexed":false},{"name":"text","type":"string","indexed":false}]}]`)
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+	e := contract.Events["Memo(address,string,uint256,string)"]
+	if e == nil || e.Names[3] != "text" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+
+	sender, _ := HexToAddress("de48338aedfdbdf18b1425af208d6fde637821e1")
+	tagHash := Keccak256([]byte("note"))
+	data, err := Encode([]Type{MustNewType("uint256"), MustNewType("string")}, big.NewInt(7), "hello")
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	values, err := e.UnpackLog([][]byte{e.ID(), leftPad(sender[:]), tagHash}, data)
+	if err != nil {
+		t.Fatalf("UnpackLog failed: %v", err)
+	}
+	formatted := FormatValues(e.IndexedTypes(), values, nil)
+	if formatted[0] != sender.Hex() || formatted[1] != "0x"+hex.EncodeToString(tagHash) || formatted[2] != "7" || formatted[3] != "hello" {
+		t.Errorf("UnpackLog = %v", formatted)
+	}
+
+	if _, err := e.UnpackLog([][]byte{QRC20TransferEvent, leftPad(sender[:]), tagHash}, data); err == nil {
+		t.Errorf("expected error for another event")
+	}
+	if _, err := e.UnpackLog([][]byte{e.ID(), leftPad(sender[:])}, data); err == nil {
+		t.Errorf("expected error for missing topic")
+	}
+}
@@ -0,0 +1,360 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+//ABI 合约的JSON ABI
+type ABI struct {
+	//Constructor 构造函数，部署合约时参数编码在字节码之后
+	Constructor *Method
+	//Methods 以规范签名为key
+	Methods map[string]*Method
+	//Events 以规范签名为key
+	Events map[string]*Event
+}
+
+//Event 合约事件
+type Event struct {
+	Name      string
+	Inputs    []Type
+	Indexed   []bool
+	Names     []string
+	Anonymous bool
+}
+
+//Sig 规范的事件签名
+func (e *Event) Sig() string {
+	return e.Name + "(" + typeListString(e.Inputs) + ")"
+}
+
+//ID 事件的topic
+func (e *Event) ID() []byte {
+	return Keccak256([]byte(e.Sig()))
+}
+
+//UnpackLog 解码事件日志，indexed参数在topics中，其余参数在data中
+//indexed的动态类型(string、bytes、数组和元组)在topic中只有哈希，解码为32字节的[]byte
+func (e *Event) UnpackLog(topics [][]byte, data []byte) ([]interface{}, error) {
+
+	if !e.Anonymous {
+		if len(topics) == 0 || !bytes.Equal(topics[0], e.ID()) {
+			return nil, fmt.Errorf("abi: log is not event %s", e.Name)
+		}
+		topics = topics[1:]
+	}
+
+	nonIndexed := make([]Type, 0, len(e.Inputs))
+	for i, t := range e.Inputs {
+		if !e.Indexed[i] {
+			nonIndexed = append(nonIndexed, t)
+		}
+	}
+	dataValues, err := Decode(nonIndexed, data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(e.Inputs))
+	for i, t := range e.Inputs {
+		if !e.Indexed[i] {
+			values = append(values, dataValues[0])
+			dataValues = dataValues[1:]
+			continue
+		}
+		if len(topics) == 0 {
+			return nil, fmt.Errorf("abi: missing topic for %s", t.String())
+		}
+		topic := topics[0]
+		topics = topics[1:]
+		if len(topic) != wordSize {
+			return nil, fmt.Errorf("abi: invalid topic length %d", len(topic))
+		}
+		if isHashedTopic(t) {
+			values = append(values, topic)
+			continue
+		}
+		v, err := decodeValue(t, topic)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+//IndexedTypes indexed的动态类型在topic中为哈希，按bytes32格式化
+func (e *Event) IndexedTypes() []Type {
+	types := make([]Type, 0, len(e.Inputs))
+	for i, t := range e.Inputs {
+		if e.Indexed[i] && isHashedTopic(t) {
+			t = MustNewType("bytes32")
+		}
+		types = append(types, t)
+	}
+	return types
+}
+
+//isHashedTopic 引用类型作为indexed参数时topic为其编码的哈希
+func isHashedTopic(t Type) bool {
+	return t.isDynamic() || t.Kind == ArrayTy || t.Kind == TupleTy
+}
+
+type jsonArgument struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Indexed    bool           `json:"indexed"`
+	Components []jsonArgument `json:"components"`
+}
+
+type jsonField struct {
+	Type      string         `json:"type"`
+	Name      string         `json:"name"`
+	Inputs    []jsonArgument `json:"inputs"`
+	Outputs   []jsonArgument `json:"outputs"`
+	Anonymous bool           `json:"anonymous"`
+}
+
+//JSON 解析solidity编译器输出的JSON ABI
+func JSON(data string) (*ABI, error) {
+	var fields []jsonField
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, fmt.Errorf("abi: invalid json: %v", err)
+	}
+
+	ret := &ABI{
+		Methods: make(map[string]*Method),
+		Events:  make(map[string]*Event),
+	}
+	for _, field := range fields {
+		switch field.Type {
+		case "function", "":
+			inputs, err := jsonTypes(field.Inputs)
+			if err != nil {
+				return nil, err
+			}
+			outputs, err := jsonTypes(field.Outputs)
+			if err != nil {
+				return nil, err
+			}
+			m := &Method{Name: field.Name, Inputs: inputs, Outputs: outputs}
+			ret.Methods[m.Sig()] = m
+		case "constructor":
+			inputs, err := jsonTypes(field.Inputs)
+			if err != nil {
+				return nil, err
+			}
+			ret.Constructor = &Method{Inputs: inputs}
+		case "event":
+			inputs, err := jsonTypes(field.Inputs)
+			if err != nil {
+				return nil, err
+			}
+			e := &Event{Name: field.Name, Inputs: inputs, Anonymous: field.Anonymous}
+			for _, arg := range field.Inputs {
+				e.Indexed = append(e.Indexed, arg.Indexed)
+				e.Names = append(e.Names, arg.Name)
+			}
+			ret.Events[e.Sig()] = e
+		}
+	}
+	return ret, nil
+}
+
+//MethodByName 按方法名或签名查找，重载的方法必须使用签名
+func (a *ABI) MethodByName(name string) (*Method, error) {
+	if m, ok := a.Methods[name]; ok {
+		return m, nil
+	}
+	if strings.Contains(name, "(") {
+		m, err := NewMethod(name)
+		if err != nil {
+			return nil, err
+		}
+		if found, ok := a.Methods[m.Sig()]; ok {
+			return found, nil
+		}
+		return nil, fmt.Errorf("abi: method %s not found", name)
+	}
+
+	var found *Method
+	for _, m := range a.Methods {
+		if m.Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("abi: method %s is overloaded, use the signature instead", name)
+		}
+		found = m
+	}
+	if found == nil {
+		return nil, fmt.Errorf("abi: method %s not found", name)
+	}
+	return found, nil
+}
+
+//EventByID 按topic查找事件
+func (a *ABI) EventByID(topic []byte) (*Event, error) {
+	for _, e := range a.Events {
+		if bytes.Equal(e.ID(), topic) {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("abi: event %x not found", topic)
+}
+
+func jsonTypes(args []jsonArgument) ([]Type, error) {
+	types := make([]Type, 0, len(args))
+	for _, arg := range args {
+		t, err := NewType(jsonTypeString(arg))
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+//jsonTypeString 元组在JSON ABI中为tuple加components
+func jsonTypeString(arg jsonArgument) string {
+	if !strings.HasPrefix(arg.Type, "tuple") {
+		return arg.Type
+	}
+	components := make([]string, 0, len(arg.Components))
+	for _, c := range arg.Components {
+		components = append(components, jsonTypeString(c))
+	}
+	return "(" + strings.Join(components, ",") + ")" + strings.TrimPrefix(arg.Type, "tuple")
+}
+
+//ParseArgs 字符串参数转换为编码需要的值
+//数组和元组参数为JSON数组，bytes为0x开头的十六进制，parseAddress为空时地址按十六进制解析
+func ParseArgs(types []Type, args []string, parseAddress func(string) (Address, error)) ([]interface{}, error) {
+	if len(types) != len(args) {
+		return nil, fmt.Errorf("abi: argument count mismatch, expected %d, got %d", len(types), len(args))
+	}
+	if parseAddress == nil {
+		parseAddress = HexToAddress
+	}
+
+	values := make([]interface{}, 0, len(args))
+	for i, t := range types {
+		v, err := parseArg(t, args[i], parseAddress)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func parseArg(t Type, s string, parseAddress func(string) (Address, error)) (interface{}, error) {
+	switch t.Kind {
+	case UintTy, IntTy:
+		return ToBigInt(strings.TrimSpace(s))
+	case AddressTy:
+		return parseAddress(strings.TrimSpace(s))
+	case BoolTy:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("abi: invalid bool: %s", s)
+		}
+		return b, nil
+	case FixedBytesTy, BytesTy:
+		return toBytes(strings.TrimSpace(s))
+	case StringTy:
+		return s, nil
+	case SliceTy, ArrayTy, TupleTy:
+		var elems []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &elems); err != nil {
+			return nil, fmt.Errorf("abi: %s argument must be a json array: %s", t.String(), s)
+		}
+
+		types := t.Components
+		if t.Kind != TupleTy {
+			types = make([]Type, len(elems))
+			for i := range types {
+				types[i] = *t.Elem
+			}
+		}
+
+		args := make([]string, 0, len(elems))
+		for _, elem := range elems {
+			//字符串元素去掉引号，数字、布尔和嵌套数组保留原文
+			var str string
+			if err := json.Unmarshal(elem, &str); err == nil {
+				args = append(args, str)
+			} else {
+				args = append(args, string(elem))
+			}
+		}
+		return ParseArgs(types, args, parseAddress)
+	}
+	return nil, fmt.Errorf("abi: unsupported type: %d", t.Kind)
+}
+
+//FormatValues 解码结果转换为可以JSON序列化的值
+//整数为十进制字符串，bytes为0x开头的十六进制，formatAddress为空时地址为十六进制
+func FormatValues(types []Type, values []interface{}, formatAddress func(Address) string) []interface{} {
+	if formatAddress == nil {
+		formatAddress = func(a Address) string { return a.Hex() }
+	}
+
+	ret := make([]interface{}, 0, len(values))
+	for i, v := range values {
+		ret = append(ret, formatValue(types[i], v, formatAddress))
+	}
+	return ret
+}
+
+func formatValue(t Type, v interface{}, formatAddress func(Address) string) interface{} {
+	switch value := v.(type) {
+	case *big.Int:
+		return value.String()
+	case Address:
+		return formatAddress(value)
+	case []byte:
+		return "0x" + hex.EncodeToString(value)
+	case []interface{}:
+		types := t.Components
+		if t.Kind != TupleTy {
+			types = make([]Type, len(value))
+			for i := range types {
+				types[i] = *t.Elem
+			}
+		}
+		return FormatValues(types, value, formatAddress)
+	}
+	return v
+}
+
+//MethodByID 按函数选择器查找
+func (a *ABI) MethodByID(id []byte) (*Method, error) {
+	for _, m := range a.Methods {
+		if bytes.Equal(m.ID(), id) {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("abi: method %x not found", id)
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//Method 合约方法
+type Method struct {
+	Name    string
+	Inputs  []Type
+	Outputs []Type
+}
+
+//NewMethod 由方法签名创建，如transfer(address,uint256)，outputs为返回值类型
+func NewMethod(signature string, outputs ...string) (*Method, error) {
+	name, inputs, err := parseSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	outs, err := NewTypes(outputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Method{Name: name, Inputs: inputs, Outputs: outs}, nil
+}
+
+//MustNewMethod 由方法签名创建，失败时panic
+func MustNewMethod(signature string, outputs ...string) *Method {
+	m, err := NewMethod(signature, outputs...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+//Sig 规范的方法签名
+func (m *Method) Sig() string {
+	return m.Name + "(" + typeListString(m.Inputs) + ")"
+}
+
+//ID 函数选择器，签名keccak256哈希的前4字节
+func (m *Method) ID() []byte {
+	return Keccak256([]byte(m.Sig()))[:4]
+}
+
+//Pack 编码调用数据，函数选择器+参数
+func (m *Method) Pack(args ...interface{}) ([]byte, error) {
+	if len(args) != len(m.Inputs) {
+		return nil, fmt.Errorf("abi: %s expects %d arguments, got %d", m.Sig(), len(m.Inputs), len(args))
+	}
+	enc, err := encodeTuple(m.Inputs, args)
+	if err != nil {
+		return nil, err
+	}
+	return append(m.ID(), enc...), nil
+}
+
+//UnpackInput 解码调用数据的参数
+func (m *Method) UnpackInput(data []byte) ([]interface{}, error) {
+	if len(data) < 4 || !bytes.Equal(data[:4], m.ID()) {
+		return nil, fmt.Errorf("abi: calldata is not a call of %s", m.Sig())
+	}
+	return Decode(m.Inputs, data[4:])
+}
+
+//UnpackOutput 解码返回值
+func (m *Method) UnpackOutput(data []byte) ([]interface{}, error) {
+	return Decode(m.Outputs, data)
+}
+
+//EventTopic 事件签名的keccak256哈希，如Transfer(address,address,uint256)
+func EventTopic(signature string) ([]byte, error) {
+	name, inputs, err := parseSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	return Keccak256([]byte(name + "(" + typeListString(inputs) + ")")), nil
+}
+
+//Keccak256 以太坊使用的keccak256哈希
+func Keccak256(data []byte) []byte {
+	return owcrypt.Hash(data, 0, owcrypt.HASH_ALG_KECCAK256)
+}
+
+//parseSignature 解析name(type1,type2)
+func parseSignature(signature string) (string, []Type, error) {
+	signature = strings.TrimSpace(signature)
+	i := strings.Index(signature, "(")
+	if i <= 0 || !strings.HasSuffix(signature, ")") {
+		return "", nil, fmt.Errorf("abi: invalid signature: %s", signature)
+	}
+	inputs, err := parseTypeList(signature[i+1 : len(signature)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(signature[:i]), inputs, nil
+}
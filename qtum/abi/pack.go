@@ -0,0 +1,311 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+var (
+	bigOne    = big.NewInt(1)
+	twoTo256  = new(big.Int).Lsh(bigOne, 256)
+	maxUint64 = new(big.Int).SetUint64(^uint64(0))
+)
+
+//Address 20字节的合约地址或账户地址(hash160)
+type Address [20]byte
+
+//HexToAddress 十六进制字符串转地址，可带0x前缀
+func HexToAddress(s string) (Address, error) {
+	var addr Address
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) != len(addr) {
+		return addr, fmt.Errorf("abi: invalid address: %s", s)
+	}
+	copy(addr[:], b)
+	return addr, nil
+}
+
+//Hex 不带0x前缀的十六进制地址
+func (a Address) Hex() string {
+	return hex.EncodeToString(a[:])
+}
+
+//Encode 按类型列表编码参数
+func Encode(types []Type, values ...interface{}) ([]byte, error) {
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("abi: argument count mismatch, expected %d, got %d", len(types), len(values))
+	}
+	return encodeTuple(types, values)
+}
+
+//encodeTuple 静态成员直接放在头部，动态成员在头部记录偏移量，数据追加在尾部
+func encodeTuple(types []Type, values []interface{}) ([]byte, error) {
+	headSize := 0
+	for _, t := range types {
+		headSize += t.headSize()
+	}
+
+	head := make([]byte, 0, headSize)
+	tail := make([]byte, 0)
+	for i, t := range types {
+		enc, err := encodeValue(t, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if t.isDynamic() {
+			head = append(head, encodeUint(big.NewInt(int64(headSize+len(tail))))...)
+			tail = append(tail, enc...)
+		} else {
+			head = append(head, enc...)
+		}
+	}
+	return append(head, tail...), nil
+}
+
+func encodeValue(t Type, v interface{}) ([]byte, error) {
+	switch t.Kind {
+	case UintTy, IntTy:
+		n, err := ToBigInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return encodeInteger(t, n)
+	case AddressTy:
+		addr, err := toAddress(v)
+		if err != nil {
+			return nil, err
+		}
+		return leftPad(addr[:]), nil
+	case BoolTy:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("abi: cannot use %T as bool", v)
+		}
+		if b {
+			return encodeUint(bigOne), nil
+		}
+		return encodeUint(new(big.Int)), nil
+	case FixedBytesTy:
+		b, err := toBytes(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > t.Size {
+			return nil, fmt.Errorf("abi: %s overflows with %d bytes", t.String(), len(b))
+		}
+		return rightPad(b), nil
+	case BytesTy:
+		b, err := toBytes(v)
+		if err != nil {
+			return nil, err
+		}
+		return encodeDynamicBytes(b), nil
+	case StringTy:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("abi: cannot use %T as string", v)
+		}
+		return encodeDynamicBytes([]byte(s)), nil
+	case SliceTy, ArrayTy:
+		elems, err := toList(v)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == ArrayTy && len(elems) != t.Size {
+			return nil, fmt.Errorf("abi: %s expects %d elements, got %d", t.String(), t.Size, len(elems))
+		}
+		types := make([]Type, len(elems))
+		for i := range types {
+			types[i] = *t.Elem
+		}
+		enc, err := encodeTuple(types, elems)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == SliceTy {
+			enc = append(encodeUint(big.NewInt(int64(len(elems)))), enc...)
+		}
+		return enc, nil
+	case TupleTy:
+		elems, err := toList(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) != len(t.Components) {
+			return nil, fmt.Errorf("abi: %s expects %d components, got %d", t.String(), len(t.Components), len(elems))
+		}
+		return encodeTuple(t.Components, elems)
+	}
+	return nil, fmt.Errorf("abi: unsupported type: %d", t.Kind)
+}
+
+//encodeInteger 检查取值范围，负数按二进制补码编码
+func encodeInteger(t Type, n *big.Int) ([]byte, error) {
+	if t.Kind == UintTy {
+		if n.Sign() < 0 || n.BitLen() > t.Size {
+			return nil, fmt.Errorf("abi: %s overflows %s", n.String(), t.String())
+		}
+		return encodeUint(n), nil
+	}
+
+	limit := new(big.Int).Lsh(bigOne, uint(t.Size-1))
+	if n.Cmp(limit) >= 0 || n.Cmp(new(big.Int).Neg(limit)) < 0 {
+		return nil, fmt.Errorf("abi: %s overflows %s", n.String(), t.String())
+	}
+	if n.Sign() < 0 {
+		return encodeUint(new(big.Int).Add(n, twoTo256)), nil
+	}
+	return encodeUint(n), nil
+}
+
+func encodeUint(n *big.Int) []byte {
+	return leftPad(n.Bytes())
+}
+
+func encodeDynamicBytes(b []byte) []byte {
+	ret := encodeUint(big.NewInt(int64(len(b))))
+	if len(b) == 0 {
+		return ret
+	}
+	return append(ret, rightPad(b)...)
+}
+
+//leftPad 左侧补0到32字节
+func leftPad(b []byte) []byte {
+	ret := make([]byte, wordSize)
+	copy(ret[wordSize-len(b):], b)
+	return ret
+}
+
+//rightPad 右侧补0到32字节的整数倍
+func rightPad(b []byte) []byte {
+	size := (len(b) + wordSize - 1) / wordSize * wordSize
+	if size == 0 {
+		size = wordSize
+	}
+	ret := make([]byte, size)
+	copy(ret, b)
+	return ret
+}
+
+//ToBigInt 整数参数转换为big.Int，支持Go的整数类型、big.Int以及十进制或0x开头的十六进制字符串
+func ToBigInt(v interface{}) (*big.Int, error) {
+	switch n := v.(type) {
+	case *big.Int:
+		if n == nil {
+			return nil, fmt.Errorf("abi: nil big.Int")
+		}
+		return new(big.Int).Set(n), nil
+	case big.Int:
+		return new(big.Int).Set(&n), nil
+	case int:
+		return big.NewInt(int64(n)), nil
+	case int8:
+		return big.NewInt(int64(n)), nil
+	case int16:
+		return big.NewInt(int64(n)), nil
+	case int32:
+		return big.NewInt(int64(n)), nil
+	case int64:
+		return big.NewInt(n), nil
+	case uint:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case uint16:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case uint32:
+		return new(big.Int).SetUint64(uint64(n)), nil
+	case uint64:
+		return new(big.Int).SetUint64(n), nil
+	case string:
+		ret, ok := new(big.Int), false
+		if strings.HasPrefix(n, "0x") {
+			ret, ok = ret.SetString(n[2:], 16)
+		} else {
+			ret, ok = ret.SetString(n, 10)
+		}
+		if !ok {
+			return nil, fmt.Errorf("abi: invalid integer: %s", n)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("abi: cannot use %T as integer", v)
+}
+
+func toAddress(v interface{}) (Address, error) {
+	switch a := v.(type) {
+	case Address:
+		return a, nil
+	case *Address:
+		return *a, nil
+	case [20]byte:
+		return Address(a), nil
+	case []byte:
+		var addr Address
+		if len(a) != len(addr) {
+			return addr, fmt.Errorf("abi: invalid address length: %d", len(a))
+		}
+		copy(addr[:], a)
+		return addr, nil
+	case string:
+		return HexToAddress(a)
+	}
+	return Address{}, fmt.Errorf("abi: cannot use %T as address", v)
+}
+
+//toBytes 支持[]byte、字节数组以及0x开头的十六进制字符串
+func toBytes(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		if !strings.HasPrefix(s, "0x") {
+			return nil, fmt.Errorf("abi: bytes string must be 0x prefixed hex")
+		}
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, fmt.Errorf("abi: invalid hex string: %s", s)
+		}
+		return b, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return b, nil
+	}
+	return nil, fmt.Errorf("abi: cannot use %T as bytes", v)
+}
+
+//toList 数组和元组的参数可以是任意类型的slice或数组
+func toList(v interface{}) ([]interface{}, error) {
+	if list, ok := v.([]interface{}); ok {
+		return list, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("abi: cannot use %T as array", v)
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, nil
+}
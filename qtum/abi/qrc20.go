@@ -0,0 +1,40 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+//QRC20标准方法，与ERC20一致
+var (
+	QRC20Name         = MustNewMethod("name()", "string")
+	QRC20Symbol       = MustNewMethod("symbol()", "string")
+	QRC20Decimals     = MustNewMethod("decimals()", "uint8")
+	QRC20TotalSupply  = MustNewMethod("totalSupply()", "uint256")
+	QRC20BalanceOf    = MustNewMethod("balanceOf(address)", "uint256")
+	QRC20Allowance    = MustNewMethod("allowance(address,address)", "uint256")
+	QRC20Transfer     = MustNewMethod("transfer(address,uint256)", "bool")
+	QRC20TransferFrom = MustNewMethod("transferFrom(address,address,uint256)", "bool")
+	QRC20Approve      = MustNewMethod("approve(address,uint256)", "bool")
+)
+
+//QRC20TransferEvent Transfer(address,address,uint256)事件的topic
+var QRC20TransferEvent = mustEventTopic("Transfer(address,address,uint256)")
+
+func mustEventTopic(signature string) []byte {
+	topic, err := EventTopic(signature)
+	if err != nil {
+		panic(err)
+	}
+	return topic
+}
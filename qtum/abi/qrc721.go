@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"errors"
+	"math/big"
+)
+
+//QRC721标准方法，与ERC721一致
+var (
+	QRC721Name             = MustNewMethod("name()", "string")
+	QRC721Symbol           = MustNewMethod("symbol()", "string")
+	QRC721BalanceOf        = MustNewMethod("balanceOf(address)", "uint256")
+	QRC721OwnerOf          = MustNewMethod("ownerOf(uint256)", "address")
+	QRC721TokenURI         = MustNewMethod("tokenURI(uint256)", "string")
+	QRC721GetApproved      = MustNewMethod("getApproved(uint256)", "address")
+	QRC721Approve          = MustNewMethod("approve(address,uint256)")
+	QRC721TransferFrom     = MustNewMethod("transferFrom(address,address,uint256)")
+	QRC721SafeTransferFrom = MustNewMethod("safeTransferFrom(address,address,uint256)")
+)
+
+//QRC721TransferEvent 与QRC20的Transfer事件签名相同，区别在于tokenId是indexed参数
+var QRC721TransferEvent = QRC20TransferEvent
+
+//TransferLog 解码后的Transfer事件
+//QRC20的Value为转账数量，QRC721的Value为tokenId
+type TransferLog struct {
+	From     Address
+	To       Address
+	Value    *big.Int
+	IsQRC721 bool
+}
+
+//DecodeTransferLog 解码Transfer(address,address,uint256)事件
+//QRC20有3个topic，数量在data中；QRC721有4个topic，tokenId为第4个topic
+func DecodeTransferLog(topics [][]byte, data []byte) (*TransferLog, error) {
+
+	if len(topics) < 3 || len(topics[0]) != wordSize || string(topics[0]) != string(QRC20TransferEvent) {
+		return nil, errors.New("not a Transfer event")
+	}
+
+	var (
+		ret   TransferLog
+		value []byte
+	)
+	switch len(topics) {
+	case 3:
+		if len(data) != wordSize {
+			return nil, errors.New("invalid QRC20 Transfer data")
+		}
+		value = data
+	case 4:
+		value = topics[3]
+		ret.IsQRC721 = true
+	default:
+		return nil, errors.New("invalid Transfer topics")
+	}
+
+	for _, topic := range topics[1:] {
+		if len(topic) != wordSize {
+			return nil, errors.New("invalid Transfer topic length")
+		}
+	}
+	if len(value) != wordSize {
+		return nil, errors.New("invalid Transfer value")
+	}
+
+	copy(ret.From[:], topics[1][wordSize-len(ret.From):])
+	copy(ret.To[:], topics[2][wordSize-len(ret.To):])
+	ret.Value = new(big.Int).SetBytes(value)
+	return &ret, nil
+}
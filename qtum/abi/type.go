@@ -0,0 +1,270 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Kind ABI类型的种类
+type Kind int
+
+const (
+	UintTy Kind = iota
+	IntTy
+	AddressTy
+	BoolTy
+	FixedBytesTy
+	BytesTy
+	StringTy
+	SliceTy //T[]
+	ArrayTy //T[k]
+	TupleTy //(T1,T2,...)
+)
+
+//wordSize ABI编码的字长
+const wordSize = 32
+
+//Type ABI类型
+type Type struct {
+	Kind Kind
+	//uint/int的位数，bytesN的字节数，T[k]的长度
+	Size int
+	//数组的元素类型
+	Elem *Type
+	//元组的成员类型
+	Components []Type
+}
+
+//NewType 解析类型字符串，如uint256、address[]、bytes32、(address,uint256)[2]
+func NewType(s string) (Type, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return Type{}, fmt.Errorf("abi: empty type")
+	}
+
+	//数组后缀
+	if strings.HasSuffix(s, "]") {
+		i := strings.LastIndex(s, "[")
+		if i <= 0 {
+			return Type{}, fmt.Errorf("abi: invalid type: %s", s)
+		}
+		elem, err := NewType(s[:i])
+		if err != nil {
+			return Type{}, err
+		}
+		dim := s[i+1 : len(s)-1]
+		if len(dim) == 0 {
+			return Type{Kind: SliceTy, Elem: &elem}, nil
+		}
+		size, err := strconv.Atoi(dim)
+		if err != nil || size <= 0 {
+			return Type{}, fmt.Errorf("abi: invalid array size: %s", s)
+		}
+		return Type{Kind: ArrayTy, Size: size, Elem: &elem}, nil
+	}
+
+	//元组
+	if strings.HasPrefix(s, "(") {
+		if !strings.HasSuffix(s, ")") {
+			return Type{}, fmt.Errorf("abi: invalid tuple type: %s", s)
+		}
+		components, err := parseTypeList(s[1 : len(s)-1])
+		if err != nil {
+			return Type{}, err
+		}
+		return Type{Kind: TupleTy, Components: components}, nil
+	}
+
+	switch {
+	case s == "address":
+		return Type{Kind: AddressTy, Size: 20}, nil
+	case s == "bool":
+		return Type{Kind: BoolTy}, nil
+	case s == "string":
+		return Type{Kind: StringTy}, nil
+	case s == "bytes":
+		return Type{Kind: BytesTy}, nil
+	case strings.HasPrefix(s, "bytes"):
+		size, err := strconv.Atoi(s[len("bytes"):])
+		if err != nil || size < 1 || size > 32 {
+			return Type{}, fmt.Errorf("abi: invalid type: %s", s)
+		}
+		return Type{Kind: FixedBytesTy, Size: size}, nil
+	case strings.HasPrefix(s, "uint"):
+		size, err := parseIntSize(s[len("uint"):])
+		if err != nil {
+			return Type{}, fmt.Errorf("abi: invalid type: %s", s)
+		}
+		return Type{Kind: UintTy, Size: size}, nil
+	case strings.HasPrefix(s, "int"):
+		size, err := parseIntSize(s[len("int"):])
+		if err != nil {
+			return Type{}, fmt.Errorf("abi: invalid type: %s", s)
+		}
+		return Type{Kind: IntTy, Size: size}, nil
+	}
+
+	return Type{}, fmt.Errorf("abi: unsupported type: %s", s)
+}
+
+//MustNewType 解析类型字符串，失败时panic
+func MustNewType(s string) Type {
+	t, err := NewType(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+//NewTypes 解析多个类型字符串
+func NewTypes(types ...string) ([]Type, error) {
+	ret := make([]Type, 0, len(types))
+	for _, s := range types {
+		t, err := NewType(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+//parseIntSize uint/int的位数，缺省为256
+func parseIntSize(s string) (int, error) {
+	if len(s) == 0 {
+		return 256, nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size < 8 || size > 256 || size%8 != 0 {
+		return 0, fmt.Errorf("abi: invalid integer size: %s", s)
+	}
+	return size, nil
+}
+
+//parseTypeList 解析逗号分隔的类型列表，忽略括号内的逗号
+func parseTypeList(s string) ([]Type, error) {
+	types := make([]Type, 0)
+	if len(strings.TrimSpace(s)) == 0 {
+		return types, nil
+	}
+
+	depth, start := 0, 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch s[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				if depth < 0 {
+					return nil, fmt.Errorf("abi: unbalanced parentheses: %s", s)
+				}
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		t, err := NewType(s[start:i])
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+		start = i + 1
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("abi: unbalanced parentheses: %s", s)
+	}
+	return types, nil
+}
+
+//String 规范的类型字符串，用于计算函数选择器
+func (t Type) String() string {
+	switch t.Kind {
+	case UintTy:
+		return "uint" + strconv.Itoa(t.Size)
+	case IntTy:
+		return "int" + strconv.Itoa(t.Size)
+	case AddressTy:
+		return "address"
+	case BoolTy:
+		return "bool"
+	case FixedBytesTy:
+		return "bytes" + strconv.Itoa(t.Size)
+	case BytesTy:
+		return "bytes"
+	case StringTy:
+		return "string"
+	case SliceTy:
+		return t.Elem.String() + "[]"
+	case ArrayTy:
+		return t.Elem.String() + "[" + strconv.Itoa(t.Size) + "]"
+	case TupleTy:
+		return "(" + typeListString(t.Components) + ")"
+	}
+	return ""
+}
+
+func typeListString(types []Type) string {
+	s := make([]string, 0, len(types))
+	for _, t := range types {
+		s = append(s, t.String())
+	}
+	return strings.Join(s, ",")
+}
+
+//isDynamic 动态类型在头部只保存偏移量
+func (t Type) isDynamic() bool {
+	switch t.Kind {
+	case BytesTy, StringTy, SliceTy:
+		return true
+	case ArrayTy:
+		return t.Elem.isDynamic()
+	case TupleTy:
+		for _, c := range t.Components {
+			if c.isDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//headSize 类型在头部占用的字节数
+func (t Type) headSize() int {
+	if t.isDynamic() {
+		return wordSize
+	}
+	switch t.Kind {
+	case ArrayTy:
+		return t.Size * t.Elem.headSize()
+	case TupleTy:
+		size := 0
+		for _, c := range t.Components {
+			size += c.headSize()
+		}
+		return size
+	}
+	return wordSize
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package abi
+
+import (
+	"fmt"
+	"math/big"
+)
+
+//Decode 按类型列表解码数据
+//uint/int解码为*big.Int，address为Address，bytesN和bytes为[]byte，数组和元组为[]interface{}
+func Decode(types []Type, data []byte) ([]interface{}, error) {
+	return decodeTuple(types, data)
+}
+
+func decodeTuple(types []Type, data []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(types))
+	offset := 0
+	for _, t := range types {
+		if t.isDynamic() {
+			pos, err := readLength(data, offset)
+			if err != nil {
+				return nil, err
+			}
+			if pos > len(data) {
+				return nil, fmt.Errorf("abi: offset %d out of range", pos)
+			}
+			v, err := decodeValue(t, data[pos:])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			offset += wordSize
+			continue
+		}
+
+		if offset+t.headSize() > len(data) {
+			return nil, fmt.Errorf("abi: data too short for %s", t.String())
+		}
+		v, err := decodeValue(t, data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		offset += t.headSize()
+	}
+	return values, nil
+}
+
+func decodeValue(t Type, data []byte) (interface{}, error) {
+	switch t.Kind {
+	case UintTy, IntTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		n := new(big.Int).SetBytes(word)
+		if t.Kind == IntTy && word[0]&0x80 != 0 {
+			n.Sub(n, twoTo256)
+		}
+		if _, err := encodeInteger(t, n); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case AddressTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		var addr Address
+		copy(addr[:], word[wordSize-len(addr):])
+		return addr, nil
+	case BoolTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		n := new(big.Int).SetBytes(word)
+		if n.BitLen() > 1 {
+			return nil, fmt.Errorf("abi: invalid bool value")
+		}
+		return n.Sign() == 1, nil
+	case FixedBytesTy:
+		word, err := readWord(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, word[:t.Size]...), nil
+	case BytesTy, StringTy:
+		length, err := readLength(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		if wordSize+length > len(data) {
+			return nil, fmt.Errorf("abi: data too short for %s", t.String())
+		}
+		b := append([]byte{}, data[wordSize:wordSize+length]...)
+		if t.Kind == StringTy {
+			return string(b), nil
+		}
+		return b, nil
+	case SliceTy, ArrayTy:
+		size := t.Size
+		if t.Kind == SliceTy {
+			length, err := readLength(data, 0)
+			if err != nil {
+				return nil, err
+			}
+			//每个元素至少占一个字长
+			if length > (len(data)-wordSize)/wordSize {
+				return nil, fmt.Errorf("abi: array length %d out of range", length)
+			}
+			size = length
+			data = data[wordSize:]
+		}
+		types := make([]Type, size)
+		for i := range types {
+			types[i] = *t.Elem
+		}
+		return decodeTuple(types, data)
+	case TupleTy:
+		return decodeTuple(t.Components, data)
+	}
+	return nil, fmt.Errorf("abi: unsupported type: %d", t.Kind)
+}
+
+func readWord(data []byte, offset int) ([]byte, error) {
+	if offset < 0 || offset+wordSize > len(data) {
+		return nil, fmt.Errorf("abi: data too short")
+	}
+	return data[offset : offset+wordSize], nil
+}
+
+//readLength 读取偏移量或长度
+func readLength(data []byte, offset int) (int, error) {
+	word, err := readWord(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	n := new(big.Int).SetBytes(word)
+	if n.Cmp(maxUint64) > 0 || n.Uint64() > uint64(len(data)) {
+		return 0, fmt.Errorf("abi: length %s out of range", n.String())
+	}
+	return int(n.Uint64()), nil
+}
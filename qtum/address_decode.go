@@ -17,10 +17,13 @@ package qtum
 
 import (
 	"encoding/hex"
-	"github.com/blocktree/go-owaddress"
-	"github.com/blocktree/openwallet/v2/openwallet"
+	"errors"
 	"strings"
 
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
 )
 
@@ -31,25 +34,31 @@ var (
 var (
 
 	//QTUM stuff
-	QTUM_mainnetAddressP2PKH         = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "h160", 20, []byte{0x3A}, nil}
-	QTUM_mainnetAddressP2SH          = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "h160", 20, []byte{0x32}, nil}
-	QTUM_mainnetPrivateWIF           = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 32, []byte{0x80}, nil}
-	QTUM_mainnetPrivateWIFCompressed = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 32, []byte{0x80}, []byte{0x01}}
-	QTUM_mainnetPublicBIP32          = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 74, []byte{0x04, 0x88, 0xB2, 0x1E}, nil}
-	QTUM_mainnetPrivateBIP32         = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 74, []byte{0x04, 0x88, 0xAD, 0xE4}, nil}
-	QTUM_testnetAddressP2PKH         = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "h160", 20, []byte{0x78}, nil}
-	QTUM_testnetAddressP2SH          = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "h160", 20, []byte{0x6E}, nil}
-	QTUM_testnetPrivateWIF           = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 32, []byte{0xEF}, nil}
-	QTUM_testnetPrivateWIFCompressed = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 32, []byte{0xEF}, []byte{0x01}}
-	QTUM_testnetPublicBIP32          = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 74, []byte{0x04, 0x35, 0x87, 0xCF}, nil}
-	QTUM_testnetPrivateBIP32         = addressEncoder.AddressType{"base58", alphabet, "doubleSHA256", "", 74, []byte{0x04, 0x35, 0x83, 0x94}, nil}
+	QTUM_mainnetAddressP2PKH         = MainNetParams.P2PKHAddressType()
+	QTUM_mainnetAddressP2SH          = MainNetParams.P2SHAddressType()
+	QTUM_mainnetPrivateWIF           = MainNetParams.WIFAddressType(false)
+	QTUM_mainnetPrivateWIFCompressed = MainNetParams.WIFAddressType(true)
+	QTUM_mainnetPublicBIP32          = MainNetParams.BIP32PublicKeyType()
+	QTUM_mainnetPrivateBIP32         = MainNetParams.BIP32PrivateKeyType()
+	QTUM_testnetAddressP2PKH         = TestNetParams.P2PKHAddressType()
+	QTUM_testnetAddressP2SH          = TestNetParams.P2SHAddressType()
+	QTUM_testnetPrivateWIF           = TestNetParams.WIFAddressType(false)
+	QTUM_testnetPrivateWIFCompressed = TestNetParams.WIFAddressType(true)
+	QTUM_testnetPublicBIP32          = TestNetParams.BIP32PublicKeyType()
+	QTUM_testnetPrivateBIP32         = TestNetParams.BIP32PrivateKeyType()
+
+	//隔离见证v0地址，P2WPKH
+	QTUM_mainnetAddressBech32V0 = MainNetParams.Bech32AddressType()
+	QTUM_testnetAddressBech32V0 = TestNetParams.Bech32AddressType()
+	QTUM_regtestAddressBech32V0 = RegTestParams.Bech32AddressType()
 )
 
 //AddressDecoderV2
 type AddressDecoderV2 struct {
 	*openwallet.AddressDecoderV2Base
-	wm        *WalletManager
-	IsTestNet bool
+	wm *WalletManager
+	//地址所属网络，为空时使用钱包配置的网络
+	Network *ChainParams
 }
 
 //NewAddressDecoder 地址解析器
@@ -59,12 +68,23 @@ func NewAddressDecoder(wm *WalletManager) *AddressDecoderV2 {
 	return &decoder
 }
 
+//network 当前使用的网络参数
+func (dec *AddressDecoderV2) network() *ChainParams {
+	if dec.Network != nil {
+		return dec.Network
+	}
+	return dec.wm.Config.Network
+}
+
 //AddressDecode 地址解析
 func (dec *AddressDecoderV2) AddressDecode(addr string, opts ...interface{}) ([]byte, error) {
 
-	cfg := QTUM_mainnetAddressP2PKH
-	if dec.IsTestNet {
-		cfg = QTUM_testnetAddressP2PKH
+	cfg := dec.network().P2PKHAddressType()
+
+	//bech32地址根据前缀自动识别
+	bech32Cfg := dec.network().Bech32AddressType()
+	if btcLikeTxDriver.IsBech32Address(addr, bech32Cfg.ChecksumType) {
+		cfg = bech32Cfg
 	}
 
 	if len(opts) > 0 {
@@ -75,16 +95,17 @@ func (dec *AddressDecoderV2) AddressDecode(addr string, opts ...interface{}) ([]
 		}
 	}
 
+	if cfg.EncodeType == "bech32" {
+		return btcLikeTxDriver.Bech32DecodeWithPrefix(addr, cfg.ChecksumType)
+	}
+
 	return addressEncoder.AddressDecode(addr, cfg)
 }
 
 //AddressEncode 地址编码
 func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (string, error) {
 
-	cfg := QTUM_mainnetAddressP2PKH
-	if dec.IsTestNet {
-		cfg = QTUM_testnetAddressP2PKH
-	}
+	cfg := dec.network().P2PKHAddressType()
 
 	if len(opts) > 0 {
 		for _, opt := range opts {
@@ -96,12 +117,10 @@ func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (st
 
 	address := addressEncoder.AddressEncode(hash, cfg)
 
-	if dec.wm.Config.RPCServerType == RPCServerCore {
-		//如果使用core钱包作为全节点，需要导入地址到core，这样才能查询地址余额和utxo
-		err := dec.wm.ImportAddress(address, "")
-		if err != nil {
-			return "", err
-		}
+	//如果使用core钱包作为全节点，需要导入地址到core，这样才能查询地址余额和utxo
+	err := dec.wm.chain().ImportAddress(address)
+	if err != nil {
+		return "", err
 	}
 
 	return address, nil
@@ -109,20 +128,65 @@ func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (st
 
 // AddressVerify 地址校验
 func (dec *AddressDecoderV2) AddressVerify(address string, opts ...interface{}) bool {
-	valid, err := owaddress.Verify("qtum", address)
+
+	params := dec.network()
+
+	if btcLikeTxDriver.IsBech32Address(address, params.Bech32HRP) {
+		_, err := btcLikeTxDriver.Bech32DecodeWithPrefix(address, params.Bech32HRP)
+		return err == nil
+	}
+
+	for _, cfg := range []addressEncoder.AddressType{params.P2PKHAddressType(), params.P2SHAddressType()} {
+		if _, err := addressEncoder.AddressDecode(address, cfg); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+//PrivateKeyToWIF 私钥转压缩公钥格式的WIF，网络由解码器决定，isTestnet仅为兼容接口
+func (dec *AddressDecoderV2) PrivateKeyToWIF(priv []byte, isTestnet bool) (string, error) {
+	if len(priv) != 32 {
+		return "", errors.New("Invalid private key length!")
+	}
+	return addressEncoder.AddressEncode(priv, dec.network().WIFAddressType(true)), nil
+}
+
+//WIFToPrivateKey WIF转私钥，支持压缩和非压缩格式
+func (dec *AddressDecoderV2) WIFToPrivateKey(wif string, isTestnet bool) ([]byte, error) {
+	params := dec.network()
+	if priv, err := addressEncoder.AddressDecode(wif, params.WIFAddressType(true)); err == nil {
+		return priv, nil
+	}
+	priv, err := addressEncoder.AddressDecode(wif, params.WIFAddressType(false))
 	if err != nil {
-		return false
+		return nil, errors.New("Invalid WIF!")
 	}
-	return valid
+	return priv, nil
+}
+
+//PublicKeyToAddress 公钥转P2PKH地址，非压缩公钥先压缩
+func (dec *AddressDecoderV2) PublicKeyToAddress(pub []byte, isTestnet bool) (string, error) {
+	if len(pub) != 33 {
+		pub = owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1)
+		if pub == nil {
+			return "", errors.New("Invalid public key!")
+		}
+	}
+	hash := owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_HASH160)
+	return addressEncoder.AddressEncode(hash, dec.network().P2PKHAddressType()), nil
+}
+
+//RedeemScriptToAddress 多重签名赎回脚本转P2SH地址
+func (dec *AddressDecoderV2) RedeemScriptToAddress(pubs [][]byte, required uint64, isTestnet bool) (string, error) {
+	address, _, err := btcLikeTxDriver.CreateMultiSig(byte(required), pubs, dec.network().AddressPrefix())
+	return address, err
 }
 
 //HashAddressToBaseAddress 哈希地址转编码地址
-func HashAddressToBaseAddress(token string, isTestnet bool) string {
+func HashAddressToBaseAddress(token string, params *ChainParams) string {
 	token = strings.TrimPrefix(token, "0x")
-	cfg := addressEncoder.QTUM_mainnetAddressP2PKH
-	if isTestnet {
-		cfg = addressEncoder.QTUM_testnetAddressP2PKH
-	}
+	cfg := params.P2PKHAddressType()
 
 	hash, err := hex.DecodeString(token)
 	if err != nil {
@@ -46,6 +46,55 @@ func TestAddressDecoder_AddressDecode(t *testing.T) {
 }
 
 func TestHashAddressToBaseAddress(t *testing.T) {
-	addr := HashAddressToBaseAddress("", true)
+	addr := HashAddressToBaseAddress("", &TestNetParams)
 	t.Logf("addr: %s", addr)
 }
+
+func TestAddressDecoder_Bech32(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.RPCServerType = RPCServerExplorer
+	addrdec := NewAddressDecoder(wm)
+
+	tests := []struct {
+		params  *ChainParams
+		hash    string
+		address string
+	}{
+		{&MainNetParams, "751e76e8199196d454941c45d1b3a323f1433bd6", "qc1qw508d6qejxtdg4y5r3zarvary0c5xw7kq52at0"},
+		{&TestNetParams, "751e76e8199196d454941c45d1b3a323f1433bd6", "tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f"},
+		{&RegTestParams, "751e76e8199196d454941c45d1b3a323f1433bd6", "qcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7k28q8nz"},
+	}
+
+	for _, test := range tests {
+		addrdec.Network = test.params
+		cfg := test.params.Bech32AddressType()
+
+		hash, _ := hex.DecodeString(test.hash)
+		address, err := addrdec.AddressEncode(hash, cfg)
+		if err != nil || address != test.address {
+			t.Errorf("AddressEncode failed, expected: %s, got: %s, err: %v", test.address, address, err)
+			continue
+		}
+
+		decoded, err := addrdec.AddressDecode(test.address)
+		if err != nil || hex.EncodeToString(decoded) != test.hash {
+			t.Errorf("AddressDecode failed, expected: %s, got: %x, err: %v", test.hash, decoded, err)
+			continue
+		}
+
+		if !addrdec.AddressVerify(test.address) {
+			t.Errorf("AddressVerify failed: %s", test.address)
+		}
+	}
+
+	//主网不接受测试网前缀
+	addrdec.Network = &MainNetParams
+	if addrdec.AddressVerify("tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f") {
+		t.Errorf("AddressVerify should reject testnet bech32 address on mainnet")
+	}
+	//比特币前缀无效
+	if addrdec.AddressVerify("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4") {
+		t.Errorf("AddressVerify should reject bitcoin bech32 address")
+	}
+}
@@ -16,25 +16,61 @@
 package qtum
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/imroc/req"
 	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//rpcInWarmup 节点正在启动(加载区块索引等)，切换到其他节点
+const rpcInWarmup = -28
+
+const (
+	DefaultRPCTimeout      = 2 * time.Minute        //请求超时
+	DefaultRPCRetryBackoff = 500 * time.Millisecond //首次重试的等待时间，之后每次加倍
+	maxRPCRetryBackoff     = 30 * time.Second
 )
 
 // A Client is a Bitcoin RPC client. It performs RPCs over HTTP using JSON
 // request and responses. A Client must be configured with a secret token
 // to authenticate with other Cores on the network.
 type Client struct {
-	BaseURL     string
-	AccessToken string
-	Debug       bool
-	client      *req.Req
+	BaseURL      string
+	AccessToken  string
+	Debug        bool
+	Endpoints    *EndpointPool //节点池，BaseURL为逗号分隔的多个节点时按策略切换
+	MaxRetries   int           //所有节点都不可用时的重试次数
+	RetryBackoff time.Duration //首次重试的等待时间，之后每次加倍
+	CookieFile   string        //节点的.cookie文件，设置后代替AccessToken鉴权
+	client       *req.Req
+	nextID       uint64
+	cookieMu     sync.Mutex
+	cookieToken  string
 	//Client *req.Req
 }
 
+//RPCRequest 批量调用中的一个请求
+type RPCRequest struct {
+	Method string
+	Params []interface{}
+}
+
+//RPCResponse 批量调用中的一个结果，Err为节点返回的错误
+type RPCResponse struct {
+	Result gjson.Result
+	Err    error
+}
+
 type Response struct {
 	Code    int         `json:"code,omitempty"`
 	Error   interface{} `json:"error,omitempty"`
@@ -45,46 +81,294 @@ type Response struct {
 
 func NewClient(url, token string, debug bool) *Client {
 	c := Client{
-		BaseURL:     url,
-		AccessToken: token,
-		Debug:       debug,
+		BaseURL:      url,
+		AccessToken:  token,
+		Debug:        debug,
+		Endpoints:    NewEndpointPool(ParseEndpoints(url)...),
+		RetryBackoff: DefaultRPCRetryBackoff,
 	}
 
 	api := req.New()
 	//trans, _ := api.Client().Transport.(*http.Transport)
 	//trans.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	//http客户端是延迟创建的，提前创建避免并发请求时竞争
+	api.Client()
+	api.SetTimeout(DefaultRPCTimeout)
 	c.client = api
 
 	return &c
 }
 
+//SetTimeout 设置请求超时
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.client.SetTimeout(timeout)
+}
+
+//SetTLS 设置https连接的CA证书和客户端证书，caFile为空时使用系统根证书，certFile为空时不使用客户端证书
+func (c *Client) SetTLS(caFile, certFile, keyFile string) error {
+
+	tlsConfig := &tls.Config{}
+
+	if len(caFile) > 0 {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("invalid CA certificate: %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(certFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport, ok := c.client.Client().Transport.(*http.Transport)
+	if !ok {
+		return errors.New("http transport is not supported")
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return nil
+}
+
+//authToken 鉴权信息，使用.cookie文件时读取文件内容，节点重启后cookie会变化
+func (c *Client) authToken(reload bool) (string, error) {
+
+	if len(c.CookieFile) == 0 {
+		return c.AccessToken, nil
+	}
+
+	c.cookieMu.Lock()
+	defer c.cookieMu.Unlock()
+
+	if len(c.cookieToken) == 0 || reload {
+		cookie, err := ioutil.ReadFile(c.CookieFile)
+		if err != nil {
+			return "", err
+		}
+		c.cookieToken = base64.StdEncoding.EncodeToString(bytes.TrimSpace(cookie))
+	}
+
+	return c.cookieToken, nil
+}
+
 // Call calls a remote procedure on another node, specified by the path.
 func (c *Client) Call(path string, request []interface{}) (*gjson.Result, error) {
 
-	var (
-		body = make(map[string]interface{}, 0)
-	)
+	if c.client == nil {
+		return nil, errors.New("API url is not setup. ")
+	}
+
+	var result *gjson.Result
+	err := c.do(func(url string) (bool, error) {
+		var (
+			retry bool
+			err   error
+		)
+		result, retry, err = c.call(url, path, request)
+		return retry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//Batch JSON-RPC批量调用，一次发送所有请求，结果与请求的顺序一致
+//单个请求失败记录在对应结果的Err中，连接失败等整体失败时返回错误
+func (c *Client) Batch(requests []RPCRequest) ([]RPCResponse, error) {
+
+	if c.client == nil {
+		return nil, errors.New("API url is not setup. ")
+	}
+
+	if len(requests) == 0 {
+		return []RPCResponse{}, nil
+	}
+
+	var responses []RPCResponse
+	err := c.do(func(url string) (bool, error) {
+		var (
+			retry bool
+			err   error
+		)
+		responses, retry, err = c.batch(url, requests)
+		return retry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+//do 在节点池上执行请求，所有节点都不可用时按指数退避重试
+func (c *Client) do(fn func(url string) (bool, error)) error {
+
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry := false
+		err := c.Endpoints.Do(func(url string) (bool, error) {
+			var err error
+			retry, err = fn(url)
+			return retry, err
+		})
+		if err == nil || !retry || attempt >= c.MaxRetries {
+			return err
+		}
+
+		if c.Debug {
+			log.Std.Info("Request API failed, retry after %v: %v", backoff, err)
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRPCRetryBackoff {
+			backoff = maxRPCRetryBackoff
+		}
+	}
+}
+
+//CallQuorum 在多个节点上查询，key相同的结果达到Quorum数量时返回，节点之间不一致时返回QuorumError
+//key用于比较结果，排除与节点高度相关的字段(如confirmations)
+func (c *Client) CallQuorum(path string, request []interface{}, key func(result *gjson.Result) string) (*gjson.Result, error) {
 
 	if c.client == nil {
 		return nil, errors.New("API url is not setup. ")
 	}
 
+	var (
+		mu      sync.Mutex
+		results = make(map[string]*gjson.Result)
+	)
+	agreed, err := c.Endpoints.DoQuorum(func(url string) (bool, string, error) {
+		result, retry, err := c.call(url, path, request)
+		if err != nil {
+			return retry, "", err
+		}
+		k := key(result)
+		mu.Lock()
+		results[k] = result
+		mu.Unlock()
+		return false, k, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results[agreed], nil
+}
+
+//HealthCheck 定时用getblockcount检查失败过的节点
+func (c *Client) HealthCheck(interval time.Duration) {
+	c.Endpoints.StartHealthCheck(interval, func(url string) error {
+		_, _, err := c.call(url, "getblockcount", nil)
+		return err
+	})
+}
+
+//newRequest json-rpc请求，每个请求使用不同的id
+func (c *Client) newRequest(method string, params []interface{}) map[string]interface{} {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      atomic.AddUint64(&c.nextID, 1),
+		"method":  method,
+		"params":  params,
+	}
+}
+
+//call 请求一个节点，连接失败、节点没有返回json-rpc结果或正在启动时retry为true
+func (c *Client) call(url, path string, request []interface{}) (*gjson.Result, bool, error) {
+
+	resp, retry, err := c.post(url, c.newRequest(path, request))
+	if err != nil {
+		return nil, retry, err
+	}
+
+	err = isError(resp)
+	if err != nil {
+		return nil, resp.Get("error.code").Int() == rpcInWarmup, err
+	}
+
+	result := resp.Get("result")
+
+	return &result, false, nil
+}
+
+//batch 批量请求一个节点，按id对应结果
+func (c *Client) batch(url string, requests []RPCRequest) ([]RPCResponse, bool, error) {
+
+	body := make([]interface{}, 0, len(requests))
+	ids := make(map[uint64]int, len(requests))
+	for i, r := range requests {
+		request := c.newRequest(r.Method, r.Params)
+		ids[request["id"].(uint64)] = i
+		body = append(body, request)
+	}
+
+	resp, retry, err := c.post(url, body)
+	if err != nil {
+		return nil, retry, err
+	}
+
+	//节点不支持批量调用或请求整体失败时返回单个错误对象
+	if !resp.IsArray() {
+		if err := isError(resp); err != nil {
+			return nil, resp.Get("error.code").Int() == rpcInWarmup, err
+		}
+		return nil, false, errors.New("Batch response is not an array! ")
+	}
+
+	responses := make([]RPCResponse, len(requests))
+	answered := make([]bool, len(requests))
+	for _, item := range resp.Array() {
+		i, ok := ids[item.Get("id").Uint()]
+		if !ok {
+			continue
+		}
+		answered[i] = true
+		if err := isError(&item); err != nil {
+			responses[i].Err = err
+			continue
+		}
+		responses[i].Result = item.Get("result")
+	}
+	for i := range responses {
+		if !answered[i] {
+			responses[i].Err = errors.New("Response is empty! ")
+		}
+	}
+
+	return responses, false, nil
+}
+
+//post 发送请求，返回解析后的json，鉴权失败时重新读取.cookie文件
+func (c *Client) post(url string, body interface{}) (*gjson.Result, bool, error) {
+
+	token, err := c.authToken(false)
+	if err != nil {
+		return nil, true, err
+	}
+
 	authHeader := req.Header{
 		"Accept":        "application/json",
-		"Authorization": "Basic " + c.AccessToken,
+		"Authorization": "Basic " + token,
 	}
 
-	//json-rpc
-	body["jsonrpc"] = "1.0"
-	body["id"] = "curltest"
-	body["method"] = path
-	body["params"] = request
-
 	if c.Debug {
 		log.Std.Info("Start Request API...")
 	}
 
-	r, err := c.client.Post(c.BaseURL, req.BodyJSON(&body), authHeader)
+	r, err := c.client.Post(url, req.BodyJSON(body), authHeader)
 
 	if c.Debug {
 		log.Std.Info("Request API Completed")
@@ -95,18 +379,22 @@ func (c *Client) Call(path string, request []interface{}) (*gjson.Result, error)
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 
-	resp := gjson.ParseBytes(r.Bytes())
-	err = isError(&resp)
-	if err != nil {
-		return nil, err
+	if r.Response().StatusCode == http.StatusUnauthorized && len(c.CookieFile) > 0 {
+		c.authToken(true)
+		return nil, true, fmt.Errorf("%s returns %s, reload cookie", url, r.Response().Status)
 	}
 
-	result := resp.Get("result")
+	//鉴权失败或代理返回的错误页面不是json
+	if !gjson.ValidBytes(r.Bytes()) {
+		return nil, true, fmt.Errorf("%s returns invalid response: %s", url, r.Response().Status)
+	}
+
+	resp := gjson.ParseBytes(r.Bytes())
 
-	return &result, nil
+	return &resp, false, nil
 }
 
 // See 2 (end of page 4) http://www.ietf.org/rfc/rfc2617.txt
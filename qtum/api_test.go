@@ -0,0 +1,183 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Batch(t *testing.T) {
+
+	//倒序返回结果，缺少最后一个请求的结果，第二个请求返回错误
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []struct {
+			ID     uint64        `json:"id"`
+			Params []interface{} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := make([]map[string]interface{}, 0)
+		for i := len(requests) - 2; i >= 0; i-- {
+			item := map[string]interface{}{"id": requests[i].ID, "result": nil, "error": nil}
+			if i == 1 {
+				item["error"] = &rpcTestError{Code: -5, Message: "No such mempool or blockchain transaction"}
+			} else {
+				item["result"] = requests[i].Params[0]
+			}
+			resp = append(resp, item)
+		}
+		//不属于本次请求的id被忽略
+		resp = append(resp, map[string]interface{}{"id": 0, "result": "unknown", "error": nil})
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", false)
+	requests := make([]RPCRequest, 0)
+	for _, txid := range []string{"tx0", "tx1", "tx2", "tx3"} {
+		requests = append(requests, RPCRequest{Method: "getrawtransaction", Params: []interface{}{txid}})
+	}
+	responses, err := client.Batch(requests)
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if len(responses) != len(requests) {
+		t.Fatalf("responses: %d, want: %d", len(responses), len(requests))
+	}
+	for i, want := range []string{"tx0", "", "tx2", ""} {
+		if responses[i].Result.String() != want {
+			t.Fatalf("response %d result: %s, want: %s", i, responses[i].Result.String(), want)
+		}
+	}
+	if responses[0].Err != nil || responses[2].Err != nil {
+		t.Fatalf("unexpected errors: %v, %v", responses[0].Err, responses[2].Err)
+	}
+	if responses[1].Err == nil || responses[1].Err.Error() != "[-5]No such mempool or blockchain transaction" {
+		t.Fatalf("response 1 error: %v", responses[1].Err)
+	}
+	if responses[3].Err == nil {
+		t.Fatalf("missing response should return an error")
+	}
+}
+
+func TestClient_BatchNotSupported(t *testing.T) {
+
+	server := newRPCTestServer(t, func(method string) interface{} {
+		return &rpcTestError{Code: -32600, Message: "Invalid Request object"}
+	})
+
+	//节点返回单个错误对象时整体失败
+	client := NewClient(server.URL, "", false)
+	_, err := client.Batch([]RPCRequest{{Method: "getblockcount"}})
+	if err == nil {
+		t.Fatalf("Batch should fail when the node does not support batch requests")
+	}
+}
+
+func TestClient_CookieReload(t *testing.T) {
+
+	var password atomic.Value
+	password.Store("pass1")
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("__cookie__:"+password.Load().(string)))
+		if r.Header.Get("Authorization") != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"result":100,"error":null,"id":1}`))
+	}))
+	defer server.Close()
+
+	cookieFile := filepath.Join(t.TempDir(), ".cookie")
+	writeCookie := func(pass string) {
+		if err := ioutil.WriteFile(cookieFile, []byte("__cookie__:"+pass+"\n"), 0600); err != nil {
+			t.Fatalf("write cookie failed: %v", err)
+		}
+	}
+	writeCookie("pass1")
+
+	client := NewClient(server.URL, "", false)
+	client.CookieFile = cookieFile
+	client.MaxRetries = 1
+	client.RetryBackoff = time.Millisecond
+	if _, err := client.Call("getblockcount", nil); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+
+	//节点重启后cookie变化，鉴权失败时重新读取
+	password.Store("pass2")
+	writeCookie("pass2")
+	atomic.StoreInt32(&hits, 0)
+	result, err := client.Call("getblockcount", nil)
+	if err != nil {
+		t.Fatalf("Call with the new cookie failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&hits); result.Uint() != 100 || n != 2 {
+		t.Fatalf("result: %d, hits: %d, want: 100 after 2 hits", result.Uint(), n)
+	}
+}
+
+func TestClient_RetryBackoff(t *testing.T) {
+
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", false)
+	client.MaxRetries = 3
+	client.RetryBackoff = 20 * time.Millisecond
+
+	//等待20ms、40ms、80ms后重试
+	startAt := time.Now()
+	if _, err := client.Call("getblockcount", nil); err == nil {
+		t.Fatalf("Call should fail when the endpoint is unavailable")
+	}
+	if elapsed := time.Since(startAt); elapsed < 140*time.Millisecond {
+		t.Fatalf("elapsed: %v, want at least 140ms", elapsed)
+	}
+	if n := atomic.LoadInt32(&hits); n != 4 {
+		t.Fatalf("hits: %d, want: 4", n)
+	}
+
+	//节点返回的业务错误不重试
+	failed := newRPCTestServer(t, func(method string) interface{} {
+		return &rpcTestError{Code: -8, Message: "Block height out of range"}
+	})
+	client = NewClient(failed.URL, "", false)
+	client.MaxRetries = 3
+	client.RetryBackoff = 20 * time.Millisecond
+	if _, err := client.Call("getblockhash", []interface{}{1000000}); err == nil {
+		t.Fatalf("Call should return the node error")
+	}
+	if failed.Hits() != 1 {
+		t.Fatalf("node error should not be retried, hits: %d", failed.Hits())
+	}
+}
@@ -16,10 +16,12 @@
 package qtum
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blocktree/openwallet/v2/common"
@@ -27,12 +29,7 @@ import (
 	"github.com/graarh/golang-socketio"
 	"github.com/graarh/golang-socketio/transport"
 	"github.com/shopspring/decimal"
-)
-
-const (
-	//blockchainBucket = "blockchain" //区块链数据集合
-	//periodOfTask      = 5 * time.Second //定时任务执行隔间
-	maxExtractingSize = 15 //并发的扫描线程数
+	"github.com/tidwall/gjson"
 )
 
 //BTCBlockScanner bitcoin的区块链扫描器
@@ -40,21 +37,33 @@ type BTCBlockScanner struct {
 	*openwallet.BlockScannerBase
 
 	CurrentBlockHeight   uint64             //当前区块高度
-	extractingCH         chan struct{}      //扫描工作令牌
 	wm                   *WalletManager     //钱包管理者
 	IsScanMemPool        bool               //是否扫描交易池
 	RescanLastBlockCount uint64             //重扫上N个区块数量
 	socketIO             *gosocketio.Client //socketIO客户端
 	stopSocketIO         chan struct{}
+	journal              *ReorgJournal //重组日志
+	journalOnce          sync.Once
+	memPoolCache         *MemPoolCache //交易池缓存
+	memPoolOnce          sync.Once
+	scanMu               sync.Mutex //扫描任务锁，定时任务和推送通知不同时扫描
+	blockNotify          int32      //推送新区块的连接数(zmq hashblock或ElectrumX)，大于0时不轮询区块
+	zmqQuit              chan struct{}
+	electrumQuit         chan struct{}
+	metrics              ExtractMetrics //区块提取的统计数据
+	metricsMu            sync.Mutex
 }
 
 //ExtractResult 扫描完成的提取结果
 type ExtractResult struct {
-	extractData         map[string]*openwallet.TxExtractData //主链交易
-	extractContractData map[string]*openwallet.TxExtractData //代币交易
-	TxID                string
-	BlockHeight         uint64
-	Success             bool
+	extractData             map[string]*openwallet.TxExtractData          //主链交易
+	extractContractData     map[string]*openwallet.TxExtractData          //代币交易
+	extractContractReceipts map[string][]*openwallet.SmartContractReceipt //合约回执，key为合约ID
+	immature                bool                                          //coinbase或coinstake，奖励未成熟
+	spent                   []string                                      //交易单花费的输出，txid:vout
+	TxID                    string
+	BlockHeight             uint64
+	Success                 bool
 }
 
 //SaveResult 保存结果
@@ -70,14 +79,13 @@ func NewBTCBlockScanner(wm *WalletManager) *BTCBlockScanner {
 		BlockScannerBase: openwallet.NewBlockScannerBase(),
 	}
 
-	bs.extractingCH = make(chan struct{}, maxExtractingSize)
 	bs.wm = wm
 	bs.IsScanMemPool = false
 	bs.RescanLastBlockCount = 0
-	bs.stopSocketIO = make(chan struct{})
+	bs.stopSocketIO = make(chan struct{}, 1)
 
 	//设置扫描任务
-	bs.SetTask(bs.ScanBlockTask)
+	bs.SetTask(bs.scanTask)
 
 	return &bs
 }
@@ -127,6 +135,8 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 			break
 		}
 
+		bs.updateChainHeight(maxHeight, currentHeight)
+
 		//是否已到最新高度
 		if currentHeight >= maxHeight {
 			bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
@@ -165,58 +175,29 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 			bs.wm.Log.Std.Info("block height: %d local hash = %s ", currentHeight-1, currentHash)
 			bs.wm.Log.Std.Info("block height: %d mainnet hash = %s ", currentHeight-1, block.Previousblockhash)
 
-			bs.wm.Log.Std.Info("delete recharge records on block height: %d.", currentHeight-1)
-
-			//删除上一区块链的所有充值记录
-			//bs.DeleteRechargesByHeight(currentHeight - 1)
-			//删除上一区块链的未扫记录
-
-			//查询本地分叉的区块
-			forkBlock, _ := bs.GetLocalBlock(currentHeight - 1)
-
-			bs.DeleteUnscanRecord(currentHeight - 1)
-			currentHeight = currentHeight - 2 //倒退2个区块重新扫描
-			if currentHeight <= 0 {
-				currentHeight = 1
-			}
-
-			localBlock, err := bs.GetLocalBlock(currentHeight)
+			//回滚孤块，直到与主链相同的区块
+			ancestor, err := bs.rollbackForkBlocks(currentHeight - 1)
 			if err != nil {
-				bs.wm.Log.Std.Error("block scanner can not get local block; unexpected error: %v", err)
-
-				//查找core钱包的RPC
-				bs.wm.Log.Info("block scanner prev block height:", currentHeight)
-
-				prevHash, err := bs.wm.GetBlockHash(currentHeight)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
-
-				localBlock, err = bs.wm.GetBlock(prevHash)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+				bs.wm.Log.Std.Error("block scanner can not rollback fork blocks; unexpected error: %v", err)
+				break
 			}
 
+			currentHeight = ancestor.Height
 			//重置当前区块的hash
-			currentHash = localBlock.Hash
+			currentHash = ancestor.Hash
 
 			bs.wm.Log.Std.Info("rescan block on height: %d, hash: %s .", currentHeight, currentHash)
 
-			//重新记录一个新扫描起点
-			bs.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
-
-			isFork = true
-
-			if forkBlock != nil {
+		} else {
 
-				//通知分叉区块给观测者，异步处理
-				bs.newBlockNotify(forkBlock, isFork)
+			//同一高度上未完成的区块已被替换，回滚其中已通知的提取结果
+			if stale, err := bs.reorgJournal().GetBlock(currentHeight); err == nil && stale.Hash != block.Hash {
+				bs.rollbackJournalBlock(stale)
 			}
 
-		} else {
+			if err := bs.reorgJournal().BeginBlock(block); err != nil {
+				bs.wm.Log.Std.Error("block scanner can not write reorg journal; unexpected error: %v", err)
+			}
 
 			err = bs.BatchExtractTransaction(block.Height, block.Hash, block.tx)
 			if err != nil {
@@ -226,9 +207,13 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 			//重置当前区块的hash
 			currentHash = hash
 
-			//保存本地新高度
+			//先完成重组日志，再保存本地新高度，中断后以日志为检查点
+			if err := bs.reorgJournal().CommitBlock(currentHeight); err != nil {
+				bs.wm.Log.Std.Error("block scanner can not commit reorg journal; unexpected error: %v", err)
+			}
 			bs.SaveLocalNewBlock(currentHeight, currentHash)
 			bs.SaveLocalBlock(block)
+			bs.updateChainHeight(0, currentHeight)
 
 			isFork = false
 
@@ -243,6 +228,9 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 		bs.scanBlock(i)
 	}
 
+	//通知达到确认数的交易单
+	bs.newConfirmationNotify(currentHeight)
+
 	if bs.IsScanMemPool {
 		//扫描交易内存池
 		bs.ScanTxMemPool()
@@ -301,26 +289,17 @@ func (bs *BTCBlockScanner) scanBlock(height uint64) (*Block, error) {
 }
 
 //ScanTxMemPool 扫描交易内存池
+//新的交易单只通知一次，之后跟踪到确认、被替换或被驱逐
 func (bs *BTCBlockScanner) ScanTxMemPool() {
 
 	bs.wm.Log.Std.Info("block scanner scanning mempool ...")
 
-	//提取未确认的交易单
-	txIDsInMemPool, err := bs.wm.GetTxIDsInMemPool()
-	if err != nil {
-		bs.wm.Log.Std.Info("block scanner can not get mempool data; unexpected error: %v", err)
+	if bs.wm.supports(CapabilityMemPoolList) {
+		bs.scanMemPoolByCore()
 		return
 	}
 
-	if txIDsInMemPool == nil || len(txIDsInMemPool) == 0 {
-		return
-	}
-
-	err = bs.BatchExtractTransaction(0, "", txIDsInMemPool)
-	if err != nil {
-		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-	}
-
+	bs.scanMemPoolByPending()
 }
 
 //rescanFailedRecord 重扫失败记录
@@ -399,175 +378,58 @@ func (bs *BTCBlockScanner) newBlockNotify(block *Block, isFork bool) {
 	bs.NewBlockNotify(header)
 }
 
-//BatchExtractTransaction 批量提取交易单
-//bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
-func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []string) error {
-
-	var (
-		quit       = make(chan struct{})
-		done       = 0 //完成标记
-		failed     = 0
-		shouldDone = len(txs) //需要完成的总数
-	)
-
-	if len(txs) == 0 {
-		return errors.New("BatchExtractTransaction block is nil.")
-	}
-
-
-	//生产通道
-	producer := make(chan ExtractResult)
-	defer close(producer)
-
-	//消费通道
-	worker := make(chan ExtractResult)
-	defer close(worker)
-
-	//保存工作
-	saveWork := func(height uint64, result chan ExtractResult) {
-		//回收创建的地址
-		for gets := range result {
-
-			if gets.Success {
-
-				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
-				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
-				if notifyErr != nil {
-					failed++ //标记保存失败数
-					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
-				}
-
-				notifyErr = nil
-				notifyErr = bs.newExtractDataNotify(height, gets.extractContractData)
-				if notifyErr != nil {
-					failed++ //标记保存失败数
-					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
-				}
-
-			} else {
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
-				bs.SaveUnscanRecord(unscanRecord)
-				//bs.wm.Log.Std.Info("block height: %d extract failed.", height)
-				failed++ //标记保存失败数
-			}
-			//累计完成的线程数
-			done++
-			if done == shouldDone {
-				//bs.wm.Log.Std.Info("done = %d, shouldDone = %d ", done, len(txs))
-				close(quit) //关闭通道，等于给通道传入nil
-			}
-		}
-	}
-
-	//提取工作
-	extractWork := func(eblockHeight uint64, eBlockHash string, mTxs []string, eProducer chan ExtractResult) {
-		for i, txid := range mTxs {
-			bs.extractingCH <- struct{}{}
-			//第一笔交易是coinbase，第二笔交易是pos收益
-			isCoinstake := false
-			if i == 1 {
-				isCoinstake = true
-			}
-			go func(mBlockHeight uint64, mTxid string, isCoinstake bool, end chan struct{}, mProducer chan<- ExtractResult) {
-
-				//导出提出的交易
-				mProducer <- bs.ExtractTransaction(mBlockHeight, eBlockHash, mTxid, isCoinstake, bs.ScanTargetFuncV2)
-				//释放
-				<-end
-
-			}(eblockHeight, txid, isCoinstake, bs.extractingCH, eProducer)
-		}
-	}
-
-	/*	开启导出的线程	*/
-
-	//独立线程运行消费
-	go saveWork(blockHeight, worker)
-
-	//独立线程运行生产
-	go extractWork(blockHeight, blockHash, txs, producer)
-
-	//以下使用生产消费模式
-	bs.extractRuntime(producer, worker, quit)
-
-	if failed > 0 {
-		return fmt.Errorf("block scanner saveWork failed")
-	} else {
-		return nil
-	}
-
-	//return nil
-}
-
-//extractRuntime 提取运行时
-func (bs *BTCBlockScanner) extractRuntime(producer chan ExtractResult, worker chan ExtractResult, quit chan struct{}) {
-
-	var (
-		values = make([]ExtractResult, 0)
-	)
-
-	for {
-
-		var activeWorker chan<- ExtractResult
-		var activeValue ExtractResult
-
-		//当数据队列有数据时，释放顶部，传输给消费者
-		if len(values) > 0 {
-			activeWorker = worker
-			activeValue = values[0]
-
-		}
+//ExtractTransaction 提取交易单
+func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash string, txid string, isCoinstake bool, scanAddressFunc openwallet.BlockScanTargetFuncV2) ExtractResult {
 
-		select {
+	//bs.wm.Log.Std.Debug("block scanner scanning tx: %s ...", txid)
+	trx, err := bs.wm.GetTransaction(txid)
 
-		//生成者不断生成数据，插入到数据队列尾部
-		case pa := <-producer:
-			values = append(values, pa)
-		case <-quit:
-			//退出
-			//bs.wm.Log.Std.Info("block scanner have been scanned!")
-			return
-		case activeWorker <- activeValue:
-			//wm.Log.Std.Info("Get %d", len(activeValue))
-			values = values[1:]
-		}
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not extract transaction data; unexpected error: %v", err)
+		return ExtractResult{BlockHeight: blockHeight, TxID: txid, Success: false}
 	}
 
-	return
-
+	return bs.extractTransactionResult(trx, blockHeight, blockHash, isCoinstake, scanAddressFunc)
 }
 
-//ExtractTransaction 提取交易单
-func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash string, txid string, isCoinstake bool, scanAddressFunc openwallet.BlockScanTargetFuncV2) ExtractResult {
+//extractTransactionResult 提取已获取的交易单，zmq推送的交易单在本地解码后直接提取
+func (bs *BTCBlockScanner) extractTransactionResult(trx *Transaction, blockHeight uint64, blockHash string, isCoinstake bool, scanAddressFunc openwallet.BlockScanTargetFuncV2) ExtractResult {
 
 	var (
 		result = ExtractResult{
-			BlockHeight:         blockHeight,
-			TxID:                txid,
-			extractData:         make(map[string]*openwallet.TxExtractData),
-			extractContractData: make(map[string]*openwallet.TxExtractData),
+			BlockHeight:             blockHeight,
+			TxID:                    trx.TxID,
+			extractData:             make(map[string]*openwallet.TxExtractData),
+			extractContractData:     make(map[string]*openwallet.TxExtractData),
+			extractContractReceipts: make(map[string][]*openwallet.SmartContractReceipt),
 		}
 	)
 
-	//bs.wm.Log.Std.Debug("block scanner scanning tx: %s ...", txid)
-	trx, err := bs.wm.GetTransaction(txid)
-
-	if err != nil {
-		bs.wm.Log.Std.Info("block scanner can not extract transaction data; unexpected error: %v", err)
-		result.Success = false
-		return result
-	}
-
 	//优先使用传入的高度
 	if blockHeight > 0 && trx.BlockHeight == 0 {
 		trx.BlockHeight = blockHeight
 		trx.BlockHash = blockHash
 	}
+
+	//核心钱包需要通过交易回执提取代币交易，标记gas退款
+	if err := bs.wm.chain().CompleteTransaction(trx, isCoinstake); err != nil {
+		bs.wm.Log.Std.Info("block scanner can not complete transaction data; unexpected error: %v", err)
+		result.Success = false
+		return result
+	}
+	result.immature = trx.isStakeReward(isCoinstake)
+	for _, vin := range trx.Vins {
+		if len(vin.Coinbase) == 0 && len(vin.TxID) > 0 {
+			result.spent = append(result.spent, outPoint(vin.TxID, vin.Vout))
+		}
+	}
+
 	//提取主币交易单
 	bs.extractTransaction(trx, &result, scanAddressFunc)
 	//提取代币交易单
 	bs.extractTokenTransfer(trx, &result, scanAddressFunc)
+	//提取合约回执
+	bs.extractSmartContractReceipts(trx, &result, scanAddressFunc)
 	return result
 
 }
@@ -629,7 +491,7 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 			txType := uint64(0)
 			txAction := ""
 
-			if trx.Isqrc20Transfer {
+			if trx.Isqrc20Transfer || trx.Isqrc721Transfer {
 				txType = 1
 				txAction = "transfer"
 			}
@@ -665,6 +527,12 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 					TxType:      txType,
 					TxAction:    txAction,
 				}
+
+				//只收到gas退款的账户
+				if isGasRefundOnly(extractData) {
+					tx.TxType = 101
+					tx.TxAction = "gasRefund"
+				}
 				wxID := openwallet.GenTransactionWxID(tx)
 				tx.WxID = wxID
 				extractData.Transaction = tx
@@ -681,6 +549,19 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 
 }
 
+//isGasRefundOnly 账户在交易单中只有gas退款的输出
+func isGasRefundOnly(extractData *openwallet.TxExtractData) bool {
+	if len(extractData.TxInputs) > 0 || len(extractData.TxOutputs) == 0 {
+		return false
+	}
+	for _, output := range extractData.TxOutputs {
+		if output.TxType != 101 {
+			return false
+		}
+	}
+	return true
+}
+
 //ExtractTxInput 提取交易单输入部分
 func (bs *BTCBlockScanner) extractTxInput(trx *Transaction, isCoinstake bool, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) ([]string, decimal.Decimal) {
 
@@ -813,6 +694,12 @@ func (bs *BTCBlockScanner) extractTxOutput(trx *Transaction, isCoinstake bool, r
 			outPut.Confirm = int64(confirmations)
 			outPut.TxType = txType
 
+			//合约调用未使用的gas退款，不是普通的收款
+			if output.IsRefund {
+				outPut.TxType = 101
+				outPut.SetExtParam("gasRefund", true)
+			}
+
 			//transactions = append(transactions, &transaction)
 
 			ed := result.extractData[targetResult.SourceKey]
@@ -834,6 +721,38 @@ func (bs *BTCBlockScanner) extractTxOutput(trx *Transaction, isCoinstake bool, r
 	return to, totalAmount
 }
 
+//newTokenTransaction 代币交易单，手续费为合约调用实际消耗的gas，合约执行失败时状态为失败
+func (bs *BTCBlockScanner) newTokenTransaction(trx *Transaction, tokenReceipt *TokenReceipt, coin openwallet.Coin, extParam string) *openwallet.Transaction {
+
+	fees := tokenReceipt.Fees
+	if len(fees) == 0 {
+		fees = "0"
+	}
+
+	tx := &openwallet.Transaction{
+		From:        []string{tokenReceipt.From + ":" + tokenReceipt.Amount},
+		To:          []string{tokenReceipt.To + ":" + tokenReceipt.Amount},
+		Fees:        fees,
+		Coin:        coin,
+		BlockHash:   tokenReceipt.BlockHash,
+		BlockHeight: tokenReceipt.BlockHeight,
+		TxID:        tokenReceipt.TxHash,
+		Decimal:     0,
+		ConfirmTime: trx.Blocktime,
+		Status:      openwallet.TxStatusSuccess,
+		TxType:      0,
+		ExtParam:    extParam,
+	}
+
+	if tokenReceipt.IsExcepted() {
+		tx.Status = openwallet.TxStatusFail
+		tx.Reason = tokenReceipt.Excepted
+	}
+
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+	return tx
+}
+
 //extractTokenTransfer 提取交易单中的代币交易
 func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
 
@@ -846,13 +765,27 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 		success = false
 	} else {
 
-		if trx.Isqrc20Transfer {
+		if trx.Isqrc20Transfer || trx.Isqrc721Transfer {
 			createAt := time.Now().Unix()
 			//QTUM交易单目前只允许包含一个代币交易
 			for _, tokenReceipt := range trx.TokenReceipts {
 
 				contractId := openwallet.GenContractID(bs.wm.Symbol(), tokenReceipt.ContractAddress)
 
+				protocol := tokenReceipt.Protocol
+				if len(protocol) == 0 {
+					protocol = QRC20Protocol
+				}
+
+				//QRC721的记录以tokenId区分，同一交易单可以转移多个tokenId
+				sidKey := contractId
+				extParam := ""
+				if protocol == QRC721Protocol {
+					sidKey = contractId + "_" + tokenReceipt.TokenID
+					ext, _ := json.Marshal(map[string]string{"tokenID": tokenReceipt.TokenID})
+					extParam = string(ext)
+				}
+
 				coin := openwallet.Coin{
 					Symbol:     bs.wm.Symbol(),
 					IsContract: true,
@@ -860,11 +793,32 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 					Contract: openwallet.SmartContract{
 						ContractID: contractId,
 						Address:    tokenReceipt.ContractAddress,
-						Protocol:   "qrc20",
+						Protocol:   protocol,
 						Symbol:     bs.wm.Symbol(),
 					},
 				}
 
+				//合约执行失败，代币没有转移，只给支付gas的发送者记录失败的交易单
+				if tokenReceipt.IsExcepted() {
+					sender := tokenReceipt.Sender
+					if len(sender) == 0 {
+						sender = tokenReceipt.From
+					}
+					senderResult := scanAddressFunc(openwallet.ScanTargetParam{
+						ScanTarget:     sender,
+						Symbol:         bs.wm.Symbol(),
+						ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+					if senderResult.Exist {
+						ed := result.extractContractData[senderResult.SourceKey]
+						if ed == nil {
+							ed = openwallet.NewBlockExtractData()
+							result.extractContractData[senderResult.SourceKey] = ed
+						}
+						ed.Transaction = bs.newTokenTransaction(trx, tokenReceipt, coin, extParam)
+					}
+					continue
+				}
+
 				targetResult := scanAddressFunc(openwallet.ScanTargetParam{
 					ScanTarget:     tokenReceipt.From,
 					Symbol:         bs.wm.Symbol(),
@@ -877,7 +831,7 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 					input.Amount = tokenReceipt.Amount
 					input.Coin = coin
 					input.Index = 0
-					input.Sid = openwallet.GenTxInputSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, 0)
+					input.Sid = openwallet.GenTxInputSID(tokenReceipt.TxHash, bs.wm.Symbol(), sidKey, 0)
 					//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
 					input.CreateAt = createAt
 					//在哪个区块高度时消费
@@ -909,7 +863,8 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 
 					output.Coin = coin
 					output.Index = 0
-					output.Sid = openwallet.GenTxOutPutSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, 0)
+					output.Sid = openwallet.GenTxOutPutSID(tokenReceipt.TxHash, bs.wm.Symbol(), sidKey, 0)
+					output.ExtParam = extParam
 					//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
 					output.CreateAt = createAt
 					//在哪个区块高度时消费
@@ -927,25 +882,8 @@ func (bs *BTCBlockScanner) extractTokenTransfer(trx *Transaction, result *Extrac
 					ed.TxOutputs = append(ed.TxOutputs, &output)
 				}
 
-				blocktime := trx.Blocktime
-
 				for _, extractData := range result.extractContractData {
-					tx := &openwallet.Transaction{
-						From:        []string{tokenReceipt.From + ":" + tokenReceipt.Amount},
-						To:          []string{tokenReceipt.To + ":" + tokenReceipt.Amount},
-						Fees:        "0",
-						Coin:        coin,
-						BlockHash:   tokenReceipt.BlockHash,
-						BlockHeight: tokenReceipt.BlockHeight,
-						TxID:        tokenReceipt.TxHash,
-						Decimal:     0,
-						ConfirmTime: blocktime,
-						Status:      openwallet.TxStatusSuccess,
-						TxType:      0,
-					}
-					wxID := openwallet.GenTransactionWxID(tx)
-					tx.WxID = wxID
-					extractData.Transaction = tx
+					extractData.Transaction = bs.newTokenTransaction(trx, tokenReceipt, coin, extParam)
 
 					//bs.wm.Log.Debug("Transaction:", extractData.Transaction)
 				}
@@ -984,6 +922,31 @@ func (bs *BTCBlockScanner) newExtractDataNotify(height uint64, extractData map[s
 	return nil
 }
 
+//newSmartContractReceiptNotify 发送合约回执通知，观察者通知失败时记录未扫区块，返回最后一个失败的错误
+func (bs *BTCBlockScanner) newSmartContractReceiptNotify(height uint64, receipts map[string][]*openwallet.SmartContractReceipt) error {
+
+	var notifyErr error
+	for o, _ := range bs.Observers {
+		for key, list := range receipts {
+			for _, data := range list {
+				err := o.BlockExtractSmartContractDataNotify(key, data)
+				if err != nil {
+					notifyErr = err
+					bs.wm.Log.Error("BlockExtractSmartContractDataNotify unexpected error:", err)
+					//记录未扫区块
+					unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractSmartContractData Notify failed.", bs.wm.Symbol())
+					err = bs.SaveUnscanRecord(unscanRecord)
+					if err != nil {
+						bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
+					}
+				}
+			}
+		}
+	}
+
+	return notifyErr
+}
+
 //DeleteUnscanRecordNotFindTX 删除未没有找到交易记录的重扫记录
 func (bs *BTCBlockScanner) DeleteUnscanRecordNotFindTX() error {
 
@@ -1018,6 +981,11 @@ func (bs *BTCBlockScanner) GetScannedBlockHeader() (*openwallet.BlockHeader, err
 
 	blockHeight, hash, _ = bs.GetLocalNewBlock()
 
+	//保存本地高度前中断时，以重组日志中已完成的区块为检查点
+	if last, err := bs.reorgJournal().LastCommittedBlock(); err == nil && last.Height > blockHeight {
+		blockHeight, hash = last.Height, last.Hash
+	}
+
 	//如果本地没有记录，查询接口的高度
 	if blockHeight == 0 {
 		blockHeight, err = bs.wm.GetBlockHeight()
@@ -1086,6 +1054,30 @@ func (bs *BTCBlockScanner) ExtractTransactionData(txid string, scanTargetFunc op
 		return nil, fmt.Errorf("extract transaction failed")
 	}
 
+	return result.mergeExtractData(), nil
+}
+
+//ExtractTransactionAndReceiptData 提取交易单及合约回执，同一合约有多个回执时只返回第一个
+func (bs *BTCBlockScanner) ExtractTransactionAndReceiptData(txid string, scanTargetFunc openwallet.BlockScanTargetFuncV2) (map[string][]*openwallet.TxExtractData, map[string]*openwallet.SmartContractReceipt, error) {
+
+	result := bs.ExtractTransaction(0, "", txid, false, scanTargetFunc)
+	if !result.Success {
+		return nil, nil, fmt.Errorf("extract transaction failed")
+	}
+
+	receipts := make(map[string]*openwallet.SmartContractReceipt)
+	for key, list := range result.extractContractReceipts {
+		if len(list) > 0 {
+			receipts[key] = list[0]
+		}
+	}
+
+	return result.mergeExtractData(), receipts, nil
+}
+
+//mergeExtractData 合并主链和代币的提取结果
+func (result *ExtractResult) mergeExtractData() map[string][]*openwallet.TxExtractData {
+
 	extData := make(map[string][]*openwallet.TxExtractData)
 	for key, data := range result.extractData {
 		txs := extData[key]
@@ -1105,7 +1097,7 @@ func (bs *BTCBlockScanner) ExtractTransactionData(txid string, scanTargetFunc op
 		extData[key] = txs
 	}
 
-	return extData, nil
+	return extData
 }
 
 //DropRechargeRecords 清楚钱包的全部充值记录
@@ -1188,11 +1180,7 @@ func (bs *BTCBlockScanner) SaveUnscanRecord(record *openwallet.UnscanRecord) err
 //GetBlockHeight 获取区块链高度
 func (wm *WalletManager) GetBlockHeight() (uint64, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getBlockHeightByExplorer()
-	} else {
-		return wm.getBlockHeightByCore()
-	}
+	return wm.chain().GetBlockHeight()
 }
 
 //getBlockHeightByCore 获取区块链高度
@@ -1260,11 +1248,7 @@ func (bs *BTCBlockScanner) SaveLocalBlock(block *Block) error {
 //GetBlockHash 根据区块高度获得区块hash
 func (wm *WalletManager) GetBlockHash(height uint64) (string, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getBlockHashByExplorer(height)
-	} else {
-		return wm.getBlockHashByCore(height)
-	}
+	return wm.chain().GetBlockHash(height)
 }
 
 //getBlockHashByCore 根据区块高度获得区块hash
@@ -1274,9 +1258,11 @@ func (wm *WalletManager) getBlockHashByCore(height uint64) (string, error) {
 		height,
 	}
 
-	result, err := wm.WalletClient.Call("getblockhash", request)
+	result, err := wm.WalletClient.CallQuorum("getblockhash", request, func(result *gjson.Result) string {
+		return result.String()
+	})
 	if err != nil {
-		return "", err
+		return "", wm.reportQuorum("getblockhash", err)
 	}
 
 	return result.String(), nil
@@ -1305,11 +1291,7 @@ func (bs *BTCBlockScanner) GetLocalBlock(height uint64) (*Block, error) {
 //GetBlock 获取区块数据
 func (wm *WalletManager) GetBlock(hash string) (*Block, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getBlockByExplorer(hash)
-	} else {
-		return wm.getBlockByCore(hash)
-	}
+	return wm.chain().GetBlock(hash)
 }
 
 //getBlockByCore 获取区块数据
@@ -1330,11 +1312,7 @@ func (wm *WalletManager) getBlockByCore(hash string) (*Block, error) {
 //GetTxIDsInMemPool 获取待处理的交易池中的交易单IDs
 func (wm *WalletManager) GetTxIDsInMemPool() ([]string, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getTxIDsInMemPoolByExplorer()
-	} else {
-		return wm.getTxIDsInMemPoolByCore()
-	}
+	return wm.chain().GetTxIDsInMemPool()
 }
 
 //getTxIDsInMemPoolByCore 获取待处理的交易池中的交易单IDs
@@ -1363,24 +1341,30 @@ func (wm *WalletManager) getTxIDsInMemPoolByCore() ([]string, error) {
 //GetTransaction 获取交易单
 func (wm *WalletManager) GetTransaction(txid string) (*Transaction, error) {
 
-	//request := []interface{}{
-	//	txid,
-	//	true,
-	//}
-	//
-	//result, err := wm.WalletClient.Call("getrawtransaction", request)
-	//if err != nil {
-	//	return nil, err
-	//}
-	//
-	//return result, nil
-
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getTransactionByExplorer(txid)
-	} else {
-		return wm.getTransactionByCore(txid)
+	return wm.chain().GetTransaction(txid)
+}
+
+//transactionBatcher 支持一次请求获取多个交易单的后端
+type transactionBatcher interface {
+	GetTransactions(txids []string) ([]*Transaction, error)
+}
+
+//GetTransactions 批量获取交易单，结果与txids的顺序一致，任一交易单失败时返回错误
+func (wm *WalletManager) GetTransactions(txids []string) ([]*Transaction, error) {
+
+	if batcher, ok := wm.chain().(transactionBatcher); ok {
+		return batcher.GetTransactions(txids)
 	}
 
+	trxs := make([]*Transaction, 0, len(txids))
+	for _, txid := range txids {
+		trx, err := wm.GetTransaction(txid)
+		if err != nil {
+			return nil, err
+		}
+		trxs = append(trxs, trx)
+	}
+	return trxs, nil
 }
 
 //getTransactionByCore 获取交易单
@@ -1396,17 +1380,131 @@ func (wm *WalletManager) getTransactionByCore(txid string) (*Transaction, error)
 		return nil, err
 	}
 
-	return newTxByCore(result, wm.Config.isTestNet), nil
+	return newTxByCore(result, wm.Config.Network), nil
+}
+
+//getTransactionsByCore 通过一次json-rpc批量调用获取交易单
+func (wm *WalletManager) getTransactionsByCore(txids []string) ([]*Transaction, error) {
+
+	requests := make([]RPCRequest, 0, len(txids))
+	for _, txid := range txids {
+		requests = append(requests, RPCRequest{Method: "getrawtransaction", Params: []interface{}{txid, true}})
+	}
+
+	responses, err := wm.WalletClient.Batch(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	trxs := make([]*Transaction, 0, len(txids))
+	for i, resp := range responses {
+		if resp.Err != nil {
+			return nil, fmt.Errorf("get transaction %s failed: %v", txids[i], resp.Err)
+		}
+		trxs = append(trxs, newTxByCore(&resp.Result, wm.Config.Network))
+	}
+
+	return trxs, nil
+}
+
+//GetContractReceiptsByCore 通过gettransactionreceipt查询合约调用的回执，提取代币转账记录
+func (wm *WalletManager) GetContractReceiptsByCore(txid string) ([]*ContractReceipt, error) {
+
+	request := []interface{}{
+		txid,
+	}
+
+	result, err := wm.WalletClient.Call("gettransactionreceipt", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return newContractReceiptsByCore(result, wm.Config.Network), nil
+}
+
+//fillTokenReceiptsByCore 核心钱包的交易单没有代币转账记录，需要查询已确认的合约调用交易回执
+func (wm *WalletManager) fillTokenReceiptsByCore(trx *Transaction) error {
+
+	if len(trx.BlockHash) == 0 || !trx.HasContractCall() {
+		return nil
+	}
+
+	receipts, err := wm.GetContractReceiptsByCore(trx.TxID)
+	if err != nil {
+		return err
+	}
+
+	trx.TokenReceipts = make([]*TokenReceipt, 0)
+	trx.ContractReceipts = make([]*ContractReceipt, 0)
+	trx.applyContractReceipts(receipts, wm.Config.Network, wm.Decimal())
+
+	return nil
+}
+
+//markGasRefundsByCore 标记coinbase或coinstake交易中的gas退款输出
+//区块中合约调用未使用的gas = (gasLimit - gasUsed) * gasPrice，由矿工按交易顺序追加到coinbase或coinstake交易的输出，退回给发送者
+func (wm *WalletManager) markGasRefundsByCore(trx *Transaction) error {
+
+	if len(trx.BlockHash) == 0 {
+		return nil
+	}
+
+	request := []interface{}{
+		trx.BlockHash,
+		2,
+	}
+
+	result, err := wm.WalletClient.Call("getblock", request)
+	if err != nil {
+		return err
+	}
+
+	type gasRefund struct {
+		address string
+		amount  decimal.Decimal
+	}
+	refunds := make([]gasRefund, 0)
+
+	for _, txJSON := range result.Get("tx").Array() {
+		tx := newTxByCore(&txJSON, wm.Config.Network)
+		if tx.TxID == trx.TxID || !tx.HasContractCall() {
+			continue
+		}
+
+		receipts, err := wm.GetContractReceiptsByCore(tx.TxID)
+		if err != nil {
+			return err
+		}
+
+		for _, receipt := range receipts {
+			script := tx.contractScript(receipt.OutputIndex)
+			if script == nil || script.GasLimit <= receipt.GasUsed {
+				continue
+			}
+			amount := decimal.New(int64(script.GasLimit-receipt.GasUsed), 0).Mul(decimal.New(int64(script.GasPrice), 0)).Shift(-wm.Decimal())
+			refunds = append(refunds, gasRefund{address: receipt.Sender, amount: amount})
+		}
+	}
+
+	//退款追加在输出的最后，从后往前匹配
+	for _, refund := range refunds {
+		for i := len(trx.Vouts) - 1; i >= 0; i-- {
+			vout := trx.Vouts[i]
+			value, _ := decimal.NewFromString(vout.Value)
+			if !vout.IsRefund && vout.Addr == refund.address && value.Equal(refund.amount) {
+				vout.IsRefund = true
+				break
+			}
+		}
+	}
+
+	return nil
 }
 
 //GetTxOut 获取交易单输出信息，用于追溯交易单输入源头
 func (wm *WalletManager) GetTxOut(txid string, vout uint64) (*Vout, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.getTxOutByExplorer(txid, vout)
-	} else {
-		return wm.getTxOutByCore(txid, vout)
-	}
+	return wm.chain().GetTxOut(txid, vout)
 }
 
 //getTxOutByCore 获取交易单输出信息，用于追溯交易单输入源头
@@ -1417,12 +1515,15 @@ func (wm *WalletManager) getTxOutByCore(txid string, vout uint64) (*Vout, error)
 		vout,
 	}
 
-	result, err := wm.WalletClient.Call("gettxout", request)
+	//已花费的输出返回null，节点之间花费状态不一致时也视为不一致
+	result, err := wm.WalletClient.CallQuorum("gettxout", request, func(result *gjson.Result) string {
+		return result.Get("value").String() + ":" + result.Get("scriptPubKey.hex").String()
+	})
 	if err != nil {
-		return nil, err
+		return nil, wm.reportQuorum("gettxout", err)
 	}
 
-	output := newTxVoutByCore(result, wm.Config.isTestNet)
+	output := newTxVoutByCore(result, wm.Config.Network)
 
 	/*
 		{
@@ -1544,11 +1645,17 @@ func (wm *WalletManager) calculateUnspent(utxos []*Unspent) map[string]*openwall
 func (bs *BTCBlockScanner) Run() error {
 
 	//使用浏览器，开启socketIO监听内存池交易
-	//if bs.wm.Config.RPCServerType == RPCServerExplorer {
-	//	if bs.socketIO == nil {
-	//		go bs.setupSocketIO()
-	//	}
-	//}
+	if bs.wm.Config.RPCServerType == RPCServerExplorer && bs.IsScanMemPool {
+		if bs.socketIO == nil {
+			go bs.setupSocketIO()
+		}
+	}
+
+	//核心钱包配置了zmq时，由推送驱动扫块和提取交易池的交易单
+	bs.setupZMQ()
+
+	//使用ElectrumX时，由区块头和地址的订阅通知驱动扫描
+	bs.setupElectrum()
 
 	bs.BlockScannerBase.Run()
 
@@ -1558,15 +1665,22 @@ func (bs *BTCBlockScanner) Run() error {
 ////Stop 停止扫描
 func (bs *BTCBlockScanner) Stop() error {
 
-	//if bs.socketIO != nil {
-	//	bs.socketIO.Close()
-	//	bs.socketIO = nil
-	//}
+	//通知停止线程，重连等待中的线程在等待结束后退出
+	if bs.wm.Config.RPCServerType == RPCServerExplorer && bs.IsScanMemPool {
+		select {
+		case bs.stopSocketIO <- struct{}{}:
+		default:
+		}
+	}
 
-	//通知停止线程
-	//bs.stopSocketIO <- struct{}{}
+	bs.closeZMQ()
+	bs.closeElectrum()
 
 	bs.BlockScannerBase.Stop()
+
+	if bs.journal != nil {
+		bs.journal.Close()
+	}
 	return nil
 }
 
@@ -1578,7 +1692,7 @@ func (bs *BTCBlockScanner) connectSocketIO(disconnected chan struct{}) (*gosocke
 		room = "inv"
 	)
 
-	apiUrl, err := url.Parse(bs.wm.Config.serverAPI)
+	apiUrl, err := url.Parse(bs.wm.ExplorerClient.Endpoints.Primary())
 	if err != nil {
 		return nil, err
 	}
@@ -1596,11 +1710,10 @@ func (bs *BTCBlockScanner) connectSocketIO(disconnected chan struct{}) (*gosocke
 		//bs.wm.Log.Info("block scanner socketIO get new transaction received: ", args)
 		txMap, ok := args.(map[string]interface{})
 		if ok {
-			txid := txMap["txid"].(string)
+			txid, _ := txMap["txid"].(string)
 			//bs.wm.Log.Debugf("new tx: %s", txid)
-			errInner := bs.BatchExtractTransaction(0, "", []string{txid})
-			if errInner != nil {
-				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", errInner)
+			if len(txid) > 0 {
+				bs.extractPendingTx(txid)
 			}
 		}
 
@@ -1612,7 +1725,10 @@ func (bs *BTCBlockScanner) connectSocketIO(disconnected chan struct{}) (*gosocke
 
 	err = socketIO.On(gosocketio.OnDisconnection, func(h *gosocketio.Channel) {
 		bs.wm.Log.Info("block scanner socketIO disconnected")
-		disconnected <- struct{}{}
+		select {
+		case disconnected <- struct{}{}:
+		default:
+		}
 	})
 	if err != nil {
 		socketIO.Close()
@@ -1646,9 +1762,12 @@ func (bs *BTCBlockScanner) setupSocketIO() error {
 		socketIO      *gosocketio.Client
 	)
 
+	//断开连接的回调可能在退出后执行，通道不关闭
 	defer func() {
-		close(reconnect)
-		close(disconnected)
+		if bs.socketIO != nil {
+			bs.socketIO.Close()
+			bs.socketIO = nil
+		}
 	}()
 
 	//启动连接
@@ -1663,7 +1782,10 @@ func (bs *BTCBlockScanner) setupSocketIO() error {
 			bs.socketIO = socketIO
 			if err != nil {
 				bs.wm.Log.Errorf("Connect socketIO failed unexpected error: %v", err)
-				disconnected <- struct{}{}
+				select {
+				case disconnected <- struct{}{}:
+				default:
+				}
 			}
 
 		case <-disconnected:
@@ -152,7 +152,7 @@ func Decode(input string, alphabet *Alphabet) ([]byte, error) {
 //return prefix + hash + error
 func DecodeCheck(address string) (byte, []byte, error) {
 	ret, err := Decode(address, BitcoinAlphabet)
-	if err != nil {
+	if err != nil || len(ret) < 5 {
 		return 0, nil, errors.New("Invalid address!")
 	}
 	checksum := owcrypt.Hash(ret[:len(ret)-4], 0, owcrypt.HASH_ALG_DOUBLE_SHA256)[:4]
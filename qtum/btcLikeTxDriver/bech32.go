@@ -215,3 +215,70 @@ func Bech32Decode(address string) ([]byte, error) {
 	}
 	return bytePayload, nil
 }
+
+//Bech32DecodeWithPrefix 解析指定前缀的隔离见证v0地址，返回见证程序（P2WPKH 20字节，P2WSH 32字节）
+func Bech32DecodeWithPrefix(address, prefix string) ([]byte, error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return nil, ErrorInvalidAddress
+	}
+	address = strings.ToLower(address)
+	if prefix == "" || !strings.HasPrefix(address, prefix+"1") {
+		return nil, ErrorInvalidAddress
+	}
+
+	data := address[len(prefix)+1:]
+	if len(data) < 7 {
+		return nil, ErrorInvalidAddress
+	}
+	value := make([]int8, len(data))
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c > 127 || CHARSET_REV[c] == -1 {
+			return nil, ErrorInvalidAddress
+		}
+		value[i] = CHARSET_REV[c]
+	}
+	if !verifyChecksum(prefix, value) {
+		return nil, ErrorInvalidAddress
+	}
+
+	//第一个字符为见证版本，只支持v0
+	if value[0] != 0 {
+		return nil, ErrorInvalidAddress
+	}
+
+	program, err := convertBits5To8(value[1 : len(value)-6])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(program) != 0x14 && len(program) != 0x20 {
+		return nil, ErrorInvalidAddress
+	}
+
+	return program, nil
+}
+
+func convertBits5To8(data []int8) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	ret := make([]byte, 0, len(data)*5/8)
+	for _, v := range data {
+		acc = (acc << 5) | uint32(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			ret = append(ret, byte(acc>>bits))
+		}
+	}
+	//填充位不能超过4位且必须为0
+	if bits >= 5 || (acc<<(8-bits))&0xff != 0 {
+		return nil, ErrorInvalidAddress
+	}
+	return ret, nil
+}
+
+//IsBech32Address 判断地址是否使用指定的bech32前缀
+func IsBech32Address(address, prefix string) bool {
+	return prefix != "" && strings.HasPrefix(strings.ToLower(address), prefix+"1")
+}
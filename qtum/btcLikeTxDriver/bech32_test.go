@@ -0,0 +1,76 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func Test_bech32_qtum_vectors(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		program string
+		address string
+	}{
+		{"qc", "751e76e8199196d454941c45d1b3a323f1433bd6", "qc1qw508d6qejxtdg4y5r3zarvary0c5xw7kq52at0"},
+		{"tq", "751e76e8199196d454941c45d1b3a323f1433bd6", "tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f"},
+		{"qc", "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262", "qc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qtd3g7a"},
+		{"tq", "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262", "tq1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qucc3th"},
+	}
+
+	for _, test := range tests {
+		program, _ := hex.DecodeString(test.program)
+
+		address := Bech32Encode(test.prefix, BTCBech32Alphabet, program)
+		if address != test.address {
+			t.Errorf("encode failed, expected: %s, got: %s", test.address, address)
+		}
+
+		decoded, err := Bech32DecodeWithPrefix(test.address, test.prefix)
+		if err != nil || hex.EncodeToString(decoded) != test.program {
+			t.Errorf("decode failed, expected: %s, got: %x, err: %v", test.program, decoded, err)
+		}
+	}
+
+	invalid := []struct {
+		prefix  string
+		address string
+	}{
+		//比特币前缀
+		{"qc", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		//网络不匹配
+		{"qc", "tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f"},
+		//校验和错误
+		{"qc", "qc1qw508d6qejxtdg4y5r3zarvary0c5xw7kq52at1"},
+		//大小写混合
+		{"qc", "qc1Qw508d6qejxtdg4y5r3zarvary0c5xw7kq52at0"},
+	}
+	for _, test := range invalid {
+		if _, err := Bech32DecodeWithPrefix(test.address, test.prefix); err == nil {
+			t.Errorf("decode should fail: %s", test.address)
+		}
+	}
+}
+
+func Test_txOut_bech32(t *testing.T) {
+	vouts := []Vout{
+		{"tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f", 1000},
+		{"qUEeiBfBZiTuHKvPA85a1u5PeeMkLnNF3K", 2000},
+	}
+
+	txOuts, err := newTxOutForEmptyTrans(vouts, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create outputs failed: %v", err)
+	}
+
+	if len(txOuts) != len(vouts) {
+		t.Fatalf("expected %d outputs, got %d", len(vouts), len(txOuts))
+	}
+
+	if hex.EncodeToString(txOuts[0].lockScript) != "0014751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Errorf("wrong P2WPKH lock script: %x", txOuts[0].lockScript)
+	}
+
+	if hex.EncodeToString(txOuts[1].lockScript) != "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac" {
+		t.Errorf("wrong P2PKH lock script: %x", txOuts[1].lockScript)
+	}
+}
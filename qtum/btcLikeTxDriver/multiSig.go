@@ -3,20 +3,87 @@ package btcLikeTxDriver
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
 
 	"github.com/blocktree/go-owcrypt"
 )
 
+//CreateMultiSig 创建P2SH-P2WSH多重签名地址，返回地址和赎回脚本
 func CreateMultiSig(required byte, pubkeys [][]byte, addressPrefix AddressPrefix) (string, string, error) {
+	redeem, err := buildMultiSigRedeemScript(required, pubkeys)
+	if err != nil {
+		return "", "", err
+	}
+
+	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)
+	redeemHash = append([]byte{0x00, 0x20}, redeemHash...)
+	redeemHash = owcrypt.Hash(redeemHash, 0, owcrypt.HASH_ALG_HASH160)
+
+	return EncodeCheck(addressPrefix.P2SHPrefix, redeemHash), hex.EncodeToString(redeem), nil
+}
+
+//CreateMultiSigP2SH 创建非隔离见证的P2SH多重签名地址，返回地址和赎回脚本
+func CreateMultiSigP2SH(required byte, pubkeys [][]byte, addressPrefix AddressPrefix) (string, string, error) {
+	redeem, err := buildMultiSigRedeemScript(required, pubkeys)
+	if err != nil {
+		return "", "", err
+	}
+
+	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_HASH160)
+
+	return EncodeCheck(addressPrefix.P2SHPrefix, redeemHash), hex.EncodeToString(redeem), nil
+}
+
+//CreateMultiSigP2WSH 创建原生隔离见证P2WSH多重签名地址，返回地址和见证脚本
+func CreateMultiSigP2WSH(required byte, pubkeys [][]byte, addressPrefix AddressPrefix) (string, string, error) {
+	redeem, err := buildMultiSigRedeemScript(required, pubkeys)
+	if err != nil {
+		return "", "", err
+	}
+
+	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)
+
+	return Bech32Encode(addressPrefix.Bech32Prefix, BTCBech32Alphabet, redeemHash), hex.EncodeToString(redeem), nil
+}
+
+//ParseMultiSigRedeemScript 解析多重签名赎回脚本，返回所需签名数和公钥列表
+func ParseMultiSigRedeemScript(redeemScript string) (int, [][]byte, error) {
+	redeem, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
+	}
+	return decodeMultiSigScript(redeem)
+}
+
+//FindMultiSigSigner 在赎回脚本的公钥中查找与签名匹配的公钥，签名为64字节r||s格式
+func FindMultiSigSigner(redeemScript, hash string, signature []byte) ([]byte, error) {
+	_, pubkeys, err := ParseMultiSigRedeemScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != 32 {
+		return nil, errors.New("Invalid transaction hash data!")
+	}
+
+	for _, pubkey := range pubkeys {
+		if verifyHash(hashBytes, SignaturePubkey{signature, pubkey}) {
+			return pubkey, nil
+		}
+	}
+	return nil, errors.New("Signature is not match any pubkey of the redeem script!")
+}
 
+func buildMultiSigRedeemScript(required byte, pubkeys [][]byte) ([]byte, error) {
 	if required < 1 {
-		return "", "", errors.New("A multisignature address must require at least one key to redeem!")
+		return nil, errors.New("A multisignature address must require at least one key to redeem!")
 	}
 	if required > byte(len(pubkeys)) {
-		return "", "", errors.New("Not enough keys supplied for a multisignature address to redeem!")
+		return nil, errors.New("Not enough keys supplied for a multisignature address to redeem!")
 	}
 	if len(pubkeys) > 16 {
-		return "", "", errors.New("Number of keys involved in the multisignature address creation is too big!")
+		return nil, errors.New("Number of keys involved in the multisignature address creation is too big!")
 	}
 
 	redeem := []byte{}
@@ -25,7 +92,7 @@ func CreateMultiSig(required byte, pubkeys [][]byte, addressPrefix AddressPrefix
 
 	for _, k := range pubkeys {
 		if len(k) != 33 && len(k) != 65 {
-			return "", "", errors.New("Invalid pubkey data for multisignature address!")
+			return nil, errors.New("Invalid pubkey data for multisignature address!")
 		}
 		redeem = append(redeem, byte(len(k)))
 		redeem = append(redeem, k...)
@@ -36,69 +103,91 @@ func CreateMultiSig(required byte, pubkeys [][]byte, addressPrefix AddressPrefix
 	redeem = append(redeem, OpCheckMultiSig)
 
 	if len(redeem) > MaxScriptElementSize {
-		return "", "", errors.New("Redeem script exceeds size limit!")
+		return nil, errors.New("Redeem script exceeds size limit!")
 	}
 
-	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)
-	redeemHash = append([]byte{0x00, 0x20}, redeemHash...)
-	redeemHash = owcrypt.Hash(redeemHash, 0, owcrypt.HASH_ALG_HASH160)
+	return redeem, nil
+}
 
-	return EncodeCheck(addressPrefix.P2SHPrefix, redeemHash), hex.EncodeToString(redeem), nil
+func isMultiSigScript(script []byte) bool {
+	_, _, err := decodeMultiSigScript(script)
+	return err == nil
 }
 
-func (t Transaction) isMultiSig() bool {
-	if len(t.Vins) != 1 {
-		return false
-	}
-	if t.Vins[0].ScriptPubkeySignature == nil {
-		return false
+//decodeMultiSigScript 解析 OP_m <pubkey>... OP_n OP_CHECKMULTISIG
+func decodeMultiSigScript(script []byte) (int, [][]byte, error) {
+	if len(script) < 3 || script[len(script)-1] != OpCheckMultiSig {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
 	}
-	if t.Vins[0].ScriptPubkeySignature[len(t.Vins[0].ScriptPubkeySignature)-1] != OpCheckMultiSig {
-		return false
+	if script[0] < OpCode_1 || script[0] > OpCode_1+15 {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
 	}
-	return true
-}
+	required := int(script[0]-OpCode_1) + 1
 
-func (t Contract) isMultiSig() bool {
-	if len(t.Vins) != 1 {
-		return false
-	}
-	if t.Vins[0].ScriptPubkeySignature == nil {
-		return false
+	pubkeys := [][]byte{}
+	index := 1
+	for index < len(script)-2 {
+		length := int(script[index])
+		if (length != 33 && length != 65) || index+1+length > len(script)-2 {
+			return 0, nil, errors.New("Invalid multisig redeem script!")
+		}
+		pubkeys = append(pubkeys, script[index+1:index+1+length])
+		index += 1 + length
 	}
-	if t.Vins[0].ScriptPubkeySignature[len(t.Vins[0].ScriptPubkeySignature)-1] != OpCheckMultiSig {
-		return false
+
+	if int(script[len(script)-2]-OpCode_1)+1 != len(pubkeys) || required > len(pubkeys) {
+		return 0, nil, errors.New("Invalid multisig redeem script!")
 	}
-	return true
+	return required, pubkeys, nil
 }
 
-func isMultiSig(lockScript, redeemScript string) bool {
-	if len(lockScript) != 0x17*2 {
-		return false
+//orderMultiSigSignatures 按赎回脚本中公钥的顺序挑选出所需数量的签名
+func orderMultiSigSignatures(redeem []byte, sigPubs []SignaturePubkey) ([]SignaturePubkey, error) {
+	required, pubkeys, err := decodeMultiSigScript(redeem)
+	if err != nil {
+		return nil, err
 	}
 
-	lockBytes, err := hex.DecodeString(lockScript)
-	if err != nil {
-		return false
+	ret := []SignaturePubkey{}
+	for _, pubkey := range pubkeys {
+		if len(ret) == required {
+			break
+		}
+		for _, sp := range sigPubs {
+			if hex.EncodeToString(sp.Pubkey) == hex.EncodeToString(pubkey) {
+				if sp.Signature == nil || len(sp.Signature) != 64 {
+					return nil, errors.New("Invalid signature data!")
+				}
+				ret = append(ret, sp)
+				break
+			}
+		}
 	}
-	if !(lockBytes[0] == OpCodeHash160 && lockBytes[1] == 0x14 || lockBytes[22] == OpCodeEqual) {
-		return false
+
+	if len(ret) < required {
+		return nil, fmt.Errorf("multisig requires %d signatures, got %d", required, len(ret))
 	}
+	return ret, nil
+}
 
-	redeemBytes, err := hex.DecodeString(redeemScript)
-	if err != nil {
+//verifyMultiSig 按OP_CHECKMULTISIG的规则验证签名，签名顺序必须与公钥顺序一致
+func verifyMultiSig(hash, redeem []byte, signatures [][]byte) bool {
+	required, pubkeys, err := decodeMultiSigScript(redeem)
+	if err != nil || len(signatures) != required {
 		return false
 	}
 
-	if len(redeemBytes) == 0 || redeemBytes[len(redeemBytes)-1] != OpCheckMultiSig {
-		return false
+	index := 0
+	for _, sig := range signatures {
+		for ; index < len(pubkeys); index++ {
+			if verifyHash(hash, SignaturePubkey{sig, pubkeys[index]}) {
+				break
+			}
+		}
+		if index == len(pubkeys) {
+			return false
+		}
+		index++
 	}
 	return true
-
-}
-
-func calcRedeemHash(redeem []byte) []byte {
-	redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)
-
-	return append([]byte{0x22, 0x00, 0x20}, redeemHash...)
 }
@@ -0,0 +1,202 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+func multiSigTestKeys(n int) ([][]byte, [][]byte) {
+	privateKeys := [][]byte{}
+	pubkeys := [][]byte{}
+	for i := 0; i < n; i++ {
+		prikey := owcrypt.Hash([]byte{byte(i + 1)}, 0, owcrypt.HASH_ALG_SHA256)
+		pubkey, _ := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+		privateKeys = append(privateKeys, prikey)
+		pubkeys = append(pubkeys, owcrypt.PointCompress(pubkey, owcrypt.ECC_CURVE_SECP256K1))
+	}
+	return privateKeys, pubkeys
+}
+
+func multiSigTestTransaction(t *testing.T, inputs int) string {
+	vins := []Vin{}
+	for i := 0; i < inputs; i++ {
+		vins = append(vins, Vin{"4bd9c4d0da2b8bd2e3d7a1bf5a5c6d1a0e27c28e1b46db9e2b3b6e5e3e9c31b" + string('0'+byte(i)), uint32(i)})
+	}
+	vouts := []Vout{{"qUEeiBfBZiTuHKvPA85a1u5PeeMkLnNF3K", 90000000}}
+
+	emptyTrans, err := CreateEmptyRawTransaction(vins, vouts, 0, true, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create empty transaction failed: %v", err)
+	}
+	return emptyTrans
+}
+
+//signMultiSigInputs 用指定的私钥对每个输入签名
+func signMultiSigInputs(t *testing.T, emptyTrans string, unlockData []TxUnlock, keys [][][]byte) [][]SignaturePubkey {
+	hashes, err := CreateRawTransactionHashForSig(emptyTrans, unlockData)
+	if err != nil {
+		t.Fatalf("create hash for sig failed: %v", err)
+	}
+
+	sigPubs := [][]SignaturePubkey{}
+	for i, hash := range hashes {
+		sigs := []SignaturePubkey{}
+		for _, key := range keys[i] {
+			sp, err := SignRawTransactionHash([]string{hash}, []TxUnlock{{PrivateKey: key}})
+			if err != nil {
+				t.Fatalf("sign hash failed: %v", err)
+			}
+			sigs = append(sigs, sp[0])
+		}
+		sigPubs = append(sigPubs, sigs)
+	}
+	return sigPubs
+}
+
+func Test_multisig_p2sh_p2wsh_multi_input(t *testing.T) {
+	privateKeys, pubkeys := multiSigTestKeys(3)
+
+	address, redeem, err := CreateMultiSig(2, pubkeys, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create multisig failed: %v", err)
+	}
+	_, scriptHash, _ := DecodeCheck(address)
+	lockScript := "a914" + hex.EncodeToString(scriptHash) + "87"
+
+	emptyTrans := multiSigTestTransaction(t, 2)
+	unlockData := []TxUnlock{
+		{LockScript: lockScript, RedeemScript: redeem, Amount: 50000000},
+		{LockScript: lockScript, RedeemScript: redeem, Amount: 60000000},
+	}
+
+	//签名顺序与公钥顺序不同
+	sigPubs := signMultiSigInputs(t, emptyTrans, unlockData, [][][]byte{
+		{privateKeys[2], privateKeys[0]},
+		{privateKeys[1], privateKeys[2]},
+	})
+
+	signedTrans, err := InsertMultiSignatureIntoEmptyTransaction(emptyTrans, sigPubs, unlockData)
+	if err != nil {
+		t.Fatalf("insert signatures failed: %v", err)
+	}
+
+	if !VerifyRawTransaction(signedTrans, unlockData) {
+		t.Fatalf("verify multisig transaction failed")
+	}
+
+	txBytes, _ := hex.DecodeString(signedTrans)
+	tx, err := decodeMsgTx(txBytes)
+	if err != nil {
+		t.Fatalf("decode signed transaction failed: %v", err)
+	}
+	for i, stack := range tx.Witness {
+		if len(stack) != 4 || len(stack[0]) != 0 || hex.EncodeToString(stack[3]) != redeem {
+			t.Fatalf("input %d: unexpected witness stack", i)
+		}
+	}
+
+	//隔离见证签名包含金额，金额错误时验证失败
+	wrongAmount := []TxUnlock{unlockData[0], unlockData[1]}
+	wrongAmount[1].Amount = 1
+	if VerifyRawTransaction(signedTrans, wrongAmount) {
+		t.Fatalf("verify should fail with wrong amount")
+	}
+
+	//签名数量不足
+	sigPubs[1] = sigPubs[1][:1]
+	if _, err := InsertMultiSignatureIntoEmptyTransaction(emptyTrans, sigPubs, unlockData); err == nil {
+		t.Fatalf("insert should fail without enough signatures")
+	}
+}
+
+func Test_multisig_p2wsh_mixed_inputs(t *testing.T) {
+	privateKeys, pubkeys := multiSigTestKeys(3)
+
+	address, redeem, err := CreateMultiSigP2WSH(2, pubkeys, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create multisig failed: %v", err)
+	}
+	program, err := Bech32DecodeWithPrefix(address, QTUMTestnetAddressPrefix.Bech32Prefix)
+	if err != nil || len(program) != 32 {
+		t.Fatalf("decode P2WSH address failed: %v", err)
+	}
+
+	emptyTrans := multiSigTestTransaction(t, 2)
+	unlockData := []TxUnlock{
+		{LockScript: "76a914" + hex.EncodeToString(owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160)) + "88ac", Amount: 30000000},
+		{LockScript: "0020" + hex.EncodeToString(program), RedeemScript: redeem, Amount: 70000000},
+	}
+
+	sigPubs := signMultiSigInputs(t, emptyTrans, unlockData, [][][]byte{
+		{privateKeys[0]},
+		{privateKeys[2], privateKeys[1]},
+	})
+
+	signedTrans, err := InsertMultiSignatureIntoEmptyTransaction(emptyTrans, sigPubs, unlockData)
+	if err != nil {
+		t.Fatalf("insert signatures failed: %v", err)
+	}
+
+	if !VerifyRawTransaction(signedTrans, unlockData) {
+		t.Fatalf("verify mixed transaction failed")
+	}
+
+	txBytes, _ := hex.DecodeString(signedTrans)
+	tx, _ := decodeMsgTx(txBytes)
+	if len(tx.Witness[0]) != 0 || len(tx.Vins[1].ScriptPubkeySignature) != 0 {
+		t.Fatalf("unexpected P2PKH witness or P2WSH script sig")
+	}
+}
+
+func Test_multisig_legacy_p2sh(t *testing.T) {
+	privateKeys, pubkeys := multiSigTestKeys(3)
+
+	address, redeem, err := CreateMultiSigP2SH(2, pubkeys, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create multisig failed: %v", err)
+	}
+	redeemBytes, _ := hex.DecodeString(redeem)
+	lockScript := "a914" + hex.EncodeToString(owcrypt.Hash(redeemBytes, 0, owcrypt.HASH_ALG_HASH160)) + "87"
+	if script, err := GetAddressLockScript(address, QTUMTestnetAddressPrefix); err != nil || script != lockScript {
+		t.Fatalf("legacy P2SH address lock script: %s, err: %v", script, err)
+	}
+	if nested, _, _ := CreateMultiSig(2, pubkeys, QTUMTestnetAddressPrefix); nested == address {
+		t.Fatalf("legacy P2SH address should differ from P2SH-P2WSH")
+	}
+
+	emptyTrans := multiSigTestTransaction(t, 1)
+	unlockData := []TxUnlock{{LockScript: lockScript, RedeemScript: redeem, Amount: 100000000}}
+
+	sigPubs := signMultiSigInputs(t, emptyTrans, unlockData, [][][]byte{{privateKeys[1], privateKeys[0], privateKeys[2]}})
+
+	signedTrans, err := InsertSignatureIntoEmptyTransaction(emptyTrans, sigPubs[0], unlockData)
+	if err != nil {
+		t.Fatalf("insert signatures failed: %v", err)
+	}
+
+	if !VerifyRawTransaction(signedTrans, unlockData) {
+		t.Fatalf("verify legacy multisig transaction failed")
+	}
+
+	txBytes, _ := hex.DecodeString(signedTrans)
+	tx, _ := decodeMsgTx(txBytes)
+	if tx.Witness != nil {
+		t.Fatalf("legacy multisig should not have witness")
+	}
+	pushes, err := decodeScriptPushes(tx.Vins[0].ScriptPubkeySignature)
+	if err != nil || len(pushes) != 4 || len(pushes[0]) != 0 {
+		t.Fatalf("unexpected multisig script sig")
+	}
+
+	//签名顺序必须与公钥顺序一致
+	required, _, _ := ParseMultiSigRedeemScript(redeem)
+	first, _ := decodeDERSignature(pushes[1])
+	second, _ := decodeDERSignature(pushes[2])
+	hashes, _ := CreateRawTransactionHashForSig(emptyTrans, unlockData)
+	hash, _ := hex.DecodeString(hashes[0])
+	if required != 2 || verifyMultiSig(hash, redeemBytes, [][]byte{second, first}) {
+		t.Fatalf("signatures out of order should not verify")
+	}
+}
@@ -0,0 +1,1069 @@
+package btcLikeTxDriver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//BIP-174 键类型
+const (
+	psbtGlobalUnsignedTx = 0x00
+
+	psbtInNonWitnessUtxo     = 0x00
+	psbtInWitnessUtxo        = 0x01
+	psbtInPartialSig         = 0x02
+	psbtInSighashType        = 0x03
+	psbtInRedeemScript       = 0x04
+	psbtInWitnessScript      = 0x05
+	psbtInBip32Derivation    = 0x06
+	psbtInFinalScriptSig     = 0x07
+	psbtInFinalScriptWitness = 0x08
+
+	psbtOutRedeemScript    = 0x00
+	psbtOutWitnessScript   = 0x01
+	psbtOutBip32Derivation = 0x02
+)
+
+var (
+	psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xFF}
+
+	ErrorInvalidPSBT = errors.New("Invalid PSBT data!")
+)
+
+//PSBTUnknown 未识别的键值对，解析后原样保留
+type PSBTUnknown struct {
+	Key   []byte
+	Value []byte
+}
+
+//PSBTWitnessUtxo 输入引用的UTXO金额及锁定脚本
+type PSBTWitnessUtxo struct {
+	Amount     uint64
+	LockScript []byte
+}
+
+//PSBTPartialSig 部分签名，Signature为DER编码并附带sighash类型
+type PSBTPartialSig struct {
+	Pubkey    []byte
+	Signature []byte
+}
+
+//PSBTBip32Derivation 公钥对应的主密钥指纹及派生路径
+type PSBTBip32Derivation struct {
+	Pubkey      []byte
+	Fingerprint uint32
+	Path        []uint32
+}
+
+type PSBTInput struct {
+	NonWitnessUtxo     []byte
+	WitnessUtxo        *PSBTWitnessUtxo
+	PartialSigs        []PSBTPartialSig
+	SighashType        uint32
+	RedeemScript       []byte
+	WitnessScript      []byte
+	Bip32Derivation    []PSBTBip32Derivation
+	FinalScriptSig     []byte
+	FinalScriptWitness [][]byte
+	Unknowns           []PSBTUnknown
+}
+
+type PSBTOutput struct {
+	RedeemScript    []byte
+	WitnessScript   []byte
+	Bip32Derivation []PSBTBip32Derivation
+	Unknowns        []PSBTUnknown
+}
+
+//PSBT 部分签名交易单，用于在离线签名设备间交换待签数据
+type PSBT struct {
+	UnsignedTx []byte
+	Inputs     []PSBTInput
+	Outputs    []PSBTOutput
+	Unknowns   []PSBTUnknown
+}
+
+//NewPSBT 由空交易单及输入解锁数据创建PSBT，TxUnlock的PrivateKey不会被使用
+//只有隔离见证输入设置WitnessUtxo，非隔离见证输入需要通过SetNonWitnessUtxo设置完整的前置交易单
+func NewPSBT(txHex string, unlockData []TxUnlock) (*PSBT, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, errors.New("Invalid transaction hex string!")
+	}
+
+	tx, err := decodeMsgTx(txBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tx.isUnsigned() {
+		return nil, errors.New("The transaction for PSBT must be unsigned!")
+	}
+
+	if len(tx.Vins) != len(unlockData) {
+		return nil, errors.New("The number of transaction inputs and the unlock data are not match!")
+	}
+
+	p := &PSBT{
+		UnsignedTx: tx.encodeToBytes(false),
+		Inputs:     make([]PSBTInput, len(tx.Vins)),
+		Outputs:    make([]PSBTOutput, len(tx.Vouts)),
+	}
+
+	for i, unlock := range unlockData {
+		lockScript, err := hex.DecodeString(unlock.LockScript)
+		if err != nil || len(lockScript) == 0 {
+			return nil, errors.New("Invalid lock script!")
+		}
+
+		spend, err := newSpendScript(unlock)
+		if err != nil {
+			return nil, err
+		}
+
+		input := PSBTInput{
+			SighashType: uint32(SigHashAll),
+		}
+		if spend.isSegwit() {
+			input.WitnessUtxo = &PSBTWitnessUtxo{Amount: unlock.Amount, LockScript: lockScript}
+		}
+
+		switch {
+		case spend.scriptType == TypeP2SH && spend.multiSig && spend.segwit:
+			//P2SH-P2WSH
+			input.WitnessScript = spend.redeemScript
+			input.RedeemScript = spend.witnessProgram()
+		case spend.scriptType == TypeP2SH:
+			input.RedeemScript = spend.redeemScript
+		case spend.scriptType == TypeP2WSH:
+			input.WitnessScript = spend.redeemScript
+		}
+
+		p.Inputs[i] = input
+	}
+
+	return p, nil
+}
+
+//DecodePSBT 解析二进制格式的PSBT
+func DecodePSBT(data []byte) (*PSBT, error) {
+	if len(data) < len(psbtMagic) || !bytes.Equal(data[:len(psbtMagic)], psbtMagic) {
+		return nil, ErrorInvalidPSBT
+	}
+
+	r := bytes.NewReader(data[len(psbtMagic):])
+	p := &PSBT{}
+
+	globals, err := readPSBTMap(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range globals {
+		if kv.Key[0] == psbtGlobalUnsignedTx {
+			if len(kv.Key) != 1 {
+				return nil, ErrorInvalidPSBT
+			}
+			p.UnsignedTx = kv.Value
+		} else {
+			p.Unknowns = append(p.Unknowns, kv)
+		}
+	}
+	if p.UnsignedTx == nil {
+		return nil, errors.New("Missing unsigned transaction in PSBT!")
+	}
+
+	tx, err := decodeMsgTx(p.UnsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	if !tx.isUnsigned() || tx.Witness != nil {
+		return nil, errors.New("The transaction for PSBT must be unsigned!")
+	}
+
+	for i := 0; i < len(tx.Vins); i++ {
+		kvs, err := readPSBTMap(r)
+		if err != nil {
+			return nil, err
+		}
+		input, err := decodePSBTInput(kvs, tx.Vins[i])
+		if err != nil {
+			return nil, err
+		}
+		p.Inputs = append(p.Inputs, *input)
+	}
+
+	for i := 0; i < len(tx.Vouts); i++ {
+		kvs, err := readPSBTMap(r)
+		if err != nil {
+			return nil, err
+		}
+		output, err := decodePSBTOutput(kvs)
+		if err != nil {
+			return nil, err
+		}
+		p.Outputs = append(p.Outputs, *output)
+	}
+
+	if r.Len() != 0 {
+		return nil, errors.New("Too much PSBT data!")
+	}
+
+	return p, nil
+}
+
+//DecodePSBTFromBase64 解析base64格式的PSBT
+func DecodePSBTFromBase64(b64 string) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, ErrorInvalidPSBT
+	}
+	return DecodePSBT(data)
+}
+
+//Serialize 编码为二进制格式
+func (p *PSBT) Serialize() ([]byte, error) {
+	tx, err := decodeMsgTx(p.UnsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	if len(tx.Vins) != len(p.Inputs) || len(tx.Vouts) != len(p.Outputs) {
+		return nil, errors.New("The number of PSBT inputs or outputs is not match the transaction!")
+	}
+
+	w := &bytes.Buffer{}
+	w.Write(psbtMagic)
+
+	writePSBTKeyValue(w, []byte{psbtGlobalUnsignedTx}, p.UnsignedTx)
+	writePSBTUnknowns(w, p.Unknowns)
+	w.WriteByte(0x00)
+
+	for _, in := range p.Inputs {
+		if in.NonWitnessUtxo != nil {
+			writePSBTKeyValue(w, []byte{psbtInNonWitnessUtxo}, in.NonWitnessUtxo)
+		}
+		if in.WitnessUtxo != nil {
+			value := uint64ToLittleEndianBytes(in.WitnessUtxo.Amount)
+			value = append(value, compactSizeBytes(uint64(len(in.WitnessUtxo.LockScript)))...)
+			value = append(value, in.WitnessUtxo.LockScript...)
+			writePSBTKeyValue(w, []byte{psbtInWitnessUtxo}, value)
+		}
+		for _, sig := range in.PartialSigs {
+			writePSBTKeyValue(w, append([]byte{psbtInPartialSig}, sig.Pubkey...), sig.Signature)
+		}
+		if in.SighashType != 0 {
+			writePSBTKeyValue(w, []byte{psbtInSighashType}, uint32ToLittleEndianBytes(in.SighashType))
+		}
+		if in.RedeemScript != nil {
+			writePSBTKeyValue(w, []byte{psbtInRedeemScript}, in.RedeemScript)
+		}
+		if in.WitnessScript != nil {
+			writePSBTKeyValue(w, []byte{psbtInWitnessScript}, in.WitnessScript)
+		}
+		writePSBTBip32Derivation(w, psbtInBip32Derivation, in.Bip32Derivation)
+		if in.FinalScriptSig != nil {
+			writePSBTKeyValue(w, []byte{psbtInFinalScriptSig}, in.FinalScriptSig)
+		}
+		if in.FinalScriptWitness != nil {
+			writePSBTKeyValue(w, []byte{psbtInFinalScriptWitness}, encodeWitnessStack(in.FinalScriptWitness))
+		}
+		writePSBTUnknowns(w, in.Unknowns)
+		w.WriteByte(0x00)
+	}
+
+	for _, out := range p.Outputs {
+		if out.RedeemScript != nil {
+			writePSBTKeyValue(w, []byte{psbtOutRedeemScript}, out.RedeemScript)
+		}
+		if out.WitnessScript != nil {
+			writePSBTKeyValue(w, []byte{psbtOutWitnessScript}, out.WitnessScript)
+		}
+		writePSBTBip32Derivation(w, psbtOutBip32Derivation, out.Bip32Derivation)
+		writePSBTUnknowns(w, out.Unknowns)
+		w.WriteByte(0x00)
+	}
+
+	return w.Bytes(), nil
+}
+
+//ToBase64 编码为base64格式
+func (p *PSBT) ToBase64() (string, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+//UnsignedTxHex 未签名交易单
+func (p *PSBT) UnsignedTxHex() string {
+	return hex.EncodeToString(p.UnsignedTx)
+}
+
+//SetNonWitnessUtxo 为非隔离见证输入设置完整的前置交易单
+func (p *PSBT) SetNonWitnessUtxo(index int, prevTxHex string) error {
+	if index < 0 || index >= len(p.Inputs) {
+		return errors.New("Input index out of range!")
+	}
+
+	prevBytes, err := hex.DecodeString(prevTxHex)
+	if err != nil {
+		return errors.New("Invalid transaction hex string!")
+	}
+	prevTx, err := decodeMsgTx(prevBytes)
+	if err != nil {
+		return err
+	}
+
+	tx, err := decodeMsgTx(p.UnsignedTx)
+	if err != nil {
+		return err
+	}
+
+	out, err := prevOutput(tx.Vins[index], prevTx)
+	if err != nil {
+		return err
+	}
+
+	input := &p.Inputs[index]
+	input.NonWitnessUtxo = prevTx.encodeToBytes(false)
+
+	//非隔离见证输入只保留完整前置交易
+	if !isWitnessInput(out.lockScript, input) {
+		input.WitnessUtxo = nil
+	}
+	return nil
+}
+
+//GetHashesForSig 计算每个输入的待签名哈希
+func (p *PSBT) GetHashesForSig() ([]string, error) {
+	unlockData, err := p.txUnlocks()
+	if err != nil {
+		return nil, err
+	}
+	return CreateRawTransactionHashForSig(p.UnsignedTxHex(), unlockData)
+}
+
+//AddPartialSig 添加输入的部分签名，签名为64字节r||s格式
+func (p *PSBT) AddPartialSig(index int, sigPub SignaturePubkey) error {
+	if index < 0 || index >= len(p.Inputs) {
+		return errors.New("Input index out of range!")
+	}
+	if sigPub.Signature == nil || len(sigPub.Signature) != 64 {
+		return errors.New("Invalid signature data!")
+	}
+	if sigPub.Pubkey == nil || len(sigPub.Pubkey) != 33 {
+		return errors.New("Invalid pubkey data!")
+	}
+
+	der := sigPub.encodeSignature(SigHashAll)
+
+	input := &p.Inputs[index]
+	for i, sig := range input.PartialSigs {
+		if bytes.Equal(sig.Pubkey, sigPub.Pubkey) {
+			input.PartialSigs[i].Signature = der
+			return nil
+		}
+	}
+	input.PartialSigs = append(input.PartialSigs, PSBTPartialSig{Pubkey: sigPub.Pubkey, Signature: der})
+	return nil
+}
+
+//SignInput 使用私钥对指定输入签名，并加入部分签名
+func (p *PSBT) SignInput(index int, privateKey []byte) error {
+	if index < 0 || index >= len(p.Inputs) {
+		return errors.New("Input index out of range!")
+	}
+
+	hashes, err := p.GetHashesForSig()
+	if err != nil {
+		return err
+	}
+
+	sigPub, err := SignRawTransactionHash([]string{hashes[index]}, []TxUnlock{{PrivateKey: privateKey}})
+	if err != nil {
+		return err
+	}
+
+	return p.AddPartialSig(index, sigPub[0])
+}
+
+//Combine 合并另一份相同交易单的PSBT中的签名数据
+func (p *PSBT) Combine(other *PSBT) error {
+	if !bytes.Equal(p.UnsignedTx, other.UnsignedTx) {
+		return errors.New("Can not combine PSBTs with different transactions!")
+	}
+
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		src := other.Inputs[i]
+
+		if in.NonWitnessUtxo == nil {
+			in.NonWitnessUtxo = src.NonWitnessUtxo
+		}
+		if in.WitnessUtxo == nil {
+			in.WitnessUtxo = src.WitnessUtxo
+		}
+		if in.RedeemScript == nil {
+			in.RedeemScript = src.RedeemScript
+		}
+		if in.WitnessScript == nil {
+			in.WitnessScript = src.WitnessScript
+		}
+		if in.FinalScriptSig == nil {
+			in.FinalScriptSig = src.FinalScriptSig
+		}
+		if in.FinalScriptWitness == nil {
+			in.FinalScriptWitness = src.FinalScriptWitness
+		}
+		for _, sig := range src.PartialSigs {
+			found := false
+			for _, exist := range in.PartialSigs {
+				if bytes.Equal(exist.Pubkey, sig.Pubkey) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				in.PartialSigs = append(in.PartialSigs, sig)
+			}
+		}
+	}
+
+	return nil
+}
+
+//IsComplete 是否所有输入都已完成签名
+func (p *PSBT) IsComplete() bool {
+	for _, in := range p.Inputs {
+		if in.FinalScriptSig == nil && in.FinalScriptWitness == nil {
+			return false
+		}
+	}
+	return true
+}
+
+//Finalize 校验部分签名，并生成各输入最终的解锁脚本及见证数据
+func (p *PSBT) Finalize() error {
+	if p.IsComplete() {
+		return nil
+	}
+
+	hashes, err := p.GetHashesForSig()
+	if err != nil {
+		return err
+	}
+
+	unlockData, err := p.txUnlocks()
+	if err != nil {
+		return err
+	}
+
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+
+		if len(in.PartialSigs) == 0 {
+			return fmt.Errorf("input %d has no signature", i)
+		}
+
+		hash, _ := hex.DecodeString(hashes[i])
+
+		sigPubs := make([]SignaturePubkey, 0, len(in.PartialSigs))
+		for _, sig := range in.PartialSigs {
+			sigPub, err := decodePSBTPartialSig(sig)
+			if err != nil {
+				return err
+			}
+			if !verifyHash(hash, *sigPub) {
+				return fmt.Errorf("input %d signature verify failed", i)
+			}
+			sigPubs = append(sigPubs, *sigPub)
+		}
+
+		spend, err := newSpendScript(unlockData[i])
+		if err != nil {
+			return err
+		}
+
+		scriptSig, witness, err := spend.unlock(sigPubs)
+		if err != nil {
+			return fmt.Errorf("input %d: %v", i, err)
+		}
+
+		in.FinalScriptSig = scriptSig
+		in.FinalScriptWitness = witness.toStack()
+
+		in.PartialSigs = nil
+		in.SighashType = 0
+		in.RedeemScript = nil
+		in.WitnessScript = nil
+		in.Bip32Derivation = nil
+	}
+
+	return nil
+}
+
+//ExtractTransaction 从已完成的PSBT提取可广播的交易单
+func (p *PSBT) ExtractTransaction() (string, error) {
+	if !p.IsComplete() {
+		return "", errors.New("The PSBT is not finalized!")
+	}
+
+	tx, err := decodeMsgTx(p.UnsignedTx)
+	if err != nil {
+		return "", err
+	}
+
+	hasWitness := false
+	for _, in := range p.Inputs {
+		if in.FinalScriptWitness != nil {
+			hasWitness = true
+		}
+	}
+
+	for i, in := range p.Inputs {
+		tx.Vins[i].ScriptPubkeySignature = in.FinalScriptSig
+		if hasWitness {
+			tx.Witness = append(tx.Witness, in.FinalScriptWitness)
+		}
+	}
+
+	return hex.EncodeToString(tx.encodeToBytes(hasWitness)), nil
+}
+
+//inputUtxo 输入引用的金额和锁定脚本，完整前置交易单需要与输入的txid一致
+//非隔离见证输入的金额不在签名哈希中，只信任完整前置交易单，忽略WitnessUtxo
+func (p *PSBT) inputUtxo(index int) (uint64, []byte, error) {
+	in := p.Inputs[index]
+
+	if in.NonWitnessUtxo != nil {
+		tx, err := decodeMsgTx(p.UnsignedTx)
+		if err != nil {
+			return 0, nil, err
+		}
+		prevTx, err := decodeMsgTx(in.NonWitnessUtxo)
+		if err != nil {
+			return 0, nil, err
+		}
+		out, err := prevOutput(tx.Vins[index], prevTx)
+		if err != nil {
+			return 0, nil, err
+		}
+		amount := littleEndianBytesToUint64(out.amount)
+		if in.WitnessUtxo != nil && isWitnessInput(out.lockScript, &in) &&
+			(in.WitnessUtxo.Amount != amount || !bytes.Equal(in.WitnessUtxo.LockScript, out.lockScript)) {
+			return 0, nil, fmt.Errorf("input %d witness utxo is not match the previous transaction", index)
+		}
+		return amount, out.lockScript, nil
+	}
+
+	if in.WitnessUtxo != nil {
+		if !isWitnessInput(in.WitnessUtxo.LockScript, &in) {
+			return 0, nil, fmt.Errorf("input %d is not segwit, the previous transaction is required", index)
+		}
+		return in.WitnessUtxo.Amount, in.WitnessUtxo.LockScript, nil
+	}
+
+	return 0, nil, fmt.Errorf("input %d missing utxo data", index)
+}
+
+//prevOutput 校验前置交易单的哈希与输入引用的txid一致，返回输入引用的输出
+func prevOutput(vin TxIn, prevTx *msgTx) (*TxOut, error) {
+	if !bytes.Equal(prevTx.txHash(), vin.TxID) {
+		return nil, errors.New("Previous transaction is not match the input!")
+	}
+	vout := littleEndianBytesToUint32(vin.Vout)
+	if int(vout) >= len(prevTx.Vouts) {
+		return nil, errors.New("Previous transaction output not found!")
+	}
+	return &prevTx.Vouts[vout], nil
+}
+
+//isWitnessInput 输入引用的输出是否为隔离见证，P2SH由赎回脚本是否为见证程序判断
+func isWitnessInput(lockScript []byte, in *PSBTInput) bool {
+	switch checkScriptType(lockScript) {
+	case TypeBech32, TypeP2WSH:
+		return true
+	case TypeP2SH:
+		redeemType := checkScriptType(in.RedeemScript)
+		return redeemType == TypeBech32 || redeemType == TypeP2WSH
+	}
+	return false
+}
+
+func (p *PSBT) txUnlocks() ([]TxUnlock, error) {
+	unlockData := make([]TxUnlock, 0, len(p.Inputs))
+	for i, in := range p.Inputs {
+		amount, lockScript, err := p.inputUtxo(i)
+		if err != nil {
+			return nil, err
+		}
+		unlock := TxUnlock{
+			LockScript: hex.EncodeToString(lockScript),
+			Amount:     amount,
+		}
+		if in.WitnessScript != nil {
+			unlock.RedeemScript = hex.EncodeToString(in.WitnessScript)
+		} else if in.RedeemScript != nil {
+			unlock.RedeemScript = hex.EncodeToString(in.RedeemScript)
+		}
+		unlockData = append(unlockData, unlock)
+	}
+	return unlockData, nil
+}
+
+//decodePSBTInput 解析输入，vin为未签名交易单中对应的输入
+//完整前置交易单需要与vin引用的txid一致，非隔离见证输入忽略WitnessUtxo
+func decodePSBTInput(kvs []PSBTUnknown, vin TxIn) (*PSBTInput, error) {
+	var prevTx *msgTx
+	in := &PSBTInput{}
+	for _, kv := range kvs {
+		keyType, keyData := kv.Key[0], kv.Key[1:]
+		switch keyType {
+		case psbtInNonWitnessUtxo:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			tx, err := decodeMsgTx(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			prevTx = tx
+			in.NonWitnessUtxo = kv.Value
+		case psbtInWitnessUtxo:
+			if len(keyData) != 0 || len(kv.Value) < 9 {
+				return nil, ErrorInvalidPSBT
+			}
+			r := bytes.NewReader(kv.Value[8:])
+			lockScript, err := readVarBytes(r)
+			if err != nil || r.Len() != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			in.WitnessUtxo = &PSBTWitnessUtxo{
+				Amount:     littleEndianBytesToUint64(kv.Value[:8]),
+				LockScript: lockScript,
+			}
+		case psbtInPartialSig:
+			if len(keyData) != 33 && len(keyData) != 65 {
+				return nil, ErrorInvalidPSBT
+			}
+			in.PartialSigs = append(in.PartialSigs, PSBTPartialSig{Pubkey: keyData, Signature: kv.Value})
+		case psbtInSighashType:
+			if len(keyData) != 0 || len(kv.Value) != 4 {
+				return nil, ErrorInvalidPSBT
+			}
+			in.SighashType = littleEndianBytesToUint32(kv.Value)
+		case psbtInRedeemScript:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			in.RedeemScript = kv.Value
+		case psbtInWitnessScript:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			in.WitnessScript = kv.Value
+		case psbtInBip32Derivation:
+			derivation, err := decodePSBTBip32Derivation(keyData, kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			in.Bip32Derivation = append(in.Bip32Derivation, *derivation)
+		case psbtInFinalScriptSig:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			in.FinalScriptSig = kv.Value
+		case psbtInFinalScriptWitness:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			witness, err := decodeWitnessStack(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			in.FinalScriptWitness = witness
+		default:
+			in.Unknowns = append(in.Unknowns, kv)
+		}
+	}
+
+	if prevTx != nil {
+		out, err := prevOutput(vin, prevTx)
+		if err != nil {
+			return nil, err
+		}
+		if !isFinalized(in) && !isWitnessInput(out.lockScript, in) {
+			in.WitnessUtxo = nil
+		}
+	} else if in.WitnessUtxo != nil && !isFinalized(in) && !isWitnessInput(in.WitnessUtxo.LockScript, in) {
+		in.WitnessUtxo = nil
+	}
+	return in, nil
+}
+
+//isFinalized 输入已完成签名，赎回脚本已被清除，不再需要前置输出
+func isFinalized(in *PSBTInput) bool {
+	return in.FinalScriptSig != nil || in.FinalScriptWitness != nil
+}
+
+func decodePSBTOutput(kvs []PSBTUnknown) (*PSBTOutput, error) {
+	out := &PSBTOutput{}
+	for _, kv := range kvs {
+		keyType, keyData := kv.Key[0], kv.Key[1:]
+		switch keyType {
+		case psbtOutRedeemScript:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			out.RedeemScript = kv.Value
+		case psbtOutWitnessScript:
+			if len(keyData) != 0 {
+				return nil, ErrorInvalidPSBT
+			}
+			out.WitnessScript = kv.Value
+		case psbtOutBip32Derivation:
+			derivation, err := decodePSBTBip32Derivation(keyData, kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			out.Bip32Derivation = append(out.Bip32Derivation, *derivation)
+		default:
+			out.Unknowns = append(out.Unknowns, kv)
+		}
+	}
+	return out, nil
+}
+
+func decodePSBTBip32Derivation(pubkey, value []byte) (*PSBTBip32Derivation, error) {
+	if (len(pubkey) != 33 && len(pubkey) != 65) || len(value) < 4 || len(value)%4 != 0 {
+		return nil, ErrorInvalidPSBT
+	}
+	derivation := &PSBTBip32Derivation{
+		Pubkey:      pubkey,
+		Fingerprint: littleEndianBytesToUint32(value[:4]),
+	}
+	for i := 4; i < len(value); i += 4 {
+		derivation.Path = append(derivation.Path, littleEndianBytesToUint32(value[i:i+4]))
+	}
+	return derivation, nil
+}
+
+//decodePSBTPartialSig 把DER签名还原为64字节r||s格式
+func decodePSBTPartialSig(sig PSBTPartialSig) (*SignaturePubkey, error) {
+	signature, err := decodeDERSignature(sig.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig.Pubkey) != 33 {
+		return nil, errors.New("Only compressed pubkey is supported!")
+	}
+	return &SignaturePubkey{Signature: signature, Pubkey: sig.Pubkey}, nil
+}
+
+func pushData(data []byte) []byte {
+	length := len(data)
+	var ret []byte
+	if length < 0x4C {
+		ret = []byte{byte(length)}
+	} else if length <= 0xFF {
+		ret = []byte{0x4C, byte(length)}
+	} else {
+		ret = []byte{0x4D, byte(length), byte(length >> 8)}
+	}
+	return append(ret, data...)
+}
+
+func readPSBTMap(r *bytes.Reader) ([]PSBTUnknown, error) {
+	kvs := []PSBTUnknown{}
+	seen := make(map[string]bool)
+	for {
+		key, err := readVarBytes(r)
+		if err != nil {
+			return nil, ErrorInvalidPSBT
+		}
+		//分隔符
+		if len(key) == 0 {
+			return kvs, nil
+		}
+		if seen[string(key)] {
+			return nil, errors.New("Duplicate key in PSBT!")
+		}
+		seen[string(key)] = true
+
+		value, err := readVarBytes(r)
+		if err != nil {
+			return nil, ErrorInvalidPSBT
+		}
+		kvs = append(kvs, PSBTUnknown{Key: key, Value: value})
+	}
+}
+
+func writePSBTKeyValue(w *bytes.Buffer, key, value []byte) {
+	w.Write(compactSizeBytes(uint64(len(key))))
+	w.Write(key)
+	w.Write(compactSizeBytes(uint64(len(value))))
+	w.Write(value)
+}
+
+func writePSBTUnknowns(w *bytes.Buffer, unknowns []PSBTUnknown) {
+	for _, kv := range unknowns {
+		writePSBTKeyValue(w, kv.Key, kv.Value)
+	}
+}
+
+func writePSBTBip32Derivation(w *bytes.Buffer, keyType byte, derivations []PSBTBip32Derivation) {
+	for _, d := range derivations {
+		value := uint32ToLittleEndianBytes(d.Fingerprint)
+		for _, index := range d.Path {
+			value = append(value, uint32ToLittleEndianBytes(index)...)
+		}
+		writePSBTKeyValue(w, append([]byte{keyType}, d.Pubkey...), value)
+	}
+}
+
+func encodeWitnessStack(stack [][]byte) []byte {
+	ret := compactSizeBytes(uint64(len(stack)))
+	for _, item := range stack {
+		ret = append(ret, compactSizeBytes(uint64(len(item)))...)
+		ret = append(ret, item...)
+	}
+	return ret
+}
+
+func decodeWitnessStack(data []byte) ([][]byte, error) {
+	r := bytes.NewReader(data)
+	stack, err := readWitnessStack(r)
+	if err != nil || r.Len() != 0 {
+		return nil, ErrorInvalidPSBT
+	}
+	return stack, nil
+}
+
+func readWitnessStack(r *bytes.Reader) ([][]byte, error) {
+	count, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(r.Len()) {
+		return nil, errors.New("Invalid transaction data length!")
+	}
+	stack := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		item, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, item)
+	}
+	return stack, nil
+}
+
+func compactSizeBytes(n uint64) []byte {
+	if n < 0xFD {
+		return []byte{byte(n)}
+	} else if n <= 0xFFFF {
+		ret := []byte{0xFD, 0, 0}
+		binary.LittleEndian.PutUint16(ret[1:], uint16(n))
+		return ret
+	} else if n <= 0xFFFFFFFF {
+		return append([]byte{0xFE}, uint32ToLittleEndianBytes(uint32(n))...)
+	}
+	return append([]byte{0xFF}, uint64ToLittleEndianBytes(n)...)
+}
+
+func readCompactSize(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, errors.New("Invalid transaction data length!")
+	}
+
+	size := 0
+	switch prefix {
+	case 0xFD:
+		size = 2
+	case 0xFE:
+		size = 4
+	case 0xFF:
+		size = 8
+	default:
+		return uint64(prefix), nil
+	}
+
+	buf, err := readBytes(r, uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	tmp := [8]byte{}
+	copy(tmp[:], buf)
+	return binary.LittleEndian.Uint64(tmp[:]), nil
+}
+
+func readBytes(r *bytes.Reader, n uint64) ([]byte, error) {
+	if n > uint64(r.Len()) {
+		return nil, errors.New("Invalid transaction data length!")
+	}
+	buf := make([]byte, n)
+	r.Read(buf)
+	return buf, nil
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	length, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	return readBytes(r, length)
+}
+
+//msgTx 通用交易单结构，长度字段使用变长整数编码，见证数据为任意元素的堆栈
+type msgTx struct {
+	Version  []byte
+	Vins     []TxIn
+	Vouts    []TxOut
+	Witness  [][][]byte
+	LockTime []byte
+}
+
+func decodeMsgTx(txBytes []byte) (*msgTx, error) {
+	r := bytes.NewReader(txBytes)
+	tx := &msgTx{}
+
+	var err error
+	if tx.Version, err = readBytes(r, 4); err != nil {
+		return nil, err
+	}
+
+	segwit := false
+	if r.Len() >= 2 && txBytes[4] == SegWitSymbol && txBytes[5] == SegWitVersion {
+		segwit = true
+		r.Seek(2, io.SeekCurrent)
+	}
+
+	numOfVins, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numOfVins; i++ {
+		var in TxIn
+		if in.TxID, err = readBytes(r, 32); err != nil {
+			return nil, err
+		}
+		if in.Vout, err = readBytes(r, 4); err != nil {
+			return nil, err
+		}
+		script, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(script) > 0 {
+			in.ScriptPubkeySignature = script
+		}
+		if in.Sequence, err = readBytes(r, 4); err != nil {
+			return nil, err
+		}
+		tx.Vins = append(tx.Vins, in)
+	}
+
+	numOfVouts, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numOfVouts; i++ {
+		var out TxOut
+		if out.amount, err = readBytes(r, 8); err != nil {
+			return nil, err
+		}
+		if out.lockScript, err = readVarBytes(r); err != nil {
+			return nil, err
+		}
+		tx.Vouts = append(tx.Vouts, out)
+	}
+
+	if segwit {
+		for i := uint64(0); i < numOfVins; i++ {
+			stack, err := readWitnessStack(r)
+			if err != nil {
+				return nil, err
+			}
+			tx.Witness = append(tx.Witness, stack)
+		}
+	}
+
+	if tx.LockTime, err = readBytes(r, 4); err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, errors.New("Too much transaction data!")
+	}
+	if len(tx.Vins) == 0 {
+		return nil, errors.New("No input found in the transaction struct!")
+	}
+
+	return tx, nil
+}
+
+func (tx *msgTx) isUnsigned() bool {
+	for _, in := range tx.Vins {
+		if len(in.ScriptPubkeySignature) != 0 {
+			return false
+		}
+	}
+	for _, stack := range tx.Witness {
+		if len(stack) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (tx *msgTx) encodeToBytes(withWitness bool) []byte {
+	ret := []byte{}
+	ret = append(ret, tx.Version...)
+	if withWitness {
+		ret = append(ret, SegWitSymbol, SegWitVersion)
+	}
+
+	ret = append(ret, compactSizeBytes(uint64(len(tx.Vins)))...)
+	for _, in := range tx.Vins {
+		ret = append(ret, in.TxID...)
+		ret = append(ret, in.Vout...)
+		ret = append(ret, compactSizeBytes(uint64(len(in.ScriptPubkeySignature)))...)
+		ret = append(ret, in.ScriptPubkeySignature...)
+		ret = append(ret, in.Sequence...)
+	}
+
+	ret = append(ret, compactSizeBytes(uint64(len(tx.Vouts)))...)
+	for _, out := range tx.Vouts {
+		ret = append(ret, out.amount...)
+		ret = append(ret, compactSizeBytes(uint64(len(out.lockScript)))...)
+		ret = append(ret, out.lockScript...)
+	}
+
+	if withWitness {
+		for i := range tx.Vins {
+			var stack [][]byte
+			if i < len(tx.Witness) {
+				stack = tx.Witness[i]
+			}
+			ret = append(ret, encodeWitnessStack(stack)...)
+		}
+	}
+
+	return append(ret, tx.LockTime...)
+}
+
+//txHash 交易单哈希（内部字节序）
+func (tx *msgTx) txHash() []byte {
+	return owcrypt.Hash(tx.encodeToBytes(false), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+}
@@ -0,0 +1,346 @@
+package btcLikeTxDriver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+func psbtTestKey(t *testing.T, keyHex string) ([]byte, []byte, []byte) {
+	key, _ := hex.DecodeString(keyHex)
+	pub, ret := owcrypt.GenPubkey(key, owcrypt.ECC_CURVE_SECP256K1)
+	if ret != owcrypt.SUCCESS {
+		t.Fatalf("gen pubkey failed")
+	}
+	pub = owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_SECP256K1)
+	return key, pub, owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_HASH160)
+}
+
+func psbtRoundTrip(t *testing.T, p *PSBT) *PSBT {
+	b64, err := p.ToBase64()
+	if err != nil {
+		t.Fatalf("encode psbt failed: %v", err)
+	}
+	decoded, err := DecodePSBTFromBase64(b64)
+	if err != nil {
+		t.Fatalf("decode psbt failed: %v", err)
+	}
+	b64chk, _ := decoded.ToBase64()
+	if b64 != b64chk {
+		t.Fatalf("psbt round trip mismatch:\n%s\n%s", b64, b64chk)
+	}
+	return decoded
+}
+
+func Test_psbt_sign_finalize(t *testing.T) {
+	key, pub, pkh := psbtTestKey(t, "1d9e46fc3ea4b8ab4df1f4f8e5bd3dfa2bc9466a57cdf6e3cb4c1a5b7e5c3f11")
+
+	p2pkhLock := append(append([]byte{OpCodeDup, OpCodeHash160, 0x14}, pkh...), OpCodeEqualVerify, OpCodeCheckSig)
+	p2wpkhLock := append([]byte{0x00, 0x14}, pkh...)
+	p2shLock := append(append([]byte{OpCodeHash160, 0x14}, owcrypt.Hash(p2wpkhLock, 0, owcrypt.HASH_ALG_HASH160)...), OpCodeEqual)
+
+	//前置交易，第0个输出支付到P2PKH
+	prevTx, err := CreateEmptyRawTransaction(
+		[]Vin{{"0000000000000000000000000000000000000000000000000000000000000001", 0}},
+		[]Vout{{EncodeCheck(QTUMTestnetAddressPrefix.P2PKHPrefix, pkh), 300000}},
+		0, false, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create prev tx failed: %v", err)
+	}
+	prevBytes, _ := hex.DecodeString(prevTx)
+	prevMsg, _ := decodeMsgTx(prevBytes)
+	prevTxID := reverseBytesToHex(prevMsg.txHash())
+
+	vins := []Vin{
+		{prevTxID, 0},
+		{"b5c4a1e6a8c2b7d8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6", 1},
+		{"c5c4a1e6a8c2b7d8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6", 2},
+	}
+	vouts := []Vout{
+		{"tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f", 500000},
+	}
+	unlockData := []TxUnlock{
+		{LockScript: hex.EncodeToString(p2pkhLock), Amount: 300000},
+		{LockScript: hex.EncodeToString(p2wpkhLock), Amount: 200000},
+		{LockScript: hex.EncodeToString(p2shLock), RedeemScript: hex.EncodeToString(p2wpkhLock), Amount: 100000},
+	}
+
+	emptyTrans, err := CreateEmptyRawTransaction(vins, vouts, 0, true, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create empty tx failed: %v", err)
+	}
+
+	p, err := NewPSBT(emptyTrans, unlockData)
+	if err != nil {
+		t.Fatalf("create psbt failed: %v", err)
+	}
+	if p.Inputs[0].WitnessUtxo != nil || p.Inputs[1].WitnessUtxo == nil || p.Inputs[2].WitnessUtxo == nil {
+		t.Errorf("only witness inputs should have witness utxo")
+	}
+	if _, err := p.GetHashesForSig(); err == nil {
+		t.Errorf("legacy input without non witness utxo should fail")
+	}
+
+	if err := p.SetNonWitnessUtxo(0, prevTx); err != nil {
+		t.Fatalf("set non witness utxo failed: %v", err)
+	}
+	if p.Inputs[0].WitnessUtxo != nil {
+		t.Errorf("legacy input should only keep non witness utxo")
+	}
+
+	p = psbtRoundTrip(t, p)
+
+	hashes, err := p.GetHashesForSig()
+	if err != nil {
+		t.Fatalf("get hashes failed: %v", err)
+	}
+	expected, _ := CreateRawTransactionHashForSig(emptyTrans, unlockData)
+	for i := range hashes {
+		if hashes[i] != expected[i] {
+			t.Errorf("input %d hash mismatch", i)
+		}
+	}
+
+	for i := range p.Inputs {
+		if err := p.SignInput(i, key); err != nil {
+			t.Fatalf("sign input %d failed: %v", i, err)
+		}
+	}
+
+	p = psbtRoundTrip(t, p)
+
+	if err := p.Finalize(); err != nil {
+		t.Fatalf("finalize failed: %v", err)
+	}
+	if !p.IsComplete() {
+		t.Fatalf("psbt should be complete")
+	}
+
+	p = psbtRoundTrip(t, p)
+
+	signedTrans, err := p.ExtractTransaction()
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	signedBytes, _ := hex.DecodeString(signedTrans)
+	signed, err := decodeMsgTx(signedBytes)
+	if err != nil {
+		t.Fatalf("decode signed tx failed: %v", err)
+	}
+
+	if len(signed.Witness[0]) != 0 {
+		t.Errorf("legacy input should have empty witness")
+	}
+	if !bytes.Equal(signed.Vins[1].ScriptPubkeySignature, nil) || len(signed.Witness[1]) != 2 {
+		t.Errorf("wrong P2WPKH input encoding")
+	}
+	if !bytes.Equal(signed.Vins[2].ScriptPubkeySignature, pushData(p2wpkhLock)) || len(signed.Witness[2]) != 2 {
+		t.Errorf("wrong P2SH-P2WPKH input encoding")
+	}
+
+	sigPub, err := decodeFromScriptBytes(signed.Vins[0].ScriptPubkeySignature)
+	if err != nil || !bytes.Equal(sigPub.Pubkey, pub) {
+		t.Fatalf("wrong P2PKH script sig: %v", err)
+	}
+	sigPubs := []SignaturePubkey{*sigPub}
+	for i := 1; i < 3; i++ {
+		sp, err := decodePSBTPartialSig(PSBTPartialSig{Pubkey: signed.Witness[i][1], Signature: signed.Witness[i][0]})
+		if err != nil {
+			t.Fatalf("decode witness %d failed: %v", i, err)
+		}
+		sigPubs = append(sigPubs, *sp)
+	}
+
+	hashBytes := [][]byte{}
+	for _, h := range hashes {
+		b, _ := hex.DecodeString(h)
+		hashBytes = append(hashBytes, b)
+	}
+	if !verifyHashes(hashBytes, sigPubs) {
+		t.Errorf("signature verify failed")
+	}
+
+	//去掉签名后应与原空交易单一致
+	for i := range signed.Vins {
+		signed.Vins[i].ScriptPubkeySignature = nil
+	}
+	if hex.EncodeToString(signed.encodeToBytes(false)) != emptyTrans {
+		t.Errorf("extracted transaction is not match the unsigned transaction")
+	}
+}
+
+func Test_psbt_multisig_finalize(t *testing.T) {
+	keys := []string{
+		"2d9e46fc3ea4b8ab4df1f4f8e5bd3dfa2bc9466a57cdf6e3cb4c1a5b7e5c3f11",
+		"3d9e46fc3ea4b8ab4df1f4f8e5bd3dfa2bc9466a57cdf6e3cb4c1a5b7e5c3f11",
+		"4d9e46fc3ea4b8ab4df1f4f8e5bd3dfa2bc9466a57cdf6e3cb4c1a5b7e5c3f11",
+	}
+	privateKeys := [][]byte{}
+	pubkeys := [][]byte{}
+	for _, k := range keys {
+		key, pub, _ := psbtTestKey(t, k)
+		privateKeys = append(privateKeys, key)
+		pubkeys = append(pubkeys, pub)
+	}
+
+	address, redeem, err := CreateMultiSig(2, pubkeys, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create multisig failed: %v", err)
+	}
+	_, hash, _ := DecodeCheck(address)
+	lockScript := append(append([]byte{OpCodeHash160, 0x14}, hash...), OpCodeEqual)
+
+	emptyTrans, err := CreateEmptyRawTransaction(
+		[]Vin{{"d5c4a1e6a8c2b7d8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6", 0}},
+		[]Vout{{address, 90000}},
+		0, false, QTUMTestnetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create empty tx failed: %v", err)
+	}
+
+	p, err := NewPSBT(emptyTrans, []TxUnlock{{LockScript: hex.EncodeToString(lockScript), RedeemScript: redeem, Amount: 100000}})
+	if err != nil {
+		t.Fatalf("create psbt failed: %v", err)
+	}
+
+	//两个签名方各自签名后合并
+	other := psbtRoundTrip(t, p)
+	if err := other.SignInput(0, privateKeys[2]); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if err := p.Finalize(); err == nil {
+		t.Errorf("finalize without signature should fail")
+	}
+	if err := p.SignInput(0, privateKeys[0]); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if err := p.Combine(psbtRoundTrip(t, other)); err != nil {
+		t.Fatalf("combine failed: %v", err)
+	}
+
+	if err := p.Finalize(); err != nil {
+		t.Fatalf("finalize failed: %v", err)
+	}
+
+	witness := p.Inputs[0].FinalScriptWitness
+	if len(witness) != 4 || len(witness[0]) != 0 || hex.EncodeToString(witness[3]) != redeem {
+		t.Fatalf("wrong multisig witness")
+	}
+
+	//签名须按赎回脚本中的公钥顺序排列
+	first, _ := decodePSBTPartialSig(PSBTPartialSig{Pubkey: pubkeys[0], Signature: witness[1]})
+	second, _ := decodePSBTPartialSig(PSBTPartialSig{Pubkey: pubkeys[2], Signature: witness[2]})
+	hashes, _ := CreateRawTransactionHashForSig(emptyTrans, []TxUnlock{{LockScript: hex.EncodeToString(lockScript), RedeemScript: redeem, Amount: 100000}})
+	hash0, _ := hex.DecodeString(hashes[0])
+	if first == nil || second == nil || !verifyHashes([][]byte{hash0, hash0}, []SignaturePubkey{*first, *second}) {
+		t.Errorf("multisig signatures are not in pubkey order")
+	}
+
+	if _, err := p.ExtractTransaction(); err != nil {
+		t.Errorf("extract failed: %v", err)
+	}
+}
+
+func Test_psbt_decode_invalid(t *testing.T) {
+	if _, err := DecodePSBT([]byte{0x70, 0x73, 0x62, 0x74, 0x00}); err == nil {
+		t.Errorf("invalid magic should fail")
+	}
+
+	emptyTrans, _ := CreateEmptyRawTransaction(
+		[]Vin{{"d5c4a1e6a8c2b7d8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6", 0}},
+		[]Vout{{"tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f", 1000}},
+		0, false, QTUMTestnetAddressPrefix)
+	txBytes, _ := hex.DecodeString(emptyTrans)
+
+	//重复的全局键
+	data := append([]byte{}, psbtMagic...)
+	buf := &bytes.Buffer{}
+	writePSBTKeyValue(buf, []byte{psbtGlobalUnsignedTx}, txBytes)
+	writePSBTKeyValue(buf, []byte{psbtGlobalUnsignedTx}, txBytes)
+	data = append(data, buf.Bytes()...)
+	data = append(data, 0x00, 0x00, 0x00)
+	if _, err := DecodePSBT(data); err == nil {
+		t.Errorf("duplicate key should fail")
+	}
+
+	//缺少输出映射
+	data = append([]byte{}, psbtMagic...)
+	buf.Reset()
+	writePSBTKeyValue(buf, []byte{psbtGlobalUnsignedTx}, txBytes)
+	data = append(data, buf.Bytes()...)
+	data = append(data, 0x00, 0x00)
+	if _, err := DecodePSBT(data); err == nil {
+		t.Errorf("missing output map should fail")
+	}
+}
+
+func Test_psbt_prev_tx_mismatch(t *testing.T) {
+	_, _, pkh := psbtTestKey(t, "1d9e46fc3ea4b8ab4df1f4f8e5bd3dfa2bc9466a57cdf6e3cb4c1a5b7e5c3f11")
+	p2pkhLock := append(append([]byte{OpCodeDup, OpCodeHash160, 0x14}, pkh...), OpCodeEqualVerify, OpCodeCheckSig)
+	address := EncodeCheck(QTUMTestnetAddressPrefix.P2PKHPrefix, pkh)
+
+	prevTx, _ := CreateEmptyRawTransaction(
+		[]Vin{{"0000000000000000000000000000000000000000000000000000000000000001", 0}},
+		[]Vout{{address, 300000}},
+		0, false, QTUMTestnetAddressPrefix)
+	prevBytes, _ := hex.DecodeString(prevTx)
+	prevMsg, _ := decodeMsgTx(prevBytes)
+
+	//伪造的前置交易单虚报输入金额
+	forgedTx, _ := CreateEmptyRawTransaction(
+		[]Vin{{"0000000000000000000000000000000000000000000000000000000000000001", 0}},
+		[]Vout{{address, 300000000}},
+		0, false, QTUMTestnetAddressPrefix)
+	forgedBytes, _ := hex.DecodeString(forgedTx)
+
+	emptyTrans, _ := CreateEmptyRawTransaction(
+		[]Vin{{reverseBytesToHex(prevMsg.txHash()), 0}},
+		[]Vout{{"tq1qw508d6qejxtdg4y5r3zarvary0c5xw7kzztr6f", 290000}},
+		0, false, QTUMTestnetAddressPrefix)
+	unlockData := []TxUnlock{{LockScript: hex.EncodeToString(p2pkhLock), Amount: 300000}}
+
+	p, err := NewPSBT(emptyTrans, unlockData)
+	if err != nil {
+		t.Fatalf("create psbt failed: %v", err)
+	}
+	if err := p.SetNonWitnessUtxo(0, forgedTx); err == nil {
+		t.Errorf("set mismatched previous transaction should fail")
+	}
+
+	p.Inputs[0].NonWitnessUtxo = forgedBytes
+	if _, err := p.GetHashesForSig(); err == nil {
+		t.Errorf("sign with mismatched previous transaction should fail")
+	}
+	data, err := p.Serialize()
+	if err != nil {
+		t.Fatalf("serialize psbt failed: %v", err)
+	}
+	if _, err := DecodePSBT(data); err == nil {
+		t.Errorf("decode psbt with mismatched previous transaction should fail")
+	}
+
+	//非隔离见证输入忽略WitnessUtxo，缺少前置交易单时不能签名
+	p.Inputs[0].NonWitnessUtxo = nil
+	p.Inputs[0].WitnessUtxo = &PSBTWitnessUtxo{Amount: 300000000, LockScript: p2pkhLock}
+	if _, err := p.GetHashesForSig(); err == nil {
+		t.Errorf("legacy input with only witness utxo should fail")
+	}
+	b64, _ := p.ToBase64()
+	if p, err = DecodePSBTFromBase64(b64); err != nil {
+		t.Fatalf("decode psbt failed: %v", err)
+	}
+	if p.Inputs[0].WitnessUtxo != nil {
+		t.Errorf("witness utxo of a legacy input should be ignored")
+	}
+
+	if err := p.SetNonWitnessUtxo(0, prevTx); err != nil {
+		t.Fatalf("set non witness utxo failed: %v", err)
+	}
+	p = psbtRoundTrip(t, p)
+	if _, err := p.GetHashesForSig(); err != nil {
+		t.Fatalf("get hashes failed: %v", err)
+	}
+}
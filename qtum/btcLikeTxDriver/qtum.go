@@ -7,8 +7,9 @@ type AddressPrefix struct {
 }
 
 var (
-	QTUMMainnetAddressPrefix = AddressPrefix{[]byte{0x3A}, []byte{0x32}, "bc"}
-	QTUMTestnetAddressPrefix = AddressPrefix{[]byte{0x78}, []byte{0x6E}, "tb"}
+	QTUMMainnetAddressPrefix = AddressPrefix{[]byte{0x3A}, []byte{0x32}, "qc"}
+	QTUMTestnetAddressPrefix = AddressPrefix{[]byte{0x78}, []byte{0x6E}, "tq"}
+	QTUMRegtestAddressPrefix = AddressPrefix{[]byte{0x78}, []byte{0x6E}, "qcrt"}
 )
 
 //const (
@@ -42,6 +43,8 @@ var (
 	OpCodeDup         = byte(0x76)
 	OpCode_1          = byte(0x51)
 	OpCheckMultiSig   = byte(0xAE)
+	OpCodeCreate      = byte(0xC1)
+	OpCodeCall        = byte(0xC2)
 )
 
 var (
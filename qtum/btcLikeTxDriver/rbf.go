@@ -0,0 +1,72 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+//IsReplaceable 交易单是否声明可被替换(BIP-125)，任一输入的sequence小于0xfffffffe即可
+func IsReplaceable(txHex string) (bool, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return false, errors.New("Invalid transaction hex string!")
+	}
+
+	tx, err := decodeMsgTx(txBytes)
+	if err != nil {
+		return false, err
+	}
+
+	for _, in := range tx.Vins {
+		if in.GetSequence() <= SequenceMaxBip125RBF {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//GetTransactionVSize 计算交易单的虚拟大小，隔离见证数据按1/4计算
+func GetTransactionVSize(txHex string) (uint64, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return 0, errors.New("Invalid transaction hex string!")
+	}
+
+	tx, err := decodeMsgTx(txBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	baseSize := uint64(len(tx.encodeToBytes(false)))
+	totalSize := uint64(len(txBytes))
+	weight := baseSize*3 + totalSize
+
+	return (weight + 3) / 4, nil
+}
+
+//CheckReplacementFee 检查替换交易单的手续费是否满足BIP-125规则
+//金额为最小单位，incrementalRelayFeeRate为每KB的费率
+func CheckReplacementFee(oldFee, oldVSize, newFee, newVSize, incrementalRelayFeeRate uint64) error {
+	if oldVSize == 0 || newVSize == 0 {
+		return errors.New("Invalid transaction size!")
+	}
+
+	//规则3：替换交易的手续费不能低于原交易
+	if newFee < oldFee {
+		return fmt.Errorf("replacement fee %d is less than the original fee %d", newFee, oldFee)
+	}
+
+	//规则4：新增的手续费需要支付替换交易自身的带宽
+	minDelta := (incrementalRelayFeeRate*newVSize + 999) / 1000
+	if newFee-oldFee < minDelta {
+		return fmt.Errorf("replacement fee must be increased by at least %d, got %d", minDelta, newFee-oldFee)
+	}
+
+	//规则6：替换交易的费率必须高于原交易
+	if newFee*oldVSize <= oldFee*newVSize {
+		return fmt.Errorf("replacement fee rate is not higher than the original transaction")
+	}
+
+	return nil
+}
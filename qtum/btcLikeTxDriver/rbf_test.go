@@ -0,0 +1,75 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+func Test_rbf_replaceable(t *testing.T) {
+	vins := []Vin{{"4bd9c4d0da2b8bd2e3d7a1bf5a5c6d1a0e27c28e1b46db9e2b3b6e5e3e9c31b0", 0}}
+	vouts := []Vout{{"qUEeiBfBZiTuHKvPA85a1u5PeeMkLnNF3K", 90000000}}
+
+	for _, replaceable := range []bool{true, false} {
+		emptyTrans, err := CreateEmptyRawTransaction(vins, vouts, 0, replaceable, QTUMTestnetAddressPrefix)
+		if err != nil {
+			t.Fatalf("create empty transaction failed: %v", err)
+		}
+		ok, err := IsReplaceable(emptyTrans)
+		if err != nil || ok != replaceable {
+			t.Fatalf("replaceable expected %v, got %v, err: %v", replaceable, ok, err)
+		}
+	}
+}
+
+func Test_rbf_vsize(t *testing.T) {
+	privateKeys, pubkeys := multiSigTestKeys(1)
+	lockScript := "0014" + hex.EncodeToString(owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160))
+
+	emptyTrans := multiSigTestTransaction(t, 1)
+	unlockData := []TxUnlock{{LockScript: lockScript, Amount: 100000000, PrivateKey: privateKeys[0]}}
+
+	signedTrans, err := SignEmptyRawTransaction(emptyTrans, unlockData)
+	if err != nil {
+		t.Fatalf("sign transaction failed: %v", err)
+	}
+
+	emptySize, _ := GetTransactionVSize(emptyTrans)
+	if emptySize != uint64(len(emptyTrans)/2) {
+		t.Fatalf("vsize of non-witness transaction should equal its size")
+	}
+
+	vsize, err := GetTransactionVSize(signedTrans)
+	if err != nil {
+		t.Fatalf("get vsize failed: %v", err)
+	}
+	//P2WPKH输入、P2PKH输出，1输入1输出约113vB
+	if vsize < 112 || vsize > 113 || vsize >= uint64(len(signedTrans)/2) {
+		t.Fatalf("unexpected vsize: %d, size: %d", vsize, len(signedTrans)/2)
+	}
+}
+
+func Test_rbf_check_fee(t *testing.T) {
+	tests := []struct {
+		oldFee, oldVSize, newFee, newVSize uint64
+		pass                               bool
+	}{
+		//正常追加
+		{100000, 200, 200000, 200, true},
+		//规则3：手续费降低
+		{100000, 200, 90000, 150, false},
+		//规则4：增量不足以支付带宽 (0.004/KB * 200B = 80000)
+		{100000, 200, 150000, 200, false},
+		{100000, 200, 180000, 200, true},
+		//规则6：费率没有提高
+		{100000, 100, 180000, 200, false},
+	}
+
+	for i, test := range tests {
+		err := CheckReplacementFee(test.oldFee, test.oldVSize, test.newFee, test.newVSize, 400000)
+		if (err == nil) != test.pass {
+			t.Fatalf("case %d: expected pass %v, got err: %v", i, test.pass, err)
+		}
+	}
+}
@@ -59,46 +59,70 @@ func calcSignaturePubkey(txHash [][]byte, unlockData []TxUnlock) ([]SignaturePub
 }
 
 func (sp SignaturePubkey) encodeToScript(sigType byte) []byte {
-	r := sp.Signature[:32]
-	s := sp.Signature[32:]
-
-	if r[0]&0x80 == 0x80 {
-		r = append([]byte{0x00}, r...)
-	} else {
-		for i := 0; i < 32; i++ {
-			if r[i] == 0 && r[i+1]&0x80 != 0x80 {
-				r = r[1:]
-			} else {
-				break
-			}
-		}
+	sig := sp.encodeSignature(sigType)
+
+	ret := append([]byte{byte(len(sig))}, sig...)
+	ret = append(ret, byte(len(sp.Pubkey)))
+	return append(ret, sp.Pubkey...)
+}
+
+//encodeSignature DER编码签名并附带sighash类型
+func (sp SignaturePubkey) encodeSignature(sigType byte) []byte {
+	r := derInteger(sp.Signature[:32])
+	s := derInteger(sp.Signature[32:])
+
+	rs := append(r, s...)
+	ret := append([]byte{0x30, byte(len(rs))}, rs...)
+	return append(ret, sigType)
+}
+
+func derInteger(data []byte) []byte {
+	for len(data) > 1 && data[0] == 0 {
+		data = data[1:]
 	}
-	if s[0]&0x80 == 0x80 {
-		s = append([]byte{0}, s...)
-	} else {
-		for i := 0; i < 32; i++ {
-			if s[i] == 0 && s[i+1]&0x80 != 0x80 {
-				s = s[1:]
-			} else {
-				break
-			}
-		}
+	if data[0]&0x80 == 0x80 {
+		data = append([]byte{0x00}, data...)
 	}
+	return append([]byte{0x02, byte(len(data))}, data...)
+}
 
-	r = append([]byte{byte(len(r))}, r...)
-	r = append([]byte{0x02}, r...)
-	s = append([]byte{byte(len(s))}, s...)
-	s = append([]byte{0x02}, s...)
+//decodeDERSignature 解析DER编码并附带sighash类型的签名，返回64字节r||s
+func decodeDERSignature(sig []byte) ([]byte, error) {
+	if len(sig) < 9 || len(sig) > 73 || sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return nil, errors.New("Invalid signature data!")
+	}
+	if sig[len(sig)-1] != SigHashAll {
+		return nil, errors.New("Only sigAll supported!")
+	}
 
-	rs := append(r, s...)
-	rs = append([]byte{byte(len(rs))}, rs...)
-	rs = append(rs, sigType)
-	rs = append([]byte{0x30}, rs...)
-	rs = append([]byte{byte(len(rs))}, rs...)
+	ret := []byte{}
+	index := 2
+	for i := 0; i < 2; i++ {
+		if index+2 > len(sig)-1 || sig[index] != 0x02 {
+			return nil, errors.New("Invalid signature data!")
+		}
+		length := int(sig[index+1])
+		index += 2
+		if length == 0 || length > 0x21 || index+length > len(sig)-1 {
+			return nil, errors.New("Invalid signature data!")
+		}
+		value := sig[index : index+length]
+		index += length
 
-	pub := append([]byte{byte(len(sp.Pubkey))}, sp.Pubkey...)
+		for len(value) > 32 && value[0] == 0 {
+			value = value[1:]
+		}
+		if len(value) > 32 {
+			return nil, errors.New("Invalid signature data!")
+		}
+		ret = append(ret, make([]byte, 32-len(value))...)
+		ret = append(ret, value...)
+	}
 
-	return append(rs, pub...)
+	if index != len(sig)-1 {
+		return nil, errors.New("Invalid signature data!")
+	}
+	return ret, nil
 }
 
 func decodeFromScriptBytes(script []byte) (*SignaturePubkey, error) {
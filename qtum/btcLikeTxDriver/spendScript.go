@@ -0,0 +1,186 @@
+package btcLikeTxDriver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//spendScript 描述一个输入的锁定脚本、赎回脚本以及解锁方式
+type spendScript struct {
+	scriptType   int
+	lockScript   []byte
+	redeemScript []byte
+	segwit       bool
+	multiSig     bool
+}
+
+func newSpendScript(unlock TxUnlock) (*spendScript, error) {
+	lockScript, err := hex.DecodeString(unlock.LockScript)
+	if err != nil || len(lockScript) == 0 {
+		return nil, errors.New("Invalid lock script!")
+	}
+
+	s := &spendScript{
+		scriptType: checkScriptType(lockScript),
+		lockScript: lockScript,
+	}
+
+	if s.scriptType == TypeP2PKH {
+		return s, nil
+	}
+
+	if s.scriptType == TypeBech32 {
+		s.segwit = true
+		return s, nil
+	}
+
+	redeem, err := hex.DecodeString(unlock.RedeemScript)
+	if err != nil || len(redeem) == 0 {
+		if s.scriptType == TypeP2SH || s.scriptType == TypeP2WSH {
+			return nil, errors.New("Missing redeem script for a P2SH input!")
+		}
+		return nil, errors.New("Unknown type of lockscript!")
+	}
+	s.redeemScript = redeem
+
+	switch s.scriptType {
+	case TypeP2SH:
+		redeemHash := owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_HASH160)
+		if checkScriptType(redeem) == TypeBech32 && bytes.Equal(redeemHash, lockScript[2:22]) {
+			s.segwit = true
+			return s, nil
+		}
+
+		if !isMultiSigScript(redeem) {
+			return nil, errors.New("Invalid redeem script!")
+		}
+		s.multiSig = true
+
+		if bytes.Equal(redeemHash, lockScript[2:22]) {
+			return s, nil
+		}
+
+		//P2SH-P2WSH
+		witnessProgram := owcrypt.Hash(s.witnessProgram(), 0, owcrypt.HASH_ALG_HASH160)
+		if !bytes.Equal(witnessProgram, lockScript[2:22]) {
+			return nil, errors.New("Redeem script is not match the lock script!")
+		}
+		s.segwit = true
+	case TypeP2WSH:
+		if !isMultiSigScript(redeem) {
+			return nil, errors.New("Invalid redeem script!")
+		}
+		if !bytes.Equal(owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256), lockScript[2:]) {
+			return nil, errors.New("Redeem script is not match the lock script!")
+		}
+		s.segwit = true
+		s.multiSig = true
+	default:
+		return nil, errors.New("Unknown type of lockscript!")
+	}
+
+	return s, nil
+}
+
+func (s spendScript) isSegwit() bool {
+	return s.segwit
+}
+
+//scriptCodeSource 隔离见证签名哈希中用于生成scriptCode的脚本
+func (s spendScript) scriptCodeSource() []byte {
+	if s.scriptType == TypeBech32 {
+		return s.lockScript
+	}
+	return s.redeemScript
+}
+
+//witnessProgram P2SH-P2WSH中放入解锁脚本的见证程序
+func (s spendScript) witnessProgram() []byte {
+	return append([]byte{0x00, 0x20}, owcrypt.Hash(s.redeemScript, 0, owcrypt.HASH_ALG_SHA256)...)
+}
+
+//unlock 根据签名生成解锁脚本和见证数据，多重签名会按公钥顺序排列并只取所需数量
+func (s spendScript) unlock(sigPubs []SignaturePubkey) ([]byte, TxWitness, error) {
+	if len(sigPubs) == 0 {
+		return nil, TxWitness{}, errors.New("Missing signature data!")
+	}
+
+	if !s.multiSig {
+		sp := sigPubs[0]
+		if sp.Signature == nil || len(sp.Signature) != 64 {
+			return nil, TxWitness{}, errors.New("Invalid signature data!")
+		}
+		if sp.Pubkey == nil || len(sp.Pubkey) != 33 {
+			return nil, TxWitness{}, errors.New("Invalid pubkey data!")
+		}
+
+		switch s.scriptType {
+		case TypeP2PKH:
+			return sp.encodeToScript(SigHashAll), TxWitness{}, nil
+		case TypeBech32:
+			return nil, TxWitness{Signature: sp.Signature, Pubkey: sp.Pubkey}, nil
+		default:
+			return pushData(s.redeemScript), TxWitness{Signature: sp.Signature, Pubkey: sp.Pubkey}, nil
+		}
+	}
+
+	ordered, err := orderMultiSigSignatures(s.redeemScript, sigPubs)
+	if err != nil {
+		return nil, TxWitness{}, err
+	}
+
+	if !s.segwit {
+		//OP_CHECKMULTISIG 需要额外的 OP_0
+		scriptSig := []byte{0x00}
+		for _, sp := range ordered {
+			scriptSig = append(scriptSig, pushData(sp.encodeSignature(SigHashAll))...)
+		}
+		return append(scriptSig, pushData(s.redeemScript)...), TxWitness{}, nil
+	}
+
+	witness := TxWitness{MultiSig: ordered, RedeemScript: s.redeemScript}
+	if s.scriptType == TypeP2SH {
+		return pushData(s.witnessProgram()), witness, nil
+	}
+	return nil, witness, nil
+}
+
+//decodeScriptPushes 解析只包含数据压栈操作的解锁脚本
+func decodeScriptPushes(script []byte) ([][]byte, error) {
+	pushes := [][]byte{}
+	index := 0
+	for index < len(script) {
+		op := script[index]
+		index++
+
+		length := 0
+		switch {
+		case op < 0x4C:
+			length = int(op)
+		case op == 0x4C:
+			if index+1 > len(script) {
+				return nil, errors.New("Invalid script data!")
+			}
+			length = int(script[index])
+			index++
+		case op == 0x4D:
+			if index+2 > len(script) {
+				return nil, errors.New("Invalid script data!")
+			}
+			length = int(script[index]) | int(script[index+1])<<8
+			index += 2
+		default:
+			return nil, errors.New("Invalid script data!")
+		}
+
+		if index+length > len(script) {
+			return nil, errors.New("Invalid script data!")
+		}
+		pushes = append(pushes, script[index:index+length])
+		index += length
+	}
+	return pushes, nil
+}
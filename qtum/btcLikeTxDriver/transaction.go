@@ -4,7 +4,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"strings"
+
+	"github.com/blocktree/go-owcrypt"
 	"github.com/shopspring/decimal"
 )
 
@@ -25,6 +26,12 @@ type Vcontract struct {
 	GasLimit string
 	GasPrice string
 	Amount uint32
+	//CallData 合约调用数据，为空时按To和SendAmount编码QRC20的transfer
+	CallData []byte
+	//Value 转入合约的主币数量，单位为最小单位
+	Value uint64
+	//Create 部署合约(OP_CREATE)，CallData为合约字节码加构造参数，不需要ContractAddr
+	Create bool
 }
 
 type TxUnlock struct {
@@ -112,47 +119,7 @@ func SignEmptyRawTransaction(txHex string, unlockData []TxUnlock) (string, error
 		return "", err
 	}
 
-	for i := 0; i < len(sigPub); i++ {
-		lockBytes, err := hex.DecodeString(unlockData[i].LockScript)
-		if err != nil {
-			return "", errors.New("Invalid lock script or redeem script!")
-		}
-
-		scriptType := checkScriptType(lockBytes)
-		//if isScriptHash(lockBytes) {
-		if scriptType == TypeP2SH || scriptType == TypeBech32 {
-
-			if scriptType == TypeBech32 {
-				emptyTrans.Vins[i].ScriptPubkeySignature = nil
-			} else {
-				redeemScript, err := hex.DecodeString(unlockData[i].RedeemScript)
-				if err != nil {
-					return "", errors.New("Invalid redeem script!")
-				}
-				redeemScript = append([]byte{byte(len(redeemScript))}, redeemScript...)
-				emptyTrans.Vins[i].ScriptPubkeySignature = redeemScript
-			}
-
-			if emptyTrans.Witness == nil {
-				for j := 0; j < i; j++ {
-					emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
-				}
-				emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{sigPub[i].Signature, sigPub[i].Pubkey})
-			}
-
-		} else {
-			emptyTrans.Vins[i].ScriptPubkeySignature = sigPub[i].encodeToScript(SigHashAll)
-			if emptyTrans.Witness != nil {
-				emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
-			}
-		}
-	}
-
-	txBytes, err = emptyTrans.encodeToBytes()
-	if err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(txBytes), nil
+	return InsertSignatureIntoEmptyTransaction(txHex, sigPub, unlockData)
 }
 
 func SignRawTransactionHash(txHash []string, unlockData []TxUnlock) ([]SignaturePubkey, error) {
@@ -169,6 +136,28 @@ func SignRawTransactionHash(txHash []string, unlockData []TxUnlock) ([]Signature
 }
 
 func InsertSignatureIntoEmptyTransaction(txHex string, sigPub []SignaturePubkey, unlockData []TxUnlock) (string, error) {
+	if len(sigPub) == 0 || len(unlockData) == 0 {
+		return "", fmt.Errorf("the signature or the unlock data is empty")
+	}
+
+	//单个多重签名输入，所有签名都属于该输入
+	if len(unlockData) == 1 {
+		return InsertMultiSignatureIntoEmptyTransaction(txHex, [][]SignaturePubkey{sigPub}, unlockData)
+	}
+
+	if len(sigPub) != len(unlockData) {
+		return "", errors.New("The number of signatures and the unlock data are not match!")
+	}
+
+	sigPubs := [][]SignaturePubkey{}
+	for _, sp := range sigPub {
+		sigPubs = append(sigPubs, []SignaturePubkey{sp})
+	}
+	return InsertMultiSignatureIntoEmptyTransaction(txHex, sigPubs, unlockData)
+}
+
+//InsertMultiSignatureIntoEmptyTransaction 把每个输入的签名合并到空交易单，多重签名输入可以有多个签名
+func InsertMultiSignatureIntoEmptyTransaction(txHex string, sigPubs [][]SignaturePubkey, unlockData []TxUnlock) (string, error) {
 	txBytes, err := hex.DecodeString(txHex)
 	if err != nil {
 		return "", errors.New("Invalid transaction hex data!")
@@ -179,67 +168,36 @@ func InsertSignatureIntoEmptyTransaction(txHex string, sigPub []SignaturePubkey,
 		return "", err
 	}
 
-	if len(sigPub) == 0 || len(unlockData) == 0 {
-		return "", fmt.Errorf("the signature or the unlock data is empty")
-	}
-
-	if len(emptyTrans.Vins) != len(unlockData) {
+	if len(emptyTrans.Vins) != len(unlockData) || len(sigPubs) != len(unlockData) {
 		return "", errors.New("The number of transaction inputs and the unlock data are not match!")
 	}
 
-	if isMultiSig(unlockData[0].LockScript, unlockData[0].RedeemScript) {
-		redeemBytes, _ := hex.DecodeString(unlockData[0].RedeemScript)
-		emptyTrans.Vins[0].ScriptPubkeySignature = redeemBytes
-		for i := 0; i < len(sigPub); i++ {
-			emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{sigPub[i].Signature, sigPub[i].Pubkey})
+	witness := []TxWitness{}
+	hasWitness := false
+	for i := 0; i < len(emptyTrans.Vins); i++ {
+		spend, err := newSpendScript(unlockData[i])
+		if err != nil {
+			return "", err
 		}
-	} else {
-		for i := 0; i < len(emptyTrans.Vins); i++ {
-
-			if sigPub[i].Signature == nil || len(sigPub[i].Signature) != 64 {
-				return "", errors.New("Invalid signature data!")
-			}
-			if sigPub[i].Pubkey == nil || len(sigPub[i].Pubkey) != 33 {
-				return "", errors.New("Invalid pubkey data!")
-			}
 
-			// bech32 branch
-			if unlockData[i].RedeemScript == "" && strings.Index(unlockData[i].LockScript, "0014") == 0 {
-				unlockData[i].RedeemScript = unlockData[i].LockScript
-				unlockData[i].LockScript = "00"
-			}
-
-			if unlockData[i].RedeemScript == "" {
-
-				emptyTrans.Vins[i].ScriptPubkeySignature = sigPub[i].encodeToScript(SigHashAll)
-				if emptyTrans.Witness != nil {
-					emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
-				}
-			} else {
-				if emptyTrans.Witness == nil {
-					for j := 0; j < i; j++ {
-						emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{})
-					}
-				}
-				emptyTrans.Witness = append(emptyTrans.Witness, TxWitness{sigPub[i].Signature, sigPub[i].Pubkey})
-				if unlockData[i].RedeemScript == "" {
-					return "", errors.New("Missing redeem script for a P2SH input!")
-				}
+		scriptSig, w, err := spend.unlock(sigPubs[i])
+		if err != nil {
+			return "", fmt.Errorf("input %d: %v", i, err)
+		}
 
-				if unlockData[i].LockScript == "00" {
-					emptyTrans.Vins[i].ScriptPubkeySignature = nil
-				} else {
-					redeem, err := hex.DecodeString(unlockData[i].RedeemScript)
-					if err != nil {
-						return "", errors.New("Invlalid redeem script!")
-					}
-					redeem = append([]byte{byte(len(redeem))}, redeem...)
-					emptyTrans.Vins[i].ScriptPubkeySignature = redeem
-				}
-			}
+		emptyTrans.Vins[i].ScriptPubkeySignature = scriptSig
+		witness = append(witness, w)
+		if !w.isEmpty() {
+			hasWitness = true
 		}
 	}
 
+	if hasWitness {
+		emptyTrans.Witness = witness
+	} else {
+		emptyTrans.Witness = nil
+	}
+
 	txBytes, err = emptyTrans.encodeToBytes()
 	if err != nil {
 		return "", err
@@ -262,41 +220,133 @@ func VerifyRawTransaction(txHex string, unlockData []TxUnlock) bool {
 		return false
 	}
 
-	var sigAndPub []SignaturePubkey
-	if signedTrans.Witness == nil {
-		for _, sp := range signedTrans.Vins {
-			tmp, err := decodeFromScriptBytes(sp.ScriptPubkeySignature)
-			if err != nil {
+	//从已签名交易中还原赎回脚本，不修改调用者的数据
+	unlocks := make([]TxUnlock, len(unlockData))
+	copy(unlocks, unlockData)
+
+	sigAndPub := make([]SignaturePubkey, len(unlocks))
+	multiSigs := make([][][]byte, len(unlocks))
+	for i, in := range signedTrans.Vins {
+		witness := TxWitness{}
+		if i < len(signedTrans.Witness) {
+			witness = signedTrans.Witness[i]
+		}
+
+		redeem, sp, sigs, err := decodeInputSignatures(unlocks[i], in.ScriptPubkeySignature, witness)
+		if err != nil {
+			return false
+		}
+		if redeem != nil {
+			unlocks[i].RedeemScript = hex.EncodeToString(redeem)
+		}
+		if sp != nil {
+			sigAndPub[i] = *sp
+		}
+		multiSigs[i] = sigs
+	}
+
+	hashes, err := signedTrans.getHashesForSig(unlocks)
+	if err != nil {
+		return false
+	}
+
+	for i := range unlocks {
+		if multiSigs[i] != nil {
+			redeem, _ := hex.DecodeString(unlocks[i].RedeemScript)
+			if !verifyMultiSig(hashes[i], redeem, multiSigs[i]) {
 				return false
 			}
-			sigAndPub = append(sigAndPub, *tmp)
+		} else if !verifyHash(hashes[i], sigAndPub[i]) {
+			return false
 		}
-	} else {
-		for i := 0; i < len(signedTrans.Vins); i++ {
-			if signedTrans.Witness[i].Signature == nil {
-				tmp, err := decodeFromScriptBytes(signedTrans.Vins[i].ScriptPubkeySignature)
+	}
+	return true
+}
+
+//decodeInputSignatures 解析输入的解锁脚本和见证数据，返回赎回脚本、单签名或多重签名列表
+func decodeInputSignatures(unlock TxUnlock, scriptSig []byte, witness TxWitness) ([]byte, *SignaturePubkey, [][]byte, error) {
+	lockScript, err := hex.DecodeString(unlock.LockScript)
+	if err != nil {
+		return nil, nil, nil, errors.New("Invalid lock script!")
+	}
+
+	var redeem []byte
+	var pubkeyHash []byte
+	var sp *SignaturePubkey
+	var sigs [][]byte
+
+	switch checkScriptType(lockScript) {
+	case TypeP2PKH:
+		sp, err = decodeFromScriptBytes(scriptSig)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		pubkeyHash = lockScript[3:23]
+	case TypeBech32:
+		if len(scriptSig) != 0 || witness.Signature == nil {
+			return nil, nil, nil, errors.New("Invalid witness data!")
+		}
+		sp = &SignaturePubkey{witness.Signature, witness.Pubkey}
+		pubkeyHash = lockScript[2:]
+	case TypeP2SH:
+		pushes, err := decodeScriptPushes(scriptSig)
+		if err != nil || len(pushes) == 0 {
+			return nil, nil, nil, errors.New("Invalid script data!")
+		}
+		last := pushes[len(pushes)-1]
+
+		switch checkScriptType(last) {
+		case TypeBech32:
+			if len(pushes) != 1 || witness.Signature == nil {
+				return nil, nil, nil, errors.New("Invalid witness data!")
+			}
+			redeem = last
+			sp = &SignaturePubkey{witness.Signature, witness.Pubkey}
+			pubkeyHash = last[2:]
+		case TypeP2WSH:
+			if len(pushes) != 1 || witness.RedeemScript == nil {
+				return nil, nil, nil, errors.New("Invalid witness data!")
+			}
+			redeem = witness.RedeemScript
+			if hex.EncodeToString(last[2:]) != hex.EncodeToString(owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_SHA256)) {
+				return nil, nil, nil, errors.New("Invalid witness data!")
+			}
+			for _, s := range witness.MultiSig {
+				sigs = append(sigs, s.Signature)
+			}
+		default:
+			//OP_0 <sig>... <redeem>
+			if len(pushes) < 2 || len(pushes[0]) != 0 {
+				return nil, nil, nil, errors.New("Invalid script data!")
+			}
+			redeem = last
+			for _, der := range pushes[1 : len(pushes)-1] {
+				sig, err := decodeDERSignature(der)
 				if err != nil {
-					return false
+					return nil, nil, nil, err
 				}
-				sigAndPub = append(sigAndPub, *tmp)
-			} else {
-				sigAndPub = append(sigAndPub, SignaturePubkey{signedTrans.Witness[i].Signature, signedTrans.Witness[i].Pubkey})
-				if strings.Index(unlockData[i].LockScript, "0014") == 0 {
-					continue
-				}
-				unlockData[i].RedeemScript = hex.EncodeToString(signedTrans.Vins[i].ScriptPubkeySignature[1:])
+				sigs = append(sigs, sig)
 			}
 		}
+	case TypeP2WSH:
+		if len(scriptSig) != 0 || witness.RedeemScript == nil {
+			return nil, nil, nil, errors.New("Invalid witness data!")
+		}
+		redeem = witness.RedeemScript
+		for _, s := range witness.MultiSig {
+			sigs = append(sigs, s.Signature)
+		}
+	default:
+		return nil, nil, nil, errors.New("Unknown type of lockscript!")
 	}
 
-	signedTrans.Witness = nil
+	if sp != nil && hex.EncodeToString(owcrypt.Hash(sp.Pubkey, 0, owcrypt.HASH_ALG_HASH160)) != hex.EncodeToString(pubkeyHash) {
+		return nil, nil, nil, errors.New("Pubkey is not match the lock script!")
+	}
 
-	hashes, err := signedTrans.getHashesForSig(unlockData)
-	if err != nil {
-		return false
+	if sp == nil && sigs == nil {
+		return nil, nil, nil, errors.New("Missing signature data!")
 	}
 
-	return verifyHashes(hashes, sigAndPub)
+	return redeem, sp, sigs, nil
 }
-
-
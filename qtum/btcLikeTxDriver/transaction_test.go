@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"testing"
+
+	"github.com/blocktree/go-owcrypt"
 )
 
 var (
@@ -591,3 +593,54 @@ func Test_sigPub_DER_encode(t *testing.T) {
 		fmt.Println(hex.EncodeToString(chk.Pubkey))
 	}
 }
+
+func Test_decode_raw_transaction_outputs(t *testing.T) {
+	raw := "02000000000101cc8a3077023c08040e677647ad0e528564764f456b01d8519828df165ab3c4550100000017160014aa59f94152351c79b57b14a53e538a923e332468feffffff02a716167c6f00000017a914a0fe07f130a36d9c7581ccd2886895c049b0cc8287ece29c00000000001976a9148c0bceb59d452b3e077f73a420b8bfe09e0550a788ac0247304402205e667171c1798cde426282bb8bff45901866ad6bf0d209e856c1765eda65ba4802203aaa319ea3de00eccef0006e6ee2089aed4b91ada7953f420a47c9c258d424ca0121033cfda2f93d13b01d46ecc406b03ebaba3e1bd526d2148a0a5d579d52f8c7cf022e941500"
+	txBytes, _ := hex.DecodeString(raw)
+
+	tx, err := DecodeRawTransaction(txBytes)
+	if err != nil {
+		t.Fatalf("DecodeRawTransaction failed: %v", err)
+	}
+
+	//见证数据不影响交易单哈希
+	txid, err := tx.GetTxID()
+	if err != nil || txid != "6595e0d9f21800849360837b85a7933aeec344a89f5c54cf5db97b79c803c462" {
+		t.Fatalf("txid = %s, err: %v", txid, err)
+	}
+	if tx.Vins[0].GetTxID() != "55c4b35a16df289851d8016b454f766485520ead4776670e04083c0277308acc" || tx.Vins[0].GetTxID() != tx.Vins[0].GetTxID() {
+		t.Errorf("vin txid = %s", tx.Vins[0].GetTxID())
+	}
+
+	amounts := []uint64{478823192231, 10281708}
+	for i, out := range tx.Vouts {
+		if out.GetAmount() != amounts[i] {
+			t.Errorf("vout %d amount = %d, want %d", i, out.GetAmount(), amounts[i])
+		}
+
+		//地址转回输出脚本
+		address, err := out.GetAddress(QTUMTestnetAddressPrefix)
+		if err != nil {
+			t.Fatalf("vout %d GetAddress failed: %v", i, err)
+		}
+		outs, err := newTxOutForEmptyTrans([]Vout{{Address: address, Amount: amounts[i]}}, QTUMTestnetAddressPrefix)
+		if err != nil || hex.EncodeToString(outs[0].lockScript) != out.GetLockScript() {
+			t.Errorf("vout %d address %s does not match lock script %s", i, address, out.GetLockScript())
+		}
+	}
+
+	//P2PK输出使用公钥哈希的地址
+	pubkey, _ := hex.DecodeString("033cfda2f93d13b01d46ecc406b03ebaba3e1bd526d2148a0a5d579d52f8c7cf02")
+	p2pk := TxOut{amount: make([]byte, 8), lockScript: append(append([]byte{0x21}, pubkey...), OpCodeCheckSig)}
+	p2pkh := TxOut{amount: make([]byte, 8), lockScript: append(append([]byte{OpCodeDup, OpCodeHash160, 0x14}, owcrypt.Hash(pubkey, 0, owcrypt.HASH_ALG_HASH160)...), OpCodeEqualVerify, OpCodeCheckSig)}
+	a1, err1 := p2pk.GetAddress(QTUMMainnetAddressPrefix)
+	a2, err2 := p2pkh.GetAddress(QTUMMainnetAddressPrefix)
+	if err1 != nil || err2 != nil || a1 != a2 {
+		t.Errorf("p2pk address = %s, p2pkh address = %s", a1, a2)
+	}
+
+	nulldata := TxOut{amount: make([]byte, 8), lockScript: []byte{0x6a, 0x01, 0x00}}
+	if _, err := nulldata.GetAddress(QTUMMainnetAddressPrefix); err == nil {
+		t.Errorf("nulldata output should have no address")
+	}
+}
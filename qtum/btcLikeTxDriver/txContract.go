@@ -2,7 +2,10 @@ package btcLikeTxDriver
 
 import (
 	"encoding/hex"
-	"github.com/blocktree/openwallet/v2/log"
+	"errors"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"math/big"
 	"strconv"
 )
 
@@ -16,6 +19,7 @@ type TxContract struct {
 	lenContract  []byte
 	contractAddr []byte
 	opCall       []byte
+	value        []byte
 }
 
 var (
@@ -31,108 +35,171 @@ func newTxContractForEmptyTrans(vcontract Vcontract) (*TxContract, error) {
 		return nil, err
 	}
 
-	//十进制转十六进制
 	//gasLimit
 	gasLimitInt, err := strconv.ParseInt(vcontract.GasLimit, 10, 64)
-	if err != nil {
-		return nil, err
-	}
-	gasLimitHex := strconv.FormatInt(gasLimitInt, 16)
-	if len(gasLimitHex)%2 == 1 {
-		gasLimitHex = "0" + gasLimitHex
-	}
-	gasLimit, err := reverseStringToBytes(gasLimitHex)
-	if err != nil {
-		return nil, err
-	}
-
-	//Length of gasLimit
-	lenGasLimitHex := strconv.FormatInt(int64(len(gasLimit)), 16)
-	if len(lenGasLimitHex)%2 == 1 {
-		lenGasLimitHex = "0" + lenGasLimitHex
-	}
-	lenGasLimit, err := hex.DecodeString(lenGasLimitHex)
-	if err != nil {
-		return nil, err
+	if err != nil || gasLimitInt <= 0 {
+		return nil, errors.New("Invalid contract gas limit!")
 	}
+	gasLimit := scriptNumBytes(gasLimitInt)
 
 	//gasPrice
 	gasPriceInt, err := strconv.ParseInt(vcontract.GasPrice, 10, 64)
-	if err != nil {
-		return nil, err
+	if err != nil || gasPriceInt <= 0 {
+		return nil, errors.New("Invalid contract gas price!")
 	}
-	gasPriceHex := strconv.FormatInt(gasPriceInt, 16)
-	if len(gasPriceHex)%2 == 1 {
-		gasPriceHex = "0" + gasPriceHex
+	gasPrice := scriptNumBytes(gasPriceInt)
+
+	//调用数据，没有指定时为QRC20的transfer(address,uint256)
+	dataHex := vcontract.CallData
+	if vcontract.Create && len(dataHex) == 0 {
+		return nil, errors.New("Contract bytecode is empty!")
 	}
-	gasPrice, err := reverseStringToBytes(gasPriceHex)
-	if err != nil {
-		return nil, err
+	if len(dataHex) == 0 {
+		sendAmount, ok := new(big.Int).SetString(vcontract.SendAmount.Truncate(0).String(), 10)
+		if !ok || sendAmount.Sign() < 0 {
+			return nil, errors.New("Invalid contract send amount!")
+		}
+
+		_, addressToHash160, err := DecodeCheck(vcontract.To)
+		if err != nil {
+			return nil, err
+		}
+
+		dataHex, err = abi.QRC20Transfer.Pack(addressToHash160, sendAmount)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	//length of gasPrice
-	lenGasPriceHex := strconv.FormatInt(int64(len(gasPrice)), 16)
-	if len(lenGasPriceHex)%2 == 1 {
-		lenGasPriceHex = "0" + lenGasPriceHex
+	ret = TxContract{
+		vmVersion:   vmVersion,
+		lenGasLimit: []byte{byte(len(gasLimit))},
+		gasLimit:    gasLimit,
+		lenGasPrice: []byte{byte(len(gasPrice))},
+		gasPrice:    gasPrice,
+		dataHex:     dataHex,
+		value:       uint64ToLittleEndianBytes(vcontract.Value),
 	}
-	lenGasPrice, err := hex.DecodeString(lenGasPriceHex)
-	if err != nil {
-		return nil, err
+
+	//部署合约没有合约地址
+	if vcontract.Create {
+		ret.opCall = []byte{OpCodeCreate}
+		return &ret, nil
 	}
 
-	//AmountTo32ByteArg
-	amountDecimal := vcontract.SendAmount
-	sotashiAmount := amountDecimal.IntPart()
-	hexAmount := strconv.FormatInt(sotashiAmount, 16)
-	defaultLen := 64
-	addLen := defaultLen - len(hexAmount)
-	var bytesArg string
-	for i := 0; i < addLen; i++ {
-		bytesArg = bytesArg + "0"
+	contractAddr, err := hex.DecodeString(vcontract.ContractAddr)
+	if err != nil || len(contractAddr) != 20 {
+		return nil, errors.New("Invalid contract address!")
 	}
-	bytesArg = bytesArg + hexAmount
 
-	//addrTo32bytesArg
-	//var addressToHash160 []byte
-	_, addressToHash160, err := DecodeCheck(vcontract.To)
-	if err != nil {
-		return nil, err
+	ret.lenContract = []byte{byte(len(contractAddr))}
+	ret.contractAddr = contractAddr
+	ret.opCall = []byte{OpCodeCall}
+	return &ret, nil
+}
+
+//GetContractCreateAddress 部署合约的交易单广播后，合约地址为hash160(txid + vout)
+//txid为字节序反转前的十六进制，vout为OP_CREATE输出的序号
+func GetContractCreateAddress(txid string, vout uint32) (string, error) {
+	txidBytes, err := reverseHexToBytes(txid)
+	if err != nil || len(txidBytes) != 32 {
+		return "", errors.New("Invalid TxHash!")
 	}
 
-	//if isTestNet {
-	//	addressToHash160, _ = addressEncoder.AddressDecode(vcontract.To, addressEncoder.QTUM_testnetAddressP2PKH)
-	//} else {
-	//	addressToHash160, _ = addressEncoder.AddressDecode(vcontract.To, addressEncoder.QTUM_mainnetAddressP2PKH)
-	//}
+	data := append(txidBytes, uint32ToLittleEndianBytes(vout)...)
+	return hex.EncodeToString(owcrypt.Hash(data, 0, owcrypt.HASH_ALG_HASH160)), nil
+}
 
-	//fmt.Printf("addressToHash160: %s\n",hex.EncodeToString(addressToHash160))
-	addrTo32bytesArg := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, addressToHash160[:]...)
-	//fmt.Printf("to32bytesArg: %s\n",hex.EncodeToString(to32bytesArg))
+//ContractScript 解析后的合约调用(OP_CALL)或部署(OP_CREATE)锁定脚本
+type ContractScript struct {
+	GasLimit     uint64
+	GasPrice     uint64
+	CallData     []byte
+	ContractAddr string //部署合约时为空
+	Create       bool
+}
 
-	//dataHex
-	combineString := hex.EncodeToString(append([]byte{0xa9, 0x05, 0x9c, 0xbb}, addrTo32bytesArg[:]...))
-	dataHexString := combineString + bytesArg
-	dataHex, err := hex.DecodeString(dataHexString)
-	if err != nil {
-		return nil, err
+//DecodeContractLockScript 解析合约输出的锁定脚本，得到gas、调用数据和合约地址
+func DecodeContractLockScript(script string) (*ContractScript, error) {
+	scriptBytes, err := hex.DecodeString(script)
+	if err != nil || len(scriptBytes) == 0 {
+		return nil, errors.New("Invalid contract script!")
 	}
 
-	if int64(len(vcontract.ContractAddr))%2 == 1 {
-		log.Errorf("Contract address length error.")
-	}
-	lanAddressHex := strconv.FormatInt(int64(len(vcontract.ContractAddr))/2, 16)
-	lanAddress, err := hex.DecodeString(lanAddressHex)
+	op := scriptBytes[len(scriptBytes)-1]
+	pushes, err := decodeScriptPushes(scriptBytes[:len(scriptBytes)-1])
 	if err != nil {
-		return nil, err
+		return nil, errors.New("Invalid contract script!")
 	}
 
-	contractAddr, err := hex.DecodeString(vcontract.ContractAddr)
-	if err != nil {
-		return nil, err
+	ret := ContractScript{}
+	switch {
+	case op == OpCodeCreate && len(pushes) == 4:
+		ret.Create = true
+	case op == OpCodeCall && len(pushes) == 5 && len(pushes[4]) == 20:
+		ret.ContractAddr = hex.EncodeToString(pushes[4])
+	default:
+		return nil, errors.New("Invalid contract script!")
 	}
 
-	opCall := []byte{0xC2}
+	gasLimit, ok := scriptNumFromBytes(pushes[1])
+	if !ok {
+		return nil, errors.New("Invalid contract gas limit!")
+	}
+	gasPrice, ok := scriptNumFromBytes(pushes[2])
+	if !ok {
+		return nil, errors.New("Invalid contract gas price!")
+	}
 
-	ret = TxContract{vmVersion, lenGasLimit, gasLimit, lenGasPrice, gasPrice, dataHex, lanAddress, contractAddr, opCall}
+	ret.GasLimit = gasLimit
+	ret.GasPrice = gasPrice
+	ret.CallData = pushes[3]
 	return &ret, nil
 }
+
+//scriptNumFromBytes 脚本中的非负整数，小端序
+func scriptNumFromBytes(b []byte) (uint64, bool) {
+	if len(b) == 0 || len(b) > 9 || b[len(b)-1]&0x80 != 0 {
+		return 0, false
+	}
+	//最高位为符号位时补充的0x00
+	if len(b) == 9 {
+		if b[8] != 0 {
+			return 0, false
+		}
+		b = b[:8]
+	}
+	n := uint64(0)
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n, true
+}
+
+//scriptNumBytes 脚本中的整数，小端序，最高位为符号位
+func scriptNumBytes(n int64) []byte {
+	ret := []byte{}
+	for n > 0 {
+		ret = append(ret, byte(n&0xff))
+		n >>= 8
+	}
+	if len(ret) > 0 && ret[len(ret)-1]&0x80 != 0 {
+		ret = append(ret, 0x00)
+	}
+	return ret
+}
+
+//lockScript 合约调用输出的锁定脚本
+func (c TxContract) lockScript() []byte {
+	ret := []byte{}
+	ret = append(ret, c.vmVersion...)
+	ret = append(ret, c.lenGasLimit...)
+	ret = append(ret, c.gasLimit...)
+	ret = append(ret, c.lenGasPrice...)
+	ret = append(ret, c.gasPrice...)
+	ret = append(ret, pushData(c.dataHex)...)
+	ret = append(ret, c.lenContract...)
+	ret = append(ret, c.contractAddr...)
+	ret = append(ret, c.opCall...)
+	return ret
+}
@@ -0,0 +1,166 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/shopspring/decimal"
+)
+
+func Test_contract_transfer_data(t *testing.T) {
+	_, pubkeys := multiSigTestKeys(1)
+	hash := owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160)
+
+	//18位精度的代币金额超出int64
+	amount, _ := decimal.NewFromString("123456789000000000000000000")
+	vcontract := Vcontract{
+		ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281",
+		To:           EncodeCheck(QTUMTestnetAddressPrefix.P2PKHPrefix, hash),
+		SendAmount:   amount,
+		GasLimit:     "250000",
+		GasPrice:     "40",
+	}
+
+	script, err := GetContractLockScript(vcontract)
+	if err != nil {
+		t.Fatalf("GetContractLockScript failed: %v", err)
+	}
+
+	data := "a9059cbb" +
+		"000000000000000000000000" + hex.EncodeToString(hash) +
+		"000000000000000000000000000000000000000000661efdf12d1653cf340000"
+	if !strings.Contains(script, "44"+data) || !strings.HasSuffix(script, "1491a6081095ef860d28874c9db613e7a4107b0281c2") {
+		t.Fatalf("unexpected contract lock script: %s", script)
+	}
+
+	vcontract.SendAmount = decimal.New(-1, 0)
+	if _, err := GetContractLockScript(vcontract); err == nil {
+		t.Errorf("negative send amount should fail")
+	}
+}
+
+func Test_contract_call_data(t *testing.T) {
+	//超过75字节的调用数据需要OP_PUSHDATA1
+	callData := make([]byte, 100)
+	callData[0] = 0x12
+	vcontract := Vcontract{
+		ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281",
+		CallData:     callData,
+		Value:        150000000,
+		GasLimit:     "40000",
+		GasPrice:     "40",
+	}
+
+	contract, err := newTxContractForEmptyTrans(vcontract)
+	if err != nil {
+		t.Fatalf("newTxContractForEmptyTrans failed: %v", err)
+	}
+
+	//40000=0x9c40，最高位为1时补0x00，避免被解析为负数
+	want := "0104" + "03409c00" + "0128" + "4c64" + hex.EncodeToString(callData) + "1491a6081095ef860d28874c9db613e7a4107b0281c2"
+	if got := hex.EncodeToString(contract.lockScript()); got != want {
+		t.Fatalf("lock script = %s, want %s", got, want)
+	}
+
+	//没有找零时只有合约调用输出，金额为转入合约的主币
+	tx := Contract{
+		Version:   uint32ToLittleEndianBytes(DefaultTxVersion),
+		Vins:      []TxIn{{TxID: make([]byte, 32), Vout: make([]byte, 4), Sequence: make([]byte, 4)}},
+		Vcontract: *contract,
+		LockTime:  make([]byte, 4),
+	}
+	txBytes, err := tx.encodeToBytes()
+	if err != nil {
+		t.Fatalf("encodeToBytes failed: %v", err)
+	}
+	decoded, err := DecodeRawTransaction(txBytes)
+	if err != nil || len(decoded.Vouts) != 1 {
+		t.Fatalf("decode contract transaction failed: %v", err)
+	}
+	if hex.EncodeToString(decoded.Vouts[0].amount) != "80d1f00800000000" {
+		t.Errorf("contract output value = %x", decoded.Vouts[0].amount)
+	}
+
+	vcontract.ContractAddr = "91a6"
+	if _, err := newTxContractForEmptyTrans(vcontract); err == nil {
+		t.Errorf("short contract address should fail")
+	}
+}
+
+func Test_contract_create(t *testing.T) {
+	bytecode, _ := hex.DecodeString("6080604052348015600f57600080fd5b50603e80601d6000396000f3fe6080604052600080fdfea165627a7a72305820")
+	vcontract := Vcontract{
+		CallData: bytecode,
+		GasLimit: "2500000",
+		GasPrice: "40",
+		Create:   true,
+	}
+
+	script, err := GetContractLockScript(vcontract)
+	if err != nil {
+		t.Fatalf("GetContractLockScript failed: %v", err)
+	}
+
+	//2500000=0x2625a0，没有合约地址，以OP_CREATE结尾
+	want := "0104" + "03a02526" + "0128" + "30" + hex.EncodeToString(bytecode) + "c1"
+	if script != want {
+		t.Fatalf("create lock script = %s, want %s", script, want)
+	}
+
+	vcontract.CallData = nil
+	if _, err := GetContractLockScript(vcontract); err == nil {
+		t.Errorf("empty bytecode should fail")
+	}
+
+	txid := "6b2cd2b5c8b5fdb2e8d1e6b0a7e9b7c8d9a6e5f4c3b2a1908f7e6d5c4b3a2918"
+	for vout, want := range []string{"e48939dfbfc795bc1a6271d89a090874c7356cbb", "c55d4b7a4f99266e5fc1971aa72bedfe6eae9fd6"} {
+		addr, err := GetContractCreateAddress(txid, uint32(vout))
+		if err != nil || addr != want {
+			t.Errorf("GetContractCreateAddress(%d) = %s, %v, want %s", vout, addr, err, want)
+		}
+	}
+
+	if _, err := GetContractCreateAddress("1234", 0); err == nil {
+		t.Errorf("invalid txid should fail")
+	}
+}
+
+func Test_decode_contract_script(t *testing.T) {
+	callData := make([]byte, 100)
+	callData[0] = 0x12
+	vcontract := Vcontract{
+		ContractAddr: "91a6081095ef860d28874c9db613e7a4107b0281",
+		CallData:     callData,
+		GasLimit:     "40000",
+		GasPrice:     "40",
+	}
+
+	script, err := GetContractLockScript(vcontract)
+	if err != nil {
+		t.Fatalf("GetContractLockScript failed: %v", err)
+	}
+
+	decoded, err := DecodeContractLockScript(script)
+	if err != nil {
+		t.Fatalf("DecodeContractLockScript failed: %v", err)
+	}
+	if decoded.Create || decoded.GasLimit != 40000 || decoded.GasPrice != 40 || decoded.ContractAddr != vcontract.ContractAddr || hex.EncodeToString(decoded.CallData) != hex.EncodeToString(callData) {
+		t.Errorf("unexpected decoded call script: %+v", decoded)
+	}
+
+	vcontract.Create = true
+	vcontract.GasLimit = "2500000"
+	script, _ = GetContractLockScript(vcontract)
+	decoded, err = DecodeContractLockScript(script)
+	if err != nil || !decoded.Create || decoded.GasLimit != 2500000 || len(decoded.ContractAddr) != 0 {
+		t.Errorf("unexpected decoded create script: %+v, %v", decoded, err)
+	}
+
+	for _, invalid := range []string{"", "zz", "76a914" + vcontract.ContractAddr + "88ac", "0104" + "0180" + "0128" + "00" + "1491a6081095ef860d28874c9db613e7a4107b0281" + "c2"} {
+		if _, err := DecodeContractLockScript(invalid); err == nil {
+			t.Errorf("DecodeContractLockScript(%s) should fail", invalid)
+		}
+	}
+}
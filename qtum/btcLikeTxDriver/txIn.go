@@ -13,7 +13,8 @@ type TxIn struct {
 }
 
 func (in TxIn) GetTxID() string {
-	return reverseBytesToHex(in.TxID)
+	//reverseBytesToHex会原地反转，复制后再转换
+	return reverseBytesToHex(append([]byte{}, in.TxID...))
 }
 
 func (in TxIn) GetVout() uint32 {
@@ -1,8 +1,10 @@
 package btcLikeTxDriver
 
 import (
+	"encoding/hex"
 	"errors"
-	"strings"
+
+	"github.com/blocktree/go-owcrypt"
 )
 
 type TxOut struct {
@@ -21,16 +23,17 @@ func newTxOutForEmptyTrans(vout []Vout, addressPrefix AddressPrefix) ([]TxOut, e
 	for _, v := range vout {
 		amount := uint64ToLittleEndianBytes(v.Amount)
 
-		if strings.Index(v.Address, addressPrefix.Bech32Prefix) == 0 {
-			redeem, err := Bech32Decode(v.Address)
+		if IsBech32Address(v.Address, addressPrefix.Bech32Prefix) {
+			program, err := Bech32DecodeWithPrefix(v.Address, addressPrefix.Bech32Prefix)
 			if err != nil {
 				return nil, errors.New("Invalid bech32 type address!")
 			}
 
-			redeem = append([]byte{byte(len(redeem))}, redeem...)
-			redeem = append([]byte{0x00}, redeem...)
+			program = append([]byte{byte(len(program))}, program...)
+			program = append([]byte{0x00}, program...)
 
-			ret = append(ret, TxOut{amount, redeem})
+			ret = append(ret, TxOut{amount, program})
+			continue
 		}
 
 		prefix, hash, err := DecodeCheck(v.Address)
@@ -61,3 +64,33 @@ func newTxOutForEmptyTrans(vout []Vout, addressPrefix AddressPrefix) ([]TxOut, e
 	}
 	return ret, nil
 }
+
+func (out TxOut) GetAmount() uint64 {
+	return littleEndianBytesToUint64(out.amount)
+}
+
+func (out TxOut) GetLockScript() string {
+	return hex.EncodeToString(out.lockScript)
+}
+
+//GetAddress 解析输出脚本的地址，支持P2PKH、P2SH、P2WPKH、P2WSH和P2PK
+func (out TxOut) GetAddress(addressPrefix AddressPrefix) (string, error) {
+	script := out.lockScript
+
+	switch checkScriptType(script) {
+	case TypeP2PKH:
+		return EncodeCheck(addressPrefix.P2PKHPrefix, script[3:23]), nil
+	case TypeP2SH:
+		return EncodeCheck(addressPrefix.P2SHPrefix, script[2:22]), nil
+	case TypeBech32, TypeP2WSH:
+		return Bech32Encode(addressPrefix.Bech32Prefix, BTCBech32Alphabet, script[2:]), nil
+	}
+
+	//P2PK: <pubkey> OP_CHECKSIG，与核心钱包一致使用公钥哈希的地址
+	if (len(script) == 35 || len(script) == 67) && int(script[0]) == len(script)-2 && script[len(script)-1] == OpCodeCheckSig {
+		hash := owcrypt.Hash(script[1:len(script)-1], 0, owcrypt.HASH_ALG_HASH160)
+		return EncodeCheck(addressPrefix.P2PKHPrefix, hash), nil
+	}
+
+	return "", errors.New("Unknown lock script type!")
+}
@@ -1,7 +1,6 @@
 package btcLikeTxDriver
 
 import (
-	"encoding/hex"
 	"errors"
 
 	"github.com/blocktree/go-owcrypt"
@@ -11,6 +10,7 @@ const (
 	TypeP2PKH  = 0
 	TypeP2SH   = 1
 	TypeBech32 = 2
+	TypeP2WSH  = 3
 )
 
 type Transaction struct {
@@ -64,90 +64,41 @@ func (t Transaction) encodeToBytes() ([]byte, error) {
 
 	ret := []byte{}
 	ret = append(ret, t.Version...)
-	if t.isMultiSig() {
-		if t.Witness == nil {
-			return nil, errors.New("No witness data for a multisig transaction!")
-		}
+
+	if t.Witness != nil {
 		ret = append(ret, SegWitSymbol, SegWitVersion)
-		ret = append(ret, byte(len(t.Vins)))
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			redeemHash := calcRedeemHash(in.ScriptPubkeySignature)
-			ret = append(ret, byte(len(redeemHash)))
-			ret = append(ret, redeemHash...)
-			ret = append(ret, in.Sequence...)
-		}
-		ret = append(ret, byte(len(t.Vouts)))
+	}
 
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
-		}
+	ret = append(ret, compactSizeBytes(uint64(len(t.Vins)))...)
 
-		ret = append(ret, byte(0x04), 0x00)
-		for _, w := range t.Witness {
-			if w.Signature == nil {
-				return nil, errors.New("Miss signature data for a multisig transaction!")
-			} else {
-				sig := w.encodeToScript(SigHashAll)
-				sig = sig[:len(sig)-34]
-				ret = append(ret, sig...)
-			}
+	for _, in := range t.Vins {
+		if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
+			return nil, errors.New("Invalid transaction input!")
 		}
+		ret = append(ret, in.TxID...)
+		ret = append(ret, in.Vout...)
+		ret = append(ret, compactSizeBytes(uint64(len(in.ScriptPubkeySignature)))...)
+		ret = append(ret, in.ScriptPubkeySignature...)
+		ret = append(ret, in.Sequence...)
+	}
 
-		ret = append(ret, byte(len(t.Vins[0].ScriptPubkeySignature)))
-
-		ret = append(ret, t.Vins[0].ScriptPubkeySignature...)
-	} else {
+	ret = append(ret, compactSizeBytes(uint64(len(t.Vouts)))...)
 
-		if t.Witness != nil {
-			ret = append(ret, SegWitSymbol, SegWitVersion)
+	for _, out := range t.Vouts {
+		if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
+			return nil, errors.New("Invalid transaction output!")
 		}
+		ret = append(ret, out.amount...)
+		ret = append(ret, compactSizeBytes(uint64(len(out.lockScript)))...)
+		ret = append(ret, out.lockScript...)
+	}
 
-		ret = append(ret, byte(len(t.Vins)))
-
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			if in.ScriptPubkeySignature == nil {
-				ret = append(ret, 0x00)
+	if t.Witness != nil {
+		for i := range t.Vins {
+			if i < len(t.Witness) {
+				ret = append(ret, t.Witness[i].encodeToBytes()...)
 			} else {
-				ret = append(ret, byte(len(in.ScriptPubkeySignature)))
-				ret = append(ret, in.ScriptPubkeySignature...)
-			}
-			ret = append(ret, in.Sequence...)
-		}
-
-		ret = append(ret, byte(len(t.Vouts)))
-
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
-		}
-
-		if t.Witness != nil {
-			for _, w := range t.Witness {
-				if w.Signature == nil {
-					ret = append(ret, byte(0x00))
-				} else {
-					ret = append(ret, byte(0x02))
-					ret = append(ret, w.encodeToScript(SigHashAll)...)
-				}
+				ret = append(ret, 0x00)
 			}
 		}
 	}
@@ -183,288 +134,67 @@ func newQRC20TokenTransaction(vins []Vin, vcontract Vcontract, vout []Vout, lock
 }
 
 func (t Contract) encodeToBytes() ([]byte, error) {
-	if len(t.Vins) == 0 {
-		return nil, errors.New("No input found in the transaction struct!")
-	}
-
-	if len(t.Vouts) == 0 {
-		return nil, errors.New("No output found in the transaction struct!")
-	}
-
-	ret := []byte{}
-	ret = append(ret, t.Version...)
-	if t.isMultiSig() {
-		if t.Witness == nil {
-			return nil, errors.New("No witness data for a multisig transaction!")
-		}
-		ret = append(ret, SegWitSymbol, SegWitVersion)
-		ret = append(ret, byte(len(t.Vins)))
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			redeemHash := calcRedeemHash(in.ScriptPubkeySignature)
-			ret = append(ret, byte(len(redeemHash)))
-			ret = append(ret, redeemHash...)
-			ret = append(ret, in.Sequence...)
-		}
-
-		//contract
-		ret = append(ret, byte(0x02), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x63)
-		ret = append(ret, t.Vcontract.vmVersion...)
-		ret = append(ret, t.Vcontract.lenGasLimit...)
-		ret = append(ret, t.Vcontract.gasLimit...)
-		ret = append(ret, t.Vcontract.lenGasPrice...)
-		ret = append(ret, t.Vcontract.gasPrice...)
-		ret = append(ret,0x44)
-		ret = append(ret, t.Vcontract.dataHex...)
-		ret = append(ret,t.Vcontract.lenContract...)
-		ret = append(ret, t.Vcontract.contractAddr...)
-		ret = append(ret, t.Vcontract.opCall...)
-
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
-		}
-
-		ret = append(ret, byte(0x04), 0x00)
-		for _, w := range t.Witness {
-			if w.Signature == nil {
-				return nil, errors.New("Miss signature data for a multisig transaction!")
-			} else {
-				sig := w.encodeToScript(SigHashAll)
-				sig = sig[:len(sig)-34]
-				ret = append(ret, sig...)
-			}
-		}
-
-		ret = append(ret, byte(len(t.Vins[0].ScriptPubkeySignature)))
-
-		ret = append(ret, t.Vins[0].ScriptPubkeySignature...)
-	} else {
+	//合约调用输出放在第一位，没有找零时只有合约调用输出
+	contractOut := TxOut{t.Vcontract.value, t.Vcontract.lockScript()}
 
-		if t.Witness != nil {
-			ret = append(ret, SegWitSymbol, SegWitVersion)
-		}
-
-		ret = append(ret, byte(len(t.Vins)))
-
-		for _, in := range t.Vins {
-			if in.TxID == nil || len(in.TxID) != 32 || in.Vout == nil || len(in.Vout) != 4 {
-				return nil, errors.New("Invalid transaction input!")
-			}
-			ret = append(ret, in.TxID...)
-			ret = append(ret, in.Vout...)
-			if in.ScriptPubkeySignature == nil {
-				ret = append(ret, 0x00)
-			} else {
-				ret = append(ret, byte(len(in.ScriptPubkeySignature)))
-				ret = append(ret, in.ScriptPubkeySignature...)
-			}
-			ret = append(ret, in.Sequence...)
-		}
-
-		//contract
-		ret = append(ret, byte(0x02), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x63)
-		ret = append(ret, t.Vcontract.vmVersion...)
-		ret = append(ret, t.Vcontract.lenGasLimit...)
-		ret = append(ret, t.Vcontract.gasLimit...)
-		ret = append(ret, t.Vcontract.lenGasPrice...)
-		ret = append(ret, t.Vcontract.gasPrice...)
-		ret = append(ret,0x44)
-		ret = append(ret, t.Vcontract.dataHex...)
-		ret = append(ret,t.Vcontract.lenContract...)
-		ret = append(ret, t.Vcontract.contractAddr...)
-		ret = append(ret, t.Vcontract.opCall...)
-
-		for _, out := range t.Vouts {
-			if out.amount == nil || len(out.amount) != 8 || out.lockScript == nil {
-				return nil, errors.New("Invalid transaction output!")
-			}
-			ret = append(ret, out.amount...)
-			ret = append(ret, byte(len(out.lockScript)))
-			ret = append(ret, out.lockScript...)
-		}
-
-		if t.Witness != nil {
-			for _, w := range t.Witness {
-				if w.Signature == nil {
-					ret = append(ret, byte(0x00))
-				} else {
-					ret = append(ret, byte(0x02))
-					ret = append(ret, w.encodeToScript(SigHashAll)...)
-				}
-			}
-		}
+	trans := Transaction{
+		Version:  t.Version,
+		Vins:     t.Vins,
+		Vouts:    append([]TxOut{contractOut}, t.Vouts...),
+		Witness:  t.Witness,
+		LockTime: t.LockTime,
 	}
 
-	ret = append(ret, t.LockTime...)
-	return ret, nil
+	return trans.encodeToBytes()
 }
 
 func DecodeRawTransaction(txBytes []byte) (*Transaction, error) {
-	limit := len(txBytes)
-	if limit == 0 {
+	if len(txBytes) == 0 {
 		return nil, errors.New("Invalid transaction data length!")
 	}
-	var rawTx Transaction
-	index := int(0)
-	segwit := false
 
-	if index+4 > limit {
-		return nil, errors.New("Invalid transaction data length!")
-	}
-	rawTx.Version = txBytes[index : index+4]
-	index += 4
-
-	if index+2 > limit {
-		return nil, errors.New("Invalid transaction data length!")
-	}
-	if txBytes[index] == SegWitSymbol {
-		if txBytes[index+1] != SegWitVersion {
-			return nil, errors.New("Invalid witness symbol!")
-		}
-		segwit = true
-		index += 2
-	}
-
-	if index+1 > limit {
-		return nil, errors.New("Invalid transaction data length!")
-	}
-	numOfVins := txBytes[index]
-	index++
-
-	for i := byte(0); i < numOfVins; i++ {
-		var tmpTxIn TxIn
-
-		if index+32 > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		tmpTxIn.TxID = txBytes[index : index+32]
-		index += 32
-
-		if index+4 > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		tmpTxIn.Vout = txBytes[index : index+4]
-		index += 4
-
-		if index+1 > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		scriptLen := txBytes[index]
-		index++
-		if scriptLen == 0 {
-			tmpTxIn.ScriptPubkeySignature = nil
-		} else {
-			if index+int(scriptLen) > limit {
-				return nil, errors.New("Invalid transaction data length!")
-			}
-			tmpTxIn.ScriptPubkeySignature = txBytes[index : index+int(scriptLen)]
-		}
-		index += int(scriptLen)
-
-		if index+4 > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		tmpTxIn.Sequence = txBytes[index : index+4]
-		index += 4
-
-		rawTx.Vins = append(rawTx.Vins, tmpTxIn)
+	tx, err := decodeMsgTx(txBytes)
+	if err != nil {
+		return nil, err
 	}
 
-	if index+1 > limit {
-		return nil, errors.New("Invalid transaction data length!")
+	rawTx := Transaction{
+		Version:  tx.Version,
+		Vins:     tx.Vins,
+		Vouts:    tx.Vouts,
+		LockTime: tx.LockTime,
 	}
-	numOfVouts := txBytes[index]
-	index++
-
-	for i := byte(0); i < numOfVouts; i++ {
-		var tmpTxOut TxOut
-
-		if index+8 > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		tmpTxOut.amount = txBytes[index : index+8]
-		index += 8
-
-		if index+1 > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		lockScriptLen := txBytes[index]
-		index++
 
-		if index+int(lockScriptLen) > limit {
-			return nil, errors.New("Invalid transaction data length!")
-		}
-		tmpTxOut.lockScript = txBytes[index : index+int(lockScriptLen)]
-		index += int(lockScriptLen)
-
-		rawTx.Vouts = append(rawTx.Vouts, tmpTxOut)
-	}
-
-	if segwit {
-		for i := byte(0); i < numOfVins; i++ {
-			if index+1 > limit {
-				return nil, errors.New("Invalid transaction data length!")
-			}
-			if txBytes[index] == 0x00 {
-				rawTx.Witness = append(rawTx.Witness, TxWitness{})
-				index++
-			} else if txBytes[index] == 0x02 {
-				index++
-
-				if index+1 > limit {
-					return nil, errors.New("Invalid transaction data length!")
-				}
-				length := txBytes[index]
-
-				if index+int(length)+1+34 > limit {
-					return nil, errors.New("Invalid transaction data length!")
-				}
-				witness, _ := decodeFromSegwitBytes(txBytes[index : index+int(length)+1+34])
-				rawTx.Witness = append(rawTx.Witness, *witness)
-
-				index += int(length) + 1 + 34
-			} else {
-				//TODO
-				//for multisig
-			}
+	for _, stack := range tx.Witness {
+		witness, err := decodeWitnessFromStack(stack)
+		if err != nil {
+			return nil, err
 		}
+		rawTx.Witness = append(rawTx.Witness, *witness)
 	}
 
-	if index+4 > limit {
-		return nil, errors.New("Invalid transaction data length!")
-	}
-	rawTx.LockTime = txBytes[index : index+4]
-	index += 4
-
-	if index != limit {
-		return nil, errors.New("Too much transaction data!")
-	}
 	return &rawTx, nil
 }
 
-func isScriptHash(script []byte) bool {
-	if script[0] == OpCodeDup && script[1] == OpCodeHash160 && script[2] == 0x14 && script[23] == OpCodeEqualVerify && script[24] == OpCodeCheckSig {
-		return false
+//GetTxID 交易单哈希，不包含见证数据
+func (t Transaction) GetTxID() (string, error) {
+	t.Witness = nil
+	txBytes, err := t.encodeToBytes()
+	if err != nil {
+		return "", err
 	}
-	return true
+	return reverseBytesToHex(owcrypt.Hash(txBytes, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)), nil
 }
 
 func checkScriptType(script []byte) int {
-	if script[0] == OpCodeDup && script[1] == OpCodeHash160 && script[2] == 0x14 && script[23] == OpCodeEqualVerify && script[24] == OpCodeCheckSig {
+	if len(script) == 25 && script[0] == OpCodeDup && script[1] == OpCodeHash160 && script[2] == 0x14 && script[23] == OpCodeEqualVerify && script[24] == OpCodeCheckSig {
 		return TypeP2PKH
-	} else if script[0] == OpCodeHash160 && script[1] == 0x14 && script[22] == OpCodeEqual {
+	} else if len(script) == 23 && script[0] == OpCodeHash160 && script[1] == 0x14 && script[22] == OpCodeEqual {
 		return TypeP2SH
-	} else if script[0] == 0x00 && script[1] == 0x14 {
+	} else if len(script) == 22 && script[0] == 0x00 && script[1] == 0x14 {
 		return TypeBech32
+	} else if len(script) == 34 && script[0] == 0x00 && script[1] == 0x20 {
+		return TypeP2WSH
 	} else {
 		return -1
 	}
@@ -484,7 +214,7 @@ func calcSegwitHash(tx Transaction) ([]byte, []byte, []byte, error) {
 
 	for _, vout := range tx.Vouts {
 		hashOutputs = append(hashOutputs, vout.amount...)
-		hashOutputs = append(hashOutputs, byte(len(vout.lockScript)))
+		hashOutputs = append(hashOutputs, compactSizeBytes(uint64(len(vout.lockScript)))...)
 		hashOutputs = append(hashOutputs, vout.lockScript...)
 	}
 	return owcrypt.Hash(hashPrevouts, 0, owcrypt.HASH_ALG_DOUBLE_SHA256),
@@ -493,14 +223,13 @@ func calcSegwitHash(tx Transaction) ([]byte, []byte, []byte, error) {
 		nil
 }
 
-func genScriptCodeFromRedeemScript(redeem string) ([]byte, error) {
-	redeemBytes, err := hex.DecodeString(redeem)
-	if err != nil {
+func genScriptCodeFromRedeemScript(redeemBytes []byte) ([]byte, error) {
+	if len(redeemBytes) == 0 {
 		return nil, errors.New("Invalid redeem script!")
 	}
 
 	ret := []byte{}
-	if redeemBytes[0] == 0x00 && redeemBytes[1] == 0x14 {
+	if redeemBytes[0] == 0x00 && len(redeemBytes) > 1 && redeemBytes[1] == 0x14 {
 		ret = redeemBytes[2:]
 
 		if len(ret) != 0x14 {
@@ -510,15 +239,17 @@ func genScriptCodeFromRedeemScript(redeem string) ([]byte, error) {
 		ret = append([]byte{OpCodeDup, OpCodeHash160}, ret...)
 		ret = append(ret, OpCodeEqualVerify, OpCodeCheckSig)
 	} else {
-		//TODO
-		//for multi sig
+		//多重签名的见证脚本直接作为scriptCode
+		if !isMultiSigScript(redeemBytes) {
+			return nil, errors.New("Invalid redeem script!")
+		}
 		ret = redeemBytes
 	}
 
 	return ret, nil
 }
 
-func (tx Transaction) calcSegwitBytesForSig(unlockData TxUnlock, txid, vout, sequence []byte) ([]byte, error) {
+func (tx Transaction) calcSegwitBytesForSig(redeem []byte, amount uint64, txid, vout, sequence []byte) ([]byte, error) {
 	sigBytes := []byte{}
 
 	sigBytes = append(sigBytes, tx.Version...)
@@ -532,15 +263,15 @@ func (tx Transaction) calcSegwitBytesForSig(unlockData TxUnlock, txid, vout, seq
 	sigBytes = append(sigBytes, txid...)
 	sigBytes = append(sigBytes, vout...)
 
-	scriptCode, err := genScriptCodeFromRedeemScript(unlockData.RedeemScript)
+	scriptCode, err := genScriptCodeFromRedeemScript(redeem)
 	if err != nil {
 		return nil, err
 	}
 
-	sigBytes = append(sigBytes, byte(len(scriptCode)))
+	sigBytes = append(sigBytes, compactSizeBytes(uint64(len(scriptCode)))...)
 	sigBytes = append(sigBytes, scriptCode...)
 
-	sigBytes = append(sigBytes, uint64ToLittleEndianBytes(unlockData.Amount)...)
+	sigBytes = append(sigBytes, uint64ToLittleEndianBytes(amount)...)
 	sigBytes = append(sigBytes, sequence...)
 
 	sigBytes = append(sigBytes, hashOutputs...)
@@ -555,39 +286,40 @@ func (t Transaction) getHashesForSig(unlockData []TxUnlock) ([][]byte, error) {
 		return nil, errors.New("The number of Keys and UTXOs are not match!")
 	}
 
+	//不修改调用者的输入数据
+	vins := make([]TxIn, len(t.Vins))
+	copy(vins, t.Vins)
+	t.Vins = vins
+	t.Witness = nil
+
 	for i := 0; i < len(unlockData); i++ {
 		sigBytes := []byte{}
 		for j := 0; j < len(unlockData); j++ {
 			t.Vins[j].ScriptPubkeySignature = nil
 		}
-		lockBytes, err := hex.DecodeString(unlockData[i].LockScript)
-		if err != nil {
-			return nil, errors.New("Invalid lockscript!")
-		}
 
-		if lockBytes == nil || len(lockBytes) == 0 || (len(lockBytes) != 22 && len(lockBytes) != 23 && len(lockBytes) != 25) {
-			return nil, errors.New("Check the lockscript data!")
+		spend, err := newSpendScript(unlockData[i])
+		if err != nil {
+			return nil, err
 		}
 
-		scriptType := checkScriptType(lockBytes)
-		if scriptType == TypeP2SH || scriptType == TypeBech32 {
-			if scriptType == TypeBech32 {
-				unlockData[i].RedeemScript = unlockData[i].LockScript
-			}
-			sigBytes, err = t.calcSegwitBytesForSig(unlockData[i], t.Vins[i].TxID, t.Vins[i].Vout, t.Vins[i].Sequence)
+		if spend.isSegwit() {
+			sigBytes, err = t.calcSegwitBytesForSig(spend.scriptCodeSource(), unlockData[i].Amount, t.Vins[i].TxID, t.Vins[i].Vout, t.Vins[i].Sequence)
 			if err != nil {
 				return nil, err
 			}
-		} else if scriptType == TypeP2PKH {
-			t.Vins[i].ScriptPubkeySignature = lockBytes
+		} else {
+			//普通交易，P2PKH使用锁定脚本，P2SH多重签名使用赎回脚本
+			if spend.scriptType == TypeP2PKH {
+				t.Vins[i].ScriptPubkeySignature = spend.lockScript
+			} else {
+				t.Vins[i].ScriptPubkeySignature = spend.redeemScript
+			}
 
 			sigBytes, err = t.encodeToBytes()
 			if err != nil {
 				return nil, err
 			}
-
-		} else {
-			return nil, errors.New("Unknown type of lockscript!")
 		}
 
 		sigBytes = append(sigBytes, uint32ToLittleEndianBytes(DefaultHashType)...)
@@ -603,10 +335,17 @@ func (t Transaction) getHashesForSig(unlockData []TxUnlock) ([][]byte, error) {
 func verifyHashes(hashes [][]byte, sigPub []SignaturePubkey) bool {
 
 	for i := 0; i < len(sigPub); i++ {
-		pubkey := owcrypt.PointDecompress(sigPub[i].Pubkey, owcrypt.ECC_CURVE_SECP256K1)[1:]
-		if owcrypt.Verify(pubkey, nil, hashes[i], sigPub[i].Signature, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		if !verifyHash(hashes[i], sigPub[i]) {
 			return false
 		}
 	}
 	return true
 }
+
+func verifyHash(hash []byte, sigPub SignaturePubkey) bool {
+	if len(sigPub.Pubkey) != 33 || len(sigPub.Signature) != 64 {
+		return false
+	}
+	pubkey := owcrypt.PointDecompress(sigPub.Pubkey, owcrypt.ECC_CURVE_SECP256K1)[1:]
+	return owcrypt.Verify(pubkey, nil, hash, sigPub.Signature, owcrypt.ECC_CURVE_SECP256K1) == owcrypt.SUCCESS
+}
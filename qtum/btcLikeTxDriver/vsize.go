@@ -0,0 +1,167 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	//estimatedSignatureLen DER签名最长72字节，加1字节hashtype
+	estimatedSignatureLen = 73
+	//estimatedPubkeyLen 压缩公钥长度
+	estimatedPubkeyLen = 33
+)
+
+//EstimateVSize 估算签名后交易单的虚拟大小，unlockData为输入的锁定脚本和赎回脚本，outputScripts为输出的锁定脚本
+func EstimateVSize(unlockData []TxUnlock, outputScripts []string) (uint64, error) {
+	baseSize := uint64(4 + 4)
+	baseSize += uint64(len(compactSizeBytes(uint64(len(unlockData)))))
+	baseSize += uint64(len(unlockData)) * (32 + 4 + 1 + 4)
+	baseSize += uint64(len(compactSizeBytes(uint64(len(outputScripts)))))
+	for _, script := range outputScripts {
+		lockScript, err := hex.DecodeString(script)
+		if err != nil {
+			return 0, errors.New("Invalid output lock script!")
+		}
+		baseSize += 8 + uint64(len(compactSizeBytes(uint64(len(lockScript))))+len(lockScript))
+	}
+
+	return estimateSignedVSize(baseSize, unlockData)
+}
+
+//EstimateTransactionVSize 根据未签名交易单估算签名后的虚拟大小
+func EstimateTransactionVSize(emptyTrans string, unlockData []TxUnlock) (uint64, error) {
+	txBytes, err := hex.DecodeString(emptyTrans)
+	if err != nil {
+		return 0, errors.New("Invalid transaction hex string!")
+	}
+
+	tx, err := decodeMsgTx(txBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(tx.Vins) != len(unlockData) {
+		return 0, errors.New("The number of transaction inputs and the unlock data are not match!")
+	}
+
+	//签名前的解锁脚本为空
+	unsigned := *tx
+	unsigned.Vins = make([]TxIn, len(tx.Vins))
+	copy(unsigned.Vins, tx.Vins)
+	for i := range unsigned.Vins {
+		unsigned.Vins[i].ScriptPubkeySignature = nil
+	}
+
+	return estimateSignedVSize(uint64(len(unsigned.encodeToBytes(false))), unlockData)
+}
+
+//GetAddressLockScript 获取地址对应的锁定脚本
+func GetAddressLockScript(address string, addressPrefix AddressPrefix) (string, error) {
+	outs, err := newTxOutForEmptyTrans([]Vout{{address, 0}}, addressPrefix)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(outs[0].lockScript), nil
+}
+
+//GetContractLockScript 获取合约调用输出的锁定脚本
+func GetContractLockScript(vcontract Vcontract) (string, error) {
+	contract, err := newTxContractForEmptyTrans(vcontract)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(contract.lockScript()), nil
+}
+
+//estimateSignedVSize baseSize为解锁脚本为空时的交易单大小，加上每个输入签名后的解锁脚本和见证数据
+func estimateSignedVSize(baseSize uint64, unlockData []TxUnlock) (uint64, error) {
+	var (
+		witnessSize uint64
+		segwit      bool
+	)
+
+	for _, unlock := range unlockData {
+		scriptSigLen, witnessLen, err := estimateInputSize(unlock)
+		if err != nil {
+			return 0, err
+		}
+
+		//空的解锁脚本已计算了1字节长度
+		baseSize += uint64(len(compactSizeBytes(scriptSigLen))) - 1 + scriptSigLen
+
+		if witnessLen > 0 {
+			segwit = true
+			witnessSize += witnessLen
+		} else {
+			//没有见证数据的输入也要1字节的数量
+			witnessSize++
+		}
+	}
+
+	weight := baseSize * 4
+	if segwit {
+		//marker和flag
+		weight += 2 + witnessSize
+	}
+
+	return (weight + 3) / 4, nil
+}
+
+//estimateInputSize 估算输入签名后的解锁脚本长度和见证数据长度
+func estimateInputSize(unlock TxUnlock) (uint64, uint64, error) {
+	lockScript, err := hex.DecodeString(unlock.LockScript)
+	if err != nil || len(lockScript) == 0 {
+		return 0, 0, errors.New("Invalid lock script!")
+	}
+
+	sigPubLen := uint64(1 + estimatedSignatureLen + 1 + estimatedPubkeyLen)
+
+	if len(unlock.RedeemScript) == 0 {
+		switch checkScriptType(lockScript) {
+		case TypeP2PKH:
+			return sigPubLen, 0, nil
+		case TypeBech32:
+			return 0, 1 + sigPubLen, nil
+		case TypeP2SH:
+			//没有赎回脚本的P2SH按P2SH-P2WPKH估算
+			return 1 + 22, 1 + sigPubLen, nil
+		}
+	}
+
+	spend, err := newSpendScript(unlock)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !spend.multiSig {
+		switch spend.scriptType {
+		case TypeP2PKH:
+			return sigPubLen, 0, nil
+		case TypeBech32:
+			return 0, 1 + sigPubLen, nil
+		default:
+			return uint64(len(pushData(spend.redeemScript))), 1 + sigPubLen, nil
+		}
+	}
+
+	required, _, err := decodeMultiSigScript(spend.redeemScript)
+	if err != nil {
+		return 0, 0, err
+	}
+	sigsLen := uint64(required) * (1 + estimatedSignatureLen)
+
+	if !spend.segwit {
+		//OP_0 <sig>... <redeemScript>
+		return 1 + sigsLen + uint64(len(pushData(spend.redeemScript))), 0, nil
+	}
+
+	//见证数据：数量、空元素、签名、见证脚本
+	redeemLen := uint64(len(spend.redeemScript))
+	witnessLen := uint64(len(compactSizeBytes(uint64(required+2)))) + 1 + sigsLen + uint64(len(compactSizeBytes(redeemLen))) + redeemLen
+
+	if spend.scriptType == TypeP2SH {
+		return uint64(len(pushData(spend.witnessProgram()))), witnessLen, nil
+	}
+	return 0, witnessLen, nil
+}
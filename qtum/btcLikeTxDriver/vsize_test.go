@@ -0,0 +1,73 @@
+package btcLikeTxDriver
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+func Test_vsize_estimate(t *testing.T) {
+	privateKeys, pubkeys := multiSigTestKeys(3)
+	pkh := hex.EncodeToString(owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160))
+
+	redeemBytes, _ := buildMultiSigRedeemScript(2, pubkeys)
+	redeem := hex.EncodeToString(redeemBytes)
+	p2shAddress, _, _ := CreateMultiSig(2, pubkeys, QTUMTestnetAddressPrefix)
+	_, scriptHash, _ := DecodeCheck(p2shAddress)
+
+	tests := []struct {
+		name   string
+		unlock TxUnlock
+		keys   [][]byte
+	}{
+		{"p2pkh", TxUnlock{LockScript: "76a914" + pkh + "88ac", Amount: 100000000}, privateKeys[:1]},
+		{"p2wpkh", TxUnlock{LockScript: "0014" + pkh, Amount: 100000000}, privateKeys[:1]},
+		{"p2sh-p2wsh", TxUnlock{LockScript: "a914" + hex.EncodeToString(scriptHash) + "87", RedeemScript: redeem, Amount: 100000000}, privateKeys[:2]},
+		{"p2sh", TxUnlock{LockScript: "a914" + hex.EncodeToString(owcrypt.Hash(redeemBytes, 0, owcrypt.HASH_ALG_HASH160)) + "87", RedeemScript: redeem, Amount: 100000000}, privateKeys[:2]},
+	}
+
+	for _, test := range tests {
+		emptyTrans := multiSigTestTransaction(t, 2)
+		unlockData := []TxUnlock{test.unlock, test.unlock}
+
+		estimated, err := EstimateTransactionVSize(emptyTrans, unlockData)
+		if err != nil {
+			t.Fatalf("%s: estimate vsize failed: %v", test.name, err)
+		}
+
+		sigPubs := signMultiSigInputs(t, emptyTrans, unlockData, [][][]byte{test.keys, test.keys})
+		signedTrans, err := InsertMultiSignatureIntoEmptyTransaction(emptyTrans, sigPubs, unlockData)
+		if err != nil {
+			t.Fatalf("%s: insert signatures failed: %v", test.name, err)
+		}
+		actual, _ := GetTransactionVSize(signedTrans)
+
+		//签名长度按最大值估算，r或s有前导零时签名会更短，每个签名最多多估4字节
+		if estimated < actual || estimated > actual+uint64(len(test.keys))*2*4 {
+			t.Fatalf("%s: estimated vsize %d, actual %d", test.name, estimated, actual)
+		}
+
+		//不解码交易单，按输出脚本估算结果一致
+		byScripts, err := EstimateVSize(unlockData, []string{"76a914" + pkh + "88ac"})
+		if err != nil || byScripts != estimated {
+			t.Fatalf("%s: estimate by scripts %d, by transaction %d, err: %v", test.name, byScripts, estimated, err)
+		}
+	}
+}
+
+func Test_vsize_lock_script(t *testing.T) {
+	_, pubkeys := multiSigTestKeys(1)
+	hash := owcrypt.Hash(pubkeys[0], 0, owcrypt.HASH_ALG_HASH160)
+
+	script, err := GetAddressLockScript(EncodeCheck(QTUMTestnetAddressPrefix.P2PKHPrefix, hash), QTUMTestnetAddressPrefix)
+	if err != nil || script != "76a914"+hex.EncodeToString(hash)+"88ac" {
+		t.Fatalf("unexpected P2PKH lock script: %s, err: %v", script, err)
+	}
+
+	bech32 := Bech32Encode(QTUMTestnetAddressPrefix.Bech32Prefix, BTCBech32Alphabet, hash)
+	script, err = GetAddressLockScript(bech32, QTUMTestnetAddressPrefix)
+	if err != nil || script != "0014"+hex.EncodeToString(hash) {
+		t.Fatalf("unexpected P2WPKH lock script: %s, err: %v", script, err)
+	}
+}
@@ -5,105 +5,66 @@ import "errors"
 type TxWitness struct {
 	Signature []byte
 	Pubkey    []byte
+	//多重签名，按赎回脚本中的公钥顺序排列
+	MultiSig     []SignaturePubkey
+	RedeemScript []byte
 }
 
-func (w TxWitness) encodeToScript(sigType byte) []byte {
-	r := w.Signature[:32]
-	s := w.Signature[32:]
-
-	if r[0]&0x80 == 0x80 {
-		r = append([]byte{0x00}, r...)
-	}
-	if s[0]&0x80 == 0x80 {
-		s = append([]byte{0}, s...)
-	}
-
-	r = append([]byte{byte(len(r))}, r...)
-	r = append([]byte{0x02}, r...)
-	s = append([]byte{byte(len(s))}, s...)
-	s = append([]byte{0x02}, s...)
-
-	rs := append(r, s...)
-	rs = append([]byte{byte(len(rs))}, rs...)
-	rs = append(rs, sigType)
-	rs = append([]byte{0x30}, rs...)
-	rs = append([]byte{byte(len(rs))}, rs...)
-
-	pub := append([]byte{byte(len(w.Pubkey))}, w.Pubkey...)
-
-	return append(rs, pub...)
+func (w TxWitness) isEmpty() bool {
+	return w.Signature == nil && w.RedeemScript == nil
 }
 
-func decodeFromSegwitBytes(script []byte) (*TxWitness, error) {
-	var ret TxWitness
-	index := 0
-	sigLen := script[index]
-	index++
-
-	if script[index] != 0x30 {
-		return nil, errors.New("Invalid signature data!")
-	}
-	index++
-
-	rsLen := script[index]
-	index++
-
-	if script[index] != 0x02 {
-		return nil, errors.New("Invalid signature data!")
-	}
-	index++
-
-	rLen := script[index]
-	index++
-
-	if rLen == 0x21 {
-		if script[index] != 0x00 && (script[index+1]&0x80 != 0x80) {
-			return nil, errors.New("Invalid signature data!")
+//toStack 见证数据堆栈
+func (w TxWitness) toStack() [][]byte {
+	if w.RedeemScript != nil {
+		//OP_CHECKMULTISIG 需要额外的空元素
+		stack := [][]byte{{}}
+		for _, sp := range w.MultiSig {
+			stack = append(stack, sp.encodeSignature(SigHashAll))
 		}
-		index++
+		return append(stack, w.RedeemScript)
 	}
 
-	ret.Signature = script[index : index+32]
-	index += 32
-
-	if script[index] != 0x02 {
-		return nil, errors.New("Invalid signature data!")
+	if w.Signature != nil {
+		sp := SignaturePubkey{w.Signature, w.Pubkey}
+		return [][]byte{sp.encodeSignature(SigHashAll), w.Pubkey}
 	}
-	index++
 
-	sLen := script[index]
-	index++
-
-	if sLen == 0x21 {
-		if script[index] != 0x00 && (script[index+1]&0x80 != 0x80) {
-			return nil, errors.New("Invalid signature data!")
-		}
-		index++
-	}
+	return nil
+}
 
-	ret.Signature = append(ret.Signature, script[index:index+32]...)
-	index += 32
+func (w TxWitness) encodeToBytes() []byte {
+	return encodeWitnessStack(w.toStack())
+}
 
-	if script[index] != SigHashAll {
-		return nil, errors.New("Only sigAll supported!")
+func decodeWitnessFromStack(stack [][]byte) (*TxWitness, error) {
+	if len(stack) == 0 {
+		return &TxWitness{}, nil
 	}
-	index++
 
-	pubLen := script[index]
-	index++
-	if pubLen != 0x21 {
-		return nil, errors.New("Only compressed pubkey is supported!")
+	if len(stack) == 2 {
+		signature, err := decodeDERSignature(stack[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(stack[1]) != 0x21 {
+			return nil, errors.New("Only compressed pubkey is supported!")
+		}
+		return &TxWitness{Signature: signature, Pubkey: stack[1]}, nil
 	}
 
-	ret.Pubkey = script[index : index+33]
-	index += 33
-
-	if (rLen+sLen+4 != rsLen) || (rsLen+3 != sigLen) || (sigLen+pubLen+2 != byte(len(script))) {
-		return nil, errors.New("Invalid transaction data!")
+	redeem := stack[len(stack)-1]
+	if len(stack[0]) != 0 || !isMultiSigScript(redeem) {
+		return nil, errors.New("Unsupported witness data!")
 	}
 
-	if index != len(script) {
-		return nil, errors.New("Invalid transaction data!")
+	w := &TxWitness{RedeemScript: redeem}
+	for _, sig := range stack[1 : len(stack)-1] {
+		signature, err := decodeDERSignature(sig)
+		if err != nil {
+			return nil, err
+		}
+		w.MultiSig = append(w.MultiSig, SignaturePubkey{Signature: signature})
 	}
-	return &ret, nil
+	return w, nil
 }
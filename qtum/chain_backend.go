@@ -0,0 +1,244 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//ChainBackend 链数据后端，WalletManager的链上查询和广播都通过后端完成
+//内置核心钱包(RPCServerCore)、浏览器API(RPCServerExplorer)、ElectrumX(RPCServerElectrum)和离线使用的模拟链(SimChain)
+type ChainBackend interface {
+	//GetBlockHeight 获取区块链高度
+	GetBlockHeight() (uint64, error)
+	//GetBlockHash 根据区块高度获得区块hash
+	GetBlockHash(height uint64) (string, error)
+	//GetBlock 获取区块数据
+	GetBlock(hash string) (*Block, error)
+	//GetTxIDsInMemPool 获取待处理的交易池中的交易单IDs
+	GetTxIDsInMemPool() ([]string, error)
+	//GetTransaction 获取交易单
+	GetTransaction(txid string) (*Transaction, error)
+	//CompleteTransaction 补充交易单中后端没有直接返回的数据，如代币转账回执和gas退款标记
+	CompleteTransaction(trx *Transaction, isCoinstake bool) error
+	//GetTxOut 获取交易单输出信息
+	GetTxOut(txid string, vout uint64) (*Vout, error)
+	//ListUnspent 获取地址的未花记录，min为最小确认数
+	ListUnspent(min uint64, addresses ...string) ([]*Unspent, error)
+	//ImportAddress 导入需要查询余额和utxo的地址，不需要导入的后端直接返回
+	ImportAddress(address string) error
+	//SendRawTransaction 广播交易
+	SendRawTransaction(txHex string) (string, error)
+	//EstimateFeeRate 预估的每KB手续费率
+	EstimateFeeRate() (decimal.Decimal, error)
+	//CallContract 试运行合约调用，不产生交易，from为空时不指定调用者
+	CallContract(contractAddress, dataHex, from string) (*QRC20Unspent, error)
+	//GetQRC20Balance 获取地址的QRC20余额
+	GetQRC20Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error)
+	//GetQRC721Balance 获取地址拥有的QRC721数量
+	GetQRC721Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error)
+}
+
+//TxNotFoundError 后端明确查询不到交易单，交易单不在交易池也没有上链
+type TxNotFoundError struct {
+	TxID string
+}
+
+func (e *TxNotFoundError) Error() string {
+	return fmt.Sprintf("no such mempool or blockchain transaction: %s", e.TxID)
+}
+
+//ChainCapability 链数据后端可选的能力
+type ChainCapability int
+
+const (
+	//CapabilityMemPoolList GetTxIDsInMemPool返回完整的交易池
+	CapabilityMemPoolList ChainCapability = iota + 1
+	//CapabilityCallContract CallContract可以试运行合约方法并返回消耗的gas
+	CapabilityCallContract
+)
+
+//capabilityReporter 不支持全部能力的后端实现，没有实现的后端视为支持全部能力
+type capabilityReporter interface {
+	Supports(capability ChainCapability) bool
+}
+
+//supports 当前链数据后端是否支持能力
+func (wm *WalletManager) supports(capability ChainCapability) bool {
+	if reporter, ok := wm.chain().(capabilityReporter); ok {
+		return reporter.Supports(capability)
+	}
+	return true
+}
+
+//chain 当前使用的链数据后端，没有设置Backend时按RPCServerType选择
+func (wm *WalletManager) chain() ChainBackend {
+	if wm.Backend != nil {
+		return wm.Backend
+	}
+	switch wm.Config.RPCServerType {
+	case RPCServerExplorer:
+		return &explorerBackend{wm: wm}
+	case RPCServerElectrum:
+		return &electrumBackend{wm: wm}
+	}
+	return &coreBackend{wm: wm}
+}
+
+//coreBackend 通过核心钱包的RPC接口查询
+type coreBackend struct {
+	wm *WalletManager
+}
+
+func (b *coreBackend) GetBlockHeight() (uint64, error) {
+	return b.wm.getBlockHeightByCore()
+}
+
+func (b *coreBackend) GetBlockHash(height uint64) (string, error) {
+	return b.wm.getBlockHashByCore(height)
+}
+
+func (b *coreBackend) GetBlock(hash string) (*Block, error) {
+	return b.wm.getBlockByCore(hash)
+}
+
+func (b *coreBackend) GetTxIDsInMemPool() ([]string, error) {
+	return b.wm.getTxIDsInMemPoolByCore()
+}
+
+//GetTransactions 扫块时批量获取交易单
+func (b *coreBackend) GetTransactions(txids []string) ([]*Transaction, error) {
+	return b.wm.getTransactionsByCore(txids)
+}
+
+func (b *coreBackend) GetTransaction(txid string) (*Transaction, error) {
+	return b.wm.getTransactionByCore(txid)
+}
+
+//CompleteTransaction 核心钱包的交易单没有代币转账记录，也没有标记gas退款
+func (b *coreBackend) CompleteTransaction(trx *Transaction, isCoinstake bool) error {
+	if err := b.wm.fillTokenReceiptsByCore(trx); err != nil {
+		return err
+	}
+	//gas退款在coinbase或coinstake交易中
+	if trx.mayContainGasRefunds(isCoinstake) {
+		return b.wm.markGasRefundsByCore(trx)
+	}
+	return nil
+}
+
+func (b *coreBackend) GetTxOut(txid string, vout uint64) (*Vout, error) {
+	return b.wm.getTxOutByCore(txid, vout)
+}
+
+func (b *coreBackend) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
+	return b.wm.getListUnspentByCore(min, addresses...)
+}
+
+//ImportAddress 需要导入地址到core，这样才能查询地址余额和utxo
+func (b *coreBackend) ImportAddress(address string) error {
+	return b.wm.ImportAddress(address, "")
+}
+
+func (b *coreBackend) SendRawTransaction(txHex string) (string, error) {
+	return b.wm.sendRawTransactionByCore(txHex)
+}
+
+func (b *coreBackend) EstimateFeeRate() (decimal.Decimal, error) {
+	return b.wm.estimateFeeRateByCore()
+}
+
+func (b *coreBackend) CallContract(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+	return b.wm.callContractByCore(contractAddress, dataHex, from)
+}
+
+func (b *coreBackend) GetQRC20Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return b.wm.GetQRC20UnspentByAddress(token.Address, address, token.Decimals)
+}
+
+func (b *coreBackend) GetQRC721Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return b.wm.getQRC721BalanceByCall(token, address)
+}
+
+//explorerBackend 通过浏览器API查询
+type explorerBackend struct {
+	wm *WalletManager
+}
+
+//Supports 浏览器没有交易池列表，也不能估算合约调用的gas
+func (b *explorerBackend) Supports(capability ChainCapability) bool {
+	return false
+}
+
+func (b *explorerBackend) GetBlockHeight() (uint64, error) {
+	return b.wm.getBlockHeightByExplorer()
+}
+
+func (b *explorerBackend) GetBlockHash(height uint64) (string, error) {
+	return b.wm.getBlockHashByExplorer(height)
+}
+
+func (b *explorerBackend) GetBlock(hash string) (*Block, error) {
+	return b.wm.getBlockByExplorer(hash)
+}
+
+func (b *explorerBackend) GetTxIDsInMemPool() ([]string, error) {
+	return b.wm.getTxIDsInMemPoolByExplorer()
+}
+
+func (b *explorerBackend) GetTransaction(txid string) (*Transaction, error) {
+	return b.wm.getTransactionByExplorer(txid)
+}
+
+//CompleteTransaction 浏览器的交易单已包含代币转账记录
+func (b *explorerBackend) CompleteTransaction(trx *Transaction, isCoinstake bool) error {
+	return nil
+}
+
+func (b *explorerBackend) GetTxOut(txid string, vout uint64) (*Vout, error) {
+	return b.wm.getTxOutByExplorer(txid, vout)
+}
+
+func (b *explorerBackend) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
+	return b.wm.listUnspentByExplorer(addresses...)
+}
+
+func (b *explorerBackend) ImportAddress(address string) error {
+	return nil
+}
+
+func (b *explorerBackend) SendRawTransaction(txHex string) (string, error) {
+	return b.wm.sendRawTransactionByExplorer(txHex)
+}
+
+func (b *explorerBackend) EstimateFeeRate() (decimal.Decimal, error) {
+	return b.wm.estimateFeeRateByExplorer()
+}
+
+func (b *explorerBackend) CallContract(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+	return b.wm.callContractByExplorer(contractAddress, dataHex, from)
+}
+
+func (b *explorerBackend) GetQRC20Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return b.wm.getAddressTokenBalanceByExplorer(token, address)
+}
+
+func (b *explorerBackend) GetQRC721Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return b.wm.getAddressQRC721BalanceByExplorer(token, address)
+}
@@ -0,0 +1,414 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	CoinSelectionBranchAndBound = "branchAndBound" //精确匹配，不产生找零
+	CoinSelectionLargestFirst   = "largestFirst"   //优先使用大额utxo，输入最少
+	CoinSelectionSmallestFirst  = "smallestFirst"  //优先使用小额utxo，合并零钱
+	CoinSelectionOldestFirst    = "oldestFirst"    //优先使用确认数多的utxo
+	CoinSelectionRandom         = "random"         //随机选择，避免暴露钱包的utxo分布
+
+	//bnbMaxTries 分支定界搜索的最大尝试次数
+	bnbMaxTries = 100000
+)
+
+var (
+	//errCoinsNotEnough 可用utxo不足以支付金额和手续费
+	errCoinsNotEnough = errors.New("available utxo is not enough")
+
+	coinSelectorsMu sync.RWMutex
+	coinSelectors   = map[string]CoinSelector{
+		CoinSelectionBranchAndBound: &bnbCoinSelector{fallback: newOrderedCoinSelector(orderByAmountDesc)},
+		CoinSelectionLargestFirst:   newOrderedCoinSelector(orderByAmountDesc),
+		CoinSelectionSmallestFirst:  newOrderedCoinSelector(orderByAmountAsc),
+		CoinSelectionOldestFirst:    newOrderedCoinSelector(orderByConfirmations),
+		CoinSelectionRandom:         newOrderedCoinSelector(orderByRandom),
+	}
+)
+
+//CoinSelectionTarget 选币的目标
+type CoinSelectionTarget struct {
+	//需要支付的金额，不含手续费
+	Amount decimal.Decimal
+	//每KB费率
+	FeeRate decimal.Decimal
+	//找零低于该值时不创建找零，计入手续费
+	Dust decimal.Decimal
+	//最大的输入数量
+	MaxInputs int
+	//必须使用的utxo，排在输入的最前面
+	Required []*Unspent
+	//根据所选输入估算交易单的虚拟大小，change为是否有找零输出
+	VSize func(inputs []*Unspent, change bool) (uint64, error)
+	//根据虚拟大小计算手续费
+	Fee func(vsize uint64) decimal.Decimal
+}
+
+//fees 所选输入的手续费
+func (target *CoinSelectionTarget) fees(inputs []*Unspent, change bool) (decimal.Decimal, error) {
+	vsize, err := target.VSize(inputs, change)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return target.Fee(vsize), nil
+}
+
+//CoinSelectionResult 选币的结果
+type CoinSelectionResult struct {
+	//使用的utxo
+	Inputs []*Unspent
+	//输入总额
+	Balance decimal.Decimal
+	//手续费
+	Fees decimal.Decimal
+	//找零，为0时不创建找零输出
+	Change decimal.Decimal
+}
+
+//CoinSelector utxo选择策略
+type CoinSelector interface {
+	//SelectCoins 从可用utxo中选择足够支付目标金额和手续费的输入
+	SelectCoins(unspents []*Unspent, target *CoinSelectionTarget) (*CoinSelectionResult, error)
+}
+
+//RegisterCoinSelector 注册utxo选择策略，可通过配置coinSelection或交易单ExtParam的coinSelection使用
+func RegisterCoinSelector(name string, selector CoinSelector) {
+	coinSelectorsMu.Lock()
+	defer coinSelectorsMu.Unlock()
+	coinSelectors[name] = selector
+}
+
+//GetCoinSelector 获取utxo选择策略
+func GetCoinSelector(name string) (CoinSelector, error) {
+	coinSelectorsMu.RLock()
+	defer coinSelectorsMu.RUnlock()
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy: %s", name)
+	}
+	return selector, nil
+}
+
+//coinSelector 交易单ExtParam的coinSelection优先，否则使用配置的策略
+func (decoder *TransactionDecoder) coinSelector(ext gjson.Result) (CoinSelector, error) {
+	name := ext.Get("coinSelection").String()
+	if len(name) == 0 {
+		name = decoder.wm.Config.CoinSelection
+	}
+	if len(name) == 0 {
+		name = CoinSelectionLargestFirst
+	}
+	return GetCoinSelector(name)
+}
+
+//newCoinSelectionTarget 创建选币目标，手续费按签名后交易单的虚拟大小计算
+func (decoder *TransactionDecoder) newCoinSelectionTarget(estimator *txSizeEstimator, amount decimal.Decimal, feeRate decimal.Decimal) *CoinSelectionTarget {
+	return &CoinSelectionTarget{
+		Amount:    amount,
+		FeeRate:   feeRate,
+		Dust:      decoder.wm.Config.DustThreshold,
+		MaxInputs: decoder.wm.Config.maxTxInputs,
+		VSize:     estimator.VSize,
+		Fee: func(vsize uint64) decimal.Decimal {
+			return decoder.wm.EstimateFeeByVSize(vsize, feeRate)
+		},
+	}
+}
+
+//spendableUnspents 可用的utxo，排除必须使用的utxo
+func spendableUnspents(unspents []*Unspent, exclude []*Unspent) []*Unspent {
+	spendable := make([]*Unspent, 0, len(unspents))
+	for _, u := range unspents {
+		if !u.Spendable {
+			continue
+		}
+		excluded := false
+		for _, e := range exclude {
+			if e == u || (e.TxID == u.TxID && e.Vout == u.Vout) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			spendable = append(spendable, u)
+		}
+	}
+	return spendable
+}
+
+func unspentAmount(u *Unspent) decimal.Decimal {
+	amount, _ := decimal.NewFromString(u.Amount)
+	return amount
+}
+
+//finishSelection 计算所选输入的手续费和找零，找零低于粉尘值时并入手续费
+func finishSelection(inputs []*Unspent, target *CoinSelectionTarget) (*CoinSelectionResult, bool, error) {
+	balance := decimal.Zero
+	for _, u := range inputs {
+		balance = balance.Add(unspentAmount(u))
+	}
+
+	feesNoChange, err := target.fees(inputs, false)
+	if err != nil {
+		return nil, false, err
+	}
+	if balance.LessThan(target.Amount.Add(feesNoChange)) {
+		return nil, false, nil
+	}
+
+	feesWithChange, err := target.fees(inputs, true)
+	if err != nil {
+		return nil, false, err
+	}
+
+	result := &CoinSelectionResult{
+		Inputs:  inputs,
+		Balance: balance,
+		Fees:    feesWithChange,
+		Change:  balance.Sub(target.Amount).Sub(feesWithChange),
+	}
+
+	if result.Change.LessThanOrEqual(decimal.Zero) || result.Change.LessThan(target.Dust) {
+		result.Fees = balance.Sub(target.Amount)
+		result.Change = decimal.Zero
+	}
+
+	return result, true, nil
+}
+
+//orderedCoinSelector 按顺序累加utxo，直到足够支付金额和手续费
+type orderedCoinSelector struct {
+	order func(unspents []*Unspent)
+}
+
+func newOrderedCoinSelector(order func(unspents []*Unspent)) *orderedCoinSelector {
+	return &orderedCoinSelector{order: order}
+}
+
+func (s *orderedCoinSelector) SelectCoins(unspents []*Unspent, target *CoinSelectionTarget) (*CoinSelectionResult, error) {
+	if target.MaxInputs > 0 && len(target.Required) > target.MaxInputs {
+		return nil, fmt.Errorf("The transaction is use max inputs over: %d", target.MaxInputs)
+	}
+
+	candidates := spendableUnspents(unspents, target.Required)
+	s.order(candidates)
+
+	inputs := make([]*Unspent, 0, len(target.Required)+len(candidates))
+	inputs = append(inputs, target.Required...)
+
+	for i := 0; ; i++ {
+		if len(inputs) > 0 {
+			result, ok, err := finishSelection(inputs, target)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return result, nil
+			}
+		}
+		if i == len(candidates) {
+			return nil, errCoinsNotEnough
+		}
+		//输入数量已达上限仍不足以支付
+		if target.MaxInputs > 0 && len(inputs) >= target.MaxInputs {
+			return nil, fmt.Errorf("The transaction is use max inputs over: %d", target.MaxInputs)
+		}
+		inputs = append(inputs, candidates[i])
+	}
+}
+
+func orderByAmountDesc(unspents []*Unspent) {
+	sort.Stable(UnspentSort{unspents, func(a, b *Unspent) int {
+		return unspentAmount(b).Cmp(unspentAmount(a))
+	}})
+}
+
+func orderByAmountAsc(unspents []*Unspent) {
+	sort.Stable(UnspentSort{unspents, func(a, b *Unspent) int {
+		return unspentAmount(a).Cmp(unspentAmount(b))
+	}})
+}
+
+func orderByConfirmations(unspents []*Unspent) {
+	sort.Stable(UnspentSort{unspents, func(a, b *Unspent) int {
+		if a.Confirmations > b.Confirmations {
+			return -1
+		} else if a.Confirmations < b.Confirmations {
+			return 1
+		}
+		return unspentAmount(b).Cmp(unspentAmount(a))
+	}})
+}
+
+var (
+	randomMu     sync.Mutex
+	randomSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func orderByRandom(unspents []*Unspent) {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	randomSource.Shuffle(len(unspents), func(i, j int) {
+		unspents[i], unspents[j] = unspents[j], unspents[i]
+	})
+}
+
+//bnbCoinSelector 分支定界选币(Branch and Bound)，寻找不需要找零的输入组合
+//找零成本为创建并在以后花费一个找零输出的手续费，输入总额在[目标, 目标+找零成本]内即可不找零
+//找不到组合时使用fallback策略
+type bnbCoinSelector struct {
+	fallback CoinSelector
+}
+
+func (s *bnbCoinSelector) SelectCoins(unspents []*Unspent, target *CoinSelectionTarget) (*CoinSelectionResult, error) {
+	candidates := spendableUnspents(unspents, target.Required)
+
+	//有效金额 = utxo金额 - 花费该utxo的手续费
+	kb := decimal.New(1000, 0)
+	baseVSize, err := target.VSize(target.Required, false)
+	if err != nil {
+		return nil, err
+	}
+	selectionTarget := target.Amount.Add(target.FeeRate.Mul(decimal.New(int64(baseVSize), 0)).Div(kb))
+
+	pool := make([]*Unspent, 0, len(candidates))
+	values := make(map[*Unspent]decimal.Decimal, len(candidates))
+	for _, u := range candidates {
+		vsize, err := target.VSize(append(append(make([]*Unspent, 0, len(target.Required)+1), target.Required...), u), false)
+		if err != nil {
+			return nil, err
+		}
+		value := unspentAmount(u).Sub(target.FeeRate.Mul(decimal.New(int64(vsize-baseVSize), 0)).Div(kb))
+		if value.GreaterThan(decimal.Zero) {
+			pool = append(pool, u)
+			values[u] = value
+		}
+	}
+	sort.Stable(UnspentSort{pool, func(a, b *Unspent) int {
+		return values[b].Cmp(values[a])
+	}})
+
+	//找零成本 = 找零输出的手续费 + 以后花费找零的手续费，按第一个输入估算
+	costOfChange := decimal.Zero
+	if len(pool) > 0 {
+		first := append(append(make([]*Unspent, 0, len(target.Required)+1), target.Required...), pool[0])
+		withChange, err := target.VSize(first, true)
+		if err != nil {
+			return nil, err
+		}
+		costOfChange = target.FeeRate.Mul(decimal.New(int64(withChange-baseVSize), 0)).Div(kb)
+	}
+
+	search := &bnbSearch{
+		values: make([]decimal.Decimal, len(pool)),
+		target: selectionTarget,
+		upper:  selectionTarget.Add(costOfChange),
+	}
+	remaining := decimal.Zero
+	for i, u := range pool {
+		search.values[i] = values[u]
+		remaining = remaining.Add(search.values[i])
+	}
+	search.selected = make([]bool, len(pool))
+	search.search(0, decimal.Zero, remaining)
+
+	if search.found {
+		inputs := make([]*Unspent, 0, len(target.Required)+len(pool))
+		inputs = append(inputs, target.Required...)
+		for i, u := range pool {
+			if search.best[i] {
+				inputs = append(inputs, u)
+			}
+		}
+
+		//按实际的手续费算法复核，最低手续费可能高于按费率计算的结果
+		if target.MaxInputs <= 0 || len(inputs) <= target.MaxInputs {
+			result, ok, err := finishSelection(inputs, target)
+			if err != nil {
+				return nil, err
+			}
+			if ok && result.Change.IsZero() {
+				return result, nil
+			}
+		}
+	}
+
+	if s.fallback == nil {
+		return nil, errCoinsNotEnough
+	}
+	return s.fallback.SelectCoins(unspents, target)
+}
+
+type bnbSearch struct {
+	values        []decimal.Decimal
+	target, upper decimal.Decimal
+	tries         int
+	selected      []bool
+	best          []bool
+	bestExcess    decimal.Decimal
+	found         bool
+}
+
+//search 深度优先搜索，先尝试包含values[i]，再尝试不包含，保留超出目标最少的组合
+func (s *bnbSearch) search(i int, curr, remaining decimal.Decimal) {
+	if s.tries >= bnbMaxTries {
+		return
+	}
+	s.tries++
+
+	if curr.GreaterThan(s.upper) || curr.Add(remaining).LessThan(s.target) {
+		return
+	}
+
+	if curr.GreaterThanOrEqual(s.target) {
+		excess := curr.Sub(s.target)
+		if !s.found || excess.LessThan(s.bestExcess) {
+			s.best = make([]bool, len(s.selected))
+			copy(s.best, s.selected)
+			s.bestExcess = excess
+			s.found = true
+		}
+		return
+	}
+
+	if i == len(s.values) {
+		return
+	}
+
+	remaining = remaining.Sub(s.values[i])
+
+	//前一个相同金额的utxo未被包含时，包含当前utxo的分支与之前的分支重复
+	if i == 0 || !s.values[i].Equal(s.values[i-1]) || s.selected[i-1] {
+		s.selected[i] = true
+		s.search(i+1, curr.Add(s.values[i]), remaining)
+		s.selected[i] = false
+	}
+
+	s.search(i+1, curr, remaining)
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+//testCoinSelectionTarget 每个输入100字节，每个输出34字节，一个接收输出
+func testCoinSelectionTarget(amount string, maxInputs int) *CoinSelectionTarget {
+	feeRate := decimal.New(1, -3)
+	return &CoinSelectionTarget{
+		Amount:    decimal.RequireFromString(amount),
+		FeeRate:   feeRate,
+		Dust:      DefaultDustThreshold,
+		MaxInputs: maxInputs,
+		VSize: func(inputs []*Unspent, change bool) (uint64, error) {
+			vsize := uint64(10 + 100*len(inputs) + 34)
+			if change {
+				vsize += 34
+			}
+			return vsize, nil
+		},
+		Fee: func(vsize uint64) decimal.Decimal {
+			return feeRate.Mul(decimal.New(int64(vsize), 0)).Div(decimal.New(1000, 0))
+		},
+	}
+}
+
+func TestSelectCoins(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		selection  string
+		unspents   []string
+		amount     string
+		maxInputs  int
+		wantInputs []string
+		wantChange bool
+		wantErr    string
+	}{
+		{
+			//0.5+0.2正好支付金额和两个输入的手续费
+			name:       "bnb exact changeless match",
+			selection:  CoinSelectionBranchAndBound,
+			unspents:   []string{"0.3", "0.5", "0.2", "0.7"},
+			amount:     "0.699756",
+			wantInputs: []string{"0.5", "0.2"},
+		},
+		{
+			name:       "bnb fallback to largest first",
+			selection:  CoinSelectionBranchAndBound,
+			unspents:   []string{"0.3", "1"},
+			amount:     "0.5",
+			wantInputs: []string{"1"},
+			wantChange: true,
+		},
+		{
+			name:       "within max inputs",
+			selection:  CoinSelectionLargestFirst,
+			unspents:   []string{"0.1", "0.1", "0.1", "0.1", "0.1"},
+			amount:     "0.35",
+			maxInputs:  4,
+			wantInputs: []string{"0.1", "0.1", "0.1", "0.1"},
+			wantChange: true,
+		},
+		{
+			name:      "over max inputs",
+			selection: CoinSelectionLargestFirst,
+			unspents:  []string{"0.1", "0.1", "0.1", "0.1", "0.1"},
+			amount:    "0.35",
+			maxInputs: 3,
+			wantErr:   "max inputs",
+		},
+		{
+			name:      "bnb over max inputs",
+			selection: CoinSelectionBranchAndBound,
+			unspents:  []string{"0.1", "0.1", "0.1", "0.1", "0.1"},
+			amount:    "0.35",
+			maxInputs: 3,
+			wantErr:   "max inputs",
+		},
+		{
+			name:      "insufficient funds",
+			selection: CoinSelectionSmallestFirst,
+			unspents:  []string{"0.1", "0.2"},
+			amount:    "0.3",
+			wantErr:   errCoinsNotEnough.Error(),
+		},
+		{
+			name:      "bnb insufficient funds",
+			selection: CoinSelectionBranchAndBound,
+			unspents:  []string{"0.1", "0.2"},
+			amount:    "0.5",
+			wantErr:   errCoinsNotEnough.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		selector, err := GetCoinSelector(test.selection)
+		if err != nil {
+			t.Fatalf("%s: GetCoinSelector failed: %v", test.name, err)
+		}
+
+		unspents := make([]*Unspent, 0, len(test.unspents))
+		for i, amount := range test.unspents {
+			unspents = append(unspents, &Unspent{TxID: "tx", Vout: uint64(i), Amount: amount, Spendable: true})
+		}
+
+		result, err := selector.SelectCoins(unspents, testCoinSelectionTarget(test.amount, test.maxInputs))
+		if len(test.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v, want: %s", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: SelectCoins failed: %v", test.name, err)
+			continue
+		}
+
+		inputs := make([]string, 0, len(result.Inputs))
+		for _, u := range result.Inputs {
+			inputs = append(inputs, u.Amount)
+		}
+		if strings.Join(inputs, ",") != strings.Join(test.wantInputs, ",") {
+			t.Errorf("%s: inputs = %v, want: %v", test.name, inputs, test.wantInputs)
+		}
+		if result.Change.GreaterThan(decimal.Zero) != test.wantChange {
+			t.Errorf("%s: change = %s, want change: %v", test.name, result.Change.String(), test.wantChange)
+		}
+		if !result.Balance.Equal(result.Fees.Add(result.Change).Add(decimal.RequireFromString(test.amount))) {
+			t.Errorf("%s: balance %s != amount + fees %s + change %s", test.name, result.Balance, result.Fees, result.Change)
+		}
+	}
+}
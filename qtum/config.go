@@ -17,7 +17,6 @@ package qtum
 
 import (
 	"fmt"
-	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
 	"path/filepath"
 	"strings"
 	"time"
@@ -45,15 +44,38 @@ const (
 	CurveType          = owcrypt.ECC_CURVE_SECP256K1
 	RPCServerCore      = 0   //RPC服务，bitcoin核心钱包
 	RPCServerExplorer  = 1   //RPC服务，insight-API
+	RPCServerElectrum  = 2   //RPC服务，ElectrumX
 	StakeConfirmations = 500 //qtum规定500个确认的权益
 )
 
+const (
+	//区块提取流水线的默认配置
+	DefaultExtractWorkers   = 15  //并发的提取线程数
+	DefaultExtractQueueSize = 100 //已提取未通知的交易单上限
+	DefaultExtractBatchSize = 10  //每批获取的交易单数量
+)
+
+var (
+	//默认替换交易的最低增量费率，与节点的最低转发费率一致
+	DefaultIncrementalRelayFee = decimal.New(4, -3)
+	//默认的QRC20 gas安全系数
+	DefaultGasLimitMultiplier = decimal.New(15, -1)
+	//默认粉尘值，(34+148)字节 * 0.004/KB
+	DefaultDustThreshold = decimal.New(728, -6)
+)
+
 const (
 	QTUM_GET_TOKEN_BALANCE_METHOD      = "0x70a08231"
 	QTUM_TRANSFER_TOKEN_BALANCE_METHOD = "0xa9059cbb"
 	QTUM_TRANSFER_EVENT_ID             = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
 )
 
+const (
+	//代币协议
+	QRC20Protocol  = "qrc20"
+	QRC721Protocol = "qrc721"
+)
+
 type WalletConfig struct {
 
 	//币种
@@ -78,8 +100,8 @@ type WalletConfig struct {
 	certFileName string
 	//区块链数据文件
 	//blockchainFile string
-	//是否测试网络
-	isTestNet bool
+	//网络参数，mainnet、testnet、regtest或custom
+	Network *ChainParams
 	// 核心钱包是否只做监听
 	CoreWalletWatchOnly bool
 	//最大的输入数量
@@ -110,14 +132,67 @@ type WalletConfig struct {
 	RPCServerType int
 	//数据目录
 	DataDir string
-	//代币转账最低成本
+	//代币转账最低成本，已由gasLimit * gasPrice代替
 	TokenTransferCost string
+	//QRC20合约调用的gas上限
+	GasLimit uint64
+	//部署合约的gas上限
+	CreateGasLimit uint64
+	//QRC20合约调用的gas单价
+	GasPrice decimal.Decimal
+	//是否通过callcontract估算gas上限
+	EstimateGasLimit bool
+	//估算gas上限的安全系数
+	GasLimitMultiplier decimal.Decimal
 	//最低手续费
 	MinFees decimal.Decimal
-	//主网地址前缀
-	MainNetAddressPrefix btcLikeTxDriver.AddressPrefix
-	//测试网地址前缀
-	TestNetAddressPrefix btcLikeTxDriver.AddressPrefix
+	//交易单是否支持追加手续费(BIP-125)
+	EnableRBF bool
+	//替换交易的最低增量费率(每KB)
+	IncrementalRelayFee decimal.Decimal
+	//utxo选择策略
+	CoinSelection string
+	//找零低于粉尘值时并入手续费
+	DustThreshold decimal.Decimal
+	//重组日志保留的区块数量
+	ReorgJournalDepth uint64
+	//交易单确认数通知的阀值，升序
+	ConfirmationThresholds []uint64
+	//是否扫描交易池
+	ScanMemPool bool
+	//核心钱包zmqpubhashblock的地址，如tcp://127.0.0.1:28332
+	ZMQPubHashBlock string
+	//核心钱包zmqpubrawtx的地址，scanMemPool开启时订阅
+	ZMQPubRawTx string
+	//区块提取的并发线程数
+	ExtractWorkers int
+	//区块提取的队列深度，已提取但未按顺序通知的交易单上限
+	ExtractQueueSize int
+	//区块提取每批获取的交易单数量
+	ExtractBatchSize int
+	//ElectrumX的ssl连接不校验证书，用于自签名证书的服务
+	ElectrumSkipVerify bool
+	//apiURL配置多个节点时的切换策略，priority或roundrobin
+	EndpointStrategy string
+	//节点连续失败多少次后熔断
+	BreakerFailures int
+	//节点熔断的时长
+	BreakerTimeout time.Duration
+	//熔断节点的健康检查间隔，0为不检查
+	HealthCheckInterval time.Duration
+	//区块hash和交易输出需要一致的节点数量，小于2时不启用
+	Quorum int
+	//核心钱包RPC请求超时
+	RPCTimeout time.Duration
+	//核心钱包所有节点都不可用时的重试次数
+	RPCMaxRetries int
+	//核心钱包首次重试的等待时间，之后每次加倍
+	RPCRetryBackoff time.Duration
+	//核心钱包的.cookie文件，设置后代替rpcUser和rpcPassword
+	RPCCookieFile string
+	//https客户端证书和私钥，位于证书目录
+	rpcClientCert string
+	rpcClientKey  string
 }
 
 func NewConfig(symbol string) *WalletConfig {
@@ -149,8 +224,8 @@ func NewConfig(symbol string) *WalletConfig {
 	c.certFileName = "rpc.cert"
 	//区块链数据文件
 	//c.blockchainFile = "blockchain.db"
-	//是否测试网络
-	c.isTestNet = true
+	//网络参数
+	c.Network, _ = NetworkParams(NetworkTestnet)
 	// 核心钱包是否只做监听
 	c.CoreWalletWatchOnly = true
 	//最大的输入数量
@@ -176,8 +251,24 @@ func NewConfig(symbol string) *WalletConfig {
 	//后台数据源类型
 	c.RPCServerType = RPCServerCore
 
-	c.MainNetAddressPrefix = btcLikeTxDriver.QTUMMainnetAddressPrefix
-	c.TestNetAddressPrefix = btcLikeTxDriver.QTUMTestnetAddressPrefix
+	//替换交易的最低增量费率
+	c.IncrementalRelayFee = DefaultIncrementalRelayFee
+	c.CoinSelection = CoinSelectionLargestFirst
+	c.DustThreshold = DefaultDustThreshold
+	c.GasLimit = DEFAULT_GAS_LIMIT
+	c.CreateGasLimit = DEFAULT_CREATE_GAS_LIMIT
+	c.GasPrice = DEFAULT_GAS_PRICE
+	c.GasLimitMultiplier = DefaultGasLimitMultiplier
+	c.ReorgJournalDepth = StakeConfirmations
+	c.ExtractWorkers = DefaultExtractWorkers
+	c.ExtractQueueSize = DefaultExtractQueueSize
+	c.ExtractBatchSize = DefaultExtractBatchSize
+	c.EndpointStrategy = EndpointPriority
+	c.BreakerFailures = DefaultBreakerFailures
+	c.BreakerTimeout = DefaultBreakerTimeout
+	c.HealthCheckInterval = DefaultHealthCheckInterval
+	c.RPCTimeout = DefaultRPCTimeout
+	c.RPCRetryBackoff = DefaultRPCRetryBackoff
 
 	return &c
 }
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//ConfirmationEvent 交易单的确认数通知
+type ConfirmationEvent struct {
+	SourceKey     string
+	Data          *openwallet.TxExtractData
+	BlockHeight   uint64
+	BlockHash     string
+	Confirmations uint64 //当前的确认数
+	Threshold     uint64 //达到的确认数阀值
+	Immature      bool   //coinbase或coinstake的奖励未达到StakeConfirmations个确认，不能花费
+	Finalized     bool   //达到最终的确认数，之后不再通知
+}
+
+//ConfirmationNotificationObject 观察者可选实现的接口，交易单达到配置的确认数时通知
+type ConfirmationNotificationObject interface {
+	//BlockExtractDataConfirmNotify 交易单达到确认数阀值
+	BlockExtractDataConfirmNotify(event *ConfirmationEvent) error
+}
+
+//TrackedTx 跟踪确认数的交易单提取结果
+type TrackedTx struct {
+	ID        string                    `storm:"id"`
+	Height    uint64                    `storm:"index"`
+	BlockHash string                    `json:"blockHash"`
+	SourceKey string                    `json:"sourceKey"`
+	Data      *openwallet.TxExtractData `json:"data"`
+	Immature  bool                      `json:"immature"`
+	Notified  uint64                    `json:"notified"` //已通知的最大阀值
+}
+
+//finalConfirmations 最终的确认数，奖励需要StakeConfirmations个确认
+func finalConfirmations(thresholds []uint64, immature bool) uint64 {
+	final := uint64(0)
+	if len(thresholds) > 0 {
+		final = thresholds[len(thresholds)-1]
+	}
+	if immature && final < StakeConfirmations {
+		final = StakeConfirmations
+	}
+	return final
+}
+
+//parseConfirmationThresholds 解析逗号分隔的确认数阀值，按升序排列并去重
+func parseConfirmationThresholds(s string) ([]uint64, error) {
+	thresholds := make([]uint64, 0)
+	exists := make(map[uint64]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(item, 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid confirmation threshold: %s", item)
+		}
+		if !exists[n] {
+			exists[n] = true
+			thresholds = append(thresholds, n)
+		}
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	return thresholds, nil
+}
+
+//TrackTxs 记录需要跟踪确认数的提取结果，已跟踪的不会重置
+func (j *ReorgJournal) TrackTxs(height uint64, hash string, extractData map[string]*openwallet.TxExtractData, immature bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	for sourceKey, data := range extractData {
+		tracked := TrackedTx{
+			ID:        fmt.Sprintf("%d_%s", height, journalRecordKey(sourceKey, data)),
+			Height:    height,
+			BlockHash: hash,
+			SourceKey: sourceKey,
+			Data:      data,
+			Immature:  immature,
+		}
+		var exist TrackedTx
+		if err := db.One("ID", tracked.ID, &exist); err == nil {
+			continue
+		}
+		if err := db.Save(&tracked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//TrackedTxs 所有跟踪中的提取结果
+func (j *ReorgJournal) TrackedTxs() ([]*TrackedTx, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return nil, err
+	}
+	var list []*TrackedTx
+	if err := db.All(&list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+//UpdateTracked 更新已通知的阀值，finalized为true时不再跟踪
+func (j *ReorgJournal) UpdateTracked(tracked *TrackedTx, finalized bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	if finalized {
+		return db.DeleteStruct(tracked)
+	}
+	return db.Save(tracked)
+}
+
+//DeleteTrackedTxs 删除孤块中跟踪的提取结果
+func (j *ReorgJournal) DeleteTrackedTxs(height uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	err = db.Select(q.Eq("Height", height)).Delete(new(TrackedTx))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//confirmationObservers 实现了ConfirmationNotificationObject的观察者
+func (bs *BTCBlockScanner) confirmationObservers() []ConfirmationNotificationObject {
+	observers := make([]ConfirmationNotificationObject, 0)
+	for o, _ := range bs.Observers {
+		if confirm, ok := o.(ConfirmationNotificationObject); ok {
+			observers = append(observers, confirm)
+		}
+	}
+	return observers
+}
+
+//trackConfirmations 跟踪提取结果的确认数，没有观察者接收确认数通知时不跟踪
+func (bs *BTCBlockScanner) trackConfirmations(height uint64, hash string, extractData map[string]*openwallet.TxExtractData, immature bool) {
+
+	if len(extractData) == 0 || len(bs.confirmationObservers()) == 0 {
+		return
+	}
+	if finalConfirmations(bs.wm.Config.ConfirmationThresholds, immature) == 0 {
+		return
+	}
+
+	//重扫失败记录时没有区块hash
+	if len(hash) == 0 {
+		if b, err := bs.reorgJournal().GetBlock(height); err == nil {
+			hash = b.Hash
+		}
+	}
+
+	if err := bs.reorgJournal().TrackTxs(height, hash, extractData, immature); err != nil {
+		bs.wm.Log.Std.Error("block height: %d, track confirmations failed. unexpected error: %v", height, err)
+	}
+}
+
+//newConfirmationNotify 按扫描高度计算跟踪中交易单的确认数，每个新达到的阀值通知一次
+//达到最终确认数时通知Finalized并停止跟踪，coinbase和coinstake在StakeConfirmations个确认前为Immature
+func (bs *BTCBlockScanner) newConfirmationNotify(currentHeight uint64) {
+
+	observers := bs.confirmationObservers()
+	if len(observers) == 0 {
+		return
+	}
+
+	list, err := bs.reorgJournal().TrackedTxs()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not get tracked transactions; unexpected error: %v", err)
+		return
+	}
+
+	thresholds := bs.wm.Config.ConfirmationThresholds
+	for _, tracked := range list {
+		if currentHeight < tracked.Height {
+			continue
+		}
+		confirmations := currentHeight - tracked.Height + 1
+		final := finalConfirmations(thresholds, tracked.Immature)
+
+		events := make([]*ConfirmationEvent, 0)
+		for _, threshold := range thresholds {
+			if threshold <= tracked.Notified || threshold > confirmations || threshold >= final {
+				continue
+			}
+			events = append(events, bs.newConfirmationEvent(tracked, confirmations, threshold))
+		}
+		finalized := confirmations >= final
+		if finalized {
+			event := bs.newConfirmationEvent(tracked, confirmations, final)
+			event.Finalized = true
+			events = append(events, event)
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		for _, event := range events {
+			for _, o := range observers {
+				if err := o.BlockExtractDataConfirmNotify(event); err != nil {
+					bs.wm.Log.Std.Error("block height: %d, BlockExtractDataConfirmNotify unexpected error: %v", tracked.Height, err)
+				}
+			}
+		}
+
+		tracked.Notified = events[len(events)-1].Threshold
+		if err := bs.reorgJournal().UpdateTracked(tracked, finalized); err != nil {
+			bs.wm.Log.Std.Error("block height: %d, update tracked transaction failed. unexpected error: %v", tracked.Height, err)
+		}
+	}
+}
+
+//newConfirmationEvent 创建确认数通知
+func (bs *BTCBlockScanner) newConfirmationEvent(tracked *TrackedTx, confirmations, threshold uint64) *ConfirmationEvent {
+	return &ConfirmationEvent{
+		SourceKey:     tracked.SourceKey,
+		Data:          tracked.Data,
+		BlockHeight:   tracked.Height,
+		BlockHash:     tracked.BlockHash,
+		Confirmations: confirmations,
+		Threshold:     threshold,
+		Immature:      tracked.Immature && confirmations < StakeConfirmations,
+	}
+}
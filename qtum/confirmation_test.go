@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//confirmationSummary 确认数通知的阀值、Immature和Finalized
+type confirmationSummary struct {
+	threshold uint64
+	immature  bool
+	finalized bool
+}
+
+func summarizeConfirmations(events []*ConfirmationEvent) []confirmationSummary {
+	list := make([]confirmationSummary, 0, len(events))
+	for _, event := range events {
+		list = append(list, confirmationSummary{event.Threshold, event.Immature, event.Finalized})
+	}
+	return list
+}
+
+func assertConfirmations(t *testing.T, observer *simObserver, want ...confirmationSummary) {
+	t.Helper()
+	got := summarizeConfirmations(observer.confirmations)
+	if len(got) != len(want) {
+		t.Fatalf("confirmations: %v, want: %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("confirmations: %v, want: %v", got, want)
+		}
+	}
+	observer.reset()
+}
+
+func TestNewConfirmationNotify_Thresholds(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	wm.Config.ConfirmationThresholds = []uint64{1, 3, 6}
+	w := newSimWallet(t, "confirmation")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, observer := newSimScanner(t, wm, chain, w)
+
+	deposits := fundBlocks(t, chain, addr.Address, 1)
+	bs.ScanBlockTask()
+	assertConfirmations(t, observer, confirmationSummary{1, false, false})
+
+	chain.Mine(1)
+	bs.ScanBlockTask()
+	assertConfirmations(t, observer)
+
+	chain.Mine(1)
+	bs.ScanBlockTask()
+	assertConfirmations(t, observer, confirmationSummary{3, false, false})
+
+	//最终确认数只通知Finalized
+	chain.Mine(3)
+	bs.ScanBlockTask()
+	event := observer.confirmations[0]
+	if event.Data.Transaction.TxID != deposits[0] || event.BlockHeight != 2 || event.Confirmations != 6 {
+		t.Fatalf("unexpected finalized event: %+v", event)
+	}
+	assertConfirmations(t, observer, confirmationSummary{6, false, true})
+
+	chain.Mine(1)
+	bs.ScanBlockTask()
+	assertConfirmations(t, observer)
+	if list, _ := bs.reorgJournal().TrackedTxs(); len(list) != 0 {
+		t.Fatalf("finalized transaction should not be tracked")
+	}
+}
+
+func TestNewConfirmationNotify_SkippedThresholds(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	wm.Config.ConfirmationThresholds = []uint64{1, 3, 6}
+	w := newSimWallet(t, "confirmation")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, observer := newSimScanner(t, wm, chain, w)
+
+	//一次扫描越过多个阀值时按顺序通知
+	fundBlocks(t, chain, addr.Address, 1)
+	chain.Mine(5)
+	bs.ScanBlockTask()
+	assertConfirmations(t, observer,
+		confirmationSummary{1, false, false},
+		confirmationSummary{3, false, false},
+		confirmationSummary{6, false, true},
+	)
+}
+
+func TestNewConfirmationNotify_Immature(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	wm.Config.ConfirmationThresholds = []uint64{1, 3, 6}
+	bs, observer := newSimScanner(t, wm, chain)
+
+	//coinstake的奖励需要StakeConfirmations个确认
+	reward := map[string]*openwallet.TxExtractData{
+		"account": {Transaction: &openwallet.Transaction{TxID: "coinstake", WxID: "coinstake"}},
+	}
+	bs.trackConfirmations(10, "hash", reward, true)
+
+	bs.newConfirmationNotify(10)
+	assertConfirmations(t, observer, confirmationSummary{1, true, false})
+
+	bs.newConfirmationNotify(20)
+	assertConfirmations(t, observer,
+		confirmationSummary{3, true, false},
+		confirmationSummary{6, true, false},
+	)
+
+	bs.newConfirmationNotify(10 + StakeConfirmations - 2)
+	assertConfirmations(t, observer)
+
+	bs.newConfirmationNotify(10 + StakeConfirmations - 1)
+	assertConfirmations(t, observer, confirmationSummary{StakeConfirmations, false, true})
+
+	bs.newConfirmationNotify(10 + StakeConfirmations)
+	assertConfirmations(t, observer)
+}
@@ -0,0 +1,575 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+//CallSmartContractABI 通过callcontract试运行合约方法，不产生交易
+func (decoder *ContractDecoder) CallSmartContractABI(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractCallResult, *openwallet.Error) {
+
+	if !decoder.wm.supports(CapabilityCallContract) {
+		return nil, openwallet.Errorf(openwallet.ErrSystemException, "chain backend can not call smart contract")
+	}
+
+	if len(rawTx.Coin.Contract.Address) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractNotFound, "contract address is empty")
+	}
+
+	callData, method, err := decoder.contractCallData(rawTx)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	execution, err := decoder.wm.CallContract(rawTx.Coin.Contract.Address, hex.EncodeToString(callData), rawTx.TxFrom)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, err.Error())
+	}
+
+	result := &openwallet.SmartContractCallResult{
+		RawHex: execution.Output,
+		Status: openwallet.SmartContractCallResultStatusSuccess,
+	}
+	if method != nil {
+		result.Method = method.Name
+	}
+
+	if execution.IsExcepted() {
+		result.Status = openwallet.SmartContractCallResultStatusFail
+		result.Exception = execution.Excepted
+		return result, nil
+	}
+
+	//有ABI时解码返回值
+	if method != nil && len(method.Outputs) > 0 {
+		output, err := hex.DecodeString(execution.Output)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "callcontract returns invalid output: %s", execution.Output)
+		}
+		values, err := method.UnpackOutput(output)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+		}
+		value, _ := json.Marshal(abi.FormatValues(method.Outputs, values, decoder.formatAddressArg))
+		result.Value = string(value)
+	}
+
+	return result, nil
+}
+
+//CreateSmartContractRawTransaction 创建合约调用交易单，合约地址为空时部署合约
+//调用数据为Raw(RawType为hex或base64)或ABIParam，Value为转入合约的主币数量
+//部署合约时Raw为合约字节码，ABIParam为构造函数参数
+//发送者为TxFrom，没有指定时使用账户余额最大的P2PKH地址，构建后Raw为待签名的交易单
+func (decoder *ContractDecoder) CreateSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) *openwallet.Error {
+
+	var (
+		txDecoder = decoder.txDecoder
+		feesRate  decimal.Decimal
+	)
+
+	if rawTx.Account == nil {
+		return openwallet.Errorf(openwallet.ErrAccountNotFound, "account is empty")
+	}
+
+	if rawTx.IsBuilt {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "transaction is already built")
+	}
+
+	if err := checkContractSender(rawTx.Account); err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	var (
+		callData []byte
+		err      error
+		deploy   = len(rawTx.Coin.Contract.Address) == 0
+	)
+	if deploy {
+		callData, err = decoder.contractDeployData(rawTx)
+	} else {
+		callData, _, err = decoder.contractCallData(rawTx)
+	}
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	value := decimal.Zero
+	if len(rawTx.Value) > 0 {
+		value, err = decimal.NewFromString(rawTx.Value)
+		if err != nil || value.IsNegative() {
+			return openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "invalid value: %s", rawTx.Value)
+		}
+	}
+
+	unspents, openErr := txDecoder.getAssetsAccountUnspents(wrapper, rawTx.Account)
+	if openErr != nil {
+		return openErr
+	}
+
+	//合约调用的发送者为第一个输入的地址，必须是P2PKH地址
+	sender, err := decoder.contractSender(unspents, rawTx.TxFrom)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, err.Error())
+	}
+
+	ext := contractExtParam(rawTx)
+
+	dataHex := hex.EncodeToString(callData)
+	contractAddress := strings.TrimPrefix(rawTx.Coin.Contract.Address, "0x")
+	var gas *contractGas
+	if deploy {
+		//callcontract不能试运行部署合约
+		gas, err = txDecoder.contractGas(ext, decoder.wm.Config.CreateGasLimit, nil)
+	} else {
+		gas, err = txDecoder.contractGas(ext, decoder.wm.Config.GasLimit, func() (uint64, error) {
+			return decoder.wm.EstimateContractGasLimit(contractAddress, dataHex, sender.Address)
+		})
+	}
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	//获取手续费率
+	if len(rawTx.FeeRate) == 0 {
+		feesRate, err = decoder.wm.EstimateFeeRate()
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, err.Error())
+		}
+	} else {
+		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	}
+
+	selector, err := txDecoder.coinSelector(ext)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	vcontract := btcLikeTxDriver.Vcontract{
+		ContractAddr: contractAddress,
+		CallData:     callData,
+		Value:        uint64(value.Shift(decoder.wm.Decimal()).IntPart()),
+		GasLimit:     fmt.Sprintf("%d", gas.Limit),
+		GasPrice:     gas.Price.Shift(decoder.wm.Decimal()).String(),
+		Create:       deploy,
+	}
+	if _, err := btcLikeTxDriver.GetContractLockScript(vcontract); err != nil {
+		return openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	//选择足够支付转入合约的主币+gas成本+手续费的utxo，输出为OP_CALL(OP_CREATE)和找零
+	contractTx, openErr := txDecoder.createContractTransaction(wrapper, rawTx.Account, unspents, sender, vcontract, value.Add(gas.Cost()), feesRate, selector)
+	if openErr != nil {
+		return openErr
+	}
+	selected := contractTx.Selected
+	keySigs := contractTx.KeySigs
+	emptyTrans := contractTx.EmptyTrans
+
+	//合约调用成本预留在手续费中，未使用的gas由节点退回发送者
+	fees := selected.Fees.Add(gas.Cost())
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", rawTx.Account.AccountID)
+	decoder.wm.Log.Std.Notice("Sender Address: %s", sender.Address)
+	if deploy {
+		decoder.wm.Log.Std.Notice("Deploy Contract Bytecode: %d bytes", len(callData))
+	} else {
+		decoder.wm.Log.Std.Notice("Contract Address: %s", contractAddress)
+		decoder.wm.Log.Std.Notice("Call Data: %s", dataHex)
+	}
+	decoder.wm.Log.Std.Notice("Value %s: %v", decoder.wm.Symbol(), value.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Gas Limit: %d, Gas Price: %s", gas.Limit, gas.Price.String())
+	decoder.wm.Log.Std.Notice("Fees %s: %v", decoder.wm.Symbol(), fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change %s: %v", decoder.wm.Symbol(), selected.Change.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+	rawTx.Raw = emptyTrans
+	rawTx.RawType = openwallet.TxRawTypeHex
+	rawTx.Value = value.StringFixed(decoder.wm.Decimal())
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+	rawTx.TxFrom = sender.Address
+	rawTx.TxTo = contractAddress
+	rawTx.IsBuilt = true
+
+	return nil
+}
+
+//SignSmartContractRawTransaction 用钱包的密钥签名合约调用交易单
+func (decoder *ContractDecoder) SignSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) *openwallet.Error {
+
+	if rawTx.Account == nil || len(rawTx.Signatures[rawTx.Account.AccountID]) == 0 {
+		return openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "transaction signature is empty")
+	}
+
+	err := decoder.txDecoder.signKeySignatures(wrapper, rawTx.Signatures[rawTx.Account.AccountID])
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, err.Error())
+	}
+
+	return nil
+}
+
+//SubmitSmartContractRawTransaction 合并签名并广播合约调用交易单
+func (decoder *ContractDecoder) SubmitSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractReceipt, *openwallet.Error) {
+
+	if !rawTx.IsBuilt || len(rawTx.Raw) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, "transaction is not built")
+	}
+
+	if !rawTx.IsCompleted {
+		signedTrans, completed, err := decoder.txDecoder.composeSignatures(wrapper, rawTx.Account, rawTx.Raw, rawTx.Signatures, 0)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, err.Error())
+		}
+		if !completed {
+			return nil, openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "transaction is not completed validation")
+		}
+		rawTx.Raw = signedTrans
+		rawTx.IsCompleted = true
+	}
+
+	txid, err := decoder.wm.SendRawTransaction(rawTx.Raw)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, err.Error())
+	}
+
+	rawTx.TxID = txid
+	rawTx.IsSubmit = true
+
+	receipt := &openwallet.SmartContractReceipt{
+		Coin:  rawTx.Coin,
+		TxID:  txid,
+		From:  rawTx.TxFrom,
+		To:    rawTx.TxTo,
+		Value: rawTx.Value,
+		Fees:  rawTx.Fees,
+	}
+
+	//部署合约的OP_CREATE为第一个输出，合约地址由txid和输出序号计算
+	if len(rawTx.Coin.Contract.Address) == 0 {
+		contractAddress, err := btcLikeTxDriver.GetContractCreateAddress(txid, 0)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, err.Error())
+		}
+		rawTx.TxTo = contractAddress
+		receipt.To = contractAddress
+		extParam, _ := json.Marshal(map[string]interface{}{"contractAddress": contractAddress})
+		receipt.ExtParam = string(extParam)
+	}
+
+	receipt.GenWxID()
+
+	return receipt, nil
+}
+
+//contractExtParam RawType为json时，Raw中除data外的字段为扩展参数
+//可以指定gasLimit、gasPrice、estimateGasLimit和coinSelection
+func contractExtParam(rawTx *openwallet.SmartContractRawTransaction) gjson.Result {
+	if rawTx.RawType != openwallet.TxRawTypeJSON {
+		return gjson.Result{}
+	}
+	return gjson.Parse(rawTx.Raw)
+}
+
+//contractRawData Raw中的调用数据或合约字节码，没有时返回nil
+//RawType为json时，数据为data字段的hex字符串
+func contractRawData(rawTx *openwallet.SmartContractRawTransaction) ([]byte, error) {
+
+	var (
+		data []byte
+		err  error
+	)
+	if len(rawTx.Raw) == 0 {
+		return nil, nil
+	}
+	switch rawTx.RawType {
+	case openwallet.TxRawTypeHex:
+		data, err = hex.DecodeString(strings.TrimPrefix(rawTx.Raw, "0x"))
+	case openwallet.TxRawTypeBase64:
+		data, err = base64.StdEncoding.DecodeString(rawTx.Raw)
+	case openwallet.TxRawTypeJSON:
+		dataHex := contractExtParam(rawTx).Get("data").String()
+		if len(dataHex) == 0 {
+			return nil, nil
+		}
+		data, err = hex.DecodeString(strings.TrimPrefix(dataHex, "0x"))
+	default:
+		return nil, fmt.Errorf("unsupported raw type: %d", rawTx.RawType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw data: %s", rawTx.Raw)
+	}
+	return data, nil
+}
+
+//contractCallData 合约调用数据，Raw优先，否则按ABIParam编码
+//method为调用的方法，没有合约ABI时可能为空
+func (decoder *ContractDecoder) contractCallData(rawTx *openwallet.SmartContractRawTransaction) ([]byte, *abi.Method, error) {
+
+	contractABI, err := decoder.contractABI(&rawTx.Coin.Contract)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callData, err := contractRawData(rawTx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if callData != nil {
+		if len(callData) < 4 {
+			return nil, nil, fmt.Errorf("invalid call data: %s", rawTx.Raw)
+		}
+
+		var method *abi.Method
+		if contractABI != nil {
+			method, _ = contractABI.MethodByID(callData[:4])
+		}
+		return callData, method, nil
+	}
+
+	if len(rawTx.ABIParam) == 0 {
+		return nil, nil, fmt.Errorf("abi param is empty")
+	}
+
+	//有合约ABI时按方法名或签名查找，否则ABIParam[0]必须是方法签名
+	var method *abi.Method
+	if contractABI != nil {
+		method, err = contractABI.MethodByName(rawTx.ABIParam[0])
+	} else {
+		method, err = abi.NewMethod(rawTx.ABIParam[0])
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args, err := abi.ParseArgs(method.Inputs, rawTx.ABIParam[1:], decoder.parseAddressArg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callData, err = method.Pack(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return callData, method, nil
+}
+
+//contractDeployData 部署合约的数据，合约字节码加ABI编码的构造函数参数
+//合约没有设置JSON ABI时，ABIParam[0]为构造函数签名，如constructor(string,uint256)
+func (decoder *ContractDecoder) contractDeployData(rawTx *openwallet.SmartContractRawTransaction) ([]byte, error) {
+
+	bytecode, err := contractRawData(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytecode) == 0 {
+		return nil, fmt.Errorf("invalid contract bytecode")
+	}
+
+	if len(rawTx.ABIParam) == 0 {
+		return bytecode, nil
+	}
+
+	contractABI, err := decoder.contractABI(&rawTx.Coin.Contract)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		constructor *abi.Method
+		params      = rawTx.ABIParam
+	)
+	if contractABI != nil && contractABI.Constructor != nil {
+		constructor = contractABI.Constructor
+	} else {
+		constructor, err = abi.NewMethod(params[0])
+		if err != nil {
+			return nil, fmt.Errorf("the constructor signature is required: %v", err)
+		}
+		params = params[1:]
+	}
+
+	args, err := abi.ParseArgs(constructor.Inputs, params, decoder.parseAddressArg)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := abi.Encode(constructor.Inputs, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(bytecode, encoded...), nil
+}
+
+//contractABI 合约的JSON ABI，没有设置时返回空
+func (decoder *ContractDecoder) contractABI(contract *openwallet.SmartContract) (*abi.ABI, error) {
+	if contract == nil || len(contract.GetABI()) == 0 {
+		return nil, nil
+	}
+	return abi.JSON(contract.GetABI())
+}
+
+//contractSender 选择发送者地址余额最大的utxo，作为合约调用的第一个输入
+func (decoder *ContractDecoder) contractSender(unspents []*Unspent, from string) (*Unspent, error) {
+	candidates := make([]*Unspent, 0)
+	for _, u := range spendableUnspents(unspents, nil) {
+		if len(from) > 0 && u.Address != from {
+			continue
+		}
+		if _, err := decoder.parseAddressArg(u.Address); err != nil {
+			continue
+		}
+		candidates = append(candidates, u)
+	}
+
+	if len(candidates) == 0 {
+		if len(from) > 0 {
+			return nil, fmt.Errorf("the utxo of sender address[%s] is empty", from)
+		}
+		return nil, fmt.Errorf("the account has no P2PKH address utxo to call contract")
+	}
+
+	orderByAmountDesc(candidates)
+	return candidates[0], nil
+}
+
+//parseAddressArg 合约的address参数，支持P2PKH地址和十六进制的hash160
+func (decoder *ContractDecoder) parseAddressArg(address string) (abi.Address, error) {
+	hexAddress := strings.TrimPrefix(address, "0x")
+	if len(hexAddress) == 40 {
+		if addr, err := abi.HexToAddress(hexAddress); err == nil {
+			return addr, nil
+		}
+	}
+
+	var addr abi.Address
+	prefix, hash, err := btcLikeTxDriver.DecodeCheck(address)
+	if err != nil || len(hash) != len(addr) || prefix != decoder.txDecoder.addressPrefix().P2PKHPrefix[0] {
+		return addr, fmt.Errorf("invalid P2PKH address: %s", address)
+	}
+
+	copy(addr[:], hash)
+	return addr, nil
+}
+
+//formatAddressArg 合约返回的address转为P2PKH地址
+func (decoder *ContractDecoder) formatAddressArg(addr abi.Address) string {
+	return btcLikeTxDriver.EncodeCheck(decoder.txDecoder.addressPrefix().P2PKHPrefix, addr[:])
+}
+
+//contractTransaction 合约调用的待签名交易单
+type contractTransaction struct {
+	EmptyTrans string
+	KeySigs    []*openwallet.KeySignature
+	Selected   *CoinSelectionResult
+}
+
+//createContractTransaction 构建合约调用(OP_CALL或OP_CREATE)的交易单
+//sender为第一个输入，也是合约调用的发送者，找零到sender，amount为转入合约的主币+gas成本
+func (decoder *TransactionDecoder) createContractTransaction(
+	wrapper openwallet.WalletDAI,
+	account *openwallet.AssetsAccount,
+	unspents []*Unspent,
+	sender *Unspent,
+	vcontract btcLikeTxDriver.Vcontract,
+	amount decimal.Decimal,
+	feesRate decimal.Decimal,
+	selector CoinSelector,
+) (*contractTransaction, *openwallet.Error) {
+
+	contractScript, err := btcLikeTxDriver.GetContractLockScript(vcontract)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	estimator := decoder.newTxSizeEstimator(wrapper, account, []string{contractScript})
+	target := decoder.newCoinSelectionTarget(estimator, amount, feesRate)
+	target.Required = []*Unspent{sender}
+	selected, err := selector.SelectCoins(unspents, target)
+	if err == errCoinsNotEnough {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "The [%s] available utxo balance: %s is not enough! ", decoder.wm.Symbol(), sumUnspentsBalance(unspents).StringFixed(decoder.wm.Decimal()))
+	} else if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+
+	vins := make([]btcLikeTxDriver.Vin, 0, len(selected.Inputs))
+	txUnlocks := make([]btcLikeTxDriver.TxUnlock, 0, len(selected.Inputs))
+	for _, utxo := range selected.Inputs {
+		vins = append(vins, btcLikeTxDriver.Vin{TxID: utxo.TxID, Vout: uint32(utxo.Vout)})
+		txUnlock, err := decoder.unspentTxUnlock(wrapper, account, utxo)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+		}
+		txUnlocks = append(txUnlocks, txUnlock)
+	}
+
+	//找零到发送者地址
+	vouts := make([]btcLikeTxDriver.Vout, 0, 1)
+	if selected.Change.GreaterThan(decimal.Zero) {
+		vouts = append(vouts, btcLikeTxDriver.Vout{Address: sender.Address, Amount: uint64(selected.Change.Shift(decoder.wm.Decimal()).IntPart())})
+	}
+
+	replaceable := decoder.wm.Config.EnableRBF
+	emptyTrans, err := btcLikeTxDriver.CreateQRC20TokenEmptyRawTransaction(vins, vcontract, vouts, 0, replaceable, decoder.addressPrefix())
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "create transaction failed, unexpected error: %v", err)
+	}
+
+	transHash, err := btcLikeTxDriver.CreateRawTransactionHashForSig(emptyTrans, txUnlocks)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "create transaction hash for sig failed, unexpected error: %v", err)
+	}
+
+	//装配签名
+	keySigs := make([]*openwallet.KeySignature, 0, len(txUnlocks))
+	for i, unlock := range txUnlocks {
+		addr, err := wrapper.GetAddress(unlock.Address)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrAddressNotFound, err.Error())
+		}
+
+		keySigs = append(keySigs, &openwallet.KeySignature{
+			EccType: decoder.wm.Config.CurveType,
+			Address: addr,
+			Message: transHash[i],
+		})
+	}
+
+	return &contractTransaction{
+		EmptyTrans: emptyTrans,
+		KeySigs:    keySigs,
+		Selected:   selected,
+	}, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestCreateSmartContractRawTransaction_JSONRaw(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+
+	wallet := newSimWallet(t, "deployer")
+	account := wallet.account(t, 1, 1)
+	addr := wallet.newAddress(t, wm, account, 0)
+	if _, err := chain.Fund(addr.Address, decimal.New(5, 0)); err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+
+	newRawTx := func(raw string) *openwallet.SmartContractRawTransaction {
+		return &openwallet.SmartContractRawTransaction{
+			Coin:    openwallet.Coin{Symbol: Symbol, IsContract: true},
+			Account: account,
+			Raw:     raw,
+			RawType: openwallet.TxRawTypeJSON,
+			FeeRate: "0.004",
+		}
+	}
+
+	//扩展参数的gasLimit和gasPrice覆盖部署合约的默认值
+	rawTx := newRawTx(`{"data":"6080604052","gasLimit":300000,"gasPrice":"0.0000005"}`)
+	if err := wm.ContractDecoder.CreateSmartContractRawTransaction(wallet, rawTx); err != nil {
+		t.Fatalf("CreateSmartContractRawTransaction failed: %v", err)
+	}
+	fees, _ := decimal.NewFromString(rawTx.Fees)
+	gasCost := decimal.New(300000, 0).Mul(decimal.New(5, -7))
+	if !fees.GreaterThan(gasCost) || !fees.LessThan(gasCost.Add(decimal.New(1, -2))) {
+		t.Fatalf("fees: %s should be the gas cost: %s plus transaction fees", rawTx.Fees, gasCost.String())
+	}
+
+	//扩展参数的选币策略
+	rawTx = newRawTx(`{"data":"6080604052","coinSelection":"unknown"}`)
+	if err := wm.ContractDecoder.CreateSmartContractRawTransaction(wallet, rawTx); err == nil {
+		t.Fatalf("CreateSmartContractRawTransaction should fail with unknown coin selection")
+	}
+}
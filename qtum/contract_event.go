@@ -0,0 +1,251 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"github.com/shopspring/decimal"
+)
+
+//extractSmartContractReceipts 提取交易单中的合约调用回执
+//调用的合约、产生日志的合约是关注的合约，或者发送者是关注的地址时，生成openwallet.SmartContractReceipt，以合约ID为key
+func (bs *BTCBlockScanner) extractSmartContractReceipts(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
+
+	for _, receipt := range trx.ContractReceipts {
+
+		//关注的合约，有ABI时用于解码事件
+		watched := make(map[string]*openwallet.SmartContract)
+		sourceKey := ""
+		addresses := []string{receipt.ContractAddress}
+		for _, logInfo := range receipt.Logs {
+			addresses = append(addresses, logInfo.Address)
+		}
+		for _, address := range addresses {
+			address = strings.ToLower(address)
+			if _, ok := watched[address]; ok {
+				continue
+			}
+			targetResult := scanAddressFunc(openwallet.ScanTargetParam{
+				ScanTarget:     address,
+				Symbol:         bs.wm.Symbol(),
+				ScanTargetType: openwallet.ScanTargetTypeContractAddress})
+			if !targetResult.Exist {
+				continue
+			}
+			contract := bs.newSmartContract(address)
+			switch info := targetResult.TargetInfo.(type) {
+			case *openwallet.SmartContract:
+				contract = info
+			case openwallet.SmartContract:
+				contract = &info
+			}
+			watched[address] = contract
+			if len(sourceKey) == 0 {
+				sourceKey = contract.ContractID
+			}
+		}
+
+		if len(watched) == 0 {
+			senderResult := scanAddressFunc(openwallet.ScanTargetParam{
+				ScanTarget:     receipt.Sender,
+				Symbol:         bs.wm.Symbol(),
+				ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+			if !senderResult.Exist {
+				continue
+			}
+		}
+
+		contract, ok := watched[strings.ToLower(receipt.ContractAddress)]
+		if !ok {
+			contract = bs.newSmartContract(receipt.ContractAddress)
+		}
+		if len(sourceKey) == 0 {
+			sourceKey = contract.ContractID
+		}
+
+		scReceipt := bs.newSmartContractReceipt(trx, receipt, contract, watched)
+		result.extractContractReceipts[sourceKey] = append(result.extractContractReceipts[sourceKey], scReceipt)
+	}
+}
+
+//newSmartContract 没有登记的合约
+func (bs *BTCBlockScanner) newSmartContract(address string) *openwallet.SmartContract {
+	contractID := openwallet.GenContractID(bs.wm.Symbol(), address)
+	return &openwallet.SmartContract{
+		ContractID: contractID,
+		Symbol:     bs.wm.Symbol(),
+		Address:    address,
+	}
+}
+
+//newSmartContractReceipt 合约回执转换为openwallet.SmartContractReceipt
+func (bs *BTCBlockScanner) newSmartContractReceipt(trx *Transaction, receipt *ContractReceipt, contract *openwallet.SmartContract, watched map[string]*openwallet.SmartContract) *openwallet.SmartContractReceipt {
+
+	fees := receipt.Fees
+	if len(fees) == 0 {
+		fees = "0"
+	}
+
+	value := "0"
+	extParam := map[string]interface{}{
+		"gasUsed":         receipt.GasUsed,
+		"senderAddress":   receipt.Sender,
+		"contractAddress": receipt.ContractAddress,
+		"outputIndex":     receipt.OutputIndex,
+	}
+	for _, vout := range trx.Vouts {
+		if vout.N == receipt.OutputIndex && len(vout.Value) > 0 {
+			value = vout.Value
+		}
+	}
+	if script := trx.contractScript(receipt.OutputIndex); script != nil {
+		extParam["gasLimit"] = script.GasLimit
+		extParam["gasPrice"] = decimal.New(int64(script.GasPrice), -8).String()
+		extParam["callData"] = hex.EncodeToString(script.CallData)
+	}
+	extParam["amount"] = value
+	ext, _ := json.Marshal(extParam)
+
+	scReceipt := &openwallet.SmartContractReceipt{
+		Coin: openwallet.Coin{
+			Symbol:     bs.wm.Symbol(),
+			IsContract: true,
+			ContractID: contract.ContractID,
+			Contract:   *contract,
+		},
+		TxID:        receipt.TxHash,
+		From:        receipt.Sender,
+		To:          receipt.ContractAddress,
+		Value:       value,
+		Fees:        fees,
+		RawReceipt:  receipt.RawReceipt,
+		Events:      make([]*openwallet.SmartContractEvent, 0),
+		BlockHash:   receipt.BlockHash,
+		BlockHeight: receipt.BlockHeight,
+		ConfirmTime: trx.Blocktime,
+		Status:      openwallet.TxStatusSuccess,
+		ExtParam:    string(ext),
+	}
+
+	if receipt.IsExcepted() {
+		scReceipt.Status = openwallet.TxStatusFail
+		scReceipt.Reason = receipt.Excepted
+	}
+
+	for _, logInfo := range receipt.Logs {
+		logContract, ok := watched[strings.ToLower(logInfo.Address)]
+		if !ok {
+			logContract = bs.newSmartContract(logInfo.Address)
+		}
+		scReceipt.Events = append(scReceipt.Events, bs.decodeContractEvent(logInfo, logContract))
+	}
+
+	scReceipt.GenWxID()
+	return scReceipt
+}
+
+//decodeContractEvent 解码合约日志
+//合约有ABI时按ABI解码，否则解码标准的Transfer事件，都无法解码时Event为topic0，Value为原始日志
+func (bs *BTCBlockScanner) decodeContractEvent(logInfo *ContractLog, contract *openwallet.SmartContract) *openwallet.SmartContractEvent {
+
+	params := bs.wm.Config.Network
+	formatAddress := func(a abi.Address) string {
+		return HashAddressToBaseAddress(a.Hex(), params)
+	}
+
+	event := &openwallet.SmartContractEvent{
+		Contract: contract,
+	}
+	if len(logInfo.Topics) > 0 {
+		event.Event = "0x" + logInfo.Topics[0]
+	}
+
+	topics, data, err := logInfo.decode()
+	if err == nil {
+		if name, value, err := decodeEventByABI(contract.GetABI(), topics, data, formatAddress); err == nil {
+			event.Event = name
+			event.Value = value
+			return event
+		}
+
+		if transfer, err := abi.DecodeTransferLog(topics, data); err == nil {
+			args := map[string]string{
+				"from": formatAddress(transfer.From),
+				"to":   formatAddress(transfer.To),
+			}
+			if transfer.IsQRC721 {
+				args["tokenId"] = transfer.Value.String()
+			} else {
+				args["value"] = transfer.Value.String()
+			}
+			value, _ := json.Marshal(args)
+			event.Event = "Transfer"
+			event.Value = string(value)
+			return event
+		}
+	}
+
+	value, _ := json.Marshal(map[string]interface{}{
+		"address": logInfo.Address,
+		"topics":  logInfo.Topics,
+		"data":    logInfo.Data,
+	})
+	event.Value = string(value)
+	return event
+}
+
+//decodeEventByABI 按合约的JSON ABI解码日志，Value以参数名为key
+func decodeEventByABI(abiJSON string, topics [][]byte, data []byte, formatAddress func(abi.Address) string) (string, string, error) {
+
+	if len(abiJSON) == 0 || len(topics) == 0 {
+		return "", "", fmt.Errorf("contract abi is empty")
+	}
+	contractABI, err := abi.JSON(abiJSON)
+	if err != nil {
+		return "", "", err
+	}
+	e, err := contractABI.EventByID(topics[0])
+	if err != nil {
+		return "", "", err
+	}
+	values, err := e.UnpackLog(topics, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	args := make(map[string]interface{})
+	for i, v := range abi.FormatValues(e.IndexedTypes(), values, formatAddress) {
+		name := ""
+		if i < len(e.Names) {
+			name = e.Names[i]
+		}
+		if len(name) == 0 {
+			name = e.Inputs[i].String()
+		}
+		args[name] = v
+	}
+	value, err := json.Marshal(args)
+	if err != nil {
+		return "", "", err
+	}
+	return e.Name, string(value), nil
+}
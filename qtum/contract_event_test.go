@@ -0,0 +1,222 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	testEventTxID         = "9e2d3c6e5d6f3c1f0f5b4a3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b"
+	testEventBlockHash    = "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b"
+	testEventUnwatched    = "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678"
+	testEventUnknownTopic = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+)
+
+//testEventABI 关注的合约只登记了Approval事件
+const testEventABI = `[{"type": "event", "name": "Approval", "anonymous": false, "inputs": [
+	{"name": "owner", "type": "address", "indexed": true},
+	{"name": "spender", "type": "address", "indexed": true},
+	{"name": "value", "type": "uint256", "indexed": false}
+]}]`
+
+//testEventReceiptsJSON 第一个回执调用关注的合约，第二个回执由关注的地址调用QRC721合约，第三个回执与钱包无关
+const testEventReceiptsJSON = `[{
+	"outputIndex": 0,
+	"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+	"gasUsed": 36236,
+	"contractAddress": "f2033ede578e17fa6231047265010445bca8cf1c",
+	"excepted": "None",
+	"log": [{
+		"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"topics": [
+			"8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925",
+			"000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1",
+			"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"
+		],
+		"data": "0000000000000000000000000000000000000000000000000000000000000064"
+	}, {
+		"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"topics": [
+			"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5",
+			"0000000000000000000000006b8bf98ff497c064e8f0bde13e0c4f5ed5bf8ce7"
+		],
+		"data": "00000000000000000000000000000000000000000000000000000000000003e8"
+	}, {
+		"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"topics": ["aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"],
+		"data": "01"
+	}]
+}, {
+	"outputIndex": 1,
+	"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+	"gasUsed": 50000,
+	"contractAddress": "6b8bf98ff497c064e8f0bde13e0c4f5ed5bf8ce7",
+	"excepted": "None",
+	"log": [{
+		"address": "6b8bf98ff497c064e8f0bde13e0c4f5ed5bf8ce7",
+		"topics": [
+			"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5",
+			"000000000000000000000000f2033ede578e17fa6231047265010445bca8cf1c",
+			"000000000000000000000000000000000000000000000000000000000000000c"
+		],
+		"data": ""
+	}]
+}, {
+	"outputIndex": 2,
+	"from": "9ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5",
+	"gasUsed": 21000,
+	"contractAddress": "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678",
+	"excepted": "Revert",
+	"log": []
+}]`
+
+//newEventTestScanner 关注合约testReceiptContract和地址testReceiptSender的扫描器
+func newEventTestScanner(t *testing.T) (*BTCBlockScanner, *simObserver, *Transaction) {
+
+	wm, _ := newSimWalletManager()
+	wm.Config.dbPath = t.TempDir()
+	params := wm.Config.Network
+	sender := HashAddressToBaseAddress(testReceiptSender, params)
+
+	contract := &openwallet.SmartContract{
+		ContractID: "watched-contract",
+		Symbol:     wm.Symbol(),
+		Address:    "0x" + testReceiptContract,
+	}
+	contract.SetABI(testEventABI)
+
+	bs, observer := setupSimScanner(t, wm, newSimBlockchainDAI(), func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		switch {
+		case target.ScanTargetType == openwallet.ScanTargetTypeContractAddress && target.ScanTarget == contract.Address:
+			return openwallet.ScanTargetResult{SourceKey: contract.ContractID, Exist: true, TargetInfo: contract}
+		case target.ScanTargetType == openwallet.ScanTargetTypeAccountAddress && target.ScanTarget == sender:
+			return openwallet.ScanTargetResult{SourceKey: "watched-account", Exist: true}
+		}
+		return openwallet.ScanTargetResult{}
+	})
+
+	trx := &Transaction{
+		TxID:        testEventTxID,
+		BlockHash:   testEventBlockHash,
+		BlockHeight: 100,
+		Blocktime:   1537841342,
+		Vouts: []*Vout{
+			{N: 0, Type: "call", Value: "0", ScriptPubKey: testContractLockScript(t, testReceiptContract, "250000", "40", []byte{0x01, 0x02, 0x03, 0x04})},
+			{N: 1, Type: "call", Value: "0", ScriptPubKey: testContractLockScript(t, testReceiptNFT, "250000", "40", []byte{0x01, 0x02, 0x03, 0x04})},
+			{N: 2, Type: "call", Value: "0", ScriptPubKey: testContractLockScript(t, testEventUnwatched, "250000", "40", []byte{0x01, 0x02, 0x03, 0x04})},
+		},
+	}
+	result := gjson.Parse(testEventReceiptsJSON)
+	trx.applyContractReceipts(newContractReceiptsByCore(&result, params), params, wm.Decimal())
+
+	return bs, observer, trx
+}
+
+func TestExtractSmartContractReceipts(t *testing.T) {
+
+	bs, observer, trx := newEventTestScanner(t)
+	params := bs.wm.Config.Network
+	sender := HashAddressToBaseAddress(testReceiptSender, params)
+	to := HashAddressToBaseAddress(testReceiptTo, params)
+
+	result := bs.extractTransactionResult(trx, trx.BlockHeight, trx.BlockHash, false, bs.ScanTargetFuncV2)
+	if !result.Success {
+		t.Fatalf("extract transaction failed")
+	}
+	if failed := bs.saveExtractResult(trx.BlockHeight, trx.BlockHash, result); failed != 0 {
+		t.Fatalf("save extract result failed: %d", failed)
+	}
+
+	//与钱包无关的回执不通知
+	nftID := openwallet.GenContractID(bs.wm.Symbol(), "0x"+testReceiptNFT)
+	if len(observer.receipts) != 2 || len(observer.receipts["watched-contract"]) != 1 || len(observer.receipts[nftID]) != 1 {
+		t.Fatalf("unexpected receipts: %v", observer.receipts)
+	}
+
+	//关注的合约：有ABI的事件按ABI解码，标准的Transfer事件按代币转账解码，其他事件保留原始日志
+	watched := observer.receipts["watched-contract"][0]
+	if watched.TxID != testEventTxID || watched.From != sender || watched.To != "0x"+testReceiptContract || watched.BlockHeight != 100 {
+		t.Fatalf("unexpected receipt: %+v", watched)
+	}
+	if watched.Status != openwallet.TxStatusSuccess || watched.Fees != "0.01449440" || watched.Coin.ContractID != "watched-contract" {
+		t.Fatalf("unexpected receipt status: %s, fees: %s, contract: %s", watched.Status, watched.Fees, watched.Coin.ContractID)
+	}
+	if len(watched.Events) != 3 {
+		t.Fatalf("events: %d, want: 3", len(watched.Events))
+	}
+
+	approval := watched.Events[0]
+	value := gjson.Parse(approval.Value)
+	if approval.Event != "Approval" || value.Get("owner").String() != sender || value.Get("spender").String() != to || value.Get("value").String() != "100" {
+		t.Fatalf("unexpected ABI decoded event: %s %s", approval.Event, approval.Value)
+	}
+	if approval.Contract.ContractID != "watched-contract" {
+		t.Fatalf("event contract: %s, want: watched-contract", approval.Contract.ContractID)
+	}
+
+	transfer := watched.Events[1]
+	value = gjson.Parse(transfer.Value)
+	if transfer.Event != "Transfer" || value.Get("from").String() != to || value.Get("value").String() != "1000" {
+		t.Fatalf("unexpected Transfer event: %s %s", transfer.Event, transfer.Value)
+	}
+
+	raw := watched.Events[2]
+	value = gjson.Parse(raw.Value)
+	if raw.Event != "0x"+testEventUnknownTopic || value.Get("data").String() != "01" || value.Get("topics.0").String() != testEventUnknownTopic {
+		t.Fatalf("unexpected raw event: %s %s", raw.Event, raw.Value)
+	}
+
+	//关注的地址调用没有登记的合约
+	nft := observer.receipts[nftID][0]
+	if nft.From != sender || nft.To != "0x"+testReceiptNFT || nft.Coin.Contract.Address != "0x"+testReceiptNFT || len(nft.Events) != 1 {
+		t.Fatalf("unexpected receipt of the watched sender: %+v", nft)
+	}
+	value = gjson.Parse(nft.Events[0].Value)
+	if nft.Events[0].Event != "Transfer" || value.Get("tokenId").String() != "12" || value.Get("to").String() != HashAddressToBaseAddress(testReceiptContract, params) {
+		t.Fatalf("unexpected QRC721 Transfer event: %s %s", nft.Events[0].Event, nft.Events[0].Value)
+	}
+	if !strings.Contains(nft.ExtParam, `"gasLimit":250000`) {
+		t.Fatalf("unexpected ext param: %s", nft.ExtParam)
+	}
+}
+
+func TestSaveExtractResult_SmartContractNotifyFailed(t *testing.T) {
+
+	bs, observer, trx := newEventTestScanner(t)
+	observer.receiptErr = errors.New("observer is down")
+
+	result := bs.extractTransactionResult(trx, trx.BlockHeight, trx.BlockHash, false, bs.ScanTargetFuncV2)
+	if failed := bs.saveExtractResult(trx.BlockHeight, trx.BlockHash, result); failed != 1 {
+		t.Fatalf("failed: %d, want: 1", failed)
+	}
+
+	//通知失败的区块记录为未扫
+	records, err := bs.GetUnscanRecords()
+	if err != nil {
+		t.Fatalf("GetUnscanRecords failed: %v", err)
+	}
+	if len(records) == 0 || records[0].BlockHeight != trx.BlockHeight {
+		t.Fatalf("unscan record of the block should be saved: %v", records)
+	}
+}
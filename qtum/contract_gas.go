@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+//contractGas 合约调用的gas设置
+type contractGas struct {
+	//gas上限
+	Limit uint64
+	//gas单价，单位为主币
+	Price decimal.Decimal
+}
+
+//Cost 合约调用预留的主币 = gasLimit * gasPrice，未使用的gas会退回发送地址
+func (gas *contractGas) Cost() decimal.Decimal {
+	return gas.Price.Mul(decimal.New(int64(gas.Limit), 0))
+}
+
+//contractGas 确定合约调用的gas，ExtParam的gasLimit和gasPrice优先
+//没有指定gasLimit时，开启estimateGasLimit则通过estimate试运行估算，否则使用gasLimit
+//estimate为空时不能估算，如部署合约
+func (decoder *TransactionDecoder) contractGas(ext gjson.Result, gasLimit uint64, estimate func() (uint64, error)) (*contractGas, error) {
+	gas := &contractGas{
+		Limit: gasLimit,
+		Price: decoder.wm.Config.GasPrice,
+	}
+
+	if gasPrice, err := decimal.NewFromString(ext.Get("gasPrice").String()); err == nil && gasPrice.GreaterThan(decimal.Zero) {
+		gas.Price = gasPrice
+	}
+
+	estimateGasLimit := decoder.wm.Config.EstimateGasLimit
+	if ext.Get("estimateGasLimit").Exists() {
+		estimateGasLimit = ext.Get("estimateGasLimit").Bool()
+	}
+
+	if gasLimit := ext.Get("gasLimit").Uint(); gasLimit > 0 {
+		gas.Limit = gasLimit
+	} else if estimateGasLimit && estimate != nil {
+		if !decoder.wm.supports(CapabilityCallContract) {
+			decoder.wm.Log.Warningf("chain backend can not estimate gas limit, use the default gas limit: %d", gas.Limit)
+		} else {
+			gasLimit, err := estimate()
+			if err != nil {
+				return nil, err
+			}
+			gas.Limit = gasLimit
+		}
+	}
+
+	if gas.Limit == 0 || !gas.Price.GreaterThan(decimal.Zero) {
+		return nil, fmt.Errorf("invalid gas limit: %d or gas price: %s", gas.Limit, gas.Price.String())
+	}
+
+	return gas, nil
+}
+
+//qrc20Gas 确定QRC20转账的gas
+func (decoder *TransactionDecoder) qrc20Gas(ext gjson.Result, contract openwallet.SmartContract, from, to string, amount decimal.Decimal) (*contractGas, error) {
+	return decoder.contractGas(ext, decoder.wm.Config.GasLimit, func() (uint64, error) {
+		return decoder.wm.EstimateQRC20GasLimit(contract, from, to, amount)
+	})
+}
+
+//EstimateQRC20GasLimit 通过callcontract试运行transfer，按实际消耗的gas乘以安全系数估算gasLimit
+func (wm *WalletManager) EstimateQRC20GasLimit(contract openwallet.SmartContract, from, to string, amount decimal.Decimal) (uint64, error) {
+
+	dataHex, err := qrc20TransferData(to, amount, contract.Decimals, wm.Config.Network)
+	if err != nil {
+		return 0, err
+	}
+
+	return wm.EstimateContractGasLimit(contract.Address, dataHex, from)
+}
+
+//EstimateContractGasLimit 通过callcontract试运行合约调用，按实际消耗的gas乘以安全系数估算gasLimit
+func (wm *WalletManager) EstimateContractGasLimit(contractAddress, dataHex, from string) (uint64, error) {
+
+	execution, err := wm.CallContract(contractAddress, dataHex, from)
+	if err != nil {
+		return 0, err
+	}
+
+	if execution.IsExcepted() {
+		return 0, fmt.Errorf("contract: %s call from: %s is excepted: %s", contractAddress, from, execution.Excepted)
+	}
+
+	gasUsed, err := decimal.NewFromString(execution.GasUsed)
+	if err != nil || !gasUsed.GreaterThan(decimal.Zero) {
+		return 0, fmt.Errorf("callcontract returns invalid gasUsed: %s", execution.GasUsed)
+	}
+
+	multiplier := wm.Config.GasLimitMultiplier
+	if multiplier.LessThan(decimal.New(1, 0)) {
+		multiplier = decimal.New(1, 0)
+	}
+
+	return uint64(gasUsed.Mul(multiplier).Ceil().IntPart()), nil
+}
+
+//CallContract 通过节点(或浏览器API)的callcontract试运行合约调用，不产生交易，from为空时不指定调用者
+func (wm *WalletManager) CallContract(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+	return wm.chain().CallContract(contractAddress, dataHex, from)
+}
+
+//callContractByCore 通过核心钱包的callcontract试运行合约调用
+func (wm *WalletManager) callContractByCore(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+
+	request := []interface{}{
+		strings.TrimPrefix(contractAddress, "0x"),
+		strings.TrimPrefix(dataHex, "0x"),
+	}
+	if len(from) > 0 {
+		request = append(request, from)
+	}
+
+	result, err := wm.WalletClient.Call("callcontract", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewQRC20Unspent(result), nil
+}
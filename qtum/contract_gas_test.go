@@ -0,0 +1,172 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+//noCallContractChain 不能试运行合约调用的模拟链
+type noCallContractChain struct {
+	*SimChain
+}
+
+func (c *noCallContractChain) Supports(capability ChainCapability) bool {
+	return capability != CapabilityCallContract
+}
+
+//newGasTestToken 创建测试代币，from持有100个代币
+func newGasTestToken(t *testing.T, chain *SimChain) (openwallet.SmartContract, string, string) {
+	params := chain.params
+	from := HashAddressToBaseAddress(testReceiptSender, params)
+	to := HashAddressToBaseAddress(testReceiptTo, params)
+	token := openwallet.SmartContract{Address: testReceiptContract, Protocol: QRC20Protocol, Decimals: 8}
+	if err := chain.SetQRC20Balance(token.Address, from, big.NewInt(100*1e8)); err != nil {
+		t.Fatalf("SetQRC20Balance failed: %v", err)
+	}
+	return token, from, to
+}
+
+func TestContractGas_ExtParam(t *testing.T) {
+
+	wm, _ := newSimWalletManager()
+	wm.Config.GasLimit = 250000
+	wm.Config.GasPrice = decimal.New(40, -8)
+	wm.Config.EstimateGasLimit = true
+	decoder := NewTransactionDecoder(wm)
+
+	estimate := func() (uint64, error) {
+		t.Fatalf("gas limit of the ExtParam should not be estimated")
+		return 0, nil
+	}
+
+	ext := gjson.Parse(`{"gasLimit": "300000", "gasPrice": "0.0000005"}`)
+	gas, err := decoder.contractGas(ext, wm.Config.GasLimit, estimate)
+	if err != nil {
+		t.Fatalf("contractGas failed: %v", err)
+	}
+	if gas.Limit != 300000 || !gas.Price.Equal(decimal.New(50, -8)) {
+		t.Fatalf("gas limit: %d, gas price: %s", gas.Limit, gas.Price.String())
+	}
+	if !gas.Cost().Equal(decimal.New(15, -2)) {
+		t.Fatalf("cost: %s, want: 0.15", gas.Cost().String())
+	}
+
+	//ExtParam关闭估算时使用配置的gasLimit
+	ext = gjson.Parse(`{"estimateGasLimit": false}`)
+	gas, err = decoder.contractGas(ext, wm.Config.GasLimit, estimate)
+	if err != nil {
+		t.Fatalf("contractGas failed: %v", err)
+	}
+	if gas.Limit != 250000 || !gas.Price.Equal(wm.Config.GasPrice) {
+		t.Fatalf("gas limit: %d, gas price: %s", gas.Limit, gas.Price.String())
+	}
+
+	//无效的gasPrice使用配置
+	ext = gjson.Parse(`{"gasLimit": 300000, "gasPrice": "-1"}`)
+	gas, err = decoder.contractGas(ext, wm.Config.GasLimit, estimate)
+	if err != nil {
+		t.Fatalf("contractGas failed: %v", err)
+	}
+	if gas.Limit != 300000 || !gas.Price.Equal(wm.Config.GasPrice) {
+		t.Fatalf("gas limit: %d, gas price: %s", gas.Limit, gas.Price.String())
+	}
+
+	wm.Config.GasPrice = decimal.Zero
+	if _, err := decoder.contractGas(gjson.Result{}, wm.Config.GasLimit, nil); err == nil {
+		t.Fatalf("zero gas price should be rejected")
+	}
+}
+
+func TestEstimateContractGasLimit_Multiplier(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	token, from, to := newGasTestToken(t, chain)
+
+	tests := []struct {
+		multiplier decimal.Decimal
+		want       uint64
+	}{
+		{decimal.New(15, -1), 54354},
+		{decimal.New(2, 0), 72472},
+		//系数小于1时不减少
+		{decimal.New(5, -1), SimContractGasUsed},
+	}
+	for _, test := range tests {
+		wm.Config.GasLimitMultiplier = test.multiplier
+		gasLimit, err := wm.EstimateQRC20GasLimit(token, from, to, decimal.New(1, 0))
+		if err != nil {
+			t.Fatalf("EstimateQRC20GasLimit failed: %v", err)
+		}
+		if gasLimit != test.want {
+			t.Fatalf("multiplier: %s, gas limit: %d, want: %d", test.multiplier.String(), gasLimit, test.want)
+		}
+	}
+
+	//余额不足时试运行失败
+	if _, err := wm.EstimateQRC20GasLimit(token, from, to, decimal.New(1000, 0)); err == nil {
+		t.Fatalf("excepted call should not be estimated")
+	}
+}
+
+func TestQRC20Gas_Estimate(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	token, from, to := newGasTestToken(t, chain)
+	wm.Config.GasLimit = 250000
+	wm.Config.GasLimitMultiplier = decimal.New(15, -1)
+	decoder := NewTransactionDecoder(wm)
+
+	//没有开启估算时使用配置的gasLimit
+	wm.Config.EstimateGasLimit = false
+	gas, err := decoder.qrc20Gas(gjson.Result{}, token, from, to, decimal.New(1, 0))
+	if err != nil {
+		t.Fatalf("qrc20Gas failed: %v", err)
+	}
+	if gas.Limit != 250000 {
+		t.Fatalf("gas limit: %d, want: 250000", gas.Limit)
+	}
+
+	//callcontract的gasUsed乘以安全系数
+	wm.Config.EstimateGasLimit = true
+	gas, err = decoder.qrc20Gas(gjson.Result{}, token, from, to, decimal.New(1, 0))
+	if err != nil {
+		t.Fatalf("qrc20Gas failed: %v", err)
+	}
+	if gas.Limit != 54354 {
+		t.Fatalf("gas limit: %d, want: 54354", gas.Limit)
+	}
+
+	//试运行失败时不能创建交易
+	if _, err := decoder.qrc20Gas(gjson.Result{}, token, from, to, decimal.New(1000, 0)); err == nil {
+		t.Fatalf("excepted call should return error")
+	}
+
+	//后端不能试运行时使用配置的gasLimit
+	wm.Backend = &noCallContractChain{SimChain: chain}
+	gas, err = decoder.qrc20Gas(gjson.Result{}, token, from, to, decimal.New(1000, 0))
+	if err != nil {
+		t.Fatalf("qrc20Gas failed: %v", err)
+	}
+	if gas.Limit != 250000 {
+		t.Fatalf("gas limit: %d, want: 250000", gas.Limit)
+	}
+}
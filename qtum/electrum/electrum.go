@@ -0,0 +1,344 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+//Package electrum 实现ElectrumX的JSON-RPC协议客户端，每行一个请求、响应或通知，支持tcp和ssl连接
+package electrum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	//订阅的通知方法
+	MethodHeadersSubscribe    = "blockchain.headers.subscribe"
+	MethodScripthashSubscribe = "blockchain.scripthash.subscribe"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+	writeTimeout   = 10 * time.Second
+
+	//未处理的通知超过队列长度时丢弃，通知只用于触发查询
+	notificationQueueSize = 256
+)
+
+//Error 服务返回的错误
+type Error struct {
+	Code    int64
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("electrum error %d: %s", e.Code, e.Message)
+}
+
+//Notification 订阅的通知，Params为参数数组
+type Notification struct {
+	Method string
+	Params gjson.Result
+}
+
+//Request 批量调用的请求
+type Request struct {
+	Method string
+	Params []interface{}
+}
+
+//Response 批量调用的结果，Err不为空时调用失败
+type Response struct {
+	Result gjson.Result
+	Err    error
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+//Client ElectrumX的连接，可以并发调用
+type Client struct {
+	Timeout time.Duration //调用的超时时间
+
+	conn          net.Conn
+	writeMu       sync.Mutex
+	mu            sync.Mutex
+	nextID        uint64
+	pending       map[uint64]chan gjson.Result
+	notifications chan *Notification
+	done          chan struct{}
+	closeOnce     sync.Once
+	err           error
+}
+
+//parseEndpoint 支持tcp://host:port和ssl://host:port(或tls://)
+func parseEndpoint(endpoint string) (string, bool, error) {
+	switch {
+	case strings.HasPrefix(endpoint, "tcp://"):
+		return strings.TrimPrefix(endpoint, "tcp://"), false, nil
+	case strings.HasPrefix(endpoint, "ssl://"):
+		return strings.TrimPrefix(endpoint, "ssl://"), true, nil
+	case strings.HasPrefix(endpoint, "tls://"):
+		return strings.TrimPrefix(endpoint, "tls://"), true, nil
+	}
+	return "", false, fmt.Errorf("unsupported electrum endpoint: %s", endpoint)
+}
+
+//Dial 连接ElectrumX服务，ssl连接的tlsConfig为空时按主机名校验证书
+func Dial(endpoint string, timeout time.Duration, tlsConfig *tls.Config) (*Client, error) {
+
+	address, useTLS, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if useTLS {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if len(tlsConfig.ServerName) == 0 {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewClient(conn), nil
+}
+
+//NewClient 使用已建立的连接创建客户端
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		Timeout:       DefaultTimeout,
+		conn:          conn,
+		pending:       make(map[uint64]chan gjson.Result),
+		notifications: make(chan *Notification, notificationQueueSize),
+		done:          make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+//Call 调用方法，返回result
+func (c *Client) Call(method string, params ...interface{}) (*gjson.Result, error) {
+	responses, err := c.Batch([]Request{{Method: method, Params: params}})
+	if err != nil {
+		return nil, err
+	}
+	if responses[0].Err != nil {
+		return nil, responses[0].Err
+	}
+	return &responses[0].Result, nil
+}
+
+//Batch 批量调用，一次发送所有请求，结果与请求的顺序一致
+func (c *Client) Batch(requests []Request) ([]Response, error) {
+
+	if len(requests) == 0 {
+		return []Response{}, nil
+	}
+
+	var (
+		body  = make([]request, 0, len(requests))
+		waits = make([]chan gjson.Result, 0, len(requests))
+	)
+
+	c.mu.Lock()
+	for _, req := range requests {
+		c.nextID++
+		wait := make(chan gjson.Result, 1)
+		c.pending[c.nextID] = wait
+		waits = append(waits, wait)
+
+		params := req.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		body = append(body, request{JSONRPC: "2.0", ID: c.nextID, Method: req.Method, Params: params})
+	}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		for _, req := range body {
+			delete(c.pending, req.ID)
+		}
+		c.mu.Unlock()
+	}()
+
+	var (
+		data []byte
+		err  error
+	)
+	if len(body) == 1 {
+		data, err = json.Marshal(body[0])
+	} else {
+		data, err = json.Marshal(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(c.Timeout)
+	defer timeout.Stop()
+
+	responses := make([]Response, len(requests))
+	for i, wait := range waits {
+		select {
+		case resp := <-wait:
+			if e := resp.Get("error"); e.Exists() && e.Type != gjson.Null {
+				responses[i].Err = newError(e)
+			} else {
+				responses[i].Result = resp.Get("result")
+			}
+		case <-c.done:
+			return nil, c.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("electrum call %s timeout", requests[i].Method)
+		}
+	}
+
+	return responses, nil
+}
+
+//newError 解析错误，ElectrumX的错误为{code, message}，部分服务直接返回字符串
+func newError(e gjson.Result) *Error {
+	if e.IsObject() {
+		return &Error{Code: e.Get("code").Int(), Message: e.Get("message").String()}
+	}
+	return &Error{Message: e.String()}
+}
+
+func (c *Client) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(data); err != nil {
+		c.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+//readLoop 读取响应和通知，连接断开时结束所有等待的调用
+func (c *Client) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		msg := gjson.ParseBytes(line)
+		if msg.IsArray() {
+			for _, item := range msg.Array() {
+				c.dispatch(item)
+			}
+		} else {
+			c.dispatch(msg)
+		}
+	}
+}
+
+func (c *Client) dispatch(msg gjson.Result) {
+
+	if id := msg.Get("id"); id.Exists() && id.Type != gjson.Null {
+		c.mu.Lock()
+		wait, ok := c.pending[id.Uint()]
+		c.mu.Unlock()
+		if ok {
+			//重复的id不阻塞读取
+			select {
+			case wait <- msg:
+			default:
+			}
+		}
+		return
+	}
+
+	if method := msg.Get("method").String(); len(method) > 0 {
+		select {
+		case c.notifications <- &Notification{Method: method, Params: msg.Get("params")}:
+		default:
+		}
+	}
+}
+
+//Notifications 订阅的通知
+func (c *Client) Notifications() <-chan *Notification {
+	return c.notifications
+}
+
+//Done 连接断开后关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+//Err 连接断开的原因
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+//Close 关闭连接
+func (c *Client) Close() error {
+	c.shutdown(fmt.Errorf("electrum client closed"))
+	return nil
+}
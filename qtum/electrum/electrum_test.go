@@ -0,0 +1,213 @@
+package electrum
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+//testServer 按行处理请求，handler返回result或error
+type testServer struct {
+	listener net.Listener
+	conns    chan net.Conn
+}
+
+func newTestServer(t *testing.T, handler func(method string, params gjson.Result) (interface{}, interface{})) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s := &testServer{listener: listener, conns: make(chan net.Conn, 1)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadBytes('\n')
+					if err != nil {
+						return
+					}
+					msg := gjson.ParseBytes(line)
+					reply := func(req gjson.Result) map[string]interface{} {
+						result, e := handler(req.Get("method").String(), req.Get("params"))
+						resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.Get("id").Uint()}
+						if e != nil {
+							resp["error"] = e
+						} else {
+							resp["result"] = result
+						}
+						return resp
+					}
+					var data []byte
+					if msg.IsArray() {
+						//批量请求倒序返回
+						resps := make([]interface{}, 0)
+						items := msg.Array()
+						for i := len(items) - 1; i >= 0; i-- {
+							resps = append(resps, reply(items[i]))
+						}
+						data, _ = json.Marshal(resps)
+					} else {
+						data, _ = json.Marshal(reply(msg))
+					}
+					conn.Write(append(data, '\n'))
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testServer) endpoint() string {
+	return "tcp://" + s.listener.Addr().String()
+}
+
+func Test_call_batch(t *testing.T) {
+	server := newTestServer(t, func(method string, params gjson.Result) (interface{}, interface{}) {
+		switch method {
+		case "server.version":
+			return []string{"ElectrumX 1.16.0", "1.4"}, nil
+		case "blockchain.transaction.id_from_pos":
+			if params.Array()[1].Int() > 1 {
+				return nil, map[string]interface{}{"code": 1, "message": "no tx at position"}
+			}
+			return params.Array()[1].String(), nil
+		}
+		return nil, "unknown method"
+	})
+	defer server.listener.Close()
+
+	client, err := Dial(server.endpoint(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	result, err := client.Call("server.version", "test", "1.4")
+	if err != nil || result.Array()[1].String() != "1.4" {
+		t.Fatalf("server.version = %v, %v", result, err)
+	}
+
+	if _, err := client.Call("server.banner"); err == nil {
+		t.Errorf("unknown method should return error")
+	} else if e, ok := err.(*Error); !ok || e.Message != "unknown method" {
+		t.Errorf("error = %v", err)
+	}
+
+	requests := make([]Request, 0)
+	for pos := 0; pos < 3; pos++ {
+		requests = append(requests, Request{Method: "blockchain.transaction.id_from_pos", Params: []interface{}{100, pos}})
+	}
+	responses, err := client.Batch(requests)
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	for pos, resp := range responses {
+		if pos < 2 && (resp.Err != nil || resp.Result.Int() != int64(pos)) {
+			t.Errorf("position %d = %v, %v", pos, resp.Result, resp.Err)
+		}
+		if pos == 2 {
+			if e, ok := resp.Err.(*Error); !ok || e.Code != 1 {
+				t.Errorf("position %d error = %v", pos, resp.Err)
+			}
+		}
+	}
+}
+
+func Test_notifications(t *testing.T) {
+	server := newTestServer(t, func(method string, params gjson.Result) (interface{}, interface{}) {
+		return map[string]interface{}{"height": 100, "hex": "00"}, nil
+	})
+	defer server.listener.Close()
+
+	client, err := Dial(server.endpoint(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Call(MethodHeadersSubscribe); err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+
+	conn := <-server.conns
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"blockchain.headers.subscribe","params":[{"height":101,"hex":"01"}]}` + "\n"))
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"blockchain.scripthash.subscribe","params":["abcd","efgh"]}` + "\n"))
+
+	want := []string{MethodHeadersSubscribe, MethodScripthashSubscribe}
+	for _, method := range want {
+		select {
+		case n := <-client.Notifications():
+			if n.Method != method {
+				t.Errorf("notification = %s, want %s", n.Method, method)
+			}
+			if method == MethodHeadersSubscribe && n.Params.Array()[0].Get("height").Uint() != 101 {
+				t.Errorf("header height = %s", n.Params.Raw)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("notification %s timeout", method)
+		}
+	}
+}
+
+func Test_disconnected(t *testing.T) {
+	server := newTestServer(t, func(method string, params gjson.Result) (interface{}, interface{}) {
+		//不响应，等待连接断开
+		time.Sleep(time.Hour)
+		return nil, nil
+	})
+	defer server.listener.Close()
+
+	client, err := Dial(server.endpoint(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Call("server.ping")
+		errCh <- err
+	}()
+
+	conn := <-server.conns
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Errorf("call should fail after disconnected")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("call is not interrupted by disconnection")
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("client is not done")
+	}
+
+	//超时
+	client, err = Dial(server.endpoint(), time.Second, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	client.Timeout = 100 * time.Millisecond
+	if _, err := client.Call("server.ping"); err == nil {
+		t.Errorf("call should timeout")
+	}
+
+	if _, err := Dial("http://127.0.0.1:1", time.Second, nil); err == nil {
+		t.Errorf("unsupported endpoint should fail")
+	}
+}
@@ -0,0 +1,537 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/blocktree/qtum-adapter/qtum/electrum"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	electrumClientName      = "qtum-adapter"
+	electrumProtocolVersion = "1.4"
+
+	//按位置查询区块交易单时每批的请求数量
+	electrumTxIDBatchSize = 100
+	//ElectrumX请求参数错误的错误码
+	electrumBadRequest = 1
+	//缓存最近的区块头数量，GetBlock通过hash查找高度
+	electrumHeaderCacheSize = 1000
+)
+
+//Electrum ElectrumX客户端，断开后下次调用时重新连接并恢复订阅
+type Electrum struct {
+	Endpoint  string
+	tlsConfig *tls.Config
+
+	mu           sync.Mutex
+	client       *electrum.Client
+	scripthashes map[string]string //已订阅的scripthash -> 地址
+
+	headerMu sync.Mutex
+	headers  map[string]*Block //区块hash -> 区块头
+	order    []string
+}
+
+//NewElectrum 创建ElectrumX客户端，skipVerify为true时ssl连接不校验证书
+func NewElectrum(endpoint string, skipVerify bool) *Electrum {
+	return &Electrum{
+		Endpoint:     endpoint,
+		tlsConfig:    &tls.Config{InsecureSkipVerify: skipVerify},
+		scripthashes: make(map[string]string),
+		headers:      make(map[string]*Block),
+	}
+}
+
+//Client 当前连接，未连接或已断开时重新连接，协商协议版本并恢复订阅
+func (e *Electrum) Client() (*electrum.Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		select {
+		case <-e.client.Done():
+		default:
+			return e.client, nil
+		}
+		e.client = nil
+	}
+
+	client, err := electrum.Dial(e.Endpoint, electrum.DefaultTimeout, e.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.Call("server.version", electrumClientName, electrumProtocolVersion); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if _, err := client.Call(electrum.MethodHeadersSubscribe); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if len(e.scripthashes) > 0 {
+		requests := make([]electrum.Request, 0, len(e.scripthashes))
+		for scripthash := range e.scripthashes {
+			requests = append(requests, electrum.Request{Method: electrum.MethodScripthashSubscribe, Params: []interface{}{scripthash}})
+		}
+		if _, err := client.Batch(requests); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	e.client = client
+	return client, nil
+}
+
+//Call 调用方法
+func (e *Electrum) Call(method string, params ...interface{}) (*gjson.Result, error) {
+	client, err := e.Client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Call(method, params...)
+}
+
+//Batch 批量调用，结果与请求的顺序一致
+func (e *Electrum) Batch(requests []electrum.Request) ([]electrum.Response, error) {
+	client, err := e.Client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Batch(requests)
+}
+
+//Subscribe 订阅scripthash的状态变化，重连后自动恢复
+func (e *Electrum) Subscribe(scripthash, address string) error {
+	e.mu.Lock()
+	_, ok := e.scripthashes[scripthash]
+	e.scripthashes[scripthash] = address
+	e.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+
+	_, err := e.Call(electrum.MethodScripthashSubscribe, scripthash)
+	return err
+}
+
+//Subscriptions 已订阅的scripthash -> 地址
+func (e *Electrum) Subscriptions() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	subs := make(map[string]string, len(e.scripthashes))
+	for scripthash, address := range e.scripthashes {
+		subs[scripthash] = address
+	}
+	return subs
+}
+
+//Close 关闭连接
+func (e *Electrum) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client == nil {
+		return nil
+	}
+	err := e.client.Close()
+	e.client = nil
+	return err
+}
+
+func (e *Electrum) cacheHeader(block *Block) {
+	e.headerMu.Lock()
+	defer e.headerMu.Unlock()
+
+	if _, ok := e.headers[block.Hash]; !ok {
+		e.order = append(e.order, block.Hash)
+	}
+	e.headers[block.Hash] = block
+	for len(e.order) > electrumHeaderCacheSize {
+		delete(e.headers, e.order[0])
+		e.order = e.order[1:]
+	}
+}
+
+func (e *Electrum) cachedHeader(hash string) (*Block, bool) {
+	e.headerMu.Lock()
+	defer e.headerMu.Unlock()
+
+	block, ok := e.headers[hash]
+	return block, ok
+}
+
+//newBlockByElectrumHeader 解析区块头，区块hash为完整区块头(包含PoS字段和签名)的双重sha256
+func newBlockByElectrumHeader(headerHex string, height uint64) (*Block, error) {
+
+	header, err := hex.DecodeString(headerHex)
+	if err != nil || len(header) < 80 {
+		return nil, fmt.Errorf("invalid block header: %s", headerHex)
+	}
+
+	obj := &Block{}
+	obj.Hash = hex.EncodeToString(reverseBytes(owcrypt.Hash(header, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)))
+	obj.Version = uint64(binary.LittleEndian.Uint32(header[0:4]))
+	obj.Previousblockhash = hex.EncodeToString(reverseBytes(header[4:36]))
+	obj.Merkleroot = hex.EncodeToString(reverseBytes(header[36:68]))
+	obj.Time = uint64(binary.LittleEndian.Uint32(header[68:72]))
+	obj.Height = height
+
+	return obj, nil
+}
+
+//electrumScripthash 地址的scripthash，为锁定脚本sha256的逆序
+func electrumScripthash(address string, addressPrefix btcLikeTxDriver.AddressPrefix) (string, error) {
+
+	lockScript, err := btcLikeTxDriver.GetAddressLockScript(address, addressPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	script, _ := hex.DecodeString(lockScript)
+
+	return hex.EncodeToString(reverseBytes(owcrypt.Hash(script, 0, owcrypt.HASH_ALG_SHA256))), nil
+}
+
+func reverseBytes(data []byte) []byte {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed
+}
+
+//electrumBackend 通过ElectrumX查询，交易池只包含已订阅地址的交易单
+type electrumBackend struct {
+	wm *WalletManager
+}
+
+//Supports 交易池只包含已订阅地址的交易单
+func (b *electrumBackend) Supports(capability ChainCapability) bool {
+	return capability != CapabilityMemPoolList
+}
+
+func (b *electrumBackend) addressPrefix() btcLikeTxDriver.AddressPrefix {
+	return b.wm.Config.Network.AddressPrefix()
+}
+
+func (b *electrumBackend) GetBlockHeight() (uint64, error) {
+	result, err := b.wm.ElectrumClient.Call(electrum.MethodHeadersSubscribe)
+	if err != nil {
+		return 0, err
+	}
+	return result.Get("height").Uint(), nil
+}
+
+func (b *electrumBackend) GetBlockHash(height uint64) (string, error) {
+	result, err := b.wm.ElectrumClient.Call("blockchain.block.header", height)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := newBlockByElectrumHeader(result.String(), height)
+	if err != nil {
+		return "", err
+	}
+	b.wm.ElectrumClient.cacheHeader(block)
+
+	return block.Hash, nil
+}
+
+//GetBlock ElectrumX不能通过hash查询区块，只支持缓存中由GetBlockHash查询过的区块
+func (b *electrumBackend) GetBlock(hash string) (*Block, error) {
+
+	header, ok := b.wm.ElectrumClient.cachedHeader(hash)
+	if !ok {
+		return nil, fmt.Errorf("block: %s is not found in electrum header cache", hash)
+	}
+
+	block := *header
+	block.tx = make([]string, 0)
+
+	//按位置逐批查询交易单，超出区块交易数量时返回位置无效的错误，其他错误由扫描任务重试
+	for pos := 0; ; pos += electrumTxIDBatchSize {
+		requests := make([]electrum.Request, 0, electrumTxIDBatchSize)
+		for i := pos; i < pos+electrumTxIDBatchSize; i++ {
+			requests = append(requests, electrum.Request{Method: "blockchain.transaction.id_from_pos", Params: []interface{}{block.Height, i}})
+		}
+
+		responses, err := b.wm.ElectrumClient.Batch(requests)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resp := range responses {
+			if resp.Err != nil {
+				if len(block.tx) == 0 || !isElectrumTxPosError(resp.Err) {
+					return nil, resp.Err
+				}
+				return &block, nil
+			}
+			block.tx = append(block.tx, resp.Result.String())
+		}
+	}
+}
+
+//isElectrumTxPosError id_from_pos的位置超出区块交易数量，ElectrumX返回BAD_REQUEST "no tx at position"
+func isElectrumTxPosError(err error) bool {
+	e, ok := err.(*electrum.Error)
+	if !ok || e.Code != electrumBadRequest {
+		return false
+	}
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "no tx at position") || strings.Contains(msg, "invalid tx position")
+}
+
+//GetTxIDsInMemPool 已订阅地址在交易池中的交易单
+func (b *electrumBackend) GetTxIDsInMemPool() ([]string, error) {
+
+	subs := b.wm.ElectrumClient.Subscriptions()
+	requests := make([]electrum.Request, 0, len(subs))
+	for scripthash := range subs {
+		requests = append(requests, electrum.Request{Method: "blockchain.scripthash.get_mempool", Params: []interface{}{scripthash}})
+	}
+
+	responses, err := b.wm.ElectrumClient.Batch(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	txids := make([]string, 0)
+	exist := make(map[string]bool)
+	for _, resp := range responses {
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+		for _, tx := range resp.Result.Array() {
+			txid := tx.Get("tx_hash").String()
+			if !exist[txid] {
+				exist[txid] = true
+				txids = append(txids, txid)
+			}
+		}
+	}
+
+	return txids, nil
+}
+
+//GetTransaction 优先查询verbose格式，服务不支持时解码原始交易单
+func (b *electrumBackend) GetTransaction(txid string) (*Transaction, error) {
+
+	result, err := b.wm.ElectrumClient.Call("blockchain.transaction.get", txid, true)
+	if err == nil {
+		return newTxByCore(result, b.wm.Config.Network), nil
+	}
+	if _, ok := err.(*electrum.Error); !ok {
+		return nil, err
+	}
+
+	result, err = b.wm.ElectrumClient.Call("blockchain.transaction.get", txid)
+	if err != nil {
+		return nil, err
+	}
+
+	txBytes, err := hex.DecodeString(result.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hex: %s", txid)
+	}
+
+	return newTxByRaw(txBytes, b.addressPrefix())
+}
+
+//CompleteTransaction 通过Qtum扩展的get_receipt查询已确认的合约调用交易回执
+func (b *electrumBackend) CompleteTransaction(trx *Transaction, isCoinstake bool) error {
+
+	if len(trx.BlockHash) == 0 || !trx.HasContractCall() {
+		return nil
+	}
+
+	result, err := b.wm.ElectrumClient.Call("blockchain.transaction.get_receipt", trx.TxID)
+	if err != nil {
+		return err
+	}
+
+	trx.TokenReceipts = make([]*TokenReceipt, 0)
+	trx.ContractReceipts = make([]*ContractReceipt, 0)
+	trx.applyContractReceipts(newContractReceiptsByCore(result, b.wm.Config.Network), b.wm.Config.Network, b.wm.Decimal())
+
+	return nil
+}
+
+func (b *electrumBackend) GetTxOut(txid string, vout uint64) (*Vout, error) {
+
+	tx, err := b.GetTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, out := range tx.Vouts {
+		if out.N == vout {
+			return out, nil
+		}
+	}
+
+	return nil, fmt.Errorf("can not find ouput")
+}
+
+//ListUnspent 没有指定地址时查询所有已订阅的地址
+func (b *electrumBackend) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
+
+	height, err := b.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	type target struct {
+		address    string
+		scriptHash string
+		lockScript string
+	}
+	targets := make([]target, 0)
+
+	if len(addresses) == 0 {
+		for _, address := range b.wm.ElectrumClient.Subscriptions() {
+			addresses = append(addresses, address)
+		}
+	}
+
+	requests := make([]electrum.Request, 0, len(addresses))
+	for _, address := range addresses {
+		lockScript, err := btcLikeTxDriver.GetAddressLockScript(address, b.addressPrefix())
+		if err != nil {
+			return nil, err
+		}
+		scripthash, err := electrumScripthash(address, b.addressPrefix())
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{address: address, scriptHash: scripthash, lockScript: lockScript})
+		requests = append(requests, electrum.Request{Method: "blockchain.scripthash.listunspent", Params: []interface{}{scripthash}})
+	}
+
+	responses, err := b.wm.ElectrumClient.Batch(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := make([]*Unspent, 0)
+	for i, resp := range responses {
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+		for _, u := range resp.Result.Array() {
+			//交易池中的未花记录高度为0或负数
+			confirmations := uint64(0)
+			if h := u.Get("height").Int(); h > 0 && uint64(h) <= height {
+				confirmations = height - uint64(h) + 1
+			}
+			if confirmations < min {
+				continue
+			}
+			utxos = append(utxos, &Unspent{
+				TxID:          u.Get("tx_hash").String(),
+				Vout:          u.Get("tx_pos").Uint(),
+				Address:       targets[i].address,
+				ScriptPubKey:  targets[i].lockScript,
+				Amount:        decimal.New(u.Get("value").Int(), -b.wm.Decimal()).String(),
+				Confirmations: confirmations,
+				Spendable:     true,
+			})
+		}
+	}
+
+	return utxos, nil
+}
+
+//ImportAddress 订阅地址，新交易单通过scripthash通知触发交易池扫描
+func (b *electrumBackend) ImportAddress(address string) error {
+	scripthash, err := electrumScripthash(address, b.addressPrefix())
+	if err != nil {
+		return err
+	}
+	return b.wm.ElectrumClient.Subscribe(scripthash, address)
+}
+
+func (b *electrumBackend) SendRawTransaction(txHex string) (string, error) {
+	result, err := b.wm.ElectrumClient.Call("blockchain.transaction.broadcast", txHex)
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+//EstimateFeeRate 服务无法估算时返回-1，使用最低手续费率
+func (b *electrumBackend) EstimateFeeRate() (decimal.Decimal, error) {
+
+	defaultRate := b.wm.Config.MinFees
+
+	result, err := b.wm.ElectrumClient.Call("blockchain.estimatefee", 2)
+	if err != nil {
+		return defaultRate, err
+	}
+
+	feeRate, _ := decimal.NewFromString(result.String())
+	if feeRate.LessThan(defaultRate) {
+		feeRate = defaultRate
+	}
+
+	return feeRate, nil
+}
+
+//CallContract 通过Qtum扩展的contract.call试运行合约调用，返回结果与callcontract一致
+func (b *electrumBackend) CallContract(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+
+	params := []interface{}{
+		strings.TrimPrefix(contractAddress, "0x"),
+		strings.TrimPrefix(dataHex, "0x"),
+	}
+	if len(from) > 0 {
+		params = append(params, from)
+	}
+
+	result, err := b.wm.ElectrumClient.Call("blockchain.contract.call", params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewQRC20Unspent(result), nil
+}
+
+func (b *electrumBackend) GetQRC20Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return b.wm.GetQRC20UnspentByAddress(token.Address, address, token.Decimals)
+}
+
+func (b *electrumBackend) GetQRC721Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return b.wm.getQRC721BalanceByCall(token, address)
+}
+
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/blocktree/qtum-adapter/qtum/electrum"
+)
+
+const (
+	electrumReconnectWait = 5 * time.Second  //断开后重连的等待时间
+	electrumPingInterval  = 60 * time.Second //保持连接，服务会断开长时间空闲的连接
+)
+
+//setupElectrum 启动ElectrumX订阅，新区块头通知触发扫块，已订阅地址的通知触发交易池扫描
+func (bs *BTCBlockScanner) setupElectrum() {
+	if bs.wm.Config.RPCServerType != RPCServerElectrum || bs.wm.ElectrumClient == nil || bs.electrumQuit != nil {
+		return
+	}
+
+	bs.wm.Log.Info("block scanner use electrum to listen new data")
+
+	quit := make(chan struct{})
+	newBlock := make(chan struct{}, 1)
+	newTx := make(chan struct{}, 1)
+	bs.electrumQuit = quit
+	go bs.runBlockTask(newBlock, quit)
+	go bs.runMemPoolTask(newTx, quit)
+	go bs.runElectrum(newBlock, newTx, quit)
+}
+
+//closeElectrum 停止ElectrumX订阅
+func (bs *BTCBlockScanner) closeElectrum() {
+	if bs.electrumQuit != nil {
+		close(bs.electrumQuit)
+		bs.electrumQuit = nil
+	}
+}
+
+//runElectrum 订阅运行时，断开期间由定时任务轮询，等待后重新连接
+func (bs *BTCBlockScanner) runElectrum(newBlock, newTx, quit chan struct{}) {
+	for {
+		client, err := bs.wm.ElectrumClient.Client()
+		if err != nil {
+			bs.wm.Log.Errorf("Connect electrum %s failed unexpected error: %v", bs.wm.ElectrumClient.Endpoint, err)
+		} else {
+			bs.wm.Log.Info("block scanner electrum connected:", bs.wm.ElectrumClient.Endpoint)
+			err = bs.receiveElectrum(client, newBlock, newTx, quit)
+			bs.wm.Log.Info("block scanner electrum disconnected:", bs.wm.ElectrumClient.Endpoint, err)
+		}
+
+		select {
+		case <-quit:
+			bs.wm.Log.Info("block scanner electrum has been stopped")
+			return
+		case <-time.After(electrumReconnectWait):
+		}
+	}
+}
+
+//receiveElectrum 处理订阅通知，连接期间定时任务不再轮询区块
+func (bs *BTCBlockScanner) receiveElectrum(client *electrum.Client, newBlock, newTx, quit chan struct{}) error {
+
+	atomic.AddInt32(&bs.blockNotify, 1)
+	defer atomic.AddInt32(&bs.blockNotify, -1)
+
+	//连接后扫描一次，补上断开期间的新区块
+	notifyNewBlock(newBlock)
+
+	ping := time.NewTicker(electrumPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return nil
+		case <-client.Done():
+			return client.Err()
+		case <-ping.C:
+			if _, err := client.Call("server.ping"); err != nil {
+				client.Close()
+				return err
+			}
+		case n := <-client.Notifications():
+			switch n.Method {
+			case electrum.MethodHeadersSubscribe:
+				//扫描任务会扫到最新高度，合并的区块头通知不需要逐个处理
+				notifyNewBlock(newBlock)
+			case electrum.MethodScripthashSubscribe:
+				if bs.IsScanMemPool {
+					notifyNewBlock(newTx)
+				}
+			}
+		}
+	}
+}
+
+//runMemPoolTask 收到地址状态变化的通知后扫描交易池
+func (bs *BTCBlockScanner) runMemPoolTask(newTx, quit chan struct{}) {
+	for {
+		select {
+		case <-quit:
+			return
+		case <-newTx:
+			if bs.Scanning {
+				bs.scanTxMemPool()
+			}
+		}
+	}
+}
@@ -0,0 +1,372 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EndpointPriority   = "priority"   //按配置顺序使用，前面的节点不可用时切换到下一个
+	EndpointRoundRobin = "roundrobin" //轮流使用可用的节点
+)
+
+const (
+	DefaultBreakerFailures     = 3                //连续失败多少次后熔断
+	DefaultBreakerTimeout      = 30 * time.Second //熔断时长
+	DefaultHealthCheckInterval = 10 * time.Second //熔断节点的健康检查间隔
+)
+
+//QuorumError 节点对同一查询返回了不同的结果，Values为节点地址 -> 结果
+type QuorumError struct {
+	Values map[string]string
+}
+
+func (e *QuorumError) Error() string {
+	urls := make([]string, 0, len(e.Values))
+	for url := range e.Values {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	results := make([]string, 0, len(urls))
+	for _, url := range urls {
+		results = append(results, fmt.Sprintf("%s=%s", url, e.Values[url]))
+	}
+	return "endpoints disagree: " + strings.Join(results, ", ")
+}
+
+//EndpointStatus 节点的状态
+type EndpointStatus struct {
+	URL       string
+	Available bool //熔断中为false
+	Failures  int  //连续失败次数
+	LastError string
+}
+
+type endpointState struct {
+	url       string
+	failures  int
+	openUntil time.Time
+	lastErr   error
+}
+
+//available 未熔断，或熔断到期进入半开，半开期间再失败一次即重新熔断
+func (e *endpointState) available(now time.Time) bool {
+	return now.After(e.openUntil)
+}
+
+//EndpointPool 多个节点地址，按策略选择节点，连续失败的节点熔断一段时间
+type EndpointPool struct {
+	Strategy        string        //EndpointPriority或EndpointRoundRobin
+	BreakerFailures int           //连续失败多少次后熔断
+	BreakerTimeout  time.Duration //熔断时长，到期后允许试探请求
+	Quorum          int           //关键查询需要一致的节点数量，小于2时不启用
+
+	mu        sync.Mutex
+	endpoints []*endpointState
+	next      int
+	quit      chan struct{}
+}
+
+//ParseEndpoints 解析逗号分隔的节点地址
+func ParseEndpoints(urls string) []string {
+	endpoints := make([]string, 0)
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); len(url) > 0 {
+			endpoints = append(endpoints, url)
+		}
+	}
+	return endpoints
+}
+
+//NewEndpointPool 创建节点池，默认按优先级切换
+func NewEndpointPool(urls ...string) *EndpointPool {
+	p := &EndpointPool{
+		Strategy:        EndpointPriority,
+		BreakerFailures: DefaultBreakerFailures,
+		BreakerTimeout:  DefaultBreakerTimeout,
+	}
+	for _, url := range urls {
+		p.endpoints = append(p.endpoints, &endpointState{url: url})
+	}
+	return p
+}
+
+//Len 节点数量
+func (p *EndpointPool) Len() int {
+	return len(p.endpoints)
+}
+
+//Primary 按配置顺序第一个可用的节点，都不可用时返回第一个
+func (p *EndpointPool) Primary() string {
+	candidates := p.candidates(false)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+//Status 所有节点的状态
+func (p *EndpointPool) Status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	status := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		s := EndpointStatus{URL: e.url, Available: e.available(now), Failures: e.failures}
+		if e.lastErr != nil {
+			s.LastError = e.lastErr.Error()
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+//candidates 本次请求依次尝试的节点，熔断中的节点排在最后，所有节点都熔断时仍然尝试
+func (p *EndpointPool) candidates(rotate bool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	available := make([]string, 0, len(p.endpoints))
+	open := make([]string, 0)
+	for _, e := range p.endpoints {
+		if e.available(now) {
+			available = append(available, e.url)
+		} else {
+			open = append(open, e.url)
+		}
+	}
+
+	if rotate && p.Strategy == EndpointRoundRobin && len(available) > 1 {
+		start := p.next % len(available)
+		p.next++
+		available = append(available[start:], available[:start]...)
+	}
+
+	return append(available, open...)
+}
+
+func (p *EndpointPool) state(url string) *endpointState {
+	for _, e := range p.endpoints {
+		if e.url == url {
+			return e
+		}
+	}
+	return nil
+}
+
+//success 请求成功，关闭熔断
+func (p *EndpointPool) success(url string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e := p.state(url); e != nil {
+		e.failures = 0
+		e.openUntil = time.Time{}
+		e.lastErr = nil
+	}
+}
+
+//failure 请求失败，连续失败达到阀值后熔断
+func (p *EndpointPool) failure(url string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e := p.state(url); e != nil {
+		e.failures++
+		e.lastErr = err
+		if p.BreakerFailures > 0 && e.failures >= p.BreakerFailures {
+			e.openUntil = time.Now().Add(p.BreakerTimeout)
+		}
+	}
+}
+
+//Do 依次在节点上执行请求，fn返回retry为true表示节点不可用(如连接失败)，记录失败并切换到下一个节点
+//节点返回的业务错误(retry为false)直接返回，不切换节点
+func (p *EndpointPool) Do(fn func(url string) (retry bool, err error)) error {
+
+	candidates := p.candidates(true)
+	if len(candidates) == 0 {
+		return errors.New("API url is not setup. ")
+	}
+
+	var lastErr error
+	for _, url := range candidates {
+		retry, err := fn(url)
+		if !retry {
+			p.success(url)
+			return err
+		}
+		p.failure(url, err)
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+//DoQuorum 在可用的节点上同时执行查询，至少Quorum个节点返回相同的key时返回该结果
+//节点之间的结果不一致时返回QuorumError，不信任任何一个节点；没有启用Quorum时等同于Do
+func (p *EndpointPool) DoQuorum(fn func(url string) (retry bool, key string, err error)) (string, error) {
+
+	if p.Quorum < 2 {
+		var key string
+		err := p.Do(func(url string) (bool, error) {
+			var (
+				retry bool
+				err   error
+			)
+			retry, key, err = fn(url)
+			return retry, err
+		})
+		return key, err
+	}
+
+	type answer struct {
+		url   string
+		retry bool
+		key   string
+		err   error
+	}
+
+	candidates := p.candidates(false)
+	answers := make(chan answer, len(candidates))
+	for _, url := range candidates {
+		go func(url string) {
+			retry, key, err := fn(url)
+			answers <- answer{url: url, retry: retry, key: key, err: err}
+		}(url)
+	}
+
+	var (
+		values   = make(map[string]string)
+		counts   = make(map[string]int)
+		firstErr error
+	)
+	for range candidates {
+		a := <-answers
+		if a.retry {
+			p.failure(a.url, a.err)
+		} else {
+			p.success(a.url)
+		}
+		if a.err != nil {
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			continue
+		}
+		values[a.url] = a.key
+		counts[a.key]++
+	}
+
+	if len(counts) > 1 {
+		return "", &QuorumError{Values: values}
+	}
+	for key, n := range counts {
+		if n >= p.Quorum {
+			return key, nil
+		}
+	}
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return "", fmt.Errorf("quorum not reached: %d of %d endpoints answered", len(values), p.Quorum)
+}
+
+//StartHealthCheck 定时检查失败过的节点，检查成功时关闭熔断
+func (p *EndpointPool) StartHealthCheck(interval time.Duration, probe func(url string) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit != nil || interval <= 0 {
+		return
+	}
+	quit := make(chan struct{})
+	p.quit = quit
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				for _, s := range p.Status() {
+					if s.Failures == 0 {
+						continue
+					}
+					if err := probe(s.URL); err != nil {
+						p.failure(s.URL, err)
+					} else {
+						p.success(s.URL)
+					}
+				}
+			}
+		}
+	}()
+}
+
+//Close 停止健康检查
+func (p *EndpointPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit != nil {
+		close(p.quit)
+		p.quit = nil
+	}
+}
+
+//reportQuorum 记录节点之间不一致的查询结果，返回原错误
+func (wm *WalletManager) reportQuorum(query string, err error) error {
+	if qerr, ok := err.(*QuorumError); ok {
+		wm.Log.Warningf("%s: %v", query, qerr)
+	}
+	return err
+}
+
+//setupEndpoints 按配置设置节点池的切换策略、熔断和Quorum
+func (wm *WalletManager) setupEndpoints(pool *EndpointPool) {
+	pool.Strategy = wm.Config.EndpointStrategy
+	pool.BreakerFailures = wm.Config.BreakerFailures
+	pool.BreakerTimeout = wm.Config.BreakerTimeout
+	pool.Quorum = wm.Config.Quorum
+	if pool.Quorum > pool.Len() {
+		wm.Log.Warningf("quorum %d is greater than the number of endpoints %d", pool.Quorum, pool.Len())
+	}
+}
+
+//closeEndpoints 停止已创建的客户端节点池的健康检查，关闭Electrum连接
+func (wm *WalletManager) closeEndpoints() {
+	if wm.WalletClient != nil && wm.WalletClient.Endpoints != nil {
+		wm.WalletClient.Endpoints.Close()
+	}
+	if wm.ExplorerClient != nil && wm.ExplorerClient.Endpoints != nil {
+		wm.ExplorerClient.Endpoints.Close()
+	}
+	if wm.ElectrumClient != nil {
+		wm.ElectrumClient.Close()
+	}
+}
@@ -0,0 +1,335 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/qtum-adapter/qtum/electrum"
+	"github.com/tidwall/gjson"
+)
+
+//rpcTestError 测试节点返回的json-rpc错误
+type rpcTestError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+//rpcTestServer json-rpc测试节点，记录收到的请求数
+type rpcTestServer struct {
+	*httptest.Server
+	hits int32
+}
+
+//newRPCTestServer 创建测试节点，result返回请求的结果，返回*rpcTestError时作为节点的错误
+func newRPCTestServer(t *testing.T, result func(method string) interface{}) *rpcTestServer {
+	s := &rpcTestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		var request struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := map[string]interface{}{"id": request.ID, "result": nil, "error": nil}
+		if value := result(request.Method); value != nil {
+			if rpcErr, ok := value.(*rpcTestError); ok {
+				resp["error"] = rpcErr
+			} else {
+				resp["result"] = value
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+//Hits 收到的请求数
+func (s *rpcTestServer) Hits() int {
+	return int(atomic.LoadInt32(&s.hits))
+}
+
+//closedURL 已关闭的节点地址，连接会被拒绝
+func closedURL(t *testing.T) string {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+	return s.URL
+}
+
+func TestLoadAssetsConfig_CloseEndpoints(t *testing.T) {
+
+	ini := fmt.Sprintf("rpcServerType = %d\napiURL = http://127.0.0.1:1/,http://127.0.0.1:2/\nhealthCheckSeconds = 60\ndataDir = %s\n",
+		RPCServerExplorer, t.TempDir())
+	c, err := config.NewConfigData("ini", []byte(ini))
+	if err != nil {
+		t.Fatalf("NewConfigData failed: %v", err)
+	}
+
+	wm := NewWalletManager()
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed: %v", err)
+	}
+	old := wm.ExplorerClient.Endpoints
+
+	//重新加载配置后只有新的节点池在健康检查
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed: %v", err)
+	}
+	defer wm.ExplorerClient.Endpoints.Close()
+
+	old.mu.Lock()
+	stopped := old.quit == nil
+	old.mu.Unlock()
+	if !stopped {
+		t.Fatalf("health check of the old endpoints should be stopped")
+	}
+
+	current := wm.ExplorerClient.Endpoints
+	current.mu.Lock()
+	started := current.quit != nil
+	current.mu.Unlock()
+	if current == old || !started {
+		t.Fatalf("health check of the new endpoints should be started")
+	}
+}
+
+func TestLoadAssetsConfig_CloseElectrum(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	endpoint := "tcp://" + listener.Addr().String()
+	ini := fmt.Sprintf("rpcServerType = %d\napiURL = %s\ndataDir = %s\n", RPCServerElectrum, endpoint, t.TempDir())
+	c, err := config.NewConfigData("ini", []byte(ini))
+	if err != nil {
+		t.Fatalf("NewConfigData failed: %v", err)
+	}
+
+	wm := NewWalletManager()
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed: %v", err)
+	}
+	old := wm.ElectrumClient
+	client, err := electrum.Dial(endpoint, time.Second, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	old.client = client
+
+	//重新加载配置后旧的连接被关闭
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed: %v", err)
+	}
+	defer wm.ElectrumClient.Close()
+
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("the old electrum connection should be closed")
+	}
+	if old.client != nil || wm.ElectrumClient == old {
+		t.Fatalf("a new electrum client should replace the old one")
+	}
+}
+
+func TestClient_Failover(t *testing.T) {
+
+	down := closedURL(t)
+	up := newRPCTestServer(t, func(method string) interface{} {
+		return 100
+	})
+
+	client := NewClient(down+","+up.URL, "", false)
+	result, err := client.Call("getblockcount", nil)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if result.Uint() != 100 {
+		t.Fatalf("result: %d, want: 100", result.Uint())
+	}
+
+	status := client.Endpoints.Status()
+	if status[0].Failures != 1 || !status[0].Available || len(status[0].LastError) == 0 {
+		t.Fatalf("unexpected status of the down endpoint: %+v", status[0])
+	}
+	if status[1].Failures != 0 {
+		t.Fatalf("unexpected status of the up endpoint: %+v", status[1])
+	}
+}
+
+func TestClient_NodeErrorNoFailover(t *testing.T) {
+
+	first := newRPCTestServer(t, func(method string) interface{} {
+		return &rpcTestError{Code: -8, Message: "Block height out of range"}
+	})
+	second := newRPCTestServer(t, func(method string) interface{} {
+		return "hash"
+	})
+
+	//节点返回的业务错误不切换节点
+	client := NewClient(first.URL+","+second.URL, "", false)
+	if _, err := client.Call("getblockhash", []interface{}{1000000}); err == nil || err.Error() != "[-8]Block height out of range" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.Hits() != 0 {
+		t.Fatalf("node error should not fail over, second endpoint hits: %d", second.Hits())
+	}
+
+	//节点正在启动时切换
+	warmup := newRPCTestServer(t, func(method string) interface{} {
+		return &rpcTestError{Code: rpcInWarmup, Message: "Loading block index..."}
+	})
+	client = NewClient(warmup.URL+","+second.URL, "", false)
+	result, err := client.Call("getblockhash", []interface{}{1})
+	if err != nil || result.String() != "hash" {
+		t.Fatalf("warming up endpoint should fail over, result: %v, err: %v", result, err)
+	}
+}
+
+func TestEndpointPool_RoundRobin(t *testing.T) {
+
+	pool := NewEndpointPool("a", "b", "c")
+	pool.Strategy = EndpointRoundRobin
+
+	used := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		pool.Do(func(url string) (bool, error) {
+			used = append(used, url)
+			return false, nil
+		})
+	}
+	if fmt.Sprint(used) != "[a b c a]" {
+		t.Fatalf("round robin used: %v", used)
+	}
+}
+
+func TestEndpointPool_Breaker(t *testing.T) {
+
+	pool := NewEndpointPool("a", "b")
+	pool.BreakerFailures = 2
+	pool.BreakerTimeout = 50 * time.Millisecond
+
+	failA := func(url string) (bool, error) {
+		if url == "a" {
+			return true, errors.New("connection refused")
+		}
+		return false, nil
+	}
+
+	//连续失败达到阀值后熔断，熔断的节点排在最后
+	pool.Do(failA)
+	if pool.Primary() != "a" {
+		t.Fatalf("endpoint a should not be open after one failure")
+	}
+	pool.Do(failA)
+	if pool.Primary() != "b" || pool.Status()[0].Available {
+		t.Fatalf("endpoint a should be open after two failures")
+	}
+
+	tried := make([]string, 0)
+	pool.Do(func(url string) (bool, error) {
+		tried = append(tried, url)
+		return false, nil
+	})
+	if fmt.Sprint(tried) != "[b]" {
+		t.Fatalf("open endpoint should not be tried first: %v", tried)
+	}
+
+	//到期后半开，试探失败重新熔断
+	time.Sleep(60 * time.Millisecond)
+	if pool.Primary() != "a" {
+		t.Fatalf("endpoint a should be half-open after the breaker timeout")
+	}
+	pool.Do(failA)
+	if pool.Primary() != "b" {
+		t.Fatalf("endpoint a should be open again after a half-open failure")
+	}
+
+	//试探成功后关闭熔断
+	time.Sleep(60 * time.Millisecond)
+	pool.Do(func(url string) (bool, error) {
+		return false, nil
+	})
+	if status := pool.Status()[0]; !status.Available || status.Failures != 0 {
+		t.Fatalf("endpoint a should be closed after a half-open success: %+v", status)
+	}
+}
+
+func TestClient_CallQuorum(t *testing.T) {
+
+	hash := func(value string) *rpcTestServer {
+		return newRPCTestServer(t, func(method string) interface{} {
+			return value
+		})
+	}
+	key := func(result *gjson.Result) string {
+		return result.String()
+	}
+
+	a, b, c := hash("aa"), hash("aa"), hash("bb")
+
+	//节点之间不一致时不信任任何一个节点
+	client := NewClient(a.URL+","+b.URL+","+c.URL, "", false)
+	client.Endpoints.Quorum = 2
+	_, err := client.CallQuorum("getblockhash", []interface{}{1}, key)
+	qerr, ok := err.(*QuorumError)
+	if !ok {
+		t.Fatalf("expected QuorumError, got: %v", err)
+	}
+	if len(qerr.Values) != 3 || qerr.Values[c.URL] != "bb" || qerr.Values[a.URL] != "aa" {
+		t.Fatalf("unexpected quorum values: %v", qerr.Values)
+	}
+
+	//不可用的节点不影响一致的结果
+	client = NewClient(a.URL+","+b.URL+","+closedURL(t), "", false)
+	client.Endpoints.Quorum = 2
+	result, err := client.CallQuorum("getblockhash", []interface{}{1}, key)
+	if err != nil || result.String() != "aa" {
+		t.Fatalf("quorum result: %v, err: %v", result, err)
+	}
+
+	//达不到Quorum数量
+	client = NewClient(a.URL+","+closedURL(t), "", false)
+	client.Endpoints.Quorum = 2
+	if _, err := client.CallQuorum("getblockhash", []interface{}{1}, key); err == nil {
+		t.Fatalf("quorum should not be reached with one answer")
+	}
+}
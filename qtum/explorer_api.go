@@ -25,6 +25,8 @@ import (
 	"github.com/tidwall/gjson"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Explorer是由bitpay的insight-API提供区块数据查询接口
@@ -33,6 +35,7 @@ type Explorer struct {
 	BaseURL     string
 	AccessToken string
 	Debug       bool
+	Endpoints   *EndpointPool //节点池，BaseURL为逗号分隔的多个地址时按策略切换
 	client      *req.Req
 	//Client *req.Req
 }
@@ -41,10 +44,13 @@ func NewExplorer(url string, debug bool) *Explorer {
 	c := Explorer{
 		BaseURL: url,
 		//AccessToken: token,
-		Debug: debug,
+		Debug:     debug,
+		Endpoints: NewEndpointPool(ParseEndpoints(url)...),
 	}
 
 	api := req.New()
+	//http客户端是延迟创建的，提前创建避免并发请求时竞争
+	api.Client()
 	c.client = api
 
 	return &c
@@ -57,11 +63,67 @@ func (b *Explorer) Call(path string, request interface{}, method string) (*gjson
 		return nil, errors.New("API url is not setup. ")
 	}
 
+	var result *gjson.Result
+	err := b.Endpoints.Do(func(baseURL string) (bool, error) {
+		var (
+			retry bool
+			err   error
+		)
+		result, retry, err = b.call(baseURL, path, request, method)
+		return retry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//CallQuorum 在多个地址上查询，key相同的结果达到Quorum数量时返回，不一致时返回QuorumError
+func (b *Explorer) CallQuorum(path string, request interface{}, method string, key func(result *gjson.Result) string) (*gjson.Result, error) {
+
+	if b.client == nil {
+		return nil, errors.New("API url is not setup. ")
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string]*gjson.Result)
+	)
+	agreed, err := b.Endpoints.DoQuorum(func(baseURL string) (bool, string, error) {
+		result, retry, err := b.call(baseURL, path, request, method)
+		if err != nil {
+			return retry, "", err
+		}
+		k := key(result)
+		mu.Lock()
+		results[k] = result
+		mu.Unlock()
+		return false, k, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results[agreed], nil
+}
+
+//HealthCheck 定时用info接口检查失败过的地址
+func (b *Explorer) HealthCheck(interval time.Duration) {
+	b.Endpoints.StartHealthCheck(interval, func(baseURL string) error {
+		_, _, err := b.call(baseURL, "info", nil, "GET")
+		return err
+	})
+}
+
+//call 请求一个地址，连接失败或服务端错误时retry为true
+func (b *Explorer) call(baseURL, path string, request interface{}, method string) (*gjson.Result, bool, error) {
+
 	if b.Debug {
 		log.Std.Debug("Start Request API...")
 	}
 
-	url := b.BaseURL + path
+	url := baseURL + path
 
 	r, err := b.client.Do(method, url, request)
 
@@ -73,18 +135,18 @@ func (b *Explorer) Call(path string, request interface{}, method string) (*gjson
 		log.Std.Debug("%+v", r)
 	}
 
-	err = b.isError(r)
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 
+	err = b.isError(r)
 	if err != nil {
-		return nil, err
+		return nil, r.Response() == nil || r.Response().StatusCode >= http.StatusInternalServerError, err
 	}
 
 	resp := gjson.ParseBytes(r.Bytes())
 
-	return &resp, nil
+	return &resp, false, nil
 }
 
 //isError 是否报错
@@ -119,9 +181,11 @@ func (wm *WalletManager) getBlockHashByExplorer(height uint64) (string, error) {
 
 	path := fmt.Sprintf("block/%d", height)
 
-	result, err := wm.ExplorerClient.Call(path, nil, "GET")
+	result, err := wm.ExplorerClient.CallQuorum(path, nil, "GET", func(result *gjson.Result) string {
+		return result.Get("hash").String()
+	})
 	if err != nil {
-		return "", err
+		return "", wm.reportQuorum(path, err)
 	}
 
 	return result.Get("hash").String(), nil
@@ -158,7 +222,7 @@ func (wm *WalletManager) getTransactionByExplorer(txid string) (*Transaction, er
 		return nil, err
 	}
 
-	tx := wm.newTxByExplorer(result, wm.Config.isTestNet)
+	tx := wm.newTxByExplorer(result, wm.Config.Network)
 
 	return tx, nil
 
@@ -261,7 +325,7 @@ func newBlockByExplorer(json *gjson.Result) *Block {
 	return obj
 }
 
-func (wm *WalletManager) newTxByExplorer(json *gjson.Result, isTestnet bool) *Transaction {
+func (wm *WalletManager) newTxByExplorer(json *gjson.Result, params *ChainParams) *Transaction {
 
 	obj := Transaction{}
 	//解析json
@@ -292,11 +356,17 @@ func (wm *WalletManager) newTxByExplorer(json *gjson.Result, isTestnet bool) *Tr
 	}
 
 	obj.Vouts = make([]*Vout, 0)
+	contractReceipts := make([]*ContractReceipt, 0)
 	if vouts := gjson.Get(json.Raw, "outputs"); vouts.IsArray() {
 		for i, vout := range vouts.Array() {
 			output := wm.newTxVoutByExplorer(&vout)
 			output.N = uint64(i)
 			obj.Vouts = append(obj.Vouts, output)
+
+			//合约输出的执行回执
+			if receipt := vout.Get("receipt"); receipt.Exists() {
+				contractReceipts = append(contractReceipts, newContractReceiptByExplorer(&receipt, output.N, params))
+			}
 		}
 	}
 
@@ -304,7 +374,18 @@ func (wm *WalletManager) newTxByExplorer(json *gjson.Result, isTestnet bool) *Tr
 	if receipts := gjson.Get(json.Raw, "qrc20TokenTransfers"); receipts.IsArray() {
 		obj.Isqrc20Transfer = true
 		for _, receipt := range receipts.Array() {
-			token := newTokenReceiptByExplorer(&receipt, isTestnet)
+			token := newTokenReceiptByExplorer(&receipt, params)
+			token.TxHash = obj.TxID
+			token.BlockHash = obj.BlockHash
+			token.BlockHeight = obj.BlockHeight
+			obj.TokenReceipts = append(obj.TokenReceipts, token)
+		}
+	}
+
+	if receipts := gjson.Get(json.Raw, "qrc721TokenTransfers"); len(receipts.Array()) > 0 {
+		obj.Isqrc721Transfer = true
+		for _, receipt := range receipts.Array() {
+			token := newQRC721ReceiptByExplorer(&receipt)
 			token.TxHash = obj.TxID
 			token.BlockHash = obj.BlockHash
 			token.BlockHeight = obj.BlockHeight
@@ -312,6 +393,9 @@ func (wm *WalletManager) newTxByExplorer(json *gjson.Result, isTestnet bool) *Tr
 		}
 	}
 
+	//代币转账已由浏览器解析，回执只用于计算gas成本和失败的代币转账
+	obj.applyContractReceipts(contractReceipts, params, wm.Decimal())
+
 	return &obj
 }
 
@@ -342,12 +426,40 @@ func (wm *WalletManager) newTxVoutByExplorer(json *gjson.Result) *Vout {
 	//提取地址
 	obj.Addr = gjson.Get(json.Raw, "address").String()
 	obj.Type = gjson.Get(json.Raw, "scriptPubKey.type").String()
+	obj.IsRefund = gjson.Get(json.Raw, "isRefund").Bool()
+
+	return &obj
+}
+
+//newContractReceiptByExplorer 合约输出的执行回执
+func newContractReceiptByExplorer(json *gjson.Result, n uint64, params *ChainParams) *ContractReceipt {
+
+	obj := ContractReceipt{}
+	obj.OutputIndex = n
+	obj.Sender = gjson.Get(json.Raw, "sender").String()
+	//十六进制的hash160转为地址
+	if len(obj.Sender) == 40 {
+		obj.Sender = HashAddressToBaseAddress(obj.Sender, params)
+	}
+	obj.ContractAddress = "0x" + gjson.Get(json.Raw, "contractAddressHex").String()
+	obj.GasUsed = gjson.Get(json.Raw, "gasUsed").Uint()
+	obj.Excepted = gjson.Get(json.Raw, "excepted").String()
+	obj.RawReceipt = json.Raw
+	obj.Logs = make([]*ContractLog, 0)
+	for _, logInfo := range gjson.Get(json.Raw, "logs").Array() {
+		address := logInfo.Get("addressHex").String()
+		if len(address) == 0 {
+			address = logInfo.Get("address").String()
+		}
+		obj.Logs = append(obj.Logs, newContractLog(address, &logInfo))
+	}
+	obj.TokenReceipts = make([]*TokenReceipt, 0)
 
 	return &obj
 }
 
 //newTokenReceiptByExplorer
-func newTokenReceiptByExplorer(json *gjson.Result, isTestnet bool) *TokenReceipt {
+func newTokenReceiptByExplorer(json *gjson.Result, params *ChainParams) *TokenReceipt {
 
 	obj := TokenReceipt{}
 	//解析json
@@ -356,50 +468,23 @@ func newTokenReceiptByExplorer(json *gjson.Result, isTestnet bool) *TokenReceipt
 	obj.To = gjson.Get(json.Raw, "to").String()
 	obj.Amount = gjson.Get(json.Raw, "value").String()
 	obj.ContractAddress = "0x" + gjson.Get(json.Raw, "addressHex").String()
+	obj.Protocol = QRC20Protocol
 
-	//obj.BlockHash = gjson.Get(json.Raw, "blockHash").String()
-	//obj.BlockHeight = gjson.Get(json.Raw, "blockNumber").Uint()
-	//obj.TxHash = gjson.Get(json.Raw, "transactionHash").String()
-	//obj.Excepted = gjson.Get(json.Raw, "excepted").String()
-	//obj.GasUsed = gjson.Get(json.Raw, "gasUsed").Uint()
-	//obj.ContractAddress = "0x" + gjson.Get(json.Raw, "contractAddress").String()
-	//obj.Sender = HashAddressToBaseAddress(
-	//	gjson.Get(json.Raw, "from").String(),
-	//	isTestnet)
-	//
-	//
-	//
-	//logs := gjson.Get(json.Raw, "log").Array()
-	//for _, logInfo := range logs {
-	//	topics := logInfo.Get("topics").Array()
-	//	data := logInfo.Get("data").String()
-	//
-	//	if len(topics) != 3 {
-	//		continue
-	//	}
-	//
-	//	if "0x"+topics[0].String() != QTUM_TRANSFER_EVENT_ID {
-	//		continue
-	//	}
-	//
-	//	if len(data) != 64 {
-	//		continue
-	//	}
-	//
-	//	//log.Info("topics[1]:", topics[1].String())
-	//	//log.Info("topics[2]:", topics[2].String())
-	//	obj.From = strings.TrimPrefix(topics[1].String(), "000000000000000000000000")
-	//	obj.To = strings.TrimPrefix(topics[2].String(), "000000000000000000000000")
-	//	obj.From = HashAddressToBaseAddress(obj.From, isTestnet)
-	//	obj.To = HashAddressToBaseAddress(obj.To, isTestnet)
-	//
-	//	//转化为10进制
-	//	//log.Debug("TokenReceipt TxHash", obj.TxHash)
-	//	//log.Debug("TokenReceipt amount", logInfo.Get("data").String())
-	//	value := new(big.Int)
-	//	value, _ = value.SetString(data, 16)
-	//	obj.Amount = decimal.NewFromBigInt(value, 0).String()
-	//}
+	return &obj
+}
+
+//newQRC721ReceiptByExplorer QRC721的转账记录，tokenId为十六进制
+func newQRC721ReceiptByExplorer(json *gjson.Result) *TokenReceipt {
+
+	obj := TokenReceipt{}
+	obj.From = gjson.Get(json.Raw, "from").String()
+	obj.To = gjson.Get(json.Raw, "to").String()
+	obj.ContractAddress = "0x" + gjson.Get(json.Raw, "addressHex").String()
+	obj.Protocol = QRC721Protocol
+	obj.Amount = "1"
+	if tokenID, err := parseTokenID(gjson.Get(json.Raw, "tokenId").String()); err == nil {
+		obj.TokenID = tokenID.String()
+	}
 
 	return &obj
 }
@@ -455,7 +540,7 @@ func (wm *WalletManager) getMultiAddrTransactionsByExplorer(offset, limit int, a
 
 	if items := result.Get("items"); items.IsArray() {
 		for _, obj := range items.Array() {
-			tx := wm.newTxByExplorer(&obj, wm.Config.isTestNet)
+			tx := wm.newTxByExplorer(&obj, wm.Config.Network)
 			trxs = append(trxs, tx)
 		}
 	}
@@ -481,11 +566,18 @@ func (wm *WalletManager) estimateFeeRateByExplorer() (decimal.Decimal, error) {
 //getTxOutByExplorer 获取交易单输出信息，用于追溯交易单输入源头
 func (wm *WalletManager) getTxOutByExplorer(txid string, vout uint64) (*Vout, error) {
 
-	tx, err := wm.getTransactionByExplorer(txid)
+	path := fmt.Sprintf("tx/%s", txid)
+
+	result, err := wm.ExplorerClient.CallQuorum(path, nil, "GET", func(result *gjson.Result) string {
+		output := result.Get(fmt.Sprintf("outputs.%d", vout))
+		return output.Get("value").String() + ":" + output.Get("scriptPubKey.hex").String()
+	})
 	if err != nil {
-		return nil, err
+		return nil, wm.reportQuorum(path, err)
 	}
 
+	tx := wm.newTxByExplorer(result, wm.Config.Network)
+
 	for i, out := range tx.Vouts {
 		if uint64(i) == vout {
 			return out, nil
@@ -549,3 +641,43 @@ func (wm *WalletManager) getAddressTokenBalanceByExplorer(token openwallet.Smart
 	return decimal.New(0, 0), nil
 
 }
+
+//getAddressQRC721BalanceByExplorer 通过合约地址查询用户地址拥有的QRC721数量
+func (wm *WalletManager) getAddressQRC721BalanceByExplorer(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+
+	trimContractAddr := strings.TrimPrefix(token.Address, "0x")
+
+	path := fmt.Sprintf("address/%s", address)
+
+	result, err := wm.ExplorerClient.Call(path, nil, "GET")
+	if err != nil {
+		return decimal.New(0, 0), err
+	}
+
+	if qrc721Balances := result.Get("qrc721Balances"); qrc721Balances.IsArray() {
+		for _, qrc721 := range qrc721Balances.Array() {
+			if qrc721.Get("addressHex").String() == trimContractAddr {
+				count, _ := decimal.NewFromString(qrc721.Get("count").String())
+				return count, nil
+			}
+		}
+	}
+
+	return decimal.New(0, 0), nil
+}
+
+//callContractByExplorer 通过浏览器API试运行合约调用，返回结果与callcontract一致
+func (wm *WalletManager) callContractByExplorer(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+
+	path := fmt.Sprintf("contract/%s/call?data=%s", strings.TrimPrefix(contractAddress, "0x"), strings.TrimPrefix(dataHex, "0x"))
+	if len(from) > 0 {
+		path = path + "&sender=" + from
+	}
+
+	result, err := wm.ExplorerClient.Call(path, nil, "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewQRC20Unspent(result), nil
+}
@@ -147,7 +147,7 @@ func TestSocketIO(t *testing.T) {
 func TestEstimateFeeRateByExplorer(t *testing.T) {
 	feeRate, _ := tw.estimateFeeRateByExplorer()
 	t.Logf("EstimateFee feeRate = %s\n", feeRate.StringFixed(8))
-	fees, _ := tw.EstimateFee(10, 2, feeRate)
+	fees, _ := tw.EstimateFee(testFeeInputs(10), testFeeOutputs(2), feeRate)
 	t.Logf("EstimateFee fees = %s\n", fees.StringFixed(8))
 }
 
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//ExtractMetrics 区块提取的统计数据
+type ExtractMetrics struct {
+	Blocks        uint64        //已提取的区块数
+	Transactions  uint64        //已提取的交易单数
+	Failed        uint64        //提取或通知失败的交易单数
+	Elapsed       time.Duration //累计提取耗时
+	LastBlockTxs  uint64        //最近一个区块的交易单数
+	LastBlockTime time.Duration //最近一个区块的提取耗时
+	ChainHeight   uint64        //节点的最新高度
+	ScannedHeight uint64        //已扫描的高度
+}
+
+//TxPerSecond 平均每秒提取的交易单数
+func (m ExtractMetrics) TxPerSecond() float64 {
+	if m.Elapsed <= 0 {
+		return 0
+	}
+	return float64(m.Transactions) / m.Elapsed.Seconds()
+}
+
+//Lag 已扫描高度落后节点的区块数
+func (m ExtractMetrics) Lag() uint64 {
+	if m.ChainHeight <= m.ScannedHeight {
+		return 0
+	}
+	return m.ChainHeight - m.ScannedHeight
+}
+
+//ExtractMetrics 获取区块提取的统计数据
+func (bs *BTCBlockScanner) ExtractMetrics() ExtractMetrics {
+	bs.metricsMu.Lock()
+	defer bs.metricsMu.Unlock()
+	return bs.metrics
+}
+
+//updateChainHeight 记录节点高度和已扫描高度，用于计算落后的区块数
+func (bs *BTCBlockScanner) updateChainHeight(chainHeight, scannedHeight uint64) {
+	bs.metricsMu.Lock()
+	defer bs.metricsMu.Unlock()
+	if chainHeight > 0 {
+		bs.metrics.ChainHeight = chainHeight
+	}
+	if scannedHeight > 0 {
+		bs.metrics.ScannedHeight = scannedHeight
+	}
+}
+
+func (bs *BTCBlockScanner) updateExtractMetrics(txs, failed int, elapsed time.Duration) {
+	bs.metricsMu.Lock()
+	defer bs.metricsMu.Unlock()
+	bs.metrics.Blocks++
+	bs.metrics.Transactions += uint64(txs)
+	bs.metrics.Failed += uint64(failed)
+	bs.metrics.Elapsed += elapsed
+	bs.metrics.LastBlockTxs = uint64(txs)
+	bs.metrics.LastBlockTime = elapsed
+}
+
+//extractJob 区块中一批连续的交易单，index为第一笔交易单在区块中的位置
+type extractJob struct {
+	index int
+	txids []string
+}
+
+//extractOutput 交易单的提取结果，index为交易单在区块中的位置
+type extractOutput struct {
+	index  int
+	result ExtractResult
+}
+
+//extractPipelineSize 流水线的线程数、队列深度和批量大小，每批不超过队列深度
+func (wm *WalletManager) extractPipelineSize() (int, int, int) {
+	workers, queueSize, batchSize := wm.Config.ExtractWorkers, wm.Config.ExtractQueueSize, wm.Config.ExtractBatchSize
+	if workers <= 0 {
+		workers = DefaultExtractWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultExtractQueueSize
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultExtractBatchSize
+	}
+	if batchSize > queueSize {
+		batchSize = queueSize
+	}
+	return workers, queueSize, batchSize
+}
+
+//BatchExtractTransaction 批量提取交易单
+//交易单按批分发给固定数量的提取线程，提取结果按交易单在区块中的顺序通知给观察者
+//已提取未通知的交易单达到队列深度时暂停分发，避免慢交易单导致结果堆积
+func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []string) error {
+
+	if len(txs) == 0 {
+		return errors.New("BatchExtractTransaction block is nil.")
+	}
+
+	startAt := time.Now()
+	workers, queueSize, batchSize := bs.wm.extractPipelineSize()
+
+	var (
+		jobs    = make(chan extractJob)
+		outputs = make(chan extractOutput, queueSize)
+		window  = make(chan struct{}, queueSize) //已分发未通知的交易单
+		wg      sync.WaitGroup
+	)
+
+	//分发
+	go func() {
+		defer close(jobs)
+		for start := 0; start < len(txs); start += batchSize {
+			end := start + batchSize
+			if end > len(txs) {
+				end = len(txs)
+			}
+			for i := start; i < end; i++ {
+				window <- struct{}{}
+			}
+			jobs <- extractJob{index: start, txids: txs[start:end]}
+		}
+	}()
+
+	//提取
+	if batches := (len(txs) + batchSize - 1) / batchSize; workers > batches {
+		workers = batches
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				bs.extractBatch(blockHeight, blockHash, job, outputs)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outputs)
+	}()
+
+	//按交易单顺序通知
+	var (
+		next    = 0
+		failed  = 0
+		waiting = make(map[int]ExtractResult)
+	)
+	for output := range outputs {
+		waiting[output.index] = output.result
+		for {
+			result, ok := waiting[next]
+			if !ok {
+				break
+			}
+			delete(waiting, next)
+			failed += bs.saveExtractResult(blockHeight, blockHash, result)
+			next++
+			<-window
+		}
+	}
+
+	bs.updateExtractMetrics(len(txs), failed, time.Since(startAt))
+
+	if failed > 0 {
+		return fmt.Errorf("block scanner saveWork failed")
+	}
+	return nil
+}
+
+//extractBatch 批量获取交易单后逐笔提取，批量获取失败时逐笔重新获取
+func (bs *BTCBlockScanner) extractBatch(blockHeight uint64, blockHash string, job extractJob, outputs chan<- extractOutput) {
+
+	trxs, err := bs.wm.GetTransactions(job.txids)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get transactions in batch; unexpected error: %v", err)
+	}
+
+	for i, txid := range job.txids {
+		index := job.index + i
+		//第一笔交易是coinbase，第二笔交易是pos收益
+		isCoinstake := index == 1
+
+		var result ExtractResult
+		if err != nil {
+			result = bs.ExtractTransaction(blockHeight, blockHash, txid, isCoinstake, bs.ScanTargetFuncV2)
+		} else {
+			result = bs.extractTransactionResult(trxs[i], blockHeight, blockHash, isCoinstake, bs.ScanTargetFuncV2)
+		}
+		outputs <- extractOutput{index: index, result: result}
+	}
+}
+
+//saveExtractResult 通知提取结果，返回失败的数量
+func (bs *BTCBlockScanner) saveExtractResult(height uint64, blockHash string, gets ExtractResult) int {
+
+	failed := 0
+
+	if !gets.Success {
+		//记录未扫区块
+		unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
+		bs.SaveUnscanRecord(unscanRecord)
+		return 1
+	}
+
+	notifyErr := bs.newExtractDataNotify(height, gets.extractData)
+	if notifyErr != nil {
+		failed++ //标记保存失败数
+		bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+	}
+
+	notifyErr = bs.newExtractDataNotify(height, gets.extractContractData)
+	if notifyErr != nil {
+		failed++ //标记保存失败数
+		bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+	}
+
+	notifyErr = bs.newSmartContractReceiptNotify(height, gets.extractContractReceipts)
+	if notifyErr != nil {
+		failed++ //标记保存失败数
+		bs.wm.Log.Std.Info("newSmartContractReceiptNotify unexpected error: %v", notifyErr)
+	}
+
+	//记录已通知的提取结果，区块重组时回滚
+	if height > 0 {
+		bs.journalExtractData(height, blockHash, gets.extractData)
+		bs.journalExtractData(height, blockHash, gets.extractContractData)
+		//跟踪确认数
+		bs.trackConfirmations(height, blockHash, gets.extractData, gets.immature)
+		bs.trackConfirmations(height, blockHash, gets.extractContractData, false)
+		//交易池中跟踪的交易单已确认或被替换
+		bs.confirmPendingTx(gets.TxID, gets.spent)
+	}
+
+	return failed
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//delayedChain 按交易单延迟返回的模拟链，记录交易单获取完成的顺序
+type delayedChain struct {
+	*SimChain
+	delays   map[string]time.Duration
+	mu       sync.Mutex
+	finished []string
+}
+
+func (c *delayedChain) GetTransaction(txid string) (*Transaction, error) {
+	time.Sleep(c.delays[txid])
+	trx, err := c.SimChain.GetTransaction(txid)
+	c.mu.Lock()
+	c.finished = append(c.finished, txid)
+	c.mu.Unlock()
+	return trx, err
+}
+
+func TestBatchExtractTransaction_InOrder(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	wm.Config.ExtractWorkers = 4
+	wm.Config.ExtractQueueSize = 4
+	wm.Config.ExtractBatchSize = 1
+	delayed := &delayedChain{SimChain: chain, delays: make(map[string]time.Duration)}
+	wm.Backend = delayed
+
+	w := newSimWallet(t, "pipeline")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, observer := newSimScanner(t, wm, chain, w)
+
+	//同一区块的充值，越靠前的交易单越慢
+	deposits := make([]string, 0)
+	for i := 0; i < 8; i++ {
+		txid, err := chain.Fund(addr.Address, decimal.New(int64(i+1), 0))
+		if err != nil {
+			t.Fatalf("Fund failed: %v", err)
+		}
+		delayed.delays[txid] = time.Duration(8-i) * 20 * time.Millisecond
+		deposits = append(deposits, txid)
+	}
+	chain.Mine(1)
+	bs.ScanBlockTask()
+
+	finished := make([]string, 0)
+	for _, txid := range delayed.finished {
+		if _, ok := delayed.delays[txid]; ok {
+			finished = append(finished, txid)
+		}
+	}
+	if reflect.DeepEqual(finished, deposits) {
+		t.Fatalf("transactions should be fetched out of order")
+	}
+	if !reflect.DeepEqual(observer.extracted, deposits) {
+		t.Fatalf("extracted: %v, want: %v", observer.extracted, deposits)
+	}
+	if metrics := bs.ExtractMetrics(); metrics.Transactions != 9 || metrics.Failed != 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
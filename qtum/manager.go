@@ -31,10 +31,8 @@ import (
 	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
 	"github.com/bndr/gotabulate"
-	"github.com/btcsuite/btcd/btcec"
-	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/codeskyblue/go-sh"
 	"github.com/shopspring/decimal"
@@ -68,6 +66,8 @@ type WalletManager struct {
 	storage         *hdkeystore.HDKeystore          //秘钥存取
 	WalletClient    *Client                         // 节点客户端
 	ExplorerClient  *Explorer                       // 浏览器API客户端
+	ElectrumClient  *Electrum                       //ElectrumX客户端
+	Backend         ChainBackend                    //链数据后端，为空时按RPCServerType选择
 	Config          *WalletConfig                   //钱包管理配置
 	walletsInSum    map[string]*openwallet.Wallet   //参与汇总的钱包
 	blockscanner    *BTCBlockScanner                //区块扫描器
@@ -681,7 +681,7 @@ func (wm *WalletManager) CreateNewPrivateKey(accountID string, key *owkeychain.E
 	//	return "", nil, err
 	//}
 
-	wif, err := wm.Decoder.PrivateKeyToWIF(keyBytes, wm.Config.isTestNet)
+	wif, err := wm.Decoder.PrivateKeyToWIF(keyBytes, wm.Config.Network.IsTestNet())
 
 	//address, err := childKey.Address(&cfg)
 	//if err != nil {
@@ -705,7 +705,7 @@ func (wm *WalletManager) CreateNewPrivateKey(accountID string, key *owkeychain.E
 
 	publicKey := childKey.GetPublicKeyBytes()
 
-	address, err := wm.Decoder.PublicKeyToAddress(publicKey, wm.Config.isTestNet)
+	address, err := wm.Decoder.PublicKeyToAddress(publicKey, wm.Config.Network.IsTestNet())
 	if err != nil {
 		return "", nil, err
 	}
@@ -982,11 +982,7 @@ func (wm *WalletManager) GetBlockChainInfo() (*BlockchainInfo, error) {
 //ListUnspent 获取未花记录
 func (wm *WalletManager) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.listUnspentByExplorer(addresses...)
-	} else {
-		return wm.getListUnspentByCore(min, addresses...)
-	}
+	return wm.chain().ListUnspent(min, addresses...)
 }
 
 //getTransactionByCore 获取交易单
@@ -1206,21 +1202,14 @@ func (wm *WalletManager) SignRawTransaction(txHex, walletID string, key *hdkeyst
 			return "", err
 		}
 
-		privateKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
-
-		cfg := chaincfg.MainNetParams
-		if wm.Config.isTestNet {
-			cfg = chaincfg.TestNet3Params
-		}
-
-		wif, err := btcutil.NewWIF(privateKey, &cfg, true)
+		wif, err := wm.Decoder.PrivateKeyToWIF(keyBytes, wm.Config.Network.IsTestNet())
 		if err != nil {
 			return "", err
 		}
 
-		fmt.Println(wif.String())
+		fmt.Println(wif)
 
-		wifs = append(wifs, wif.String())
+		wifs = append(wifs, wif)
 
 	}
 
@@ -1242,11 +1231,7 @@ func (wm *WalletManager) SignRawTransaction(txHex, walletID string, key *hdkeyst
 //SendRawTransaction 广播交易
 func (wm *WalletManager) SendRawTransaction(txHex string) (string, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.sendRawTransactionByExplorer(txHex)
-	} else {
-		return wm.sendRawTransactionByCore(txHex)
-	}
+	return wm.chain().SendRawTransaction(txHex)
 }
 
 //sendRawTransactionByCore 广播交易
@@ -1354,7 +1339,7 @@ func (wm *WalletManager) SendTransaction(walletID, to string, amount decimal.Dec
 		}
 
 		//计算手续费，找零地址有2个，一个是发送，一个是新创建的
-		fees, err := wm.EstimateFee(int64(len(usedUTXO)), 2, feesRate)
+		fees, err := wm.EstimateFee(usedUTXO, []string{to, changeAddr.Address}, feesRate)
 		if err != nil {
 			return nil, err
 		}
@@ -1421,7 +1406,7 @@ func (wm *WalletManager) SendTransaction(walletID, to string, amount decimal.Dec
 		}
 
 		//计算手续费，找零地址有2个，一个是发送，一个是新创建的
-		piecefees, err := wm.EstimateFee(int64(len(sendUxto)), 2, feesRate)
+		piecefees, err := wm.EstimateFee(sendUxto, []string{to, changeAddr.Address}, feesRate)
 
 		if piecefees.LessThan(decimal.NewFromFloat(0.00001)) {
 			piecefees = decimal.NewFromFloat(0.00001)
@@ -1580,7 +1565,7 @@ func (wm *WalletManager) SendBatchTransaction(walletID string, to []string, amou
 		}
 
 		//计算手续费，找零地址有2个，一个是发送，一个是新创建的
-		fees, err := wm.EstimateFee(int64(len(usedUTXO)), int64(len(to)+1), feesRate)
+		fees, err := wm.EstimateFee(usedUTXO, append(append([]string{}, to...), changeAddr.Address), feesRate)
 		if err != nil {
 			return "", err
 		}
@@ -1682,34 +1667,56 @@ func (wm *WalletManager) CreateChangeAddress(walletID string, key *hdkeystore.HD
 	return getAddrs[0], nil
 }
 
-//EstimateFee 预估手续费
-func (wm *WalletManager) EstimateFee(inputs, outputs int64, feeRate decimal.Decimal) (decimal.Decimal, error) {
+//EstimateFee 预估手续费，按输入的锁定脚本和输出地址估算签名后交易单的虚拟大小
+//没有锁定脚本的输入由地址推导，P2SH输入没有赎回脚本时按P2SH-P2WPKH估算
+func (wm *WalletManager) EstimateFee(inputs []*Unspent, outputs []string, feeRate decimal.Decimal) (decimal.Decimal, error) {
 
-	var piece int64 = 1
+	addressPrefix := wm.Config.Network.AddressPrefix()
 
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
-	if inputs > int64(wm.Config.maxTxInputs) {
-		piece = int64(math.Ceil(float64(inputs) / float64(wm.Config.maxTxInputs)))
+	unlockData := make([]btcLikeTxDriver.TxUnlock, 0, len(inputs))
+	for _, u := range inputs {
+		lockScript := u.ScriptPubKey
+		if len(lockScript) == 0 {
+			var err error
+			lockScript, err = btcLikeTxDriver.GetAddressLockScript(u.Address, addressPrefix)
+			if err != nil {
+				return decimal.Zero, err
+			}
+		}
+		unlockData = append(unlockData, btcLikeTxDriver.TxUnlock{LockScript: lockScript})
 	}
 
-	//计算公式如下：148 * 输入数额 + 34 * 输出数额 + 10
-	trx_bytes := decimal.New(inputs*148+outputs*34+piece*10, 0)
-	trx_fee := trx_bytes.Div(decimal.New(1000, 0)).Mul(feeRate)
+	outputScripts := make([]string, 0, len(outputs))
+	for _, address := range outputs {
+		script, err := btcLikeTxDriver.GetAddressLockScript(address, addressPrefix)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		outputScripts = append(outputScripts, script)
+	}
+
+	vsize, err := btcLikeTxDriver.EstimateVSize(unlockData, outputScripts)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return wm.EstimateFeeByVSize(vsize, feeRate), nil
+}
+
+//EstimateFeeByVSize 按交易单的虚拟大小计算手续费，费率为每KB
+func (wm *WalletManager) EstimateFeeByVSize(vsize uint64, feeRate decimal.Decimal) decimal.Decimal {
+	trx_fee := decimal.New(int64(vsize), 0).Mul(feeRate).Div(decimal.New(1000, 0))
 	trx_fee = trx_fee.Round(wm.Decimal())
 	if trx_fee.LessThan(wm.Config.MinFees) {
 		trx_fee = wm.Config.MinFees
 	}
-	return trx_fee, nil
+	return trx_fee
 }
 
 //EstimateFeeRate 预估的没KB手续费率
 func (wm *WalletManager) EstimateFeeRate() (decimal.Decimal, error) {
 
-	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.estimateFeeRateByExplorer()
-	} else {
-		return wm.estimateFeeRateByCore()
-	}
+	return wm.chain().EstimateFeeRate()
 }
 
 //estimateFeeRateByCore 预估的没KB手续费率
@@ -2021,7 +2028,7 @@ func (wm *WalletManager) GenQtumAddress() (string, error) {
 
 	fmt.Printf("pubkeyHash: %s\n", hex.EncodeToString(pubkeyHash))
 
-	address := addressEncoder.AddressEncode(pubkeyHash, addressEncoder.QTUM_mainnetAddressP2PKH)
+	address := addressEncoder.AddressEncode(pubkeyHash, wm.Config.Network.P2PKHAddressType())
 
 	fmt.Printf("address: %s\n", address)
 
@@ -426,10 +426,28 @@ func TestBuildTransaction(t *testing.T) {
 	//t.Logf("BuildTransaction signHex = %s\n", hex)
 }
 
+//testFeeInputs P2PKH输入
+func testFeeInputs(n int) []*Unspent {
+	inputs := make([]*Unspent, 0, n)
+	for i := 0; i < n; i++ {
+		inputs = append(inputs, &Unspent{ScriptPubKey: dummyChangeScript})
+	}
+	return inputs
+}
+
+//testFeeOutputs P2PKH输出地址
+func testFeeOutputs(n int) []string {
+	outputs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		outputs = append(outputs, HashAddressToBaseAddress("0000000000000000000000000000000000000000", tw.Config.Network))
+	}
+	return outputs
+}
+
 func TestEstimateFee(t *testing.T) {
 	feeRate, _ := tw.EstimateFeeRate()
 	t.Logf("EstimateFee feeRate = %s\n", feeRate.StringFixed(8))
-	fees, _ := tw.EstimateFee(10, 2, feeRate)
+	fees, _ := tw.EstimateFee(testFeeInputs(10), testFeeOutputs(2), feeRate)
 	t.Logf("EstimateFee fees = %s\n", fees.StringFixed(8))
 }
 
@@ -0,0 +1,422 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	//socketIO推送的交易单处理标记的有效期
+	memPoolSeenExpiry = time.Hour
+)
+
+const (
+	//交易单从交易池移除的原因
+	PendingTxEvicted  = "evicted"  //被交易池驱逐或过期，没有上链
+	PendingTxReplaced = "replaced" //输入被其他交易单花费，如RBF
+)
+
+//PendingTxEvent 已通知的未确认交易单被移除
+type PendingTxEvent struct {
+	TxID       string
+	Reason     string //evicted或replaced
+	ReplacedBy string //替换的交易单，Reason为replaced时有值
+	SourceKey  string
+	Data       *openwallet.TxExtractData
+}
+
+//MemPoolNotificationObject 观察者可选实现的接口，接收交易池中已通知交易单的移除通知
+type MemPoolNotificationObject interface {
+	//PendingTxRemovedNotify 未确认交易单被驱逐或替换，不会再上链
+	PendingTxRemovedNotify(event *PendingTxEvent) error
+}
+
+//PendingTx 跟踪中的未确认交易单
+type PendingTx struct {
+	TxID      string
+	FirstSeen int64
+	Spent     []string         //花费的输出，txid:vout
+	Records   []*JournalRecord //已通知的提取结果
+	Missing   bool             //已离开交易池，等待下一轮确认是否上链
+}
+
+//MemPoolCache 交易池缓存，已见过的交易单只提取和通知一次
+//有提取结果的交易单会被跟踪，直到确认、被替换或被驱逐
+type MemPoolCache struct {
+	mu      sync.Mutex
+	seen    map[string]int64      //交易池中已处理的交易单 -> 处理时间
+	pending map[string]*PendingTx //跟踪中的交易单
+	spent   map[string]string     //跟踪中的交易单花费的输出 -> txid
+}
+
+//NewMemPoolCache 创建交易池缓存
+func NewMemPoolCache() *MemPoolCache {
+	return &MemPoolCache{
+		seen:    make(map[string]int64),
+		pending: make(map[string]*PendingTx),
+		spent:   make(map[string]string),
+	}
+}
+
+//outPoint 输出的唯一标识
+func outPoint(txid string, vout uint64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+//Diff 对比交易池的交易单，返回新增的交易单和已离开交易池的交易单
+func (c *MemPoolCache) Diff(txids []string) ([]string, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := make(map[string]bool, len(txids))
+	added := make([]string, 0)
+	for _, txid := range txids {
+		current[txid] = true
+		if _, ok := c.seen[txid]; !ok {
+			added = append(added, txid)
+		}
+	}
+
+	removed := make([]string, 0)
+	for txid := range c.seen {
+		if !current[txid] {
+			removed = append(removed, txid)
+			delete(c.seen, txid)
+		}
+	}
+	return added, removed
+}
+
+//Seen 标记交易单已处理，返回false表示之前已处理过
+func (c *MemPoolCache) Seen(txid string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.seen[txid]; ok {
+		return false
+	}
+	c.seen[txid] = time.Now().Unix()
+	return true
+}
+
+//ExpireSeen 清理超过有效期且没有跟踪的处理标记，socketIO推送的交易单不会出现在Diff中
+func (c *MemPoolCache) ExpireSeen(expiry time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := time.Now().Add(-expiry).Unix()
+	for txid, seenAt := range c.seen {
+		if _, ok := c.pending[txid]; !ok && seenAt < deadline {
+			delete(c.seen, txid)
+		}
+	}
+}
+
+//Forget 提取失败时移除标记，下次重新提取
+func (c *MemPoolCache) Forget(txid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, txid)
+}
+
+//Track 跟踪有提取结果的交易单
+func (c *MemPoolCache) Track(tx *PendingTx) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[tx.TxID] = tx
+	for _, op := range tx.Spent {
+		c.spent[op] = tx.TxID
+	}
+}
+
+//Pending 跟踪中的交易单
+func (c *MemPoolCache) Pending() []*PendingTx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]*PendingTx, 0, len(c.pending))
+	for _, tx := range c.pending {
+		list = append(list, tx)
+	}
+	return list
+}
+
+//Conflicts 花费了相同输出的其他跟踪中交易单
+func (c *MemPoolCache) Conflicts(txid string, spent []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conflicts := make([]string, 0)
+	exists := make(map[string]bool)
+	for _, op := range spent {
+		other, ok := c.spent[op]
+		if !ok || other == txid || exists[other] {
+			continue
+		}
+		exists[other] = true
+		conflicts = append(conflicts, other)
+	}
+	return conflicts
+}
+
+//SetMissing 标记交易单是否已离开交易池，返回之前的状态
+func (c *MemPoolCache) SetMissing(txid string, missing bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, ok := c.pending[txid]
+	if !ok {
+		return false
+	}
+	prev := tx.Missing
+	tx.Missing = missing
+	return prev
+}
+
+//Missing 上一轮已离开交易池，仍在跟踪的交易单
+func (c *MemPoolCache) Missing() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	txids := make([]string, 0)
+	for txid, tx := range c.pending {
+		if tx.Missing {
+			txids = append(txids, txid)
+		}
+	}
+	return txids
+}
+
+//Remove 停止跟踪交易单，返回跟踪的记录
+func (c *MemPoolCache) Remove(txid string) *PendingTx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, ok := c.pending[txid]
+	if !ok {
+		return nil
+	}
+	delete(c.pending, txid)
+	for _, op := range tx.Spent {
+		if c.spent[op] == txid {
+			delete(c.spent, op)
+		}
+	}
+	return tx
+}
+
+//memPool 交易池缓存
+func (bs *BTCBlockScanner) memPool() *MemPoolCache {
+	bs.memPoolOnce.Do(func() {
+		bs.memPoolCache = NewMemPoolCache()
+	})
+	return bs.memPoolCache
+}
+
+//extractPendingTx 提取并通知新的未确认交易单，每个交易单只通知一次
+func (bs *BTCBlockScanner) extractPendingTx(txid string) {
+
+	if !bs.memPool().Seen(txid) {
+		return
+	}
+
+	bs.trackPendingTx(bs.ExtractTransaction(0, "", txid, false, bs.ScanTargetFuncV2))
+}
+
+//extractPendingRawTx 提取zmq推送的交易单，已在本地解码，不需要再查询节点
+func (bs *BTCBlockScanner) extractPendingRawTx(trx *Transaction) {
+
+	if !bs.memPool().Seen(trx.TxID) {
+		return
+	}
+
+	bs.trackPendingTx(bs.extractTransactionResult(trx, 0, "", false, bs.ScanTargetFuncV2))
+}
+
+//trackPendingTx 通知未确认交易单的提取结果，并跟踪有提取结果的交易单
+func (bs *BTCBlockScanner) trackPendingTx(result ExtractResult) {
+
+	txid := result.TxID
+	if !result.Success {
+		bs.memPool().Forget(txid)
+		return
+	}
+
+	//新交易单花费了跟踪中交易单的输入，原交易单已被替换
+	for _, replaced := range bs.memPool().Conflicts(txid, result.spent) {
+		bs.removePendingTx(replaced, PendingTxReplaced, txid)
+	}
+
+	records := make([]*JournalRecord, 0)
+	for _, extractData := range []map[string]*openwallet.TxExtractData{result.extractData, result.extractContractData} {
+		if err := bs.newExtractDataNotify(0, extractData); err != nil {
+			bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", err)
+		}
+		for sourceKey, data := range extractData {
+			records = append(records, &JournalRecord{SourceKey: sourceKey, Data: data})
+		}
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	bs.memPool().Track(&PendingTx{
+		TxID:      txid,
+		FirstSeen: time.Now().Unix(),
+		Spent:     result.spent,
+		Records:   records,
+	})
+}
+
+//confirmPendingTx 区块中的交易单已确认，花费相同输出的其他跟踪中交易单已被替换
+func (bs *BTCBlockScanner) confirmPendingTx(txid string, spent []string) {
+	for _, replaced := range bs.memPool().Conflicts(txid, spent) {
+		bs.removePendingTx(replaced, PendingTxReplaced, txid)
+	}
+	bs.memPool().Remove(txid)
+}
+
+//resolveMissingTx 离开交易池的交易单在扫块后仍未确认，已上链时停止跟踪
+//节点明确返回交易单不存在时通知被驱逐，查询失败或交易单仍存在时保持离开状态，下一轮再确认
+func (bs *BTCBlockScanner) resolveMissingTx(txid string) {
+
+	trx, err := bs.wm.GetTransaction(txid)
+	if err == nil {
+		if len(trx.BlockHash) > 0 || trx.BlockHeight > 0 {
+			bs.memPool().Remove(txid)
+		}
+		return
+	}
+
+	if !isTxNotFound(err) {
+		bs.wm.Log.Std.Info("block scanner can not resolve missing transaction: %s; unexpected error: %v", txid, err)
+		return
+	}
+
+	bs.removePendingTx(txid, PendingTxEvicted, "")
+}
+
+//isTxNotFound 节点明确返回交易单不存在，连接失败等其他错误不能确定交易单已被驱逐
+func isTxNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*TxNotFoundError); ok {
+		return true
+	}
+	//核心钱包返回[-5]，ElectrumX转发节点的错误信息
+	//浏览器的404和[-32601]Method not found等不能说明交易单不存在
+	msg := err.Error()
+	return strings.HasPrefix(msg, "[-5]") ||
+		strings.Contains(msg, "No such mempool or blockchain transaction")
+}
+
+//removePendingTx 停止跟踪交易单，通知观察者
+func (bs *BTCBlockScanner) removePendingTx(txid, reason, replacedBy string) {
+
+	tx := bs.memPool().Remove(txid)
+	if tx == nil {
+		return
+	}
+
+	bs.wm.Log.Std.Info("pending transaction: %s has been %s.", txid, reason)
+
+	for o, _ := range bs.Observers {
+		removed, ok := o.(MemPoolNotificationObject)
+		if !ok {
+			continue
+		}
+		for _, r := range tx.Records {
+			err := removed.PendingTxRemovedNotify(&PendingTxEvent{
+				TxID:       txid,
+				Reason:     reason,
+				ReplacedBy: replacedBy,
+				SourceKey:  r.SourceKey,
+				Data:       r.Data,
+			})
+			if err != nil {
+				bs.wm.Log.Std.Error("PendingTxRemovedNotify unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+//scanMemPoolByCore 对比getrawmempool的结果，提取新的交易单，离开交易池的交易单在下一轮确认是否被驱逐
+//区块在交易池之前扫描，已上链的交易单已由扫块停止跟踪
+func (bs *BTCBlockScanner) scanMemPoolByCore() {
+
+	txIDsInMemPool, err := bs.wm.GetTxIDsInMemPool()
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get mempool data; unexpected error: %v", err)
+		return
+	}
+
+	for _, txid := range bs.memPool().Missing() {
+		bs.resolveMissingTx(txid)
+	}
+
+	added, removed := bs.memPool().Diff(txIDsInMemPool)
+	for _, txid := range added {
+		//离开后又回到交易池的跟踪中交易单，不重复通知
+		if bs.memPool().SetMissing(txid, false) {
+			bs.memPool().Seen(txid)
+			continue
+		}
+		bs.extractPendingTx(txid)
+	}
+	for _, txid := range removed {
+		bs.memPool().SetMissing(txid, true)
+	}
+}
+
+//scanMemPoolByPending 后端不能返回完整的交易池，提取后端返回的部分交易单，跟踪中的交易单逐个查询
+//浏览器的新交易单由socketIO推送，ElectrumX返回已订阅地址的交易单
+func (bs *BTCBlockScanner) scanMemPoolByPending() {
+
+	txIDsInMemPool, err := bs.wm.GetTxIDsInMemPool()
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get mempool data; unexpected error: %v", err)
+	}
+
+	for _, txid := range txIDsInMemPool {
+		bs.extractPendingTx(txid)
+	}
+
+	bs.checkPendingTxs()
+}
+
+//checkPendingTxs 逐个查询跟踪中的交易单
+//连续两轮查询不到时视为被驱逐
+func (bs *BTCBlockScanner) checkPendingTxs() {
+	bs.memPool().ExpireSeen(memPoolSeenExpiry)
+	for _, tx := range bs.memPool().Pending() {
+		trx, err := bs.wm.GetTransaction(tx.TxID)
+		if err == nil && len(trx.TxID) > 0 {
+			if len(trx.BlockHash) > 0 || trx.BlockHeight > 0 {
+				bs.memPool().Remove(tx.TxID)
+			} else {
+				bs.memPool().SetMissing(tx.TxID, false)
+			}
+			continue
+		}
+		//查询失败不计入
+		if err != nil && !isTxNotFound(err) {
+			continue
+		}
+		if bs.memPool().SetMissing(tx.TxID, true) {
+			bs.removePendingTx(tx.TxID, PendingTxEvicted, "")
+		}
+	}
+}
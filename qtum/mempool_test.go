@@ -0,0 +1,174 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//flakyChain 查询交易单返回连接错误的模拟链
+type flakyChain struct {
+	*SimChain
+	err error
+}
+
+func (c *flakyChain) GetTransaction(txid string) (*Transaction, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.SimChain.GetTransaction(txid)
+}
+
+func TestResolveMissingTx(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	flaky := &flakyChain{SimChain: chain}
+	wm.Backend = flaky
+	bs := NewBTCBlockScanner(wm)
+
+	isPending := func(txid string) bool {
+		for _, tx := range bs.memPool().Pending() {
+			if tx.TxID == txid {
+				return true
+			}
+		}
+		return false
+	}
+
+	fundTxID, err := chain.Fund(chain.faucet, decimal.New(1, 0))
+	if err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	evictedTxID := "00000000000000000000000000000000000000000000000000000000000000aa"
+	for _, txid := range []string{fundTxID, evictedTxID} {
+		bs.memPool().Track(&PendingTx{TxID: txid})
+		bs.memPool().SetMissing(txid, true)
+	}
+
+	//查询失败或后端不支持查询时不能确定是否被驱逐
+	for _, flaky.err = range []error{errors.New("connection refused"), errors.New("[-32601]Method not found")} {
+		for _, txid := range []string{fundTxID, evictedTxID} {
+			bs.resolveMissingTx(txid)
+			if !isPending(txid) {
+				t.Fatalf("transaction: %s should keep pending after a failed query: %v", txid, flaky.err)
+			}
+		}
+	}
+
+	//交易单仍未确认，下一轮再确认
+	flaky.err = nil
+	bs.resolveMissingTx(fundTxID)
+	if !isPending(fundTxID) || len(bs.memPool().Missing()) != 2 {
+		t.Fatalf("unconfirmed transaction: %s should keep missing", fundTxID)
+	}
+
+	chain.Mine(1)
+	bs.resolveMissingTx(fundTxID)
+	if isPending(fundTxID) {
+		t.Fatalf("confirmed transaction: %s should be removed", fundTxID)
+	}
+
+	bs.resolveMissingTx(evictedTxID)
+	if isPending(evictedTxID) {
+		t.Fatalf("not found transaction: %s should be evicted", evictedTxID)
+	}
+}
+
+func TestIsTxNotFound(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("[-5]No such mempool or blockchain transaction. Use gettransaction for wallet transactions."), true},
+		{errors.New("electrum error 2: daemon error: {'code': -5, 'message': 'No such mempool or blockchain transaction'}"), true},
+		{&TxNotFoundError{TxID: "aa"}, true},
+		{errors.New("[-32601]Method not found"), false},
+		{errors.New("Not Found"), false},
+		{errors.New("[-28]Loading block index..."), false},
+		{errors.New("connection refused"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := isTxNotFound(test.err); got != test.want {
+			t.Errorf("isTxNotFound(%v) = %v, want: %v", test.err, got, test.want)
+		}
+	}
+}
+
+//partialMemPoolChain 只能返回部分交易池的模拟链，如ElectrumX
+type partialMemPoolChain struct {
+	*SimChain
+}
+
+func (c *partialMemPoolChain) Supports(capability ChainCapability) bool {
+	return capability != CapabilityMemPoolList
+}
+
+func TestScanTxMemPool_PartialMemPool(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	wm.Backend = &partialMemPoolChain{SimChain: chain}
+	bs := NewBTCBlockScanner(wm)
+	bs.SetBlockScanTargetFuncV2(func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		return openwallet.ScanTargetResult{}
+	})
+
+	fundTxID, err := chain.Fund(chain.faucet, decimal.New(1, 0))
+	if err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	evictedTxID := "00000000000000000000000000000000000000000000000000000000000000aa"
+	for _, txid := range []string{fundTxID, evictedTxID} {
+		bs.memPool().Track(&PendingTx{TxID: txid})
+	}
+
+	//连续两轮查询不到时视为被驱逐
+	for round := 1; round <= 2; round++ {
+		bs.ScanTxMemPool()
+		if pending := len(bs.memPool().Pending()); pending != 3-round {
+			t.Fatalf("round %d pending transactions: %d, want: %d", round, pending, 3-round)
+		}
+	}
+
+	chain.Mine(1)
+	bs.ScanTxMemPool()
+	if pending := len(bs.memPool().Pending()); pending != 0 {
+		t.Fatalf("confirmed transaction should not be pending")
+	}
+}
+
+func TestChainCapabilities(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.RPCServerType = RPCServerExplorer
+	if wm.supports(CapabilityMemPoolList) || wm.supports(CapabilityCallContract) {
+		t.Fatalf("explorer backend should not support mempool list and contract call")
+	}
+
+	wm.Config.RPCServerType = RPCServerElectrum
+	if wm.supports(CapabilityMemPoolList) || !wm.supports(CapabilityCallContract) {
+		t.Fatalf("electrum backend should only support contract call")
+	}
+
+	wm.Config.RPCServerType = RPCServerCore
+	if !wm.supports(CapabilityMemPoolList) || !wm.supports(CapabilityCallContract) {
+		t.Fatalf("core backend should support all capabilities")
+	}
+}
@@ -16,7 +16,15 @@
 package qtum
 
 import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 )
 
@@ -283,8 +291,9 @@ type QRC20Unspent struct {
 
 	Key     string `storm:"id"`
 	Address string `json:"address"`
-	GasUsed string `json:"gasUsed"`
-	Output  string `json:"output"`
+	GasUsed  string `json:"gasUsed"`
+	Excepted string `json:"excepted"`
+	Output   string `json:"output"`
 	//HDAddress     openwallet.Address
 
 }
@@ -294,29 +303,37 @@ func NewQRC20Unspent(json *gjson.Result) *QRC20Unspent {
 	//解析json
 	obj.Address = gjson.Get(json.Raw, "address").String()
 	obj.GasUsed = gjson.Get(json.Raw, "executionResult.gasUsed").String()
+	obj.Excepted = gjson.Get(json.Raw, "executionResult.excepted").String()
 	obj.Output = gjson.Get(json.Raw, "executionResult.output").String()
 
 	return obj
 }
 
-type Transaction struct {
-	TxID            string
-	Size            uint64
-	Version         uint64
-	LockTime        int64
-	Hex             string
-	BlockHash       string
-	BlockHeight     uint64
-	Confirmations   uint64
-	Blocktime       int64
-	IsCoinBase      bool
-	IsCoinstake     bool
-	Fees            string
-	Isqrc20Transfer bool
+//IsExcepted 合约执行是否异常
+func (obj *QRC20Unspent) IsExcepted() bool {
+	return len(obj.Excepted) > 0 && obj.Excepted != "None"
+}
 
-	Vins          []*Vin
-	Vouts         []*Vout
-	TokenReceipts []*TokenReceipt
+type Transaction struct {
+	TxID             string
+	Size             uint64
+	Version          uint64
+	LockTime         int64
+	Hex              string
+	BlockHash        string
+	BlockHeight      uint64
+	Confirmations    uint64
+	Blocktime        int64
+	IsCoinBase       bool
+	IsCoinstake      bool
+	Fees             string
+	Isqrc20Transfer  bool
+	Isqrc721Transfer bool
+
+	Vins             []*Vin
+	Vouts            []*Vout
+	TokenReceipts    []*TokenReceipt
+	ContractReceipts []*ContractReceipt
 }
 
 type Vin struct {
@@ -334,6 +351,7 @@ type Vout struct {
 	Value        string
 	ScriptPubKey string
 	Type         string
+	IsRefund     bool //矿工退回合约调用未使用的gas
 }
 
 type TokenReceipt struct {
@@ -347,9 +365,75 @@ type TokenReceipt struct {
 	ContractAddress string
 	Excepted        string
 	Amount          string
+	Protocol        string //代币协议，qrc20或qrc721
+	TokenID         string //QRC721的tokenId，十进制
+	Fees            string //合约调用实际消耗的gas成本
+}
+
+//IsExcepted 合约执行是否异常，异常时代币转账失败
+func (obj *TokenReceipt) IsExcepted() bool {
+	return len(obj.Excepted) > 0 && obj.Excepted != "None"
+}
+
+//ContractReceipt 合约输出的执行回执
+type ContractReceipt struct {
+	TxHash          string
+	BlockHash       string
+	BlockHeight     uint64
+	OutputIndex     uint64
+	Sender          string
+	ContractAddress string
+	GasUsed         uint64
+	Excepted        string
+	Fees            string          //合约调用实际消耗的gas成本
+	Logs            []*ContractLog  //回执的原始日志
+	RawReceipt      string          //原始回执，json
+	TokenReceipts   []*TokenReceipt //回执日志中的代币转账
 }
 
-func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
+//ContractLog 合约执行产生的日志
+type ContractLog struct {
+	Address string   //产生日志的合约地址，0x开头的十六进制
+	Topics  []string //十六进制
+	Data    string   //十六进制
+}
+
+//newContractLog 解析回执日志，合约地址为十六进制的hash160
+func newContractLog(address string, json *gjson.Result) *ContractLog {
+	obj := &ContractLog{
+		Address: "0x" + strings.TrimPrefix(address, "0x"),
+		Topics:  make([]string, 0),
+		Data:    strings.TrimPrefix(json.Get("data").String(), "0x"),
+	}
+	for _, topic := range json.Get("topics").Array() {
+		obj.Topics = append(obj.Topics, strings.TrimPrefix(topic.String(), "0x"))
+	}
+	return obj
+}
+
+//decode 日志的topics和data解码为字节
+func (obj *ContractLog) decode() ([][]byte, []byte, error) {
+	topics := make([][]byte, 0, len(obj.Topics))
+	for _, topic := range obj.Topics {
+		b, err := hex.DecodeString(topic)
+		if err != nil {
+			return nil, nil, err
+		}
+		topics = append(topics, b)
+	}
+	data, err := hex.DecodeString(obj.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return topics, data, nil
+}
+
+//IsExcepted 合约执行是否异常，如revert、out of gas
+func (obj *ContractReceipt) IsExcepted() bool {
+	return len(obj.Excepted) > 0 && obj.Excepted != "None"
+}
+
+func newTxByCore(json *gjson.Result, params *ChainParams) *Transaction {
 
 	/*
 		{
@@ -378,6 +462,7 @@ func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
 	obj.Confirmations = gjson.Get(json.Raw, "confirmations").Uint()
 	obj.Blocktime = gjson.Get(json.Raw, "blocktime").Int()
 	obj.Size = gjson.Get(json.Raw, "size").Uint()
+	obj.Hex = gjson.Get(json.Raw, "hex").String()
 	//obj.Fees = gjson.Get(json.Raw, "fees").String()
 
 	obj.Vins = make([]*Vin, 0)
@@ -392,7 +477,7 @@ func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
 	obj.Vouts = make([]*Vout, 0)
 	if vouts := gjson.Get(json.Raw, "vout"); vouts.IsArray() {
 		for _, vout := range vouts.Array() {
-			output := newTxVoutByCore(&vout, isTestnet)
+			output := newTxVoutByCore(&vout, params)
 			obj.Vouts = append(obj.Vouts, output)
 		}
 	}
@@ -400,6 +485,291 @@ func newTxByCore(json *gjson.Result, isTestnet bool) *Transaction {
 	return &obj
 }
 
+//HasContractCall 交易单是否包含合约调用(OP_CALL)或部署(OP_CREATE)的输出
+func (tx *Transaction) HasContractCall() bool {
+	for _, vout := range tx.Vouts {
+		if strings.HasPrefix(vout.Type, "call") || strings.HasPrefix(vout.Type, "create") {
+			return true
+		}
+	}
+	return false
+}
+
+//mayContainGasRefunds gas退款只会出现在PoW区块的coinbase交易或PoS区块的coinstake交易中
+//coinstake交易的第一个输出为空
+func (tx *Transaction) mayContainGasRefunds(isCoinstake bool) bool {
+	if len(tx.Vouts) < 2 {
+		return false
+	}
+	if tx.IsCoinstake || (isCoinstake && len(tx.Vouts[0].ScriptPubKey) == 0) {
+		return true
+	}
+	if tx.IsCoinBase || (len(tx.Vins) > 0 && len(tx.Vins[0].Coinbase) > 0) {
+		//PoS区块的coinbase交易没有奖励
+		for _, vout := range tx.Vouts {
+			if value, _ := decimal.NewFromString(vout.Value); value.GreaterThan(decimal.Zero) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//isStakeReward 交易单是coinbase或coinstake，奖励需要StakeConfirmations个确认才能花费
+//核心钱包的交易单没有标记，coinbase的输入没有前置交易，coinstake是区块的第二个交易且第一个输出为空
+func (tx *Transaction) isStakeReward(isCoinstake bool) bool {
+	if tx.IsCoinBase || tx.IsCoinstake {
+		return true
+	}
+	if len(tx.Vins) > 0 && len(tx.Vins[0].Coinbase) > 0 {
+		return true
+	}
+	return isCoinstake && len(tx.Vouts) > 0 && len(tx.Vouts[0].ScriptPubKey) == 0
+}
+
+//newContractReceiptsByCore 解析gettransactionreceipt的结果，每个合约输出一个回执，提取其中代币的Transfer事件
+func newContractReceiptsByCore(json *gjson.Result, params *ChainParams) []*ContractReceipt {
+
+	/*
+		[{
+			"blockHash": "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b",
+			"blockNumber": 521424,
+			"transactionHash": "9e2d3c6e5d6f3c1f0f5b4a3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b",
+			"transactionIndex": 2,
+			"outputIndex": 0,
+			"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+			"to": "f2033ede578e17fa6231047265010445bca8cf1c",
+			"cumulativeGasUsed": 36236,
+			"gasUsed": 36236,
+			"contractAddress": "f2033ede578e17fa6231047265010445bca8cf1c",
+			"excepted": "None",
+			"log": [{
+				"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+				"topics": [
+					"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+					"000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1",
+					"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"
+				],
+				"data": "0000000000000000000000000000000000000000000000000de0b6b3a7640000"
+			}]
+		}]
+	*/
+
+	receipts := make([]*ContractReceipt, 0)
+
+	for _, receipt := range json.Array() {
+
+		obj := &ContractReceipt{
+			TxHash:          receipt.Get("transactionHash").String(),
+			BlockHash:       receipt.Get("blockHash").String(),
+			BlockHeight:     receipt.Get("blockNumber").Uint(),
+			OutputIndex:     receipt.Get("outputIndex").Uint(),
+			Sender:          HashAddressToBaseAddress(receipt.Get("from").String(), params),
+			ContractAddress: "0x" + receipt.Get("contractAddress").String(),
+			GasUsed:         receipt.Get("gasUsed").Uint(),
+			Excepted:        receipt.Get("excepted").String(),
+			Logs:            make([]*ContractLog, 0),
+			RawReceipt:      receipt.Raw,
+			TokenReceipts:   make([]*TokenReceipt, 0),
+		}
+
+		for _, logInfo := range receipt.Get("log").Array() {
+			obj.Logs = append(obj.Logs, newContractLog(logInfo.Get("address").String(), &logInfo))
+
+			token := newTokenReceiptByLog(&logInfo, params)
+			if token == nil {
+				continue
+			}
+			token.TxHash = obj.TxHash
+			token.BlockHash = obj.BlockHash
+			token.BlockHeight = obj.BlockHeight
+			token.Sender = obj.Sender
+			token.GasUsed = obj.GasUsed
+			token.Excepted = obj.Excepted
+			obj.TokenReceipts = append(obj.TokenReceipts, token)
+		}
+
+		receipts = append(receipts, obj)
+	}
+
+	return receipts
+}
+
+//newTokenReceiptByLog 解码回执日志中的Transfer事件，不是Transfer事件时返回空
+func newTokenReceiptByLog(logInfo *gjson.Result, params *ChainParams) *TokenReceipt {
+
+	topics := logInfo.Get("topics").Array()
+	if len(topics) < 3 || "0x"+topics[0].String() != QTUM_TRANSFER_EVENT_ID {
+		return nil
+	}
+
+	topicBytes := make([][]byte, 0, len(topics))
+	for _, topic := range topics {
+		b, err := hex.DecodeString(topic.String())
+		if err != nil {
+			return nil
+		}
+		topicBytes = append(topicBytes, b)
+	}
+	data, err := hex.DecodeString(logInfo.Get("data").String())
+	if err != nil {
+		return nil
+	}
+
+	transfer, err := abi.DecodeTransferLog(topicBytes, data)
+	if err != nil {
+		return nil
+	}
+
+	obj := &TokenReceipt{
+		From:            HashAddressToBaseAddress(transfer.From.Hex(), params),
+		To:              HashAddressToBaseAddress(transfer.To.Hex(), params),
+		ContractAddress: "0x" + logInfo.Get("address").String(),
+	}
+
+	//QRC721的tokenId为indexed参数，数量固定为1
+	if transfer.IsQRC721 {
+		obj.Protocol = QRC721Protocol
+		obj.TokenID = transfer.Value.String()
+		obj.Amount = "1"
+	} else {
+		obj.Protocol = QRC20Protocol
+		obj.Amount = transfer.Value.String()
+	}
+
+	return obj
+}
+
+//newFailedTokenReceipt 合约执行失败时没有日志，按调用数据还原代币转账，用于报告失败状态
+//支持transfer、transferFrom和QRC721的safeTransferFrom，其他调用返回空
+func newFailedTokenReceipt(receipt *ContractReceipt, script *btcLikeTxDriver.ContractScript, params *ChainParams) *TokenReceipt {
+
+	if script.Create || len(script.CallData) < 4 {
+		return nil
+	}
+
+	obj := &TokenReceipt{
+		TxHash:          receipt.TxHash,
+		BlockHash:       receipt.BlockHash,
+		BlockHeight:     receipt.BlockHeight,
+		Sender:          receipt.Sender,
+		GasUsed:         receipt.GasUsed,
+		ContractAddress: "0x" + script.ContractAddr,
+		Excepted:        receipt.Excepted,
+		Protocol:        QRC20Protocol,
+	}
+
+	var (
+		selector = hex.EncodeToString(script.CallData[:4])
+		from     abi.Address
+		to       abi.Address
+		value    *big.Int
+	)
+	switch selector {
+	case hex.EncodeToString(abi.QRC20Transfer.ID()):
+		args, err := abi.QRC20Transfer.UnpackInput(script.CallData)
+		if err != nil {
+			return nil
+		}
+		to, value = args[0].(abi.Address), args[1].(*big.Int)
+		obj.From = receipt.Sender
+	case hex.EncodeToString(abi.QRC20TransferFrom.ID()):
+		args, err := abi.QRC20TransferFrom.UnpackInput(script.CallData)
+		if err != nil {
+			return nil
+		}
+		from, to, value = args[0].(abi.Address), args[1].(abi.Address), args[2].(*big.Int)
+	case hex.EncodeToString(abi.QRC721SafeTransferFrom.ID()):
+		args, err := abi.QRC721SafeTransferFrom.UnpackInput(script.CallData)
+		if err != nil {
+			return nil
+		}
+		from, to, value = args[0].(abi.Address), args[1].(abi.Address), args[2].(*big.Int)
+		obj.Protocol = QRC721Protocol
+	default:
+		return nil
+	}
+
+	if len(obj.From) == 0 {
+		obj.From = HashAddressToBaseAddress(from.Hex(), params)
+	}
+	obj.To = HashAddressToBaseAddress(to.Hex(), params)
+	if obj.Protocol == QRC721Protocol {
+		obj.TokenID = value.String()
+		obj.Amount = "1"
+	} else {
+		obj.Amount = value.String()
+	}
+
+	return obj
+}
+
+//contractScript 合约输出的锁定脚本
+func (tx *Transaction) contractScript(n uint64) *btcLikeTxDriver.ContractScript {
+	for _, vout := range tx.Vouts {
+		if vout.N != n {
+			continue
+		}
+		script, err := btcLikeTxDriver.DecodeContractLockScript(vout.ScriptPubKey)
+		if err != nil {
+			return nil
+		}
+		return script
+	}
+	return nil
+}
+
+//applyContractReceipts 根据合约的执行回执补充代币交易
+//交易单合约调用实际消耗的gas(gasUsed * gasPrice)作为代币交易的手续费，执行失败的代币转账标记为失败，decimals为主币精度
+func (tx *Transaction) applyContractReceipts(receipts []*ContractReceipt, params *ChainParams, decimals int32) {
+
+	gasCost := decimal.Zero
+	failed := make([]*TokenReceipt, 0)
+	for _, receipt := range receipts {
+		tx.TokenReceipts = append(tx.TokenReceipts, receipt.TokenReceipts...)
+		tx.ContractReceipts = append(tx.ContractReceipts, receipt)
+		if len(receipt.TxHash) == 0 {
+			receipt.TxHash = tx.TxID
+		}
+		if len(receipt.BlockHash) == 0 || receipt.BlockHeight == 0 {
+			receipt.BlockHash = tx.BlockHash
+			receipt.BlockHeight = tx.BlockHeight
+		}
+
+		script := tx.contractScript(receipt.OutputIndex)
+		if script == nil {
+			continue
+		}
+		cost := decimal.New(int64(receipt.GasUsed), 0).Mul(decimal.New(int64(script.GasPrice), 0))
+		receipt.Fees = cost.Shift(-decimals).StringFixed(decimals)
+		gasCost = gasCost.Add(cost)
+
+		if receipt.IsExcepted() {
+			if token := newFailedTokenReceipt(receipt, script, params); token != nil {
+				failed = append(failed, token)
+			}
+		}
+	}
+	tx.TokenReceipts = append(tx.TokenReceipts, failed...)
+
+	fees := gasCost.Shift(-decimals).StringFixed(decimals)
+	for _, token := range tx.TokenReceipts {
+		if len(token.TxHash) == 0 {
+			token.TxHash = tx.TxID
+		}
+		if len(token.BlockHash) == 0 || token.BlockHeight == 0 {
+			token.BlockHash = tx.BlockHash
+			token.BlockHeight = tx.BlockHeight
+		}
+		token.Fees = fees
+		if token.Protocol == QRC721Protocol {
+			tx.Isqrc721Transfer = true
+		} else {
+			tx.Isqrc20Transfer = true
+		}
+	}
+}
+
 func newTxVinByCore(json *gjson.Result) *Vin {
 
 	/*
@@ -425,7 +795,7 @@ func newTxVinByCore(json *gjson.Result) *Vin {
 	return &obj
 }
 
-func newTxVoutByCore(json *gjson.Result, isTestnet bool) *Vout {
+func newTxVoutByCore(json *gjson.Result, params *ChainParams) *Vout {
 
 	/*
 		{
@@ -457,8 +827,87 @@ func newTxVoutByCore(json *gjson.Result, isTestnet bool) *Vout {
 
 	//if len(obj.Addr) == 0 {
 	//	scriptBytes, _ := hex.DecodeString(obj.ScriptPubKey)
-	//	obj.Addr, _ = ScriptPubKeyToBech32Address(scriptBytes, isTestnet)
+	//	obj.Addr, _ = ScriptPubKeyToBech32Address(scriptBytes, params)
 	//}
 
 	return &obj
 }
+
+//newTxByRaw 本地解码原始交易单，结果与getrawtransaction一致，未上链的交易单没有区块信息
+func newTxByRaw(txBytes []byte, addressPrefix btcLikeTxDriver.AddressPrefix) (*Transaction, error) {
+
+	raw, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	txid, err := raw.GetTxID()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := Transaction{}
+	obj.TxID = txid
+	obj.Version = uint64(binary.LittleEndian.Uint32(raw.Version))
+	obj.LockTime = int64(binary.LittleEndian.Uint32(raw.LockTime))
+	obj.Size = uint64(len(txBytes))
+	obj.Hex = hex.EncodeToString(txBytes)
+
+	obj.Vins = make([]*Vin, 0)
+	for i, in := range raw.Vins {
+		input := &Vin{N: uint64(i)}
+		//coinbase的输入没有来源交易
+		if in.GetVout() == 0xFFFFFFFF && strings.Trim(in.GetTxID(), "0") == "" {
+			input.Coinbase = in.GetScriptPubkey()
+			obj.IsCoinBase = true
+		} else {
+			input.TxID = in.GetTxID()
+			input.Vout = uint64(in.GetVout())
+		}
+		obj.Vins = append(obj.Vins, input)
+	}
+
+	obj.Vouts = make([]*Vout, 0)
+	for i, out := range raw.Vouts {
+		script, _ := hex.DecodeString(out.GetLockScript())
+		output := &Vout{
+			N:            uint64(i),
+			Value:        decimal.New(int64(out.GetAmount()), -8).String(),
+			ScriptPubKey: out.GetLockScript(),
+			Type:         rawScriptType(script),
+		}
+		output.Addr, _ = out.GetAddress(addressPrefix)
+		obj.Vouts = append(obj.Vouts, output)
+	}
+
+	//coinstake交易的第一个输出为空
+	if !obj.IsCoinBase && len(obj.Vouts) > 1 && obj.Vouts[0].Value == "0" && len(obj.Vouts[0].ScriptPubKey) == 0 {
+		obj.IsCoinstake = true
+	}
+
+	return &obj, nil
+}
+
+//rawScriptType 输出脚本的类型，与核心钱包的scriptPubKey.type一致
+func rawScriptType(script []byte) string {
+	n := len(script)
+	switch {
+	case n == 25 && script[0] == btcLikeTxDriver.OpCodeDup && script[1] == btcLikeTxDriver.OpCodeHash160 && script[2] == 0x14 && script[23] == btcLikeTxDriver.OpCodeEqualVerify && script[24] == btcLikeTxDriver.OpCodeCheckSig:
+		return "pubkeyhash"
+	case n == 23 && script[0] == btcLikeTxDriver.OpCodeHash160 && script[1] == 0x14 && script[22] == btcLikeTxDriver.OpCodeEqual:
+		return "scripthash"
+	case n == 22 && script[0] == 0x00 && script[1] == 0x14:
+		return "witness_v0_keyhash"
+	case n == 34 && script[0] == 0x00 && script[1] == 0x20:
+		return "witness_v0_scripthash"
+	case (n == 35 || n == 67) && int(script[0]) == n-2 && script[n-1] == btcLikeTxDriver.OpCodeCheckSig:
+		return "pubkey"
+	case n > 0 && script[0] == 0x6a:
+		return "nulldata"
+	case n > 0 && script[n-1] == btcLikeTxDriver.OpCodeCall:
+		return "call"
+	case n > 0 && script[n-1] == btcLikeTxDriver.OpCodeCreate:
+		return "create"
+	}
+	return "nonstandard"
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+)
+
+//isMultiSigAccount 账户拥有者公钥大于1为多重签名账户
+func isMultiSigAccount(account *openwallet.AssetsAccount) bool {
+	return account != nil && len(account.OwnerKeys) > 1
+}
+
+//multiSigAccountIDs 多重签名账户每个拥有者的AccountID
+func multiSigAccountIDs(account *openwallet.AssetsAccount) []string {
+	accountIDs := make([]string, 0)
+	for _, ownerKey := range account.OwnerKeys {
+		if len(ownerKey) == 0 {
+			continue
+		}
+		accountIDs = append(accountIDs, openwallet.GenAccountID(ownerKey))
+	}
+	return accountIDs
+}
+
+//checkContractSender 合约调用的发送者为第一个输入的P2PKH地址，多重签名账户不能发送合约交易
+func checkContractSender(account *openwallet.AssetsAccount) error {
+	if isMultiSigAccount(account) {
+		return fmt.Errorf("multisig account: %s not support contract transaction", account.AccountID)
+	}
+	return nil
+}
+
+//MultiSigRedeemScript 计算多重签名地址的赎回脚本
+//地址公钥字段已保存赎回脚本时直接使用，否则由拥有者公钥按地址的HDPath推导
+//地址可以是P2SH-P2WSH、P2SH或P2WSH编码
+func (wm *WalletManager) MultiSigRedeemScript(account *openwallet.AssetsAccount, addr *openwallet.Address) (string, error) {
+	if account == nil {
+		return "", fmt.Errorf("multisig account is empty")
+	}
+	if addr == nil {
+		return "", fmt.Errorf("multisig address is empty")
+	}
+
+	if _, _, err := btcLikeTxDriver.ParseMultiSigRedeemScript(addr.PublicKey); err == nil {
+		return addr.PublicKey, nil
+	}
+
+	if !isMultiSigAccount(account) {
+		return "", fmt.Errorf("account: %s is not a multisig account", account.AccountID)
+	}
+
+	change, index, err := parseAddressIndex(addr.HDPath)
+	if err != nil {
+		return "", err
+	}
+
+	pubkeys := make([][]byte, 0)
+	for _, ownerKey := range account.OwnerKeys {
+		if len(ownerKey) == 0 {
+			continue
+		}
+		pubkey, err := owkeychain.OWDecode(ownerKey)
+		if err != nil {
+			return "", err
+		}
+		start, err := pubkey.GenPublicChild(change)
+		if err != nil {
+			return "", err
+		}
+		child, err := start.GenPublicChild(index)
+		if err != nil {
+			return "", err
+		}
+		pubkeys = append(pubkeys, child.GetPublicKeyBytes())
+	}
+
+	addressPrefix := wm.Config.Network.AddressPrefix()
+
+	//先按拥有者顺序，再按公钥排序(BIP67)匹配地址
+	sorted := make([][]byte, len(pubkeys))
+	copy(sorted, pubkeys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	for _, keys := range [][][]byte{pubkeys, sorted} {
+		p2sh, redeem, err := btcLikeTxDriver.CreateMultiSig(byte(account.Required), keys, addressPrefix)
+		if err != nil {
+			return "", err
+		}
+		legacy, _, err := btcLikeTxDriver.CreateMultiSigP2SH(byte(account.Required), keys, addressPrefix)
+		if err != nil {
+			return "", err
+		}
+		p2wsh, _, err := btcLikeTxDriver.CreateMultiSigP2WSH(byte(account.Required), keys, addressPrefix)
+		if err != nil {
+			return "", err
+		}
+		if addr.Address == p2sh || addr.Address == legacy || addr.Address == p2wsh {
+			return redeem, nil
+		}
+	}
+
+	return "", fmt.Errorf("address: %s is not match the redeem script of account: %s", addr.Address, account.AccountID)
+}
+
+//parseAddressIndex 解析HDPath最后两级的change和index
+func parseAddressIndex(hdPath string) (uint32, uint32, error) {
+	path := strings.Split(hdPath, "/")
+	if len(path) < 2 {
+		return 0, 0, fmt.Errorf("invalid hdPath: %s", hdPath)
+	}
+	change, err := strconv.ParseUint(path[len(path)-2], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hdPath: %s", hdPath)
+	}
+	index, err := strconv.ParseUint(path[len(path)-1], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid hdPath: %s", hdPath)
+	}
+	return uint32(change), uint32(index), nil
+}
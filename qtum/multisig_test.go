@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
+)
+
+func TestMultiSig_CreateSignVerify(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+
+	owners := []*simWallet{newSimWallet(t, "owner0"), newSimWallet(t, "owner1"), newSimWallet(t, "owner2")}
+	keys := make([]string, len(owners))
+	for i, owner := range owners {
+		keys[i] = owner.account(t, i+1, 2).PublicKey
+	}
+
+	//每个拥有者的账户路径不同，拥有者公钥的顺序以自己为首
+	account0 := owners[0].account(t, 1, 2, keys[1], keys[2])
+	account1 := owners[1].account(t, 2, 2, keys[0], keys[2])
+
+	addr := owners[0].newAddress(t, wm, account0, 0)
+	if _, err := chain.Fund(addr.Address, decimal.New(1, 0)); err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+
+	receiver := newSimWallet(t, "receiver")
+	to := receiver.newAddress(t, wm, receiver.account(t, 1, 1), 0)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: account0,
+		To:      map[string]string{to.Address: "0.5"},
+	}
+	if err := wm.TxDecoder.CreateRawTransaction(owners[0], rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed: %v", err)
+	}
+	if len(rawTx.Signatures) != 3 || rawTx.Required != 2 {
+		t.Fatalf("multisig signatures: %d, required: %d", len(rawTx.Signatures), rawTx.Required)
+	}
+
+	//第二个拥有者用自己的账户签名，签名数量不足
+	signer := *rawTx
+	signer.Account = account1
+	if err := wm.TxDecoder.SignRawTransaction(owners[1], &signer); err != nil {
+		t.Fatalf("SignRawTransaction of owner1 failed: %v", err)
+	}
+	if err := wm.TxDecoder.VerifyRawTransaction(owners[0], rawTx); err != nil {
+		t.Fatalf("VerifyRawTransaction failed: %v", err)
+	}
+	if rawTx.IsCompleted {
+		t.Fatalf("transaction with 1 of 2 signatures should not be completed")
+	}
+
+	//第三个拥有者的钱包不能签其他拥有者的部分
+	if err := wm.TxDecoder.SignRawTransaction(receiver, rawTx); err == nil {
+		t.Fatalf("SignRawTransaction should fail for a wallet which is not an owner")
+	}
+
+	if err := wm.TxDecoder.SignRawTransaction(owners[0], rawTx); err != nil {
+		t.Fatalf("SignRawTransaction of owner0 failed: %v", err)
+	}
+	if err := wm.TxDecoder.VerifyRawTransaction(owners[0], rawTx); err != nil {
+		t.Fatalf("VerifyRawTransaction failed: %v", err)
+	}
+	if !rawTx.IsCompleted {
+		t.Fatalf("transaction with 2 of 2 signatures should be completed")
+	}
+
+	if _, err := chain.SendRawTransaction(rawTx.RawHex); err != nil {
+		t.Fatalf("SendRawTransaction failed: %v", err)
+	}
+	chain.Mine(1)
+	unspents, err := chain.ListUnspent(1, to.Address)
+	if err != nil || len(unspents) != 1 || unspents[0].Amount != "0.5" {
+		t.Fatalf("receiver unspents: %v, err: %v", unspents, err)
+	}
+}
+
+func TestMultiSigRedeemScript_Encodings(t *testing.T) {
+
+	wm, _ := newSimWalletManager()
+	owners := []*simWallet{newSimWallet(t, "owner0"), newSimWallet(t, "owner1")}
+	account := owners[0].account(t, 1, 2, owners[1].account(t, 1, 2).PublicKey)
+
+	pubkeys := make([][]byte, 0)
+	for _, ownerKey := range account.OwnerKeys {
+		pub, _ := owkeychain.OWDecode(ownerKey)
+		start, _ := pub.GenPublicChild(0)
+		child, _ := start.GenPublicChild(3)
+		pubkeys = append(pubkeys, child.GetPublicKeyBytes())
+	}
+
+	prefix := wm.Config.Network.AddressPrefix()
+	for name, create := range map[string]func(byte, [][]byte, btcLikeTxDriver.AddressPrefix) (string, string, error){
+		"p2sh-p2wsh": btcLikeTxDriver.CreateMultiSig,
+		"p2sh":       btcLikeTxDriver.CreateMultiSigP2SH,
+		"p2wsh":      btcLikeTxDriver.CreateMultiSigP2WSH,
+	} {
+		address, redeem, err := create(2, pubkeys, prefix)
+		if err != nil {
+			t.Fatalf("%s: create multisig failed: %v", name, err)
+		}
+		addr := &openwallet.Address{Address: address, AccountID: account.AccountID, HDPath: account.HDPath + "/0/3"}
+		got, err := wm.MultiSigRedeemScript(account, addr)
+		if err != nil || got != redeem {
+			t.Errorf("%s: MultiSigRedeemScript got: %s, err: %v", name, got, err)
+		}
+	}
+
+	//账户或地址为空时返回错误
+	addr := &openwallet.Address{AccountID: account.AccountID, HDPath: account.HDPath + "/0/3"}
+	if _, err := wm.MultiSigRedeemScript(nil, addr); err == nil {
+		t.Errorf("MultiSigRedeemScript should reject the empty account")
+	}
+	if _, err := wm.MultiSigRedeemScript(account, nil); err == nil {
+		t.Errorf("MultiSigRedeemScript should reject the empty address")
+	}
+}
+
+func TestMultiSig_RejectContractTransaction(t *testing.T) {
+
+	wm, _ := newSimWalletManager()
+	owners := []*simWallet{newSimWallet(t, "owner0"), newSimWallet(t, "owner1")}
+	account := owners[0].account(t, 1, 2, owners[1].account(t, 1, 2).PublicKey)
+	owners[0].newAddress(t, wm, account, 0)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     Symbol,
+			IsContract: true,
+			Contract:   openwallet.SmartContract{Address: "91a6081095ef860d28874c9db613e7a4107b0281", Protocol: "qrc20", Decimals: 8},
+		},
+		Account: account,
+		To:      map[string]string{"qUEeiBfBZiTuHKvPA85a1u5PeeMkLnNF3K": "1"},
+	}
+	err := wm.TxDecoder.CreateRawTransaction(owners[0], rawTx)
+	if err == nil || !strings.Contains(err.Error(), "multisig") {
+		t.Fatalf("qrc20 transfer from multisig account should be rejected, err: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+)
+
+const (
+	NetworkMainnet = "mainnet"
+	NetworkTestnet = "testnet"
+	NetworkRegtest = "regtest"
+	//自定义网络，参数由配置文件提供，未配置的使用regtest的值
+	NetworkCustom = "custom"
+)
+
+//ChainParams 网络参数，地址、WIF、BIP32编码和默认端口
+type ChainParams struct {
+	Name            string
+	P2PKHPrefix     byte
+	P2SHPrefix      byte
+	Bech32HRP       string
+	WIFPrefix       byte
+	BIP32PublicKey  []byte
+	BIP32PrivateKey []byte
+	RPCPort         int
+	P2PPort         int
+}
+
+var (
+	//MainNetParams 主网
+	MainNetParams = ChainParams{
+		Name:            NetworkMainnet,
+		P2PKHPrefix:     0x3A,
+		P2SHPrefix:      0x32,
+		Bech32HRP:       "qc",
+		WIFPrefix:       0x80,
+		BIP32PublicKey:  []byte{0x04, 0x88, 0xB2, 0x1E},
+		BIP32PrivateKey: []byte{0x04, 0x88, 0xAD, 0xE4},
+		RPCPort:         3889,
+		P2PPort:         3888,
+	}
+
+	//TestNetParams 测试网
+	TestNetParams = ChainParams{
+		Name:            NetworkTestnet,
+		P2PKHPrefix:     0x78,
+		P2SHPrefix:      0x6E,
+		Bech32HRP:       "tq",
+		WIFPrefix:       0xEF,
+		BIP32PublicKey:  []byte{0x04, 0x35, 0x87, 0xCF},
+		BIP32PrivateKey: []byte{0x04, 0x35, 0x83, 0x94},
+		RPCPort:         13889,
+		P2PPort:         13888,
+	}
+
+	//RegTestParams 本地回归测试网，base58前缀与测试网相同，bech32前缀不同
+	RegTestParams = ChainParams{
+		Name:            NetworkRegtest,
+		P2PKHPrefix:     0x78,
+		P2SHPrefix:      0x6E,
+		Bech32HRP:       "qcrt",
+		WIFPrefix:       0xEF,
+		BIP32PublicKey:  []byte{0x04, 0x35, 0x87, 0xCF},
+		BIP32PrivateKey: []byte{0x04, 0x35, 0x83, 0x94},
+		RPCPort:         13889,
+		P2PPort:         23888,
+	}
+)
+
+//NetworkParams 根据网络名称获取网络参数的副本，custom返回regtest的参数用于修改
+func NetworkParams(name string) (*ChainParams, error) {
+	var params ChainParams
+	switch strings.ToLower(name) {
+	case NetworkMainnet:
+		params = MainNetParams
+	case NetworkTestnet:
+		params = TestNetParams
+	case NetworkRegtest:
+		params = RegTestParams
+	case NetworkCustom:
+		params = RegTestParams
+		params.Name = NetworkCustom
+	default:
+		return nil, fmt.Errorf("unknown network: %s", name)
+	}
+	params.BIP32PublicKey = append([]byte{}, params.BIP32PublicKey...)
+	params.BIP32PrivateKey = append([]byte{}, params.BIP32PrivateKey...)
+	return &params, nil
+}
+
+//IsTestNet 是否非主网，用于openwallet接口的isTestnet参数
+func (p *ChainParams) IsTestNet() bool {
+	return p.Name != NetworkMainnet
+}
+
+//AddressPrefix 交易驱动使用的地址前缀
+func (p *ChainParams) AddressPrefix() btcLikeTxDriver.AddressPrefix {
+	return btcLikeTxDriver.AddressPrefix{
+		P2PKHPrefix:  []byte{p.P2PKHPrefix},
+		P2SHPrefix:   []byte{p.P2SHPrefix},
+		Bech32Prefix: p.Bech32HRP,
+	}
+}
+
+//P2PKHAddressType P2PKH地址编码
+func (p *ChainParams) P2PKHAddressType() addressEncoder.AddressType {
+	return addressEncoder.AddressType{EncodeType: "base58", Alphabet: alphabet, ChecksumType: "doubleSHA256", HashType: "h160", HashLen: 20, Prefix: []byte{p.P2PKHPrefix}}
+}
+
+//P2SHAddressType P2SH地址编码
+func (p *ChainParams) P2SHAddressType() addressEncoder.AddressType {
+	return addressEncoder.AddressType{EncodeType: "base58", Alphabet: alphabet, ChecksumType: "doubleSHA256", HashType: "h160", HashLen: 20, Prefix: []byte{p.P2SHPrefix}}
+}
+
+//Bech32AddressType 隔离见证v0地址编码，ChecksumType为bech32前缀
+func (p *ChainParams) Bech32AddressType() addressEncoder.AddressType {
+	return addressEncoder.AddressType{EncodeType: "bech32", Alphabet: addressEncoder.BTCBech32Alphabet, ChecksumType: p.Bech32HRP, HashType: "h160", HashLen: 20, Prefix: []byte{0}}
+}
+
+//WIFAddressType 私钥WIF编码
+func (p *ChainParams) WIFAddressType(compressed bool) addressEncoder.AddressType {
+	cfg := addressEncoder.AddressType{EncodeType: "base58", Alphabet: alphabet, ChecksumType: "doubleSHA256", HashLen: 32, Prefix: []byte{p.WIFPrefix}}
+	if compressed {
+		cfg.Suffix = []byte{0x01}
+	}
+	return cfg
+}
+
+//BIP32PublicKeyType 扩展公钥编码
+func (p *ChainParams) BIP32PublicKeyType() addressEncoder.AddressType {
+	return addressEncoder.AddressType{EncodeType: "base58", Alphabet: alphabet, ChecksumType: "doubleSHA256", HashLen: 74, Prefix: p.BIP32PublicKey}
+}
+
+//BIP32PrivateKeyType 扩展私钥编码
+func (p *ChainParams) BIP32PrivateKeyType() addressEncoder.AddressType {
+	return addressEncoder.AddressType{EncodeType: "base58", Alphabet: alphabet, ChecksumType: "doubleSHA256", HashLen: 74, Prefix: p.BIP32PrivateKey}
+}
+
+//DefaultRPCURL 本地核心钱包的默认RPC地址
+func (p *ChainParams) DefaultRPCURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", p.RPCPort)
+}
+
+//loadNetworkParams 读取网络配置，未配置network时兼容isTestNet
+func loadNetworkParams(c config.Configer) (*ChainParams, error) {
+	name := c.String("network")
+	if len(name) == 0 {
+		name = NetworkMainnet
+		if isTestNet, _ := c.Bool("isTestNet"); isTestNet {
+			name = NetworkTestnet
+		}
+	}
+
+	params, err := NetworkParams(name)
+	if err != nil {
+		return nil, err
+	}
+	if params.Name != NetworkCustom {
+		return params, nil
+	}
+
+	for key, value := range map[string]*byte{
+		"p2pkhPrefix": &params.P2PKHPrefix,
+		"p2shPrefix":  &params.P2SHPrefix,
+		"wifPrefix":   &params.WIFPrefix,
+	} {
+		if s := c.String(key); len(s) > 0 {
+			n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", key, s)
+			}
+			*value = byte(n)
+		}
+	}
+	for key, value := range map[string]*[]byte{
+		"bip32PublicKey":  &params.BIP32PublicKey,
+		"bip32PrivateKey": &params.BIP32PrivateKey,
+	} {
+		if s := c.String(key); len(s) > 0 {
+			version, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+			if err != nil || len(version) != 4 {
+				return nil, fmt.Errorf("invalid %s: %s", key, s)
+			}
+			*value = version
+		}
+	}
+	if hrp := c.String("bech32HRP"); len(hrp) > 0 {
+		params.Bech32HRP = strings.ToLower(hrp)
+	}
+	if port, err := c.Int("rpcPort"); err == nil && port > 0 {
+		params.RPCPort = port
+	}
+	if port, err := c.Int("p2pPort"); err == nil && port > 0 {
+		params.P2PPort = port
+	}
+	return params, nil
+}
@@ -0,0 +1,285 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
+)
+
+//maxTokenID tokenId为uint256
+var maxTokenID = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+//isQRC721Contract 合约是否QRC721协议
+func isQRC721Contract(contract openwallet.SmartContract) bool {
+	return strings.EqualFold(contract.Protocol, QRC721Protocol)
+}
+
+//parseTokenID 解析QRC721的tokenId，0x前缀或64位的为十六进制，否则为十进制
+func parseTokenID(s string) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+		base = 16
+	} else if len(s) == 64 {
+		base = 16
+	}
+
+	tokenID, ok := new(big.Int).SetString(s, base)
+	if !ok || tokenID.Sign() < 0 || tokenID.Cmp(maxTokenID) > 0 {
+		return nil, fmt.Errorf("invalid tokenId: %s", s)
+	}
+	return tokenID, nil
+}
+
+//QRC721BalanceOf 查询地址拥有的QRC721数量
+func (decoder *ContractDecoder) QRC721BalanceOf(contract openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return decoder.wm.GetQRC721Balance(contract, address)
+}
+
+//QRC721OwnerOf 查询tokenId的拥有者地址
+func (decoder *ContractDecoder) QRC721OwnerOf(contract openwallet.SmartContract, tokenID string) (string, error) {
+	id, err := parseTokenID(tokenID)
+	if err != nil {
+		return "", err
+	}
+	return decoder.wm.GetQRC721Owner(contract.Address, id)
+}
+
+//GetQRC721Balance 获取地址拥有的QRC721数量
+func (wm *WalletManager) GetQRC721Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	return wm.chain().GetQRC721Balance(token, address)
+}
+
+//getQRC721BalanceByCall 通过后端的合约试运行查询balanceOf
+func (wm *WalletManager) getQRC721BalanceByCall(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+
+	addr, err := qrc20AddressArg(address, wm.Config.Network)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	values, err := wm.callQRC721(token.Address, abi.QRC721BalanceOf, addr)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return decimal.NewFromBigInt(values[0].(*big.Int), 0), nil
+}
+
+//GetQRC721Owner 获取tokenId的拥有者，返回P2PKH地址
+func (wm *WalletManager) GetQRC721Owner(contractAddress string, tokenID *big.Int) (string, error) {
+
+	values, err := wm.callQRC721(contractAddress, abi.QRC721OwnerOf, tokenID)
+	if err != nil {
+		return "", err
+	}
+
+	owner := values[0].(abi.Address)
+	if owner == (abi.Address{}) {
+		return "", fmt.Errorf("tokenId: %s has no owner", tokenID.String())
+	}
+
+	addressPrefix := wm.Config.Network.AddressPrefix()
+
+	return btcLikeTxDriver.EncodeCheck(addressPrefix.P2PKHPrefix, owner[:]), nil
+}
+
+//callQRC721 试运行QRC721的查询方法，返回解码后的结果
+func (wm *WalletManager) callQRC721(contractAddress string, method *abi.Method, args ...interface{}) ([]interface{}, error) {
+
+	data, err := method.Pack(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	execution, err := wm.CallContract(contractAddress, hex.EncodeToString(data), "")
+	if err != nil {
+		return nil, err
+	}
+
+	//不存在的tokenId，ownerOf会执行失败
+	if execution.IsExcepted() {
+		return nil, fmt.Errorf("contract: %s call %s is excepted: %s", contractAddress, method.Name, execution.Excepted)
+	}
+
+	output, err := hex.DecodeString(execution.Output)
+	if err != nil {
+		return nil, fmt.Errorf("callcontract returns invalid output: %s", execution.Output)
+	}
+
+	return method.UnpackOutput(output)
+}
+
+//CreateQRC721RawTransaction 创建QRC721交易单，调用safeTransferFrom(from,to,tokenId)
+//rawTx.To只能有一个接收地址，值为tokenId，发送者为tokenId的拥有者，必须属于当前账户
+func (decoder *TransactionDecoder) CreateQRC721RawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	var (
+		accountID = rawTx.Account.AccountID
+		contract  = rawTx.Coin.Contract
+		toAddress string
+		tokenID   *big.Int
+		feesRate  decimal.Decimal
+		err       error
+	)
+
+	if len(contract.Address) == 0 {
+		return fmt.Errorf("contract address is empty")
+	}
+
+	if err := checkContractSender(rawTx.Account); err != nil {
+		return err
+	}
+
+	if len(rawTx.To) == 0 {
+		return fmt.Errorf("Receiver addresses is empty! ")
+	}
+
+	if len(rawTx.To) > 1 {
+		return fmt.Errorf("token transfer not support multiple receiver address")
+	}
+
+	for to, value := range rawTx.To {
+		toAddress = to
+		tokenID, err = parseTokenID(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	//tokenId的拥有者必须是账户的地址
+	owner, err := decoder.wm.GetQRC721Owner(contract.Address, tokenID)
+	if err != nil {
+		return err
+	}
+
+	ownerAddresses, err := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", owner)
+	if err != nil || len(ownerAddresses) == 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "account[%s] is not the owner of token[%s] tokenId: %s", accountID, contract.Address, tokenID.String())
+	}
+
+	fromArg, err := qrc20AddressArg(owner, decoder.wm.Config.Network)
+	if err != nil {
+		return err
+	}
+
+	toArg, err := qrc20AddressArg(toAddress, decoder.wm.Config.Network)
+	if err != nil {
+		return err
+	}
+
+	callData, err := abi.QRC721SafeTransferFrom.Pack(fromArg, toArg, tokenID)
+	if err != nil {
+		return err
+	}
+	dataHex := hex.EncodeToString(callData)
+
+	gas, err := decoder.contractGas(rawTx.GetExtParam(), decoder.wm.Config.GasLimit, func() (uint64, error) {
+		return decoder.wm.EstimateContractGasLimit(contract.Address, dataHex, owner)
+	})
+	if err != nil {
+		return err
+	}
+
+	unspents, openErr := decoder.getAssetsAccountUnspents(wrapper, rawTx.Account)
+	if openErr != nil {
+		return openErr
+	}
+
+	//合约调用的发送者为第一个输入的地址，必须使用拥有者地址的utxo
+	ownerUnspents := make([]*Unspent, 0)
+	for _, u := range spendableUnspents(unspents, nil) {
+		if u.Address == owner {
+			ownerUnspents = append(ownerUnspents, u)
+		}
+	}
+	if len(ownerUnspents) == 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "account[%s] token[%s] the utxo of owner address[%s] is empty! ", accountID, contract.Address, owner)
+	}
+	orderByAmountDesc(ownerUnspents)
+
+	//获取手续费率
+	if len(rawTx.FeeRate) == 0 {
+		feesRate, err = decoder.wm.EstimateFeeRate()
+		if err != nil {
+			return err
+		}
+	} else {
+		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	}
+
+	selector, err := decoder.coinSelector(rawTx.GetExtParam())
+	if err != nil {
+		return err
+	}
+
+	vcontract := btcLikeTxDriver.Vcontract{
+		ContractAddr: strings.TrimPrefix(contract.Address, "0x"),
+		CallData:     callData,
+		GasLimit:     strconv.FormatUint(gas.Limit, 10),
+		GasPrice:     gas.Price.Shift(decoder.wm.Decimal()).String(),
+	}
+
+	contractTx, openErr := decoder.createContractTransaction(wrapper, rawTx.Account, unspents, ownerUnspents[0], vcontract, gas.Cost(), feesRate, selector)
+	if openErr != nil {
+		return openErr
+	}
+
+	fees := contractTx.Selected.Fees.Add(gas.Cost())
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("From Account: %s", accountID)
+	decoder.wm.Log.Std.Notice("Owner Address: %s", owner)
+	decoder.wm.Log.Std.Notice("To Address: %s", toAddress)
+	decoder.wm.Log.Std.Notice("TokenId: %s", tokenID.String())
+	decoder.wm.Log.Std.Notice("Fees %s: %v", decoder.wm.Symbol(), fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change %s: %v", decoder.wm.Symbol(), contractTx.Selected.Change.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	//转出到账户外的地址时，账户的数量减少1
+	txAmount := "0"
+	addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", toAddress)
+	if findErr != nil || len(addresses) == 0 {
+		txAmount = "-1"
+	}
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+	rawTx.Signatures[accountID] = contractTx.KeySigs
+	rawTx.RawHex = contractTx.EmptyTrans
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+	rawTx.TxAmount = txAmount
+	rawTx.TxFrom = []string{owner + ":1"}
+	rawTx.TxTo = []string{toAddress + ":1"}
+	rawTx.SetExtParam("tokenID", tokenID.String())
+	rawTx.SetExtParam("gasLimit", gas.Limit)
+	rawTx.SetExtParam("gasPrice", gas.Price.String())
+	rawTx.IsBuilt = true
+
+	return nil
+}
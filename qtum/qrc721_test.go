@@ -0,0 +1,47 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestQRC721_SummaryNotSupported(t *testing.T) {
+
+	wm, _ := newSimWalletManager()
+	wallet := newSimWallet(t, "qrc721")
+	account := wallet.account(t, 1, 1)
+	wallet.newAddress(t, wm, account, 0)
+
+	sumRawTx := &openwallet.SummaryRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     Symbol,
+			IsContract: true,
+			Contract:   openwallet.SmartContract{Address: "91a6081095ef860d28874c9db613e7a4107b0281", Protocol: QRC721Protocol},
+		},
+		Account:        account,
+		SummaryAddress: "qUEeiBfBZiTuHKvPA85a1u5PeeMkLnNF3K",
+	}
+
+	if _, err := wm.TxDecoder.CreateSummaryRawTransaction(wallet, sumRawTx); err == nil {
+		t.Errorf("CreateSummaryRawTransaction should reject qrc721 token")
+	}
+	if _, err := wm.TxDecoder.(*TransactionDecoder).CreateSummaryRawTransactionWithError(wallet, sumRawTx); err == nil {
+		t.Errorf("CreateSummaryRawTransactionWithError should reject qrc721 token")
+	}
+}
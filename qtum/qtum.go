@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/common/file"
 	"github.com/blocktree/openwallet/v2/console"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
@@ -28,6 +29,7 @@ import (
 	"github.com/shopspring/decimal"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 //初始化配置流程
@@ -589,9 +591,92 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.rpcUser = c.String("rpcUser")
 	wm.Config.rpcPassword = c.String("rpcPassword")
 	//wm.Config.nodeInstallPath = c.String("nodeInstallPath")
-	wm.Config.isTestNet, _ = c.Bool("isTestNet")
+	network, err := loadNetworkParams(c)
+	if err != nil {
+		return err
+	}
+	wm.Config.Network = network
+	if len(wm.Config.serverAPI) == 0 && wm.Config.RPCServerType == RPCServerCore {
+		wm.Config.serverAPI = network.DefaultRPCURL()
+	}
 	wm.Config.TokenTransferCost = c.String("tokenTransferCost")
 	wm.Config.MinFees, _ = decimal.NewFromString(c.String("minFees"))
+	wm.Config.EnableRBF, _ = c.Bool("enableRBF")
+	if incrementalRelayFee, err := decimal.NewFromString(c.String("incrementalRelayFee")); err == nil && incrementalRelayFee.GreaterThan(decimal.Zero) {
+		wm.Config.IncrementalRelayFee = incrementalRelayFee
+	}
+	if coinSelection := c.String("coinSelection"); len(coinSelection) > 0 {
+		wm.Config.CoinSelection = coinSelection
+	}
+	if dustThreshold, err := decimal.NewFromString(c.String("dustThreshold")); err == nil && dustThreshold.GreaterThanOrEqual(decimal.Zero) {
+		wm.Config.DustThreshold = dustThreshold
+	}
+	if gasLimit, err := c.Int64("gasLimit"); err == nil && gasLimit > 0 {
+		wm.Config.GasLimit = uint64(gasLimit)
+	}
+	if createGasLimit, err := c.Int64("createGasLimit"); err == nil && createGasLimit > 0 {
+		wm.Config.CreateGasLimit = uint64(createGasLimit)
+	}
+	if gasPrice, err := decimal.NewFromString(c.String("gasPrice")); err == nil && gasPrice.GreaterThan(decimal.Zero) {
+		wm.Config.GasPrice = gasPrice
+	}
+	wm.Config.EstimateGasLimit, _ = c.Bool("estimateGasLimit")
+	if multiplier, err := decimal.NewFromString(c.String("gasLimitMultiplier")); err == nil && multiplier.GreaterThanOrEqual(decimal.New(1, 0)) {
+		wm.Config.GasLimitMultiplier = multiplier
+	}
+	if depth, err := c.Int64("reorgJournalDepth"); err == nil && depth > 0 {
+		wm.Config.ReorgJournalDepth = uint64(depth)
+	}
+	thresholds, err := parseConfirmationThresholds(c.String("confirmationThresholds"))
+	if err != nil {
+		return err
+	}
+	wm.Config.ConfirmationThresholds = thresholds
+	wm.Config.ScanMemPool, _ = c.Bool("scanMemPool")
+	if wm.blockscanner != nil {
+		wm.blockscanner.IsScanMemPool = wm.Config.ScanMemPool
+	}
+	wm.Config.ZMQPubHashBlock = c.String("zmqPubHashBlock")
+	wm.Config.ZMQPubRawTx = c.String("zmqPubRawTx")
+	if workers, err := c.Int("extractWorkers"); err == nil && workers > 0 {
+		wm.Config.ExtractWorkers = workers
+	}
+	if queueSize, err := c.Int("extractQueueSize"); err == nil && queueSize > 0 {
+		wm.Config.ExtractQueueSize = queueSize
+	}
+	if batchSize, err := c.Int("extractBatchSize"); err == nil && batchSize > 0 {
+		wm.Config.ExtractBatchSize = batchSize
+	}
+	wm.Config.ElectrumSkipVerify, _ = c.Bool("electrumSkipVerify")
+	if strategy := c.String("endpointStrategy"); len(strategy) > 0 {
+		wm.Config.EndpointStrategy = strategy
+	}
+	if failures, err := c.Int("breakerFailures"); err == nil && failures > 0 {
+		wm.Config.BreakerFailures = failures
+	}
+	if seconds, err := c.Int("breakerSeconds"); err == nil && seconds > 0 {
+		wm.Config.BreakerTimeout = time.Duration(seconds) * time.Second
+	}
+	if seconds, err := c.Int("healthCheckSeconds"); err == nil && seconds >= 0 {
+		wm.Config.HealthCheckInterval = time.Duration(seconds) * time.Second
+	}
+	wm.Config.Quorum, _ = c.Int("quorum")
+	if seconds, err := c.Int("rpcTimeout"); err == nil && seconds > 0 {
+		wm.Config.RPCTimeout = time.Duration(seconds) * time.Second
+	}
+	wm.Config.RPCMaxRetries, _ = c.Int("rpcMaxRetries")
+	if ms, err := c.Int("rpcRetryBackoff"); err == nil && ms > 0 {
+		wm.Config.RPCRetryBackoff = time.Duration(ms) * time.Millisecond
+	}
+	wm.Config.RPCCookieFile = c.String("rpcCookieFile")
+	if certsDir := c.String("certsDir"); len(certsDir) > 0 {
+		wm.Config.certsDir = certsDir
+	}
+	if certFileName := c.String("certFileName"); len(certFileName) > 0 {
+		wm.Config.certFileName = certFileName
+	}
+	wm.Config.rpcClientCert = c.String("rpcClientCert")
+	wm.Config.rpcClientKey = c.String("rpcClientKey")
 	//if wm.Config.isTestNet {
 	//	wm.Config.walletDataPath = c.String("testNetDataPath")
 	//} else {
@@ -604,10 +689,27 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 
 	token := basicAuth(wm.Config.rpcUser, wm.Config.rpcPassword)
 
-	if wm.Config.RPCServerType == RPCServerCore {
+	//重新加载配置时，先停止旧节点池的健康检查，关闭旧的Electrum连接
+	wm.closeEndpoints()
+
+	switch wm.Config.RPCServerType {
+	case RPCServerCore:
 		wm.WalletClient = NewClient(wm.Config.serverAPI, token, false)
-	} else {
+		if err := wm.setupRPCClient(wm.WalletClient); err != nil {
+			return err
+		}
+		wm.setupEndpoints(wm.WalletClient.Endpoints)
+		if wm.WalletClient.Endpoints.Len() > 1 {
+			wm.WalletClient.HealthCheck(wm.Config.HealthCheckInterval)
+		}
+	case RPCServerElectrum:
+		wm.ElectrumClient = NewElectrum(wm.Config.serverAPI, wm.Config.ElectrumSkipVerify)
+	default:
 		wm.ExplorerClient = NewExplorer(wm.Config.serverAPI, false)
+		wm.setupEndpoints(wm.ExplorerClient.Endpoints)
+		if wm.ExplorerClient.Endpoints.Len() > 1 {
+			wm.ExplorerClient.HealthCheck(wm.Config.HealthCheckInterval)
+		}
 	}
 
 	wm.Config.DataDir = c.String("dataDir")
@@ -618,6 +720,35 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	return nil
 }
 
+//setupRPCClient 设置核心钱包RPC的超时、重试、cookie鉴权和https证书
+//证书目录中存在certFileName时作为CA证书，配置了rpcClientCert时使用客户端证书
+func (wm *WalletManager) setupRPCClient(client *Client) error {
+
+	client.SetTimeout(wm.Config.RPCTimeout)
+	client.MaxRetries = wm.Config.RPCMaxRetries
+	client.RetryBackoff = wm.Config.RPCRetryBackoff
+	client.CookieFile = wm.Config.RPCCookieFile
+
+	if !strings.Contains(wm.Config.serverAPI, "https://") {
+		return nil
+	}
+
+	caFile := ""
+	if len(wm.Config.certFileName) > 0 {
+		if path := filepath.Join(wm.Config.certsDir, wm.Config.certFileName); file.Exists(path) {
+			caFile = path
+		}
+	}
+
+	certFile, keyFile := "", ""
+	if len(wm.Config.rpcClientCert) > 0 {
+		certFile = filepath.Join(wm.Config.certsDir, wm.Config.rpcClientCert)
+		keyFile = filepath.Join(wm.Config.certsDir, wm.Config.rpcClientKey)
+	}
+
+	return client.SetTLS(caFile, certFile, keyFile)
+}
+
 //InitAssetsConfig 初始化默认配置
 func (wm *WalletManager) InitAssetsConfig() (config.Configer, error) {
 	return config.NewConfigData("ini", []byte(wm.Config.defaultConfig))
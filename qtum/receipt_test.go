@@ -0,0 +1,399 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	testReceiptSender   = "de48338aedfdbdf18b1425af208d6fde637821e1"
+	testReceiptTo       = "9ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"
+	testReceiptContract = "f2033ede578e17fa6231047265010445bca8cf1c"
+	testReceiptNFT      = "6b8bf98ff497c064e8f0bde13e0c4f5ed5bf8ce7"
+)
+
+//testReceiptsJSON gettransactionreceipt的结果，第一个回执包含QRC20和QRC721的Transfer以及Approval日志，第二个回执执行失败
+const testReceiptsJSON = `[{
+	"blockHash": "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b",
+	"blockNumber": 521424,
+	"transactionHash": "9e2d3c6e5d6f3c1f0f5b4a3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b",
+	"transactionIndex": 2,
+	"outputIndex": 0,
+	"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+	"to": "f2033ede578e17fa6231047265010445bca8cf1c",
+	"cumulativeGasUsed": 36236,
+	"gasUsed": 36236,
+	"contractAddress": "f2033ede578e17fa6231047265010445bca8cf1c",
+	"excepted": "None",
+	"log": [{
+		"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"topics": [
+			"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1",
+			"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"
+		],
+		"data": "0000000000000000000000000000000000000000000000000de0b6b3a7640000"
+	}, {
+		"address": "6b8bf98ff497c064e8f0bde13e0c4f5ed5bf8ce7",
+		"topics": [
+			"ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1",
+			"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5",
+			"000000000000000000000000000000000000000000000000000000000000000c"
+		],
+		"data": ""
+	}, {
+		"address": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"topics": [
+			"8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925",
+			"000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1",
+			"0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"
+		],
+		"data": "0000000000000000000000000000000000000000000000000000000000000064"
+	}]
+}, {
+	"blockHash": "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b",
+	"blockNumber": 521424,
+	"transactionHash": "9e2d3c6e5d6f3c1f0f5b4a3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b",
+	"transactionIndex": 2,
+	"outputIndex": 1,
+	"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+	"to": "f2033ede578e17fa6231047265010445bca8cf1c",
+	"cumulativeGasUsed": 136236,
+	"gasUsed": 100000,
+	"contractAddress": "f2033ede578e17fa6231047265010445bca8cf1c",
+	"excepted": "OutOfGas",
+	"log": []
+}]`
+
+//testContractLockScript 合约调用输出的锁定脚本
+func testContractLockScript(t *testing.T, contractAddr, gasLimit, gasPrice string, callData []byte) string {
+	script, err := btcLikeTxDriver.GetContractLockScript(btcLikeTxDriver.Vcontract{
+		ContractAddr: contractAddr,
+		GasLimit:     gasLimit,
+		GasPrice:     gasPrice,
+		CallData:     callData,
+	})
+	if err != nil {
+		t.Fatalf("GetContractLockScript failed: %v", err)
+	}
+	return script
+}
+
+func TestNewContractReceiptsByCore(t *testing.T) {
+
+	params, _ := NetworkParams(NetworkTestnet)
+	result := gjson.Parse(testReceiptsJSON)
+	receipts := newContractReceiptsByCore(&result, params)
+	if len(receipts) != 2 {
+		t.Fatalf("receipts: %d, want: 2", len(receipts))
+	}
+
+	sender := HashAddressToBaseAddress(testReceiptSender, params)
+	to := HashAddressToBaseAddress(testReceiptTo, params)
+
+	receipt := receipts[0]
+	if receipt.Sender != sender || receipt.ContractAddress != "0x"+testReceiptContract || receipt.OutputIndex != 0 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	if receipt.GasUsed != 36236 || receipt.Excepted != "None" || receipt.IsExcepted() {
+		t.Fatalf("gasUsed: %d, excepted: %s", receipt.GasUsed, receipt.Excepted)
+	}
+	//非Transfer事件只保留原始日志
+	if len(receipt.Logs) != 3 {
+		t.Fatalf("logs: %d, want: 3", len(receipt.Logs))
+	}
+	if len(receipt.TokenReceipts) != 2 {
+		t.Fatalf("token receipts: %d, want: 2", len(receipt.TokenReceipts))
+	}
+
+	qrc20 := receipt.TokenReceipts[0]
+	if qrc20.Protocol != QRC20Protocol || qrc20.ContractAddress != "0x"+testReceiptContract {
+		t.Fatalf("unexpected QRC20 receipt: %+v", qrc20)
+	}
+	if qrc20.From != sender || qrc20.To != to || qrc20.Amount != "1000000000000000000" || len(qrc20.TokenID) > 0 {
+		t.Fatalf("unexpected QRC20 transfer: %+v", qrc20)
+	}
+
+	qrc721 := receipt.TokenReceipts[1]
+	if qrc721.Protocol != QRC721Protocol || qrc721.ContractAddress != "0x"+testReceiptNFT {
+		t.Fatalf("unexpected QRC721 receipt: %+v", qrc721)
+	}
+	if qrc721.From != sender || qrc721.To != to || qrc721.TokenID != "12" || qrc721.Amount != "1" {
+		t.Fatalf("unexpected QRC721 transfer: %+v", qrc721)
+	}
+
+	for _, token := range receipt.TokenReceipts {
+		if token.TxHash != receipt.TxHash || token.BlockHash != receipt.BlockHash || token.BlockHeight != 521424 {
+			t.Fatalf("block info is not carried to the token receipt: %+v", token)
+		}
+		if token.Sender != sender || token.GasUsed != 36236 || token.Excepted != "None" {
+			t.Fatalf("gasUsed and excepted are not carried to the token receipt: %+v", token)
+		}
+	}
+
+	failed := receipts[1]
+	if failed.OutputIndex != 1 || failed.GasUsed != 100000 || !failed.IsExcepted() || len(failed.TokenReceipts) != 0 {
+		t.Fatalf("unexpected failed receipt: %+v", failed)
+	}
+}
+
+func TestNewTokenReceiptByLog_Invalid(t *testing.T) {
+
+	params, _ := NetworkParams(NetworkTestnet)
+	logs := []string{
+		//Transfer事件缺少to
+		`{"address": "f2033ede578e17fa6231047265010445bca8cf1c", "topics": ["ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", "000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1"], "data": ""}`,
+		//QRC20的data长度错误
+		`{"address": "f2033ede578e17fa6231047265010445bca8cf1c", "topics": ["ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", "000000000000000000000000de48338aedfdbdf18b1425af208d6fde637821e1", "0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"], "data": "64"}`,
+		//topic不是十六进制
+		`{"address": "f2033ede578e17fa6231047265010445bca8cf1c", "topics": ["ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", "zz", "0000000000000000000000009ee1dd83ac2e7d5b3f8e4fb4ef8b2e2c35a1c1e5"], "data": ""}`,
+	}
+	for i, raw := range logs {
+		logInfo := gjson.Parse(raw)
+		if token := newTokenReceiptByLog(&logInfo, params); token != nil {
+			t.Fatalf("log %d should be skipped, got: %+v", i, token)
+		}
+	}
+}
+
+func TestFillTokenReceiptsByCore(t *testing.T) {
+
+	node := newRPCTestServer(t, func(method string) interface{} {
+		if method == "gettransactionreceipt" {
+			return json.RawMessage(testReceiptsJSON)
+		}
+		return &rpcTestError{Code: -32601, Message: "Method not found"}
+	})
+
+	wm := NewWalletManager()
+	wm.WalletClient = NewClient(node.URL, "", false)
+
+	trx := &Transaction{
+		TxID:        "9e2d3c6e5d6f3c1f0f5b4a3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b",
+		BlockHash:   "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b",
+		BlockHeight: 521424,
+		Vouts: []*Vout{
+			{N: 0, Type: "call", ScriptPubKey: testContractLockScript(t, testReceiptContract, "250000", "40", []byte{0x01, 0x02, 0x03, 0x04})},
+			{N: 1, Type: "call", ScriptPubKey: testContractLockScript(t, testReceiptContract, "100000", "40", []byte{0x01, 0x02, 0x03, 0x04})},
+		},
+	}
+
+	//未确认的交易单没有回执
+	unconfirmed := *trx
+	unconfirmed.BlockHash = ""
+	if err := wm.fillTokenReceiptsByCore(&unconfirmed); err != nil {
+		t.Fatalf("fillTokenReceiptsByCore failed: %v", err)
+	}
+	if node.Hits() != 0 || len(unconfirmed.TokenReceipts) != 0 {
+		t.Fatalf("unconfirmed transaction should not query the receipt")
+	}
+
+	if err := wm.fillTokenReceiptsByCore(trx); err != nil {
+		t.Fatalf("fillTokenReceiptsByCore failed: %v", err)
+	}
+	if len(trx.ContractReceipts) != 2 || len(trx.TokenReceipts) != 2 {
+		t.Fatalf("contract receipts: %d, token receipts: %d", len(trx.ContractReceipts), len(trx.TokenReceipts))
+	}
+	if !trx.Isqrc20Transfer || !trx.Isqrc721Transfer {
+		t.Fatalf("transfer flags are not set, qrc20: %v, qrc721: %v", trx.Isqrc20Transfer, trx.Isqrc721Transfer)
+	}
+	for _, token := range trx.TokenReceipts {
+		if token.GasUsed != 36236 || token.Excepted != "None" {
+			t.Fatalf("unexpected token receipt: %+v", token)
+		}
+	}
+}
+
+func TestApplyContractReceipts_FailedTransfer(t *testing.T) {
+
+	params, _ := NetworkParams(NetworkTestnet)
+	transfer, err := abi.QRC20Transfer.Pack("0x"+testReceiptTo, big.NewInt(500))
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	trx := &Transaction{
+		TxID:        "9e2d3c6e5d6f3c1f0f5b4a3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b",
+		BlockHash:   "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b",
+		BlockHeight: 521424,
+		Vouts: []*Vout{
+			{N: 0, Type: "call", ScriptPubKey: testContractLockScript(t, testReceiptContract, "250000", "40", transfer)},
+			{N: 1, Type: "call", ScriptPubKey: testContractLockScript(t, testReceiptContract, "100000", "40", []byte{0x01, 0x02, 0x03, 0x04})},
+		},
+	}
+
+	//回滚的transfer没有日志，未知的调用执行失败时不还原代币转账
+	result := gjson.Parse(`[{
+		"outputIndex": 0,
+		"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+		"gasUsed": 50000,
+		"contractAddress": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"excepted": "Revert",
+		"log": []
+	}, {
+		"outputIndex": 1,
+		"from": "de48338aedfdbdf18b1425af208d6fde637821e1",
+		"gasUsed": 21000,
+		"contractAddress": "f2033ede578e17fa6231047265010445bca8cf1c",
+		"excepted": "Revert",
+		"log": []
+	}]`)
+	trx.applyContractReceipts(newContractReceiptsByCore(&result, params), params, 8)
+
+	if len(trx.ContractReceipts) != 2 {
+		t.Fatalf("contract receipts: %d, want: 2", len(trx.ContractReceipts))
+	}
+	//每个回执的手续费 = gasUsed * gasPrice
+	if fees := trx.ContractReceipts[0].Fees; fees != "0.02000000" {
+		t.Fatalf("fees of the first receipt: %s, want: 0.02000000", fees)
+	}
+	if fees := trx.ContractReceipts[1].Fees; fees != "0.00840000" {
+		t.Fatalf("fees of the second receipt: %s, want: 0.00840000", fees)
+	}
+	for _, receipt := range trx.ContractReceipts {
+		if receipt.TxHash != trx.TxID || receipt.BlockHash != trx.BlockHash || receipt.BlockHeight != trx.BlockHeight {
+			t.Fatalf("block info is not filled: %+v", receipt)
+		}
+	}
+
+	if len(trx.TokenReceipts) != 1 || !trx.Isqrc20Transfer || trx.Isqrc721Transfer {
+		t.Fatalf("token receipts: %d, want: 1 failed QRC20 transfer", len(trx.TokenReceipts))
+	}
+	token := trx.TokenReceipts[0]
+	if !token.IsExcepted() || token.Excepted != "Revert" || token.GasUsed != 50000 {
+		t.Fatalf("transfer should be failed: %+v", token)
+	}
+	if token.From != HashAddressToBaseAddress(testReceiptSender, params) || token.To != HashAddressToBaseAddress(testReceiptTo, params) {
+		t.Fatalf("unexpected transfer, from: %s, to: %s", token.From, token.To)
+	}
+	if token.Amount != "500" || token.ContractAddress != "0x"+testReceiptContract || token.Protocol != QRC20Protocol {
+		t.Fatalf("unexpected transfer: %+v", token)
+	}
+	//代币交易的手续费是交易单全部合约调用的gas成本
+	if token.Fees != "0.02840000" || token.TxHash != trx.TxID || token.BlockHeight != trx.BlockHeight {
+		t.Fatalf("fees: %s, want: 0.02840000", token.Fees)
+	}
+}
+
+func TestMarkGasRefundsByCore(t *testing.T) {
+
+	wm := NewWalletManager()
+	params := wm.Config.Network
+	script := testContractLockScript(t, testReceiptContract, "250000", "40", []byte{0x01, 0x02, 0x03, 0x04})
+
+	block := map[string]interface{}{
+		"tx": []interface{}{
+			map[string]interface{}{"txid": "coinbase", "vin": []interface{}{map[string]interface{}{"coinbase": "03d0f40700"}}, "vout": []interface{}{}},
+			map[string]interface{}{"txid": "coinstake"},
+			map[string]interface{}{"txid": "contract", "vout": []interface{}{
+				map[string]interface{}{"value": 0, "n": 0, "scriptPubKey": map[string]interface{}{"hex": script, "type": "call"}},
+			}},
+		},
+	}
+	node := newRPCTestServer(t, func(method string) interface{} {
+		switch method {
+		case "getblock":
+			return block
+		case "gettransactionreceipt":
+			return json.RawMessage(`[{"outputIndex": 0, "from": "` + testReceiptSender + `", "gasUsed": 36236, "excepted": "None", "log": []}]`)
+		}
+		return &rpcTestError{Code: -32601, Message: "Method not found"}
+	})
+	wm.WalletClient = NewClient(node.URL, "", false)
+
+	sender := HashAddressToBaseAddress(testReceiptSender, params)
+	other := HashAddressToBaseAddress(testReceiptTo, params)
+
+	//退款 = (250000 - 36236) * 40 = 0.0855056
+	trx := &Transaction{
+		TxID:        "coinstake",
+		BlockHash:   "b6d91b2a6a0c9a5c0dce2dc6bdb9ff1d8d8f0f4b8e1e4d8b0d8f1c2c3e4f5a6b",
+		IsCoinstake: true,
+		Vouts: []*Vout{
+			{N: 0, Value: "0"},
+			{N: 1, Addr: sender, Value: "0.5"},
+			{N: 2, Addr: other, Value: "0.0855056"},
+			{N: 3, Addr: sender, Value: "0.0855056"},
+		},
+	}
+	if !trx.mayContainGasRefunds(true) {
+		t.Fatalf("coinstake may contain gas refunds")
+	}
+	if err := wm.markGasRefundsByCore(trx); err != nil {
+		t.Fatalf("markGasRefundsByCore failed: %v", err)
+	}
+
+	for _, vout := range trx.Vouts {
+		if vout.IsRefund != (vout.N == 3) {
+			t.Fatalf("output %d refund: %v", vout.N, vout.IsRefund)
+		}
+	}
+}
+
+func TestMayContainGasRefunds(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		trx         *Transaction
+		isCoinstake bool
+		want        bool
+	}{
+		{"coinstake", &Transaction{IsCoinstake: true, Vouts: []*Vout{{}, {Value: "1"}}}, false, true},
+		{"coinstake by position", &Transaction{Vouts: []*Vout{{}, {Value: "1", ScriptPubKey: "76a9"}}}, true, true},
+		{"second transaction", &Transaction{Vouts: []*Vout{{Value: "1", ScriptPubKey: "76a9"}, {Value: "1", ScriptPubKey: "76a9"}}}, true, false},
+		{"pow coinbase", &Transaction{Vins: []*Vin{{Coinbase: "03d0f40700"}}, Vouts: []*Vout{{Value: "4"}, {Value: "0.1"}}}, false, true},
+		{"pos coinbase", &Transaction{Vins: []*Vin{{Coinbase: "03d0f40700"}}, Vouts: []*Vout{{Value: "0"}, {Value: "0"}}}, false, false},
+		{"single output", &Transaction{IsCoinstake: true, Vouts: []*Vout{{Value: "1"}}}, false, false},
+		{"normal", &Transaction{Vins: []*Vin{{TxID: "prev"}}, Vouts: []*Vout{{Value: "1"}, {Value: "1"}}}, false, false},
+	}
+	for _, test := range tests {
+		if got := test.trx.mayContainGasRefunds(test.isCoinstake); got != test.want {
+			t.Fatalf("%s: %v, want: %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIsGasRefundOnly(t *testing.T) {
+
+	refund := &openwallet.TxOutPut{Recharge: openwallet.Recharge{TxType: 101}}
+	reward := &openwallet.TxOutPut{Recharge: openwallet.Recharge{TxType: 100}}
+	input := &openwallet.TxInput{}
+
+	tests := []struct {
+		name string
+		data *openwallet.TxExtractData
+		want bool
+	}{
+		{"refund", &openwallet.TxExtractData{TxOutputs: []*openwallet.TxOutPut{refund, refund}}, true},
+		{"refund and reward", &openwallet.TxExtractData{TxOutputs: []*openwallet.TxOutPut{refund, reward}}, false},
+		{"staker", &openwallet.TxExtractData{TxInputs: []*openwallet.TxInput{input}, TxOutputs: []*openwallet.TxOutPut{refund}}, false},
+		{"empty", &openwallet.TxExtractData{}, false},
+	}
+	for _, test := range tests {
+		if got := isGasRefundOnly(test.data); got != test.want {
+			t.Fatalf("%s: %v, want: %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/v2/common/file"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	//重组日志的数据库文件名
+	reorgJournalFileName = "reorg_journal.db"
+)
+
+//ReorgNotificationObject 观察者可选实现的接口，接收孤块中已通知过的提取结果
+//区块被重组时，所有观察者都会收到Fork为true的区块通知
+type ReorgNotificationObject interface {
+	//BlockExtractDataRollbackNotify 孤块中的交易单提取结果，已不在主链上
+	BlockExtractDataRollbackNotify(sourceKey string, data *openwallet.TxExtractData) error
+}
+
+//JournalBlock 重组日志中已扫描的区块
+type JournalBlock struct {
+	Height    uint64           `storm:"id"`
+	Hash      string           `json:"hash"`
+	PrevHash  string           `json:"prevHash"`
+	Time      uint64           `json:"time"`
+	Committed bool             `json:"committed"` //区块的提取结果已通知，扫描高度已保存
+	Digest    string           `json:"digest"`    //提取结果的摘要，sha256
+	Records   []*JournalRecord `json:"records"`   //已通知给观察者的提取结果
+}
+
+//JournalRecord 已通知给观察者的提取结果
+type JournalRecord struct {
+	SourceKey string                    `json:"sourceKey"`
+	Data      *openwallet.TxExtractData `json:"data"`
+}
+
+//BlockHeader 孤块的区块头
+func (b *JournalBlock) BlockHeader(symbol string) *openwallet.BlockHeader {
+	return &openwallet.BlockHeader{
+		Hash:              b.Hash,
+		Previousblockhash: b.PrevHash,
+		Height:            b.Height,
+		Time:              b.Time,
+		Symbol:            symbol,
+	}
+}
+
+//digest 按sourceKey和交易单排序后计算提取结果的摘要
+func (b *JournalBlock) digest() string {
+	keys := make([]string, 0, len(b.Records))
+	for _, r := range b.Records {
+		data, _ := json.Marshal(r.Data)
+		keys = append(keys, r.SourceKey+":"+string(data))
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//ReorgJournal 重组日志，记录每个高度已扫描的区块hash和通知过的提取结果
+//区块重组时可以向前找到与主链相同的区块，并把孤块的提取结果回滚给观察者
+type ReorgJournal struct {
+	dbFile string
+	depth  uint64
+	mu     sync.Mutex
+	db     *storm.DB
+}
+
+//NewReorgJournal 创建重组日志，depth为保留的区块数量
+func NewReorgJournal(dbFile string, depth uint64) *ReorgJournal {
+	return &ReorgJournal{
+		dbFile: dbFile,
+		depth:  depth,
+	}
+}
+
+//open 打开数据库，调用者需要持有锁
+func (j *ReorgJournal) open() (*storm.DB, error) {
+	if j.db != nil {
+		return j.db, nil
+	}
+	file.MkdirAll(filepath.Dir(j.dbFile))
+	db, err := storm.Open(j.dbFile)
+	if err != nil {
+		return nil, err
+	}
+	j.db = db
+	return db, nil
+}
+
+//Close 关闭数据库
+func (j *ReorgJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.db == nil {
+		return nil
+	}
+	err := j.db.Close()
+	j.db = nil
+	return err
+}
+
+//GetBlock 获取高度的区块记录，没有记录时返回storm.ErrNotFound
+func (j *ReorgJournal) GetBlock(height uint64) (*JournalBlock, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return nil, err
+	}
+	var b JournalBlock
+	if err := db.One("Height", height, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+//LastCommittedBlock 最新的已完成区块，作为扫描的检查点
+func (j *ReorgJournal) LastCommittedBlock() (*JournalBlock, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return nil, err
+	}
+	var b JournalBlock
+	if err := db.Select(q.Eq("Committed", true)).OrderBy("Height").Reverse().First(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+//BeginBlock 开始扫描区块，相同hash的区块重扫时保留已记录的提取结果
+func (j *ReorgJournal) BeginBlock(block *Block) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	var b JournalBlock
+	if err := db.One("Height", block.Height, &b); err == nil && b.Hash == block.Hash {
+		return nil
+	}
+	b = JournalBlock{
+		Height:   block.Height,
+		Hash:     block.Hash,
+		PrevHash: block.Previousblockhash,
+		Time:     block.Time,
+		Records:  make([]*JournalRecord, 0),
+	}
+	b.Digest = b.digest()
+	return db.Save(&b)
+}
+
+//AppendRecords 记录已通知的提取结果，hash为空时记录到该高度已有的区块
+//同一sourceKey的相同交易单只记录一次
+func (j *ReorgJournal) AppendRecords(height uint64, hash string, extractData map[string]*openwallet.TxExtractData) error {
+	if len(extractData) == 0 {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	var b JournalBlock
+	if err := db.One("Height", height, &b); err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if len(hash) > 0 && b.Hash != hash {
+		return nil
+	}
+
+	exists := make(map[string]bool)
+	for _, r := range b.Records {
+		exists[journalRecordKey(r.SourceKey, r.Data)] = true
+	}
+	for sourceKey, data := range extractData {
+		key := journalRecordKey(sourceKey, data)
+		if exists[key] {
+			continue
+		}
+		exists[key] = true
+		b.Records = append(b.Records, &JournalRecord{SourceKey: sourceKey, Data: data})
+	}
+	b.Digest = b.digest()
+	return db.Save(&b)
+}
+
+//CommitBlock 区块扫描完成，清理超出保留数量的旧区块
+func (j *ReorgJournal) CommitBlock(height uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateField(&JournalBlock{Height: height}, "Committed", true); err != nil {
+		return err
+	}
+	if j.depth > 0 && height > j.depth {
+		err = db.Select(q.Lt("Height", height-j.depth)).Delete(new(JournalBlock))
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+//DeleteBlock 删除孤块的记录
+func (j *ReorgJournal) DeleteBlock(height uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	db, err := j.open()
+	if err != nil {
+		return err
+	}
+	err = db.DeleteStruct(&JournalBlock{Height: height})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//journalRecordKey 提取结果的唯一标识
+func journalRecordKey(sourceKey string, data *openwallet.TxExtractData) string {
+	if data != nil && data.Transaction != nil {
+		return sourceKey + "_" + data.Transaction.WxID
+	}
+	raw, _ := json.Marshal(data)
+	return sourceKey + "_" + string(raw)
+}
+
+//reorgJournal 重组日志保存在数据目录，加载配置后才能确定路径
+func (bs *BTCBlockScanner) reorgJournal() *ReorgJournal {
+	bs.journalOnce.Do(func() {
+		bs.journal = NewReorgJournal(filepath.Join(bs.wm.Config.dbPath, reorgJournalFileName), bs.wm.Config.ReorgJournalDepth)
+	})
+	return bs.journal
+}
+
+//journalExtractData 记录已通知给观察者的提取结果
+func (bs *BTCBlockScanner) journalExtractData(height uint64, hash string, extractData map[string]*openwallet.TxExtractData) {
+	if err := bs.reorgJournal().AppendRecords(height, hash, extractData); err != nil {
+		bs.wm.Log.Std.Error("block height: %d, write reorg journal failed. unexpected error: %v", height, err)
+	}
+}
+
+//rollbackForkBlocks 从本地高度向前对比主链的区块hash，回滚所有孤块，返回共同的祖先区块
+//每回滚一个区块都保存新的扫描高度，中断后可以继续回滚
+func (bs *BTCBlockScanner) rollbackForkBlocks(localHeight uint64) (*Block, error) {
+
+	height := localHeight
+	for height > 1 {
+
+		forkBlock, err := bs.reorgJournal().GetBlock(height)
+		if err != nil {
+			//日志没有记录时使用本地保存的区块
+			localBlock, err := bs.GetLocalBlock(height)
+			if err != nil {
+				bs.wm.Log.Std.Info("block height: %d has no local record, stop looking for the common ancestor.", height)
+				break
+			}
+			forkBlock = &JournalBlock{
+				Height:   localBlock.Height,
+				Hash:     localBlock.Hash,
+				PrevHash: localBlock.Previousblockhash,
+				Time:     localBlock.Time,
+			}
+		}
+
+		chainHash, err := bs.wm.GetBlockHash(height)
+		if err != nil {
+			return nil, err
+		}
+		if chainHash == forkBlock.Hash {
+			break
+		}
+
+		bs.wm.Log.Std.Info("rollback fork block on height: %d, hash: %s .", forkBlock.Height, forkBlock.Hash)
+		bs.rollbackJournalBlock(forkBlock)
+
+		height--
+		if len(forkBlock.PrevHash) > 0 {
+			bs.SaveLocalNewBlock(height, forkBlock.PrevHash)
+		}
+	}
+
+	hash, err := bs.wm.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+
+	//重新记录一个新扫描起点
+	bs.SaveLocalNewBlock(height, hash)
+
+	return &Block{Height: height, Hash: hash}, nil
+}
+
+//rollbackJournalBlock 通知孤块及其中已通知的提取结果，删除孤块的记录
+func (bs *BTCBlockScanner) rollbackJournalBlock(forkBlock *JournalBlock) {
+
+	//通知分叉区块给观测者
+	header := forkBlock.BlockHeader(bs.wm.Symbol())
+	header.Fork = true
+	bs.NewBlockNotify(header)
+
+	bs.newRollbackNotify(forkBlock.Height, forkBlock.Records)
+
+	//删除孤块的未扫记录
+	bs.DeleteUnscanRecord(forkBlock.Height)
+
+	if err := bs.reorgJournal().DeleteTrackedTxs(forkBlock.Height); err != nil {
+		bs.wm.Log.Std.Error("block height: %d, delete tracked transactions failed. unexpected error: %v", forkBlock.Height, err)
+	}
+
+	if err := bs.reorgJournal().DeleteBlock(forkBlock.Height); err != nil {
+		bs.wm.Log.Std.Error("block height: %d, delete reorg journal failed. unexpected error: %v", forkBlock.Height, err)
+	}
+}
+
+//newRollbackNotify 孤块中的提取结果通知给实现了ReorgNotificationObject的观察者
+func (bs *BTCBlockScanner) newRollbackNotify(height uint64, records []*JournalRecord) {
+
+	for o, _ := range bs.Observers {
+		rollback, ok := o.(ReorgNotificationObject)
+		if !ok {
+			continue
+		}
+		for _, r := range records {
+			err := rollback.BlockExtractDataRollbackNotify(r.SourceKey, r.Data)
+			if err != nil {
+				bs.wm.Log.Std.Error("block height: %d, BlockExtractDataRollbackNotify unexpected error: %v", height, err)
+			}
+		}
+	}
+}
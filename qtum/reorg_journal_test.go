@@ -0,0 +1,181 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+//fundBlocks 每个区块给地址转账一次，返回按高度排列的txid
+func fundBlocks(t *testing.T, chain *SimChain, address string, blocks int) []string {
+	txids := make([]string, 0, blocks)
+	for i := 0; i < blocks; i++ {
+		txid, err := chain.Fund(address, decimal.New(int64(i+1), 0))
+		if err != nil {
+			t.Fatalf("Fund failed: %v", err)
+		}
+		chain.Mine(1)
+		txids = append(txids, txid)
+	}
+	return txids
+}
+
+func sortedTxIDs(txids []string) []string {
+	list := append([]string{}, txids...)
+	sort.Strings(list)
+	return list
+}
+
+func TestRollbackForkBlocks_DeepReorg(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	w := newSimWallet(t, "reorg")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, observer := newSimScanner(t, wm, chain, w)
+
+	//高度2-5各有一笔充值
+	deposits := fundBlocks(t, chain, addr.Address, 4)
+	bs.ScanBlockTask()
+	if !reflect.DeepEqual(observer.extracted, deposits) {
+		t.Fatalf("extracted: %v, want: %v", observer.extracted, deposits)
+	}
+
+	//回滚3个区块，孤块的交易单重新打包在高度3，主链延长到高度6
+	if err := chain.Rollback(3); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	chain.Mine(4)
+	observer.reset()
+	bs.ScanBlockTask()
+
+	if got, want := sortedTxIDs(observer.rollbacks), sortedTxIDs(deposits[1:]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rollbacks: %v, want: %v", got, want)
+	}
+	if got, want := sortedTxIDs(observer.extracted), sortedTxIDs(deposits[1:]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("extracted after reorg: %v, want: %v", got, want)
+	}
+
+	//重组日志与新的主链一致
+	for height := uint64(2); height <= 6; height++ {
+		block, err := bs.reorgJournal().GetBlock(height)
+		if err != nil {
+			t.Fatalf("journal block: %d not found: %v", height, err)
+		}
+		hash, _ := chain.GetBlockHash(height)
+		if block.Hash != hash || !block.Committed {
+			t.Fatalf("journal block: %d hash: %s, want: %s committed", height, block.Hash, hash)
+		}
+		records := 0
+		switch height {
+		case 2:
+			records = 1
+		case 3:
+			records = 3
+		}
+		if len(block.Records) != records {
+			t.Fatalf("journal block: %d records: %d, want: %d", height, len(block.Records), records)
+		}
+	}
+
+	header, err := bs.GetScannedBlockHeader()
+	if err != nil {
+		t.Fatalf("GetScannedBlockHeader failed: %v", err)
+	}
+	if hash, _ := chain.GetBlockHash(6); header.Height != 6 || header.Hash != hash {
+		t.Fatalf("scanned block: %d %s, want: 6 %s", header.Height, header.Hash, hash)
+	}
+}
+
+func TestRollbackForkBlocks_RestartMidRollback(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	w := newSimWallet(t, "reorg")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, _ := newSimScanner(t, wm, chain, w)
+
+	deposits := fundBlocks(t, chain, addr.Address, 4)
+	bs.ScanBlockTask()
+
+	if err := chain.Rollback(3); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	chain.Mine(4)
+
+	//回滚高度5的孤块并保存检查点后中断
+	forkBlock, err := bs.reorgJournal().GetBlock(5)
+	if err != nil {
+		t.Fatalf("journal block: 5 not found: %v", err)
+	}
+	bs.rollbackJournalBlock(forkBlock)
+	bs.SaveLocalNewBlock(4, forkBlock.PrevHash)
+
+	bs, observer := restartSimScanner(t, bs)
+	header, err := bs.GetScannedBlockHeader()
+	if err != nil {
+		t.Fatalf("GetScannedBlockHeader failed: %v", err)
+	}
+	if header.Height != 4 || header.Hash != forkBlock.PrevHash {
+		t.Fatalf("checkpoint: %d %s, want: 4 %s", header.Height, header.Hash, forkBlock.PrevHash)
+	}
+
+	//重启后只回滚剩下的孤块
+	bs.ScanBlockTask()
+	if got, want := sortedTxIDs(observer.rollbacks), sortedTxIDs(deposits[1:3]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rollbacks after restart: %v, want: %v", got, want)
+	}
+	if got, want := sortedTxIDs(observer.extracted), sortedTxIDs(deposits[1:]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("extracted after restart: %v, want: %v", got, want)
+	}
+	if height := bs.GetScannedBlockHeight(); height != 6 {
+		t.Fatalf("scanned height: %d, want: 6", height)
+	}
+}
+
+func TestGetScannedBlockHeader_JournalCheckpoint(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	w := newSimWallet(t, "reorg")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, _ := newSimScanner(t, wm, chain, w)
+
+	fundBlocks(t, chain, addr.Address, 2)
+	bs.ScanBlockTask()
+
+	//区块已完成但保存本地高度前中断，重启后不重复通知
+	hash, _ := chain.GetBlockHash(2)
+	bs.SaveLocalNewBlock(2, hash)
+	bs, observer := restartSimScanner(t, bs)
+	header, err := bs.GetScannedBlockHeader()
+	if err != nil {
+		t.Fatalf("GetScannedBlockHeader failed: %v", err)
+	}
+	if header.Height != 3 {
+		t.Fatalf("checkpoint: %d, want: 3", header.Height)
+	}
+
+	chain.Mine(1)
+	bs.ScanBlockTask()
+	if len(observer.extracted) != 0 || len(observer.rollbacks) != 0 {
+		t.Fatalf("committed blocks should not be notified again, extracted: %v, rollbacks: %v", observer.extracted, observer.rollbacks)
+	}
+	if height := bs.GetScannedBlockHeight(); height != 4 {
+		t.Fatalf("scanned height: %d, want: 4", height)
+	}
+}
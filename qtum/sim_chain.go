@@ -0,0 +1,949 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/abi"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	//模拟链创世区块发给水龙头的数量，Fund从水龙头转出
+	SimFaucetAmount = uint64(100000000) * 100000000
+	//模拟链合约调用固定消耗的gas
+	SimContractGasUsed = uint64(36236)
+	//模拟链默认的每KB手续费率
+	SimDefaultFeeRate = "0.004"
+)
+
+const (
+	simExceptedNone     = "None"
+	simExceptedRevert   = "Revert"
+	simExceptedOutOfGas = "OutOfGasBase"
+)
+
+//simCoin 模拟链的未花输出
+type simCoin struct {
+	txid    string
+	n       uint64
+	address string
+	script  string
+	value   uint64
+}
+
+//simTx 模拟链的交易单
+type simTx struct {
+	raw         []byte
+	block       *simBlock //未上链时为空
+	spent       []*simCoin
+	outputs     []*simCoin
+	fee         uint64
+	replaceable bool
+	receipts    []*simReceipt
+}
+
+//simBlock 模拟链的区块
+type simBlock struct {
+	hash   string
+	prev   string
+	height uint64
+	time   int64
+	txids  []string
+	undo   []func() //回滚区块时按倒序撤销合约状态的修改
+}
+
+//simReceipt 与gettransactionreceipt的结果格式一致
+type simReceipt struct {
+	BlockHash         string    `json:"blockHash"`
+	BlockNumber       uint64    `json:"blockNumber"`
+	TransactionHash   string    `json:"transactionHash"`
+	TransactionIndex  int       `json:"transactionIndex"`
+	OutputIndex       uint64    `json:"outputIndex"`
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	CumulativeGasUsed uint64    `json:"cumulativeGasUsed"`
+	GasUsed           uint64    `json:"gasUsed"`
+	ContractAddress   string    `json:"contractAddress"`
+	Excepted          string    `json:"excepted"`
+	Log               []*simLog `json:"log"`
+}
+
+type simLog struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+//SimChain 内存中的模拟链，实现ChainBackend，不需要节点就可以驱动区块扫描和交易单构建
+//支持出块、UTXO、QRC20余额、QRC721拥有者和广播交易，合约只模拟代币的查询和转账，不模拟gas退款
+//使用时设置WalletManager.Backend，params需要与钱包配置的网络一致
+type SimChain struct {
+	mu            sync.RWMutex
+	params        *ChainParams
+	addressPrefix btcLikeTxDriver.AddressPrefix
+	faucet        string //水龙头地址
+	faucetScript  []byte
+	faucetCoin    string //水龙头当前的未花输出
+	blocks        []*simBlock
+	txs           map[string]*simTx
+	coins         map[string]*simCoin
+	mempool       []string
+	qrc20         map[string]map[abi.Address]*big.Int //合约地址 -> 持有者 -> 余额
+	qrc721        map[string]map[string]abi.Address   //合约地址 -> tokenId -> 拥有者
+	feeRate       decimal.Decimal
+	nonce         uint64
+}
+
+//NewSimChain 创建模拟链，创世区块的coinbase发给水龙头
+func NewSimChain(params *ChainParams) *SimChain {
+	c := &SimChain{
+		params:        params,
+		addressPrefix: params.AddressPrefix(),
+		txs:           make(map[string]*simTx),
+		coins:         make(map[string]*simCoin),
+		mempool:       make([]string, 0),
+		qrc20:         make(map[string]map[abi.Address]*big.Int),
+		qrc721:        make(map[string]map[string]abi.Address),
+	}
+	c.feeRate, _ = decimal.NewFromString(SimDefaultFeeRate)
+
+	hash := owcrypt.Hash([]byte("qtum-adapter simulated chain faucet"), 0, owcrypt.HASH_ALG_HASH160)
+	c.faucet = btcLikeTxDriver.EncodeCheck(c.addressPrefix.P2PKHPrefix, hash)
+	c.faucetScript, _ = hex.DecodeString(fmt.Sprintf("76a914%x88ac", hash))
+
+	genesis := c.mineBlock(SimFaucetAmount)
+	c.faucetCoin = outPoint(genesis.txids[0], 0)
+	return c
+}
+
+//Mine 打包交易池的交易单，产出n个区块，返回区块hash
+func (c *SimChain) Mine(n int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hashes = append(hashes, c.mineBlock(0).hash)
+	}
+	return hashes
+}
+
+//Rollback 回滚最新的n个区块，区块中的交易单回到交易池，用于模拟区块重组
+func (c *SimChain) Rollback(n int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n >= len(c.blocks) {
+		return fmt.Errorf("can not rollback %d blocks, chain height is %d", n, len(c.blocks)-1)
+	}
+
+	for i := 0; i < n; i++ {
+		block := c.blocks[len(c.blocks)-1]
+		for j := len(block.undo) - 1; j >= 0; j-- {
+			block.undo[j]()
+		}
+
+		pending := make([]string, 0, len(block.txids))
+		for j, txid := range block.txids {
+			stx := c.txs[txid]
+			if j == 0 {
+				//coinbase随区块删除
+				for _, coin := range stx.outputs {
+					delete(c.coins, outPoint(coin.txid, coin.n))
+				}
+				delete(c.txs, txid)
+				continue
+			}
+			stx.block = nil
+			stx.receipts = nil
+			pending = append(pending, txid)
+		}
+		c.mempool = append(pending, c.mempool...)
+		c.blocks = c.blocks[:len(c.blocks)-1]
+	}
+
+	return nil
+}
+
+//Fund 从水龙头转账给地址，交易单进入交易池，返回txid
+func (c *SimChain) Fund(address string, amount decimal.Decimal) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value := amount.Shift(8).IntPart()
+	if value <= 0 {
+		return "", fmt.Errorf("invalid amount: %s", amount.String())
+	}
+
+	coin := c.coins[c.faucetCoin]
+	if coin == nil || coin.value < uint64(value) {
+		return "", fmt.Errorf("simulated chain faucet is insufficient")
+	}
+
+	vouts := []btcLikeTxDriver.Vout{{Address: address, Amount: uint64(value)}}
+	if change := coin.value - uint64(value); change > 0 {
+		vouts = append(vouts, btcLikeTxDriver.Vout{Address: c.faucet, Amount: change})
+	}
+
+	txHex, err := btcLikeTxDriver.CreateEmptyRawTransaction([]btcLikeTxDriver.Vin{{TxID: coin.txid, Vout: uint32(coin.n)}}, vouts, 0, false, c.addressPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	txid, err := c.acceptTx(txHex)
+	if err != nil {
+		return "", err
+	}
+	c.faucetCoin = outPoint(txid, 1)
+	return txid, nil
+}
+
+//SetQRC20Balance 设置地址的QRC20余额，value为最小单位，合约不存在时创建
+func (c *SimChain) SetQRC20Balance(contractAddress, address string, value *big.Int) error {
+	holder, err := qrc20AddressArg(address, c.params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := simContractKey(contractAddress)
+	if _, exist := c.qrc721[key]; exist {
+		return fmt.Errorf("contract: %s is a QRC721 contract", contractAddress)
+	}
+	if c.qrc20[key] == nil {
+		c.qrc20[key] = make(map[abi.Address]*big.Int)
+	}
+	c.setQRC20Balance(key, holder, new(big.Int).Set(value), nil)
+	return nil
+}
+
+//SetQRC721Owner 设置tokenId的拥有者，合约不存在时创建
+func (c *SimChain) SetQRC721Owner(contractAddress, tokenID, address string) error {
+	id, err := parseTokenID(tokenID)
+	if err != nil {
+		return err
+	}
+	owner, err := qrc20AddressArg(address, c.params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := simContractKey(contractAddress)
+	if _, exist := c.qrc20[key]; exist {
+		return fmt.Errorf("contract: %s is a QRC20 contract", contractAddress)
+	}
+	if c.qrc721[key] == nil {
+		c.qrc721[key] = make(map[string]abi.Address)
+	}
+	c.setQRC721Owner(key, id, owner, nil)
+	return nil
+}
+
+//SetFeeRate 设置EstimateFeeRate返回的每KB手续费率
+func (c *SimChain) SetFeeRate(feeRate decimal.Decimal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.feeRate = feeRate
+}
+
+//GetBlockHeight 获取区块链高度
+func (c *SimChain) GetBlockHeight() (uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tip(), nil
+}
+
+//GetBlockHash 根据区块高度获得区块hash
+func (c *SimChain) GetBlockHash(height uint64) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if height > c.tip() {
+		return "", fmt.Errorf("block height out of range")
+	}
+	return c.blocks[height].hash, nil
+}
+
+//GetBlock 获取区块数据
+func (c *SimChain) GetBlock(hash string) (*Block, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		block := c.blocks[i]
+		if block.hash != hash {
+			continue
+		}
+		return &Block{
+			Hash:              block.hash,
+			Confirmations:     c.tip() - block.height + 1,
+			tx:                append([]string{}, block.txids...),
+			Previousblockhash: block.prev,
+			Height:            block.height,
+			Version:           uint64(btcLikeTxDriver.DefaultTxVersion),
+			Time:              uint64(block.time),
+		}, nil
+	}
+	return nil, fmt.Errorf("block not found")
+}
+
+//GetTxIDsInMemPool 获取交易池中的交易单IDs
+func (c *SimChain) GetTxIDsInMemPool() ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string{}, c.mempool...), nil
+}
+
+//GetTransaction 获取交易单，结果与getrawtransaction一致
+func (c *SimChain) GetTransaction(txid string) (*Transaction, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stx := c.txs[txid]
+	if stx == nil {
+		return nil, &TxNotFoundError{TxID: txid}
+	}
+
+	trx, err := newTxByRaw(stx.raw, c.addressPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if stx.block != nil {
+		trx.BlockHash = stx.block.hash
+		trx.BlockHeight = stx.block.height
+		trx.Confirmations = c.tip() - stx.block.height + 1
+		trx.Blocktime = stx.block.time
+	}
+	return trx, nil
+}
+
+//CompleteTransaction 按上链时记录的合约回执补充代币交易
+func (c *SimChain) CompleteTransaction(trx *Transaction, isCoinstake bool) error {
+	c.mu.RLock()
+	stx := c.txs[trx.TxID]
+	if stx == nil || stx.block == nil || len(stx.receipts) == 0 {
+		c.mu.RUnlock()
+		return nil
+	}
+	data, err := json.Marshal(stx.receipts)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	result := gjson.ParseBytes(data)
+	trx.TokenReceipts = make([]*TokenReceipt, 0)
+	trx.ContractReceipts = make([]*ContractReceipt, 0)
+	trx.applyContractReceipts(newContractReceiptsByCore(&result, c.params), c.params, 8)
+	return nil
+}
+
+//GetTxOut 获取未花的交易单输出
+func (c *SimChain) GetTxOut(txid string, vout uint64) (*Vout, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	coin := c.coins[outPoint(txid, vout)]
+	if coin == nil {
+		return nil, fmt.Errorf("txout %s not found or spent", outPoint(txid, vout))
+	}
+	script, _ := hex.DecodeString(coin.script)
+	return &Vout{
+		N:            coin.n,
+		Addr:         coin.address,
+		Value:        decimal.New(int64(coin.value), -8).String(),
+		ScriptPubKey: coin.script,
+		Type:         rawScriptType(script),
+	}, nil
+}
+
+//ListUnspent 获取未花记录，包括交易池中的输出，addresses为空时返回全部
+func (c *SimChain) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	filter := make(map[string]bool)
+	for _, address := range addresses {
+		filter[address] = true
+	}
+
+	utxos := make([]*Unspent, 0)
+	for _, coin := range c.coins {
+		if len(filter) > 0 && !filter[coin.address] {
+			continue
+		}
+		confirmations := uint64(0)
+		if block := c.txs[coin.txid].block; block != nil {
+			confirmations = c.tip() - block.height + 1
+		}
+		if confirmations < min {
+			continue
+		}
+		utxos = append(utxos, &Unspent{
+			TxID:          coin.txid,
+			Vout:          coin.n,
+			Address:       coin.address,
+			ScriptPubKey:  coin.script,
+			Amount:        decimal.New(int64(coin.value), -8).String(),
+			Confirmations: confirmations,
+			Spendable:     true,
+			Solvable:      true,
+		})
+	}
+
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].TxID != utxos[j].TxID {
+			return utxos[i].TxID < utxos[j].TxID
+		}
+		return utxos[i].Vout < utxos[j].Vout
+	})
+	return utxos, nil
+}
+
+//ImportAddress 模拟链可以直接查询任意地址
+func (c *SimChain) ImportAddress(address string) error {
+	return nil
+}
+
+//SendRawTransaction 广播交易，不校验签名
+//输入必须未花费，与交易池冲突时按BIP125替换标记了可替换且手续费更低的交易单
+func (c *SimChain) SendRawTransaction(txHex string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.acceptTx(txHex)
+}
+
+//EstimateFeeRate 预估的每KB手续费率
+func (c *SimChain) EstimateFeeRate() (decimal.Decimal, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.feeRate, nil
+}
+
+//CallContract 试运行合约调用，结果与callcontract一致
+func (c *SimChain) CallContract(contractAddress, dataHex, from string) (*QRC20Unspent, error) {
+
+	data, err := hex.DecodeString(strings.TrimPrefix(dataHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %s", dataHex)
+	}
+
+	var sender abi.Address
+	if len(from) > 0 {
+		sender, err = qrc20AddressArg(from, c.params)
+		if err != nil {
+			if sender, err = abi.HexToAddress(from); err != nil {
+				return nil, fmt.Errorf("invalid sender: %s", from)
+			}
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key := simContractKey(contractAddress)
+	if c.qrc20[key] == nil && c.qrc721[key] == nil {
+		return nil, fmt.Errorf("contract: %s does not exist", contractAddress)
+	}
+
+	obj := &QRC20Unspent{
+		Address:  key,
+		GasUsed:  fmt.Sprintf("%d", SimContractGasUsed),
+		Excepted: simExceptedNone,
+	}
+	output, _, ok := c.call(key, sender, data, nil)
+	if !ok {
+		obj.Excepted = simExceptedRevert
+	}
+	obj.Output = hex.EncodeToString(output)
+	return obj, nil
+}
+
+//GetQRC20Balance 获取地址的QRC20余额
+func (c *SimChain) GetQRC20Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	holder, err := qrc20AddressArg(address, c.params)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	balance := c.qrc20[simContractKey(token.Address)][holder]
+	if balance == nil {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromBigInt(balance, -int32(token.Decimals)), nil
+}
+
+//GetQRC721Balance 获取地址拥有的QRC721数量
+func (c *SimChain) GetQRC721Balance(token openwallet.SmartContract, address string) (decimal.Decimal, error) {
+	holder, err := qrc20AddressArg(address, c.params)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return decimal.New(c.qrc721Count(simContractKey(token.Address), holder), 0), nil
+}
+
+//tip 最新区块的高度
+func (c *SimChain) tip() uint64 {
+	return uint64(len(c.blocks) - 1)
+}
+
+//mineBlock 产出新区块，coinbase的奖励发给水龙头，交易池的交易单按进入的顺序上链并执行合约调用
+func (c *SimChain) mineBlock(reward uint64) *simBlock {
+
+	c.nonce++
+	block := &simBlock{
+		height: uint64(len(c.blocks)),
+		time:   time.Now().Unix(),
+		txids:  make([]string, 0, len(c.mempool)+1),
+	}
+	if block.height > 0 {
+		block.prev = c.blocks[block.height-1].hash
+	}
+
+	coinbase := simCoinbase(block.height, c.faucetScript, reward)
+	trx, _ := newTxByRaw(coinbase, c.addressPrefix)
+	c.addTx(&simTx{raw: coinbase, block: block}, trx)
+	block.txids = append(block.txids, trx.TxID)
+	block.txids = append(block.txids, c.mempool...)
+	c.mempool = make([]string, 0)
+
+	seed := make([]byte, 16)
+	binary.LittleEndian.PutUint64(seed, block.height)
+	binary.LittleEndian.PutUint64(seed[8:], c.nonce)
+	seed = append(seed, []byte(block.prev+strings.Join(block.txids, ""))...)
+	block.hash = hex.EncodeToString(owcrypt.Hash(seed, 0, owcrypt.HASH_ALG_DOUBLE_SHA256))
+
+	for i, txid := range block.txids[1:] {
+		stx := c.txs[txid]
+		stx.block = block
+		c.executeContracts(txid, i+1, stx, block)
+	}
+
+	c.blocks = append(c.blocks, block)
+	return block
+}
+
+//acceptTx 交易单进入交易池
+func (c *SimChain) acceptTx(txHex string) (string, error) {
+
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return "", fmt.Errorf("TX decode failed")
+	}
+	trx, err := newTxByRaw(txBytes, c.addressPrefix)
+	if err != nil {
+		return "", fmt.Errorf("TX decode failed")
+	}
+	if _, exist := c.txs[trx.TxID]; exist {
+		return "", fmt.Errorf("txn-already-known")
+	}
+	if trx.IsCoinBase {
+		return "", fmt.Errorf("coinbase")
+	}
+
+	var (
+		in        uint64
+		out       uint64
+		conflicts = make(map[string]bool)
+	)
+	for _, vin := range trx.Vins {
+		coin, spender := c.prevCoin(outPoint(vin.TxID, vin.Vout))
+		if coin == nil {
+			return "", fmt.Errorf("bad-txns-inputs-missingorspent")
+		}
+		if len(spender) > 0 {
+			conflicts[spender] = true
+		}
+		in += coin.value
+	}
+	for _, vout := range trx.Vouts {
+		value, _ := decimal.NewFromString(vout.Value)
+		out += uint64(value.Shift(8).IntPart())
+	}
+	if in < out {
+		return "", fmt.Errorf("bad-txns-in-belowout")
+	}
+
+	if len(conflicts) > 0 {
+		var replacedFee uint64
+		for txid := range conflicts {
+			if !c.txs[txid].replaceable {
+				return "", fmt.Errorf("txn-mempool-conflict")
+			}
+			replacedFee += c.txs[txid].fee
+		}
+		if in-out <= replacedFee {
+			return "", fmt.Errorf("insufficient fee")
+		}
+		for txid := range conflicts {
+			c.evict(txid)
+		}
+	}
+
+	stx := &simTx{raw: txBytes, fee: in - out}
+	stx.replaceable, _ = btcLikeTxDriver.IsReplaceable(txHex)
+	for _, vin := range trx.Vins {
+		key := outPoint(vin.TxID, vin.Vout)
+		coin := c.coins[key]
+		if coin == nil {
+			return "", fmt.Errorf("bad-txns-inputs-missingorspent")
+		}
+		stx.spent = append(stx.spent, coin)
+	}
+	for _, coin := range stx.spent {
+		delete(c.coins, outPoint(coin.txid, coin.n))
+	}
+	c.addTx(stx, trx)
+	c.mempool = append(c.mempool, trx.TxID)
+	return trx.TxID, nil
+}
+
+//addTx 记录交易单，有地址和金额的输出加入未花记录
+func (c *SimChain) addTx(stx *simTx, trx *Transaction) {
+	for _, vout := range trx.Vouts {
+		value, _ := decimal.NewFromString(vout.Value)
+		if len(vout.Addr) == 0 || !value.IsPositive() {
+			continue
+		}
+		coin := &simCoin{
+			txid:    trx.TxID,
+			n:       vout.N,
+			address: vout.Addr,
+			script:  vout.ScriptPubKey,
+			value:   uint64(value.Shift(8).IntPart()),
+		}
+		stx.outputs = append(stx.outputs, coin)
+		c.coins[outPoint(coin.txid, coin.n)] = coin
+	}
+	c.txs[trx.TxID] = stx
+}
+
+//prevCoin 查找输入花费的输出，已被交易池的交易单花费时同时返回该交易单
+func (c *SimChain) prevCoin(key string) (*simCoin, string) {
+	if coin := c.coins[key]; coin != nil {
+		return coin, ""
+	}
+	for _, txid := range c.mempool {
+		for _, coin := range c.txs[txid].spent {
+			if outPoint(coin.txid, coin.n) == key {
+				return coin, txid
+			}
+		}
+	}
+	return nil, ""
+}
+
+//evict 从交易池驱逐交易单及其子交易，恢复花费的输出
+func (c *SimChain) evict(txid string) {
+	stx := c.txs[txid]
+	if stx == nil || stx.block != nil {
+		return
+	}
+
+	for _, child := range append([]string{}, c.mempool...) {
+		for _, coin := range c.txs[child].spent {
+			if coin.txid == txid {
+				c.evict(child)
+				break
+			}
+		}
+	}
+
+	for _, coin := range stx.outputs {
+		delete(c.coins, outPoint(coin.txid, coin.n))
+	}
+	for _, coin := range stx.spent {
+		c.coins[outPoint(coin.txid, coin.n)] = coin
+	}
+	delete(c.txs, txid)
+	for i, id := range c.mempool {
+		if id == txid {
+			c.mempool = append(c.mempool[:i], c.mempool[i+1:]...)
+			break
+		}
+	}
+}
+
+//executeContracts 执行交易单的合约调用，生成回执
+//调用者为第一个输入的P2PKH地址，执行失败的调用没有日志
+func (c *SimChain) executeContracts(txid string, index int, stx *simTx, block *simBlock) {
+
+	trx, err := newTxByRaw(stx.raw, c.addressPrefix)
+	if err != nil || !trx.HasContractCall() {
+		return
+	}
+
+	var sender abi.Address
+	if len(stx.spent) > 0 {
+		script, _ := hex.DecodeString(stx.spent[0].script)
+		if rawScriptType(script) == "pubkeyhash" {
+			copy(sender[:], script[3:23])
+		}
+	}
+
+	cumulativeGasUsed := uint64(0)
+	for _, vout := range trx.Vouts {
+		script, err := btcLikeTxDriver.DecodeContractLockScript(vout.ScriptPubKey)
+		if err != nil || script.Create {
+			continue
+		}
+
+		gasUsed := SimContractGasUsed
+		if script.GasLimit < gasUsed {
+			gasUsed = script.GasLimit
+		}
+		cumulativeGasUsed += gasUsed
+
+		key := simContractKey(script.ContractAddr)
+		receipt := &simReceipt{
+			BlockHash:         block.hash,
+			BlockNumber:       block.height,
+			TransactionHash:   txid,
+			TransactionIndex:  index,
+			OutputIndex:       vout.N,
+			From:              sender.Hex(),
+			To:                key,
+			CumulativeGasUsed: cumulativeGasUsed,
+			GasUsed:           gasUsed,
+			ContractAddress:   key,
+			Excepted:          simExceptedNone,
+			Log:               make([]*simLog, 0),
+		}
+		//gasLimit不足时按执行失败处理
+		if gasUsed < SimContractGasUsed {
+			receipt.Excepted = simExceptedOutOfGas
+		} else if _, logs, ok := c.call(key, sender, script.CallData, block); ok {
+			receipt.Log = logs
+		} else {
+			receipt.Excepted = simExceptedRevert
+		}
+		stx.receipts = append(stx.receipts, receipt)
+	}
+}
+
+//call 执行合约调用，block为空时只试运行，不修改状态
+//只支持代币的查询和转账，其他调用按执行失败处理
+func (c *SimChain) call(key string, sender abi.Address, data []byte, block *simBlock) ([]byte, []*simLog, bool) {
+
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	selector := hex.EncodeToString(data[:4])
+
+	if balances, exist := c.qrc20[key]; exist {
+		switch selector {
+		case hex.EncodeToString(abi.QRC20BalanceOf.ID()):
+			args, err := abi.QRC20BalanceOf.UnpackInput(data)
+			if err != nil {
+				return nil, nil, false
+			}
+			balance := balances[args[0].(abi.Address)]
+			if balance == nil {
+				balance = new(big.Int)
+			}
+			return simPackOutput(abi.QRC20BalanceOf, balance)
+		case hex.EncodeToString(abi.QRC20Transfer.ID()):
+			args, err := abi.QRC20Transfer.UnpackInput(data)
+			if err != nil {
+				return nil, nil, false
+			}
+			to, value := args[0].(abi.Address), args[1].(*big.Int)
+			balance := balances[sender]
+			if balance == nil || balance.Cmp(value) < 0 {
+				return nil, nil, false
+			}
+			if block != nil {
+				c.setQRC20Balance(key, sender, new(big.Int).Sub(balance, value), block)
+				received := balances[to]
+				if received == nil {
+					received = new(big.Int)
+				}
+				c.setQRC20Balance(key, to, new(big.Int).Add(received, value), block)
+			}
+			output, _, ok := simPackOutput(abi.QRC20Transfer, true)
+			return output, []*simLog{simTransferLog(key, sender, to, value, false)}, ok
+		}
+		return nil, nil, false
+	}
+
+	if owners, exist := c.qrc721[key]; exist {
+		switch selector {
+		case hex.EncodeToString(abi.QRC721BalanceOf.ID()):
+			args, err := abi.QRC721BalanceOf.UnpackInput(data)
+			if err != nil {
+				return nil, nil, false
+			}
+			return simPackOutput(abi.QRC721BalanceOf, big.NewInt(c.qrc721Count(key, args[0].(abi.Address))))
+		case hex.EncodeToString(abi.QRC721OwnerOf.ID()):
+			args, err := abi.QRC721OwnerOf.UnpackInput(data)
+			if err != nil {
+				return nil, nil, false
+			}
+			owner, exist := owners[args[0].(*big.Int).String()]
+			if !exist {
+				return nil, nil, false
+			}
+			return simPackOutput(abi.QRC721OwnerOf, owner)
+		case hex.EncodeToString(abi.QRC721TransferFrom.ID()), hex.EncodeToString(abi.QRC721SafeTransferFrom.ID()):
+			//transferFrom和safeTransferFrom的参数相同
+			args, err := abi.Decode(abi.QRC721TransferFrom.Inputs, data[4:])
+			if err != nil {
+				return nil, nil, false
+			}
+			from, to, id := args[0].(abi.Address), args[1].(abi.Address), args[2].(*big.Int)
+			if owner, exist := owners[id.String()]; !exist || owner != from || sender != from {
+				return nil, nil, false
+			}
+			if block != nil {
+				c.setQRC721Owner(key, id, to, block)
+			}
+			return nil, []*simLog{simTransferLog(key, from, to, id, true)}, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+//setQRC20Balance 修改余额，上链的修改记录到区块用于回滚
+func (c *SimChain) setQRC20Balance(key string, holder abi.Address, value *big.Int, block *simBlock) {
+	balances := c.qrc20[key]
+	prev, exist := balances[holder]
+	balances[holder] = value
+	if block != nil {
+		block.undo = append(block.undo, func() {
+			if exist {
+				balances[holder] = prev
+			} else {
+				delete(balances, holder)
+			}
+		})
+	}
+}
+
+//setQRC721Owner 修改tokenId的拥有者，上链的修改记录到区块用于回滚
+func (c *SimChain) setQRC721Owner(key string, id *big.Int, owner abi.Address, block *simBlock) {
+	owners := c.qrc721[key]
+	tokenID := id.String()
+	prev, exist := owners[tokenID]
+	owners[tokenID] = owner
+	if block != nil {
+		block.undo = append(block.undo, func() {
+			if exist {
+				owners[tokenID] = prev
+			} else {
+				delete(owners, tokenID)
+			}
+		})
+	}
+}
+
+//qrc721Count 地址拥有的tokenId数量
+func (c *SimChain) qrc721Count(key string, holder abi.Address) int64 {
+	count := int64(0)
+	for _, owner := range c.qrc721[key] {
+		if owner == holder {
+			count++
+		}
+	}
+	return count
+}
+
+//simContractKey 合约地址统一为不带0x的小写十六进制
+func simContractKey(contractAddress string) string {
+	return strings.ToLower(strings.TrimPrefix(contractAddress, "0x"))
+}
+
+//simPackOutput 编码合约方法的返回值
+func simPackOutput(method *abi.Method, values ...interface{}) ([]byte, []*simLog, bool) {
+	output, err := abi.Encode(method.Outputs, values...)
+	if err != nil {
+		return nil, nil, false
+	}
+	return output, nil, true
+}
+
+//simTransferLog Transfer事件的日志，QRC721的tokenId为第4个topic
+func simTransferLog(key string, from, to abi.Address, value *big.Int, isQRC721 bool) *simLog {
+	log := &simLog{
+		Address: key,
+		Topics: []string{
+			hex.EncodeToString(abi.QRC20TransferEvent),
+			simWord(from[:]),
+			simWord(to[:]),
+		},
+	}
+	if isQRC721 {
+		log.Topics = append(log.Topics, simWord(value.Bytes()))
+	} else {
+		log.Data = simWord(value.Bytes())
+	}
+	return log
+}
+
+//simWord 左补零到32字节的十六进制
+func simWord(b []byte) string {
+	word := make([]byte, 32)
+	copy(word[32-len(b):], b)
+	return hex.EncodeToString(word)
+}
+
+//simCoinbase 区块的coinbase交易，输入脚本包含区块高度
+func simCoinbase(height uint64, lockScript []byte, reward uint64) []byte {
+
+	sigScript := make([]byte, 9)
+	sigScript[0] = 8
+	binary.LittleEndian.PutUint64(sigScript[1:], height)
+
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, reward)
+
+	tx := []byte{0x02, 0x00, 0x00, 0x00, 0x01}
+	tx = append(tx, make([]byte, 32)...)
+	tx = append(tx, 0xff, 0xff, 0xff, 0xff, byte(len(sigScript)))
+	tx = append(tx, sigScript...)
+	tx = append(tx, 0xff, 0xff, 0xff, 0xff, 0x01)
+	tx = append(tx, value...)
+	tx = append(tx, byte(len(lockScript)))
+	tx = append(tx, lockScript...)
+	tx = append(tx, 0x00, 0x00, 0x00, 0x00)
+	return tx
+}
@@ -0,0 +1,377 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//simWallet 测试用的钱包，地址保存在内存中
+type simWallet struct {
+	openwallet.WalletDAIBase
+	key       *hdkeystore.HDKey
+	addresses []*openwallet.Address
+}
+
+func newSimWallet(t *testing.T, name string) *simWallet {
+	seed := owcrypt.Hash([]byte(name), 0, owcrypt.HASH_ALG_SHA256)
+	key, err := hdkeystore.NewHDKey(seed, name, "m/44'/88'")
+	if err != nil {
+		t.Fatalf("NewHDKey failed: %v", err)
+	}
+	return &simWallet{key: key}
+}
+
+func (w *simWallet) HDKey(password ...string) (*hdkeystore.HDKey, error) {
+	return w.key, nil
+}
+
+func (w *simWallet) GetAddress(address string) (*openwallet.Address, error) {
+	for _, addr := range w.addresses {
+		if addr.Address == address {
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("address: %s not found", address)
+}
+
+//GetAddressList 支持AccountID和Address条件
+func (w *simWallet) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	list := make([]*openwallet.Address, 0)
+	for _, addr := range w.addresses {
+		match := true
+		for i := 0; i+1 < len(cols); i += 2 {
+			switch cols[i] {
+			case "AccountID":
+				match = match && addr.AccountID == cols[i+1]
+			case "Address":
+				match = match && addr.Address == cols[i+1]
+			}
+		}
+		if match {
+			list = append(list, addr)
+		}
+	}
+	return list, nil
+}
+
+//account 创建钱包在m/44'/88'/index'的账户，ownerKeys为其他拥有者的账户公钥
+func (w *simWallet) account(t *testing.T, index int, required uint64, ownerKeys ...string) *openwallet.AssetsAccount {
+	hdPath := fmt.Sprintf("m/44'/88'/%d'", index)
+	accountKey, err := w.key.DerivedKeyWithPath(hdPath, CurveType)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath failed: %v", err)
+	}
+	account := &openwallet.AssetsAccount{
+		HDPath:    hdPath,
+		PublicKey: accountKey.GetPublicKey().OWEncode(),
+		Required:  required,
+		Index:     uint64(index),
+	}
+	account.OwnerKeys = append([]string{account.PublicKey}, ownerKeys...)
+	account.AccountID = account.GetAccountID()
+	return account
+}
+
+//newAddress 按openwallet的规则创建账户的第index个地址
+func (w *simWallet) newAddress(t *testing.T, wm *WalletManager, account *openwallet.AssetsAccount, index int) *openwallet.Address {
+	pubkeys := make([][]byte, 0)
+	for _, ownerKey := range account.OwnerKeys {
+		pub, err := owkeychain.OWDecode(ownerKey)
+		if err != nil {
+			t.Fatalf("OWDecode failed: %v", err)
+		}
+		start, _ := pub.GenPublicChild(0)
+		child, err := start.GenPublicChild(uint32(index))
+		if err != nil {
+			t.Fatalf("GenPublicChild failed: %v", err)
+		}
+		pubkeys = append(pubkeys, child.GetPublicKeyBytes())
+	}
+	var (
+		address string
+		err     error
+	)
+	if len(pubkeys) > 1 {
+		address, err = wm.Decoder.RedeemScriptToAddress(pubkeys, account.Required, true)
+	} else {
+		address, err = wm.Decoder.PublicKeyToAddress(pubkeys[0], true)
+	}
+	if err != nil {
+		t.Fatalf("create address failed: %v", err)
+	}
+	addr := &openwallet.Address{
+		Address:   address,
+		AccountID: account.AccountID,
+		HDPath:    fmt.Sprintf("%s/0/%d", account.HDPath, index),
+		Symbol:    Symbol,
+	}
+	if len(pubkeys) == 1 {
+		addr.PublicKey = hex.EncodeToString(pubkeys[0])
+	}
+	w.addresses = append(w.addresses, addr)
+	return addr
+}
+
+//newSimWalletManager 使用模拟链的钱包管理，网络为测试网
+func newSimWalletManager() (*WalletManager, *SimChain) {
+	wm := NewWalletManager()
+	chain := NewSimChain(wm.Config.Network)
+	wm.Backend = chain
+	return wm, chain
+}
+
+//simBlockchainDAI 内存中的区块链数据，重启扫描器时可以继续使用
+type simBlockchainDAI struct {
+	openwallet.BlockchainDAIBase
+	mu      sync.Mutex
+	current *openwallet.BlockHeader
+	blocks  map[uint64]*openwallet.BlockHeader
+	unscans map[string]*openwallet.UnscanRecord
+}
+
+func newSimBlockchainDAI() *simBlockchainDAI {
+	return &simBlockchainDAI{
+		blocks:  make(map[uint64]*openwallet.BlockHeader),
+		unscans: make(map[string]*openwallet.UnscanRecord),
+	}
+}
+
+func (dai *simBlockchainDAI) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.current = header
+	return nil
+}
+
+func (dai *simBlockchainDAI) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	if dai.current == nil {
+		return nil, fmt.Errorf("current block head not found")
+	}
+	return dai.current, nil
+}
+
+func (dai *simBlockchainDAI) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.blocks[header.Height] = header
+	return nil
+}
+
+func (dai *simBlockchainDAI) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	header, ok := dai.blocks[height]
+	if !ok {
+		return nil, fmt.Errorf("block height: %d not found", height)
+	}
+	return header, nil
+}
+
+func (dai *simBlockchainDAI) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.unscans[record.ID] = record
+	return nil
+}
+
+func (dai *simBlockchainDAI) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	for id, record := range dai.unscans {
+		if record.BlockHeight == height {
+			delete(dai.unscans, id)
+		}
+	}
+	return nil
+}
+
+func (dai *simBlockchainDAI) DeleteUnscanRecordByID(id string, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	delete(dai.unscans, id)
+	return nil
+}
+
+func (dai *simBlockchainDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	list := make([]*openwallet.UnscanRecord, 0, len(dai.unscans))
+	for _, record := range dai.unscans {
+		list = append(list, record)
+	}
+	return list, nil
+}
+
+//simObserver 记录扫描器通知的观察者，提取和回滚结果记录为txid，合约回执按sourceKey记录
+type simObserver struct {
+	mu            sync.Mutex
+	extracted     []string
+	rollbacks     []string
+	confirmations []*ConfirmationEvent
+	receipts      map[string][]*openwallet.SmartContractReceipt
+	//receiptErr 不为空时合约回执通知返回的错误
+	receiptErr error
+}
+
+func (o *simObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return nil
+}
+
+func (o *simObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.extracted = append(o.extracted, data.Transaction.TxID)
+	return nil
+}
+
+func (o *simObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.receiptErr != nil {
+		return o.receiptErr
+	}
+	if o.receipts == nil {
+		o.receipts = make(map[string][]*openwallet.SmartContractReceipt)
+	}
+	o.receipts[sourceKey] = append(o.receipts[sourceKey], data)
+	return nil
+}
+
+func (o *simObserver) BlockExtractDataRollbackNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rollbacks = append(o.rollbacks, data.Transaction.TxID)
+	return nil
+}
+
+func (o *simObserver) BlockExtractDataConfirmNotify(event *ConfirmationEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.confirmations = append(o.confirmations, event)
+	return nil
+}
+
+//reset 清空已记录的通知
+func (o *simObserver) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.extracted = nil
+	o.rollbacks = nil
+	o.confirmations = nil
+	o.receipts = nil
+}
+
+//newSimScanner 扫描模拟链的扫描器，扫描钱包的地址，重组日志保存在临时目录
+//扫描起点为当前的最新区块
+func newSimScanner(t *testing.T, wm *WalletManager, chain *SimChain, wallets ...*simWallet) (*BTCBlockScanner, *simObserver) {
+	wm.Config.dbPath = t.TempDir()
+	bs, observer := setupSimScanner(t, wm, newSimBlockchainDAI(), func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		for _, w := range wallets {
+			if addr, err := w.GetAddress(target.ScanTarget); err == nil {
+				return openwallet.ScanTargetResult{SourceKey: addr.AccountID, Exist: true, TargetInfo: addr}
+			}
+		}
+		return openwallet.ScanTargetResult{}
+	})
+
+	height, _ := chain.GetBlockHeight()
+	if height == 0 {
+		chain.Mine(1)
+		height = 1
+	}
+	hash, _ := chain.GetBlockHash(height)
+	bs.SaveLocalNewBlock(height, hash)
+	return bs, observer
+}
+
+//restartSimScanner 关闭扫描器的重组日志，用相同的数据重新创建扫描器，模拟进程重启
+func restartSimScanner(t *testing.T, bs *BTCBlockScanner) (*BTCBlockScanner, *simObserver) {
+	bs.reorgJournal().Close()
+	return setupSimScanner(t, bs.wm, bs.BlockchainDAI, bs.ScanTargetFuncV2)
+}
+
+func setupSimScanner(t *testing.T, wm *WalletManager, dai openwallet.BlockchainDAI, scanTarget openwallet.BlockScanTargetFuncV2) (*BTCBlockScanner, *simObserver) {
+	bs := NewBTCBlockScanner(wm)
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockScanTargetFuncV2(scanTarget)
+	observer := &simObserver{}
+	bs.AddObserver(observer)
+	bs.Scanning = true
+	t.Cleanup(func() {
+		bs.reorgJournal().Close()
+	})
+	return bs, observer
+}
+
+func TestSimChain_ScanDepositAndReorg(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+	w := newSimWallet(t, "simchain")
+	addr := w.newAddress(t, wm, w.account(t, 0, 1), 0)
+	bs, observer := newSimScanner(t, wm, chain, w)
+
+	//出块后扫描到充值
+	txid, err := chain.Fund(addr.Address, decimal.New(5, 0))
+	if err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+	bs.ScanBlockTask()
+	if !reflect.DeepEqual(observer.extracted, []string{txid}) {
+		t.Fatalf("extracted: %v, want: %v", observer.extracted, []string{txid})
+	}
+	balances, err := bs.GetBalanceByAddress(addr.Address)
+	if err != nil || len(balances) != 1 || balances[0].Balance != "5" {
+		t.Fatalf("unexpected balance: %+v, err: %v", balances, err)
+	}
+
+	//充值所在区块被重组，交易单被替换后不再上链
+	if err := chain.Rollback(1); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	chain.mu.Lock()
+	chain.evict(txid)
+	chain.mu.Unlock()
+	chain.Mine(2)
+
+	observer.reset()
+	bs.ScanBlockTask()
+	if !reflect.DeepEqual(observer.rollbacks, []string{txid}) {
+		t.Fatalf("rollbacks: %v, want: %v", observer.rollbacks, []string{txid})
+	}
+	if len(observer.extracted) != 0 {
+		t.Fatalf("evicted deposit should not be extracted again: %v", observer.extracted)
+	}
+	if _, err := chain.GetTransaction(txid); err == nil {
+		t.Fatalf("evicted deposit: %s should not be found", txid)
+	}
+	if height := bs.GetScannedBlockHeight(); height != 3 {
+		t.Fatalf("scanned height: %d, want: 3", height)
+	}
+}
@@ -23,13 +23,15 @@ import (
 	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
 	"github.com/shopspring/decimal"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	DEFAULT_GAS_LIMIT = "250000"
-	DEFAULT_GAS_PRICE = decimal.New(4, -7)
+	DEFAULT_GAS_LIMIT        uint64 = 250000
+	DEFAULT_CREATE_GAS_LIMIT uint64 = 2500000
+	DEFAULT_GAS_PRICE               = decimal.New(4, -7)
 )
 
 type TransactionDecoder struct {
@@ -46,7 +48,9 @@ func NewTransactionDecoder(wm *WalletManager) *TransactionDecoder {
 
 //CreateRawTransaction 创建交易单
 func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
-	if rawTx.Coin.IsContract {
+	if rawTx.Coin.IsContract && isQRC721Contract(rawTx.Coin.Contract) {
+		return decoder.CreateQRC721RawTransaction(wrapper, rawTx)
+	} else if rawTx.Coin.IsContract {
 		return decoder.CreateQRC20RawTransaction(wrapper, rawTx)
 	} else {
 		return decoder.CreateSimpleRawTransaction(wrapper, rawTx)
@@ -57,15 +61,9 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
 
 	var (
-		rawTxWithErrArray []*openwallet.RawTransactionWithError
-		rawTxArray        = make([]*openwallet.RawTransaction, 0)
-		err               error
+		rawTxArray = make([]*openwallet.RawTransaction, 0)
 	)
-	if sumRawTx.Coin.IsContract {
-		rawTxWithErrArray, err = decoder.CreateQRC20SummaryRawTransaction(wrapper, sumRawTx)
-	} else {
-		rawTxWithErrArray, err = decoder.CreateSimpleSummaryRawTransaction(wrapper, sumRawTx)
-	}
+	rawTxWithErrArray, err := decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
 	if err != nil {
 		return nil, err
 	}
@@ -110,17 +108,7 @@ func (decoder *TransactionDecoder) CreateSimpleRawTransaction(wrapper openwallet
 		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] balance is not enough", accountID)
 	}
 
-	//获取utxo，按小到大排序
-	sort.Sort(UnspentSort{unspents, func(a, b *Unspent) int {
-		a_amount, _ := decimal.NewFromString(a.Amount)
-		b_amount, _ := decimal.NewFromString(b.Amount)
-		if a_amount.LessThan(b_amount) {
-			return 1
-		} else {
-			return -1
-		}
-	}})
-
+	//utxo的选择顺序由选币策略决定
 	return decoder.createSimpleRawTransactionWithUTXO(wrapper, rawTx, unspents)
 
 }
@@ -222,7 +210,15 @@ func (decoder *TransactionDecoder) CreateSimpleSummaryRawTransaction(wrapper ope
 			//执行构建交易单工作
 			//decoder.wm.Log.Debugf("sumUnspents: %+v", sumUnspents)
 			//计算手续费，构建交易单inputs，地址保留余额>0，地址需要加入输出，最后+1是汇总地址
-			fees, createErr := decoder.wm.EstimateFee(int64(len(sumUnspents)), int64(len(outputAddrs)+1), feesRate)
+			sumOutputs := make([]string, 0, len(outputAddrs)+1)
+			for a := range outputAddrs {
+				sumOutputs = append(sumOutputs, a)
+			}
+			outputScripts, createErr := decoder.addressLockScripts(append(sumOutputs, sumRawTx.SummaryAddress)...)
+			if createErr != nil {
+				return nil, createErr
+			}
+			fees, createErr := decoder.newTxSizeEstimator(wrapper, sumRawTx.Account, outputScripts).Fees(sumUnspents, false, feesRate)
 			if createErr != nil {
 				return nil, createErr
 			}
@@ -321,12 +317,13 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 		return fmt.Errorf("contract address is empty")
 	}
 
+	if err := checkContractSender(rawTx.Account); err != nil {
+		return err
+	}
+
 	tokenCoin := rawTx.Coin.Contract.Token
 	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
 
-	//TODO: 应该通过配置文件设置
-	//合约手续费在普通交易基础上加0.1个qtum, 小数点8位
-	actualFees, _ = decimal.NewFromString("0.1")
 
 	address, err := wrapper.GetAddressList(0, 200, "AccountID", rawTx.Account.AccountID)
 	if err != nil {
@@ -379,7 +376,7 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 	for _, address := range address {
 
 		//查找地址token余额
-		tokenBalance, checkErr := decoder.wm.GetQRC20Balance(rawTx.Coin.Contract, address.Address, decoder.wm.Config.isTestNet)
+		tokenBalance, checkErr := decoder.wm.GetQRC20Balance(rawTx.Coin.Contract, address.Address)
 		if checkErr != nil {
 			return checkErr
 		}
@@ -449,15 +446,19 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 	//选择一个地址作为发送
 	//txFrom = []string{fmt.Sprintf("%s:%s", availableUTXO[0].Address, toAmount.StringFixed(tokenDecimals))}
 
-	//获取utxo，按小到大排序
-	sort.Sort(UnspentSort{availableUTXO, func(a, b *Unspent) int {
+	//合约手续费在普通交易基础上加gasLimit * gasPrice
+	gas, err := decoder.qrc20Gas(rawTx.GetExtParam(), rawTx.Coin.Contract, useTokenAddress, toAddress, toAmount)
+	if err != nil {
+		return err
+	}
+	actualFees = gas.Cost()
 
-		if a.Amount > b.Amount {
-			return 1
-		} else {
-			return -1
-		}
-	}})
+	//合约调用的发送者为第一个输入的地址，必须使用Token地址余额最大的utxo
+	requiredUTXO := spendableUnspents(availableUTXO, nil)
+	orderByAmountDesc(requiredUTXO)
+	if len(requiredUTXO) == 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "account[%s] token[%s] total balance is enough, but the utxo of address[%s] is empty! ", accountID, tokenCoin, useTokenAddress)
+	}
 
 	//查找账户没有token余额的utxo，可用于手续费
 	if len(missToken) > 0 {
@@ -479,58 +480,36 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
 	}
 
-	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
-
-	//循环的计算余额是否足够支付发送数额+手续费
-	for {
-
-		usedUTXO = make([]*Unspent, 0)
-		balance = decimal.Zero
-
-		//计算一个可用于支付的余额
-		for _, u := range availableUTXO {
-
-			if u.Spendable {
-				ua, _ := decimal.NewFromString(u.Amount)
-				balance = balance.Add(ua)
-				usedUTXO = append(usedUTXO, u)
-				if balance.GreaterThanOrEqual(actualFees) {
-					break
-				}
-			}
-		}
-
-		if balance.LessThan(actualFees) {
-			return openwallet.Errorf(openwallet.ErrInsufficientFees, "The [%s] available utxo balance: %s is not enough! ", decoder.wm.Symbol(), balance.StringFixed(decoder.wm.Decimal()))
-		}
-
-		//计算手续费，输出地址有2个，一个是发送，一个是找零，一个是OP_CALL
-		fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), int64(2), feesRate)
-		if err != nil {
-			return err
-		}
-
-		//如果要手续费有发送支付，得计算加入手续费后，计算余额是否足够
-		//总共要发送的
-		actualFees = actualFees.Add(fees)
-		if actualFees.GreaterThan(balance) {
-			continue
-		}
+	selector, err := decoder.coinSelector(rawTx.GetExtParam())
+	if err != nil {
+		return err
+	}
 
-		break
+	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
 
+	//选择足够支付合约成本+手续费的utxo，输出为OP_CALL和找零
+	contractScript, err := btcLikeTxDriver.GetContractLockScript(decoder.newQRC20Vcontract(rawTx.Coin.Contract, toAddress, toAmount, gas))
+	if err != nil {
+		return err
 	}
-
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
-	if len(usedUTXO) > decoder.wm.Config.maxTxInputs {
-		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.Config.maxTxInputs)
-		return errors.New(errStr)
+	estimator := decoder.newTxSizeEstimator(wrapper, rawTx.Account, []string{contractScript})
+	target := decoder.newCoinSelectionTarget(estimator, actualFees, feesRate)
+	target.Required = requiredUTXO[:1]
+	selected, err := selector.SelectCoins(availableUTXO, target)
+	if err == errCoinsNotEnough {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "The [%s] available utxo balance: %s is not enough! ", decoder.wm.Symbol(), sumUnspentsBalance(availableUTXO).StringFixed(decoder.wm.Decimal()))
+	} else if err != nil {
+		return err
 	}
 
+	usedUTXO = selected.Inputs
+	balance = selected.Balance
+	actualFees = actualFees.Add(selected.Fees)
+
 	//取账户最后一个地址
 	changeAddress := usedUTXO[0].Address
 
-	changeAmount := balance.Sub(actualFees)
+	changeAmount := selected.Change
 	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
 	rawTx.Fees = actualFees.StringFixed(decoder.wm.Decimal())
 
@@ -552,7 +531,7 @@ func (decoder *TransactionDecoder) CreateQRC20RawTransaction(wrapper openwallet.
 
 	tokenOutputAddrs[toAddress] = toAmount.StringFixed(tokenDecimals)
 
-	err = decoder.createQRC2ORawTransaction(wrapper, rawTx, usedUTXO, outputAddrs, tokenOutputAddrs)
+	err = decoder.createQRC2ORawTransaction(wrapper, rawTx, usedUTXO, outputAddrs, tokenOutputAddrs, gas)
 	if err != nil {
 		return err
 	}
@@ -580,6 +559,14 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 		return nil, fmt.Errorf("contract address is empty")
 	}
 
+	if isQRC721Contract(sumRawTx.Coin.Contract) {
+		return nil, fmt.Errorf("qrc721 token not support summary transaction")
+	}
+
+	if err := checkContractSender(sumRawTx.Account); err != nil {
+		return nil, err
+	}
+
 	// 如果有提供手续费账户，检查账户是否存在
 	if feesAcount := sumRawTx.FeesSupportAccount; feesAcount != nil {
 		account, supportErr := wrapper.GetAssetsAccountInfo(feesAcount.AccountID)
@@ -594,8 +581,6 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 
 	tokenDecimals := int32(sumRawTx.Coin.Contract.Decimals)
 
-	//合约手续费在普通交易基础上加0.1个qtum, 小数点8位
-	transferCost, _ := decimal.NewFromString(decoder.wm.Config.TokenTransferCost)
 	//coinDecimals := decoder.wm.Decimal()
 
 	if minTransfer.LessThan(retainedBalance) {
@@ -641,7 +626,7 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 		tokenOutputAddrs = make(map[string]string, 0)
 		//decoder.wm.Log.Debug("address.Address:", address.Address)
 		//查找地址token余额
-		tokenBalance, createErr := decoder.wm.GetQRC20Balance(sumRawTx.Coin.Contract, address.Address, decoder.wm.Config.isTestNet)
+		tokenBalance, createErr := decoder.wm.GetQRC20Balance(sumRawTx.Coin.Contract, address.Address)
 		if createErr != nil {
 			continue
 		}
@@ -668,8 +653,26 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 
 		}
 		//decoder.wm.Log.Debug("addrBalance:", addrBalance)
-		//计算手续费，构建交易单inputs，输出2个，1个为目标地址，1个为OP_CALL
-		fees, createErr := decoder.wm.EstimateFee(int64(len(sumUnspents)), 2, feesRate)
+		//计算汇总数量
+		sumTokenAmount := tokenBalance.Sub(retainedBalance)
+
+		//合约手续费在普通交易基础上加gasLimit * gasPrice
+		gas, createErr := decoder.qrc20Gas(sumRawTx.GetExtParam(), sumRawTx.Coin.Contract, address.Address, sumRawTx.SummaryAddress, sumTokenAmount)
+		if createErr != nil {
+			rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+				RawTx: &openwallet.RawTransaction{Coin: sumRawTx.Coin, Account: sumRawTx.Account},
+				Error: openwallet.ConvertError(createErr),
+			})
+			continue
+		}
+		transferCost := gas.Cost()
+
+		//计算手续费，构建交易单inputs，输出2个，1个为OP_CALL，1个为找零
+		contractScript, createErr := btcLikeTxDriver.GetContractLockScript(decoder.newQRC20Vcontract(sumRawTx.Coin.Contract, sumRawTx.SummaryAddress, sumTokenAmount, gas))
+		if createErr != nil {
+			return nil, createErr
+		}
+		fees, createErr := decoder.newTxSizeEstimator(wrapper, sumRawTx.Account, []string{contractScript}).Fees(sumUnspents, true, feesRate)
 		if createErr != nil {
 			return nil, createErr
 		}
@@ -763,8 +766,6 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 			//outputAddrs[address.Address] = changeAmount.StringFixed(coinDecimals)
 		}
 
-		//计算汇总数量
-		sumTokenAmount := tokenBalance.Sub(retainedBalance)
 		//token输出汇总地址及汇总数量
 		tokenOutputAddrs[sumRawTx.SummaryAddress] = sumTokenAmount.StringFixed(tokenDecimals)
 
@@ -784,7 +785,7 @@ func (decoder *TransactionDecoder) CreateQRC20SummaryRawTransaction(wrapper open
 			Required: 1,
 		}
 
-		createErr = decoder.createQRC2ORawTransaction(wrapper, rawTx, sumUnspents, outputAddrs, tokenOutputAddrs)
+		createErr = decoder.createQRC2ORawTransaction(wrapper, rawTx, sumUnspents, outputAddrs, tokenOutputAddrs, gas)
 		rawTxWithErr := &openwallet.RawTransactionWithError{
 			RawTx: rawTx,
 			Error: openwallet.ConvertError(createErr),
@@ -807,63 +808,104 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 	//	return err
 	//}
 
-	var (
-		txUnlocks   = make([]btcLikeTxDriver.TxUnlock, 0)
-		emptyTrans  = rawTx.RawHex
-		transHash   = make([]string, 0)
-		sigPub      = make([]btcLikeTxDriver.SignaturePubkey, 0)
-		privateKeys = make([][]byte, 0)
-	)
-
 	if rawTx.Signatures == nil || len(rawTx.Signatures) == 0 {
 		//this.wm.Log.Std.Error("len of signatures error. ")
 		return fmt.Errorf("transaction signature is empty")
 	}
 
+	if isMultiSigAccount(rawTx.Account) {
+		return decoder.signMultiSigRawTransaction(wrapper, rawTx)
+	}
+
+	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
+	if len(keySignatures) == 0 {
+		return fmt.Errorf("transaction signature of account: %s is empty", rawTx.Account.AccountID)
+	}
+
+	err := decoder.signKeySignatures(wrapper, keySignatures)
+	if err != nil {
+		return err
+	}
+
+	rawTx.Signatures[rawTx.Account.AccountID] = keySignatures
+
+	//decoder.wm.Log.Info("rawTx.Signatures 1:", rawTx.Signatures)
+
+	return nil
+}
+
+//signMultiSigRawTransaction 多重签名交易单只签当前钱包拥有者的部分
+//待签名数据按拥有者的AccountID保存，钱包在rawTx.Account.HDPath的公钥即为当前拥有者
+//各拥有者的账户路径可能不同，签名密钥按当前账户路径加地址的change和index推导
+func (decoder *TransactionDecoder) signMultiSigRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
 	key, err := wrapper.HDKey()
 	if err != nil {
 		return err
 	}
 
-	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
-	if keySignatures != nil {
-		for _, keySignature := range keySignatures {
+	accountKey, err := key.DerivedKeyWithPath(rawTx.Account.HDPath, decoder.wm.Config.CurveType)
+	if err != nil {
+		return err
+	}
+	ownerAccountID := openwallet.GenAccountID(accountKey.GetPublicKey().OWEncode())
 
-			childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
-			keyBytes, err := childKey.GetPrivateKeyBytes()
-			if err != nil {
-				return err
-			}
-			privateKeys = append(privateKeys, keyBytes)
-			transHash = append(transHash, keySignature.Message)
+	keySignatures := rawTx.Signatures[ownerAccountID]
+	if len(keySignatures) == 0 {
+		return fmt.Errorf("transaction signature of account: %s is empty", ownerAccountID)
+	}
+
+	ownerSignatures := make([]*openwallet.KeySignature, 0, len(keySignatures))
+	for _, keySignature := range keySignatures {
+		change, index, err := parseAddressIndex(keySignature.Address.HDPath)
+		if err != nil {
+			return err
 		}
+		ownerAddress := *keySignature.Address
+		ownerAddress.HDPath = fmt.Sprintf("%s/%d/%d", rawTx.Account.HDPath, change, index)
+		ownerSignature := *keySignature
+		ownerSignature.Address = &ownerAddress
+		ownerSignatures = append(ownerSignatures, &ownerSignature)
 	}
 
-	txBytes, err := hex.DecodeString(emptyTrans)
+	err = decoder.signKeySignatures(wrapper, ownerSignatures)
 	if err != nil {
-		return errors.New("Invalid transaction hex data!")
+		return err
 	}
 
-	trx, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	for i, keySignature := range keySignatures {
+		keySignature.Signature = ownerSignatures[i].Signature
+	}
+
+	return nil
+}
+
+//signKeySignatures 用钱包的密钥签名待签名哈希，签名结果填充到keySignatures
+func (decoder *TransactionDecoder) signKeySignatures(wrapper openwallet.WalletDAI, keySignatures []*openwallet.KeySignature) error {
+
+	var (
+		txUnlocks = make([]btcLikeTxDriver.TxUnlock, 0)
+		transHash = make([]string, 0)
+		sigPub    = make([]btcLikeTxDriver.SignaturePubkey, 0)
+	)
+
+	key, err := wrapper.HDKey()
 	if err != nil {
-		return errors.New("Invalid transaction data! ")
+		return err
 	}
 
-	for i, vin := range trx.Vins {
+	for _, keySignature := range keySignatures {
 
-		utxo, err := decoder.wm.GetTxOut(vin.GetTxID(), uint64(vin.GetVout()))
+		childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
 		if err != nil {
 			return err
 		}
-
-		keyBytes := privateKeys[i]
-
-		txUnlock := btcLikeTxDriver.TxUnlock{
-			LockScript: utxo.ScriptPubKey,
-			PrivateKey: keyBytes,
+		keyBytes, err := childKey.GetPrivateKeyBytes()
+		if err != nil {
+			return err
 		}
-		txUnlocks = append(txUnlocks, txUnlock)
-
+		txUnlocks = append(txUnlocks, btcLikeTxDriver.TxUnlock{PrivateKey: keyBytes})
+		transHash = append(transHash, keySignature.Message)
 	}
 
 	//decoder.wm.Log.Debug("transHash len:", len(transHash))
@@ -875,12 +917,6 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 		return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
 	} else {
 		decoder.wm.Log.Info("transaction hash sign success")
-		//for i, s := range sigPub {
-		//	decoder.wm.Log.Info("第", i+1, "个签名结果")
-		//	decoder.wm.Log.Info()
-		//	decoder.wm.Log.Info("对应的公钥为")
-		//	decoder.wm.Log.Info(hex.EncodeToString(s.Pubkey))
-		//}
 	}
 
 	if len(sigPub) != len(keySignatures) {
@@ -892,102 +928,162 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 		//decoder.wm.Log.Debug("keySignature.Signature:",i, "=", keySignature.Signature)
 	}
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySignatures
-
-	//decoder.wm.Log.Info("rawTx.Signatures 1:", rawTx.Signatures)
-
 	return nil
 }
 
 //VerifyRawTransaction 验证交易单，验证交易单并返回加入签名后的交易单
+//多重签名交易单在签名数量未达到rawTx.Required时，IsCompleted为false
 func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
-	//先加载是否有配置文件
-	//err := decoder.wm.loadConfig()
-	//if err != nil {
-	//	return err
-	//}
-
-	var (
-		txUnlocks  = make([]btcLikeTxDriver.TxUnlock, 0)
-		emptyTrans = rawTx.RawHex
-		sigPub     = make([]btcLikeTxDriver.SignaturePubkey, 0)
-	)
-
-	if rawTx.Signatures == nil || len(rawTx.Signatures) == 0 {
-		//this.wm.Log.Std.Error("len of signatures error. ")
-		return fmt.Errorf("transaction signature is empty")
+	signedTrans, completed, err := decoder.composeSignatures(wrapper, rawTx.Account, rawTx.RawHex, rawTx.Signatures, rawTx.Required)
+	if err != nil {
+		return err
 	}
 
-	//:待支持多重签名
-
-	for accountID, keySignatures := range rawTx.Signatures {
-		decoder.wm.Log.Debug("accountID Signatures:", accountID)
-		for _, keySignature := range keySignatures {
+	rawTx.IsCompleted = completed
+	if completed {
+		rawTx.RawHex = signedTrans
+	}
 
-			signature, _ := hex.DecodeString(keySignature.Signature)
-			pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
+	return nil
+}
 
-			signaturePubkey := btcLikeTxDriver.SignaturePubkey{
-				Signature: signature,
-				Pubkey:    pubkey,
-			}
+//composeSignatures 把各账户的签名填充到空交易单，返回签名后的交易单
+//签名数量未达到要求或验证不通过时completed为false
+func (decoder *TransactionDecoder) composeSignatures(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, emptyTrans string, signatures map[string][]*openwallet.KeySignature, requiredSigs uint64) (string, bool, error) {
 
-			sigPub = append(sigPub, signaturePubkey)
+	var (
+		txUnlocks = make([]btcLikeTxDriver.TxUnlock, 0)
+		multiSig  = isMultiSigAccount(account)
+	)
 
-			decoder.wm.Log.Debug("Signature:", keySignature.Signature)
-			decoder.wm.Log.Debug("PublicKey:", keySignature.Address.PublicKey)
-		}
+	if signatures == nil || len(signatures) == 0 {
+		//this.wm.Log.Std.Error("len of signatures error. ")
+		return "", false, fmt.Errorf("transaction signature is empty")
 	}
 
 	txBytes, err := hex.DecodeString(emptyTrans)
 	if err != nil {
-		return errors.New("Invalid transaction hex data!")
+		return "", false, errors.New("Invalid transaction hex data!")
 	}
 
 	trx, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
 	if err != nil {
-		return errors.New("Invalid transaction data! ")
+		return "", false, errors.New("Invalid transaction data! ")
 	}
 
 	for _, vin := range trx.Vins {
 
 		utxo, err := decoder.wm.GetTxOut(vin.GetTxID(), uint64(vin.GetVout()))
 		if err != nil {
-			return err
+			//替换交易单的输入已被原交易单花费，从前置交易单获取
+			utxo, err = decoder.previousOutput(vin.GetTxID(), uint64(vin.GetVout()))
+			if err != nil {
+				return "", false, err
+			}
+		}
+
+		value, _ := decimal.NewFromString(utxo.Value)
+		txUnlock := btcLikeTxDriver.TxUnlock{
+			LockScript: utxo.ScriptPubKey,
+			Amount:     uint64(value.Shift(decoder.wm.Decimal()).IntPart()),
 		}
 
-		txUnlock := btcLikeTxDriver.TxUnlock{LockScript: utxo.ScriptPubKey}
+		if multiSig {
+			addr, err := wrapper.GetAddress(utxo.Addr)
+			if err != nil {
+				return "", false, err
+			}
+			txUnlock.RedeemScript, err = decoder.wm.MultiSigRedeemScript(account, addr)
+			if err != nil {
+				return "", false, err
+			}
+		}
 		txUnlocks = append(txUnlocks, txUnlock)
+	}
+
+	transHash, err := btcLikeTxDriver.CreateRawTransactionHashForSig(emptyTrans, txUnlocks)
+	if err != nil {
+		return "", false, fmt.Errorf("create transaction hash for sig failed, unexpected error: %v", err)
+	}
+
+	hashIndex := make(map[string]int)
+	for i, hash := range transHash {
+		hashIndex[hash] = i
+	}
+
+	//按待签名哈希把各账户的签名归入对应的输入
+	accountIDs := make([]string, 0, len(signatures))
+	for accountID := range signatures {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Strings(accountIDs)
 
+	sigPubs := make([][]btcLikeTxDriver.SignaturePubkey, len(txUnlocks))
+	for _, accountID := range accountIDs {
+		decoder.wm.Log.Debug("accountID Signatures:", accountID)
+		for _, keySignature := range signatures[accountID] {
+
+			if len(keySignature.Signature) == 0 {
+				continue
+			}
+
+			i, ok := hashIndex[keySignature.Message]
+			if !ok {
+				return "", false, fmt.Errorf("the signature message of account: %s is not match the transaction", accountID)
+			}
+
+			signature, _ := hex.DecodeString(keySignature.Signature)
+			pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
+			if len(txUnlocks[i].RedeemScript) > 0 {
+				pubkey, err = btcLikeTxDriver.FindMultiSigSigner(txUnlocks[i].RedeemScript, keySignature.Message, signature)
+				if err != nil {
+					return "", false, fmt.Errorf("the signature of account: %s is invalid, unexpected error: %v", accountID, err)
+				}
+			}
+
+			sigPubs[i] = append(sigPubs[i], btcLikeTxDriver.SignaturePubkey{
+				Signature: signature,
+				Pubkey:    pubkey,
+			})
+
+			decoder.wm.Log.Debug("Signature:", keySignature.Signature)
+		}
 	}
 
-	//decoder.wm.Log.Debug(emptyTrans)
+	//检查签名数量是否满足要求
+	for i, unlock := range txUnlocks {
+		required := 1
+		if len(unlock.RedeemScript) > 0 {
+			required, _, err = btcLikeTxDriver.ParseMultiSigRedeemScript(unlock.RedeemScript)
+			if err != nil {
+				return "", false, err
+			}
+			if requiredSigs > 0 && requiredSigs != uint64(required) {
+				return "", false, fmt.Errorf("transaction required signatures: %d is not match the redeem script: %d", requiredSigs, required)
+			}
+		}
+		if len(sigPubs[i]) < required {
+			decoder.wm.Log.Debugf("input %d has %d signatures, required %d", i, len(sigPubs[i]), required)
+			return "", false, nil
+		}
+	}
 
 	////////填充签名结果到空交易单
-	//  传入TxUnlock结构体的原因是： 解锁向脚本支付的UTXO时需要对应地址的赎回脚本， 当前案例的对应字段置为 "" 即可
-	signedTrans, err := btcLikeTxDriver.InsertSignatureIntoEmptyTransaction(emptyTrans, sigPub, txUnlocks)
+	signedTrans, err := btcLikeTxDriver.InsertMultiSignatureIntoEmptyTransaction(emptyTrans, sigPubs, txUnlocks)
 	if err != nil {
-		return fmt.Errorf("transaction compose signatures failed")
+		return "", false, fmt.Errorf("transaction compose signatures failed, unexpected error: %v", err)
 	}
-	//else {
-	//	//	fmt.Println("拼接后的交易单")
-	//	//	fmt.Println(signedTrans)
-	//	//}
 
 	/////////验证交易单
-	//验证时，对于公钥哈希地址，需要将对应的锁定脚本传入TxUnlock结构体
 	pass := btcLikeTxDriver.VerifyRawTransaction(signedTrans, txUnlocks)
-	if pass {
-		decoder.wm.Log.Debug("transaction verify passed")
-		rawTx.IsCompleted = true
-		rawTx.RawHex = signedTrans
-	} else {
+	if !pass {
 		decoder.wm.Log.Debug("transaction verify failed")
-		rawTx.IsCompleted = false
+		return "", false, nil
 	}
 
-	return nil
+	decoder.wm.Log.Debug("transaction verify passed")
+	return signedTrans, true, nil
 }
 
 //SendRawTransaction 广播交易单
@@ -1097,61 +1193,35 @@ func (decoder *TransactionDecoder) createSimpleRawTransactionWithUTXO(
 		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
 	}
 
+	selector, err := decoder.coinSelector(rawTx.GetExtParam())
+	if err != nil {
+		return err
+	}
+
 	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
 	computeTotalSend := totalSend
-	//循环的计算余额是否足够支付发送数额+手续费
-	for {
-
-		usedUTXO = make([]*Unspent, 0)
-		balance = decimal.New(0, 0)
-
-		//计算一个可用于支付的余额
-		for _, u := range unspents {
-
-			if u.Spendable {
-				ua, _ := decimal.NewFromString(u.Amount)
-				balance = balance.Add(ua)
-				usedUTXO = append(usedUTXO, u)
-				if balance.GreaterThanOrEqual(computeTotalSend) {
-					break
-				}
-			}
-		}
-
-		if balance.LessThan(computeTotalSend) {
-			return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough! ", balance.StringFixed(decoder.wm.Decimal()))
-		}
-
-		//计算手续费，找零地址有2个，一个是发送，一个是新创建的
-		fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), int64(len(destinations)+1), feesRate)
-		if err != nil {
-			return err
-		}
-
-		//如果要手续费有发送支付，得计算加入手续费后，计算余额是否足够
-		//总共要发送的
-		computeTotalSend = totalSend.Add(fees)
-		if computeTotalSend.GreaterThan(balance) {
-			continue
-		}
-		computeTotalSend = totalSend
-
-		actualFees = fees
-
-		break
 
+	//选择足够支付发送数额+手续费的utxo，输出为接收地址和找零地址
+	outputScripts, err := decoder.addressLockScripts(destinations...)
+	if err != nil {
+		return err
 	}
-
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
-	if len(usedUTXO) > decoder.wm.Config.maxTxInputs {
-		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.Config.maxTxInputs)
-		return errors.New(errStr)
+	estimator := decoder.newTxSizeEstimator(wrapper, rawTx.Account, outputScripts)
+	selected, err := selector.SelectCoins(unspents, decoder.newCoinSelectionTarget(estimator, totalSend, feesRate))
+	if err == errCoinsNotEnough {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough! ", sumUnspentsBalance(unspents).StringFixed(decoder.wm.Decimal()))
+	} else if err != nil {
+		return err
 	}
 
+	usedUTXO = selected.Inputs
+	balance = selected.Balance
+	actualFees = selected.Fees
+
 	//取账户最后一个地址
 	changeAddress := usedUTXO[0].Address
 
-	changeAmount := balance.Sub(computeTotalSend).Sub(actualFees)
+	changeAmount := selected.Change
 	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
 	rawTx.Fees = actualFees.StringFixed(decoder.wm.Decimal())
 
@@ -1189,6 +1259,347 @@ func (decoder *TransactionDecoder) createSimpleRawTransactionWithUTXO(
 	return nil
 }
 
+//BumpFeeRawTransaction 追加手续费(BIP-125)，使用原交易单相同的输入构建替换交易单
+//rawTx.TxID为已广播未确认的交易单，feeRate为新的每KB费率，增加的手续费从找零中扣除
+func (decoder *TransactionDecoder) BumpFeeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, feeRate string) error {
+
+	var (
+		outputAddrs  = make(map[string]decimal.Decimal)
+		totalOutput  = decimal.Zero
+		changeIndex  = -1
+		changeAmount = decimal.Zero
+		decimals     = decoder.wm.Decimal()
+	)
+
+	if len(rawTx.TxID) == 0 {
+		return fmt.Errorf("the txid of the replaced transaction is empty")
+	}
+
+	newFeeRate, err := decimal.NewFromString(feeRate)
+	if err != nil || !newFeeRate.GreaterThan(decimal.Zero) {
+		return fmt.Errorf("invalid fee rate: %s", feeRate)
+	}
+
+	tx, err := decoder.wm.GetTransaction(rawTx.TxID)
+	if err != nil {
+		return err
+	}
+
+	if tx.Confirmations > 0 {
+		return fmt.Errorf("transaction: %s is already confirmed", rawTx.TxID)
+	}
+
+	txHex := tx.Hex
+	if len(txHex) == 0 {
+		txHex = rawTx.RawHex
+	}
+
+	replaceable, err := btcLikeTxDriver.IsReplaceable(txHex)
+	if err != nil {
+		return err
+	}
+	if !replaceable {
+		return fmt.Errorf("transaction: %s does not signal replaceability", rawTx.TxID)
+	}
+
+	oldVSize, err := btcLikeTxDriver.GetTransactionVSize(txHex)
+	if err != nil {
+		return err
+	}
+
+	//替换交易单必须使用相同的输入
+	usedUTXO, totalInput, err := decoder.getPreviousOutputs(tx)
+	if err != nil {
+		return err
+	}
+
+	//找零输出为属于当前账户，且不是接收地址的最大输出
+	for i, out := range tx.Vouts {
+		if len(out.Addr) == 0 {
+			return fmt.Errorf("only transactions paying to addresses can be replaced")
+		}
+
+		amount, _ := decimal.NewFromString(out.Value)
+		totalOutput = totalOutput.Add(amount)
+
+		if _, isReceiver := rawTx.To[out.Addr]; isReceiver {
+			continue
+		}
+
+		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", out.Addr)
+		if findErr != nil || len(addresses) == 0 {
+			continue
+		}
+
+		if changeIndex == -1 || amount.GreaterThan(changeAmount) {
+			changeIndex = i
+			changeAmount = amount
+		}
+	}
+
+	if changeIndex == -1 {
+		return fmt.Errorf("transaction: %s has no change output to pay the extra fees", rawTx.TxID)
+	}
+
+	//按原交易单的虚拟大小计算新手续费
+	oldFees := totalInput.Sub(totalOutput)
+	newFees := newFeeRate.Mul(decimal.New(int64(oldVSize), 0)).Div(decimal.New(1000, 0)).Round(decimals)
+
+	changeAmount = changeAmount.Sub(newFees.Sub(oldFees))
+	if changeAmount.LessThan(decimal.Zero) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "The change: %s is not enough to pay the fees: %s", changeAmount.Add(newFees.Sub(oldFees)).StringFixed(decimals), newFees.StringFixed(decimals))
+	}
+	//找零低于粉尘阈值，去掉找零输出并入手续费
+	if changeAmount.LessThan(decoder.wm.Config.DustThreshold) {
+		newFees = newFees.Add(changeAmount)
+		changeAmount = decimal.Zero
+	}
+
+	//装配输出，原接收方金额不变
+	to := make(map[string]string)
+	for i, out := range tx.Vouts {
+		amount, _ := decimal.NewFromString(out.Value)
+		if i == changeIndex {
+			if changeAmount.Equal(decimal.Zero) {
+				continue
+			}
+			amount = changeAmount
+		} else {
+			to[out.Addr] = amount.StringFixed(decimals)
+		}
+		outputAddrs = appendOutput(outputAddrs, out.Addr, amount)
+	}
+
+	//按替换交易单的输入输出估算虚拟大小
+	outputAddresses := make([]string, 0, len(outputAddrs))
+	for addr := range outputAddrs {
+		outputAddresses = append(outputAddresses, addr)
+	}
+	outputScripts, err := decoder.addressLockScripts(outputAddresses...)
+	if err != nil {
+		return err
+	}
+	newVSize, err := decoder.newTxSizeEstimator(wrapper, rawTx.Account, outputScripts).VSize(usedUTXO, false)
+	if err != nil {
+		return err
+	}
+
+	err = btcLikeTxDriver.CheckReplacementFee(
+		uint64(oldFees.Shift(decimals).IntPart()),
+		oldVSize,
+		uint64(newFees.Shift(decimals).IntPart()),
+		newVSize,
+		uint64(decoder.wm.Config.IncrementalRelayFee.Shift(decimals).IntPart()))
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "fee rate: %s can not replace transaction: %s, %v", feeRate, rawTx.TxID, err)
+	}
+
+	if len(rawTx.To) == 0 {
+		rawTx.To = to
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Replace Transaction: %s", rawTx.TxID)
+	decoder.wm.Log.Std.Notice("Old Fees: %v", oldFees.StringFixed(decimals))
+	decoder.wm.Log.Std.Notice("New Fees: %v", newFees.StringFixed(decimals))
+	decoder.wm.Log.Std.Notice("Change: %v", changeAmount.StringFixed(decimals))
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	//替换交易单仍然可以继续追加手续费
+	rawTx.SetExtParam("replaceable", true)
+	rawTx.SetExtParam("replacedTxID", rawTx.TxID)
+	rawTx.FeeRate = newFeeRate.StringFixed(decimals)
+	rawTx.Fees = newFees.StringFixed(decimals)
+	rawTx.Signatures = nil
+	rawTx.TxID = ""
+	rawTx.IsCompleted = false
+	rawTx.IsSubmit = false
+
+	return decoder.createSimpleRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+}
+
+//CreateCPFPRawTransaction 子交易支付父交易手续费(CPFP)
+//rawTx.TxID为未确认的父交易单，花费其中属于当前账户的输出，使父子交易整体费率达到feeRate(每KB)
+//子交易的输出转回花费的第一个输出的地址
+func (decoder *TransactionDecoder) CreateCPFPRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, feeRate string) error {
+
+	var (
+		usedUTXO    = make([]*Unspent, 0)
+		outputAddrs = make(map[string]decimal.Decimal)
+		totalSpent  = decimal.Zero
+		totalOutput = decimal.Zero
+		decimals    = decoder.wm.Decimal()
+		parentTxID  = rawTx.TxID
+	)
+
+	if len(parentTxID) == 0 {
+		return fmt.Errorf("the txid of the parent transaction is empty")
+	}
+
+	targetRate, err := decimal.NewFromString(feeRate)
+	if err != nil || !targetRate.GreaterThan(decimal.Zero) {
+		return fmt.Errorf("invalid fee rate: %s", feeRate)
+	}
+
+	parent, err := decoder.wm.GetTransaction(parentTxID)
+	if err != nil {
+		return err
+	}
+
+	if parent.Confirmations > 0 {
+		return fmt.Errorf("transaction: %s is already confirmed", parentTxID)
+	}
+
+	//父交易单的大小和手续费
+	parentVSize := parent.Size
+	if len(parent.Hex) > 0 {
+		parentVSize, err = btcLikeTxDriver.GetTransactionVSize(parent.Hex)
+		if err != nil {
+			return err
+		}
+	}
+	if parentVSize == 0 {
+		return fmt.Errorf("can not get the size of transaction: %s", parentTxID)
+	}
+
+	_, parentInput, err := decoder.getPreviousOutputs(parent)
+	if err != nil {
+		return err
+	}
+
+	for _, out := range parent.Vouts {
+		amount, _ := decimal.NewFromString(out.Value)
+		totalOutput = totalOutput.Add(amount)
+	}
+	parentFees := parentInput.Sub(totalOutput)
+
+	//查找父交易中属于当前账户且未花费的输出
+	for _, out := range parent.Vouts {
+		if len(out.Addr) == 0 {
+			continue
+		}
+
+		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", out.Addr)
+		if findErr != nil || len(addresses) == 0 {
+			continue
+		}
+
+		utxo, err := decoder.wm.GetTxOut(parentTxID, out.N)
+		if err != nil || utxo == nil || len(utxo.ScriptPubKey) == 0 {
+			continue
+		}
+
+		amount, _ := decimal.NewFromString(out.Value)
+		totalSpent = totalSpent.Add(amount)
+
+		usedUTXO = append(usedUTXO, &Unspent{
+			TxID:         parentTxID,
+			Vout:         out.N,
+			Address:      out.Addr,
+			ScriptPubKey: out.ScriptPubKey,
+			Amount:       out.Value,
+			Spendable:    true,
+		})
+	}
+
+	if len(usedUTXO) == 0 {
+		return fmt.Errorf("transaction: %s has no unspent output of account: %s", parentTxID, rawTx.Account.AccountID)
+	}
+
+	//父交易单的费率已达到目标
+	parentRate := parentFees.Mul(decimal.New(1000, 0)).Div(decimal.New(int64(parentVSize), 0))
+	if parentRate.GreaterThanOrEqual(targetRate) {
+		return fmt.Errorf("the fee rate: %s of transaction: %s already reaches the target", parentRate.StringFixed(decimals), parentTxID)
+	}
+
+	//子交易手续费 = 目标费率 * (父交易大小 + 子交易大小) - 父交易手续费
+	//子交易只有一个输出，转回第一个输入的地址
+	childFees, err := decoder.newTxSizeEstimator(wrapper, rawTx.Account, nil).Fees(usedUTXO, true, targetRate)
+	if err != nil {
+		return err
+	}
+	parentRequired := targetRate.Mul(decimal.New(int64(parentVSize), 0)).Div(decimal.New(1000, 0)).Round(decimals)
+	childFees = childFees.Add(parentRequired).Sub(parentFees)
+
+	//子交易的输出低于粉尘阈值时不能广播
+	childAmount := totalSpent.Sub(childFees)
+	if !childAmount.GreaterThan(decimal.Zero) || childAmount.LessThan(decoder.wm.Config.DustThreshold) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "The outputs: %s of transaction: %s is not enough to pay the fees: %s and keep the child output above the dust threshold: %s",
+			totalSpent.StringFixed(decimals), parentTxID, childFees.StringFixed(decimals), decoder.wm.Config.DustThreshold.StringFixed(decimals))
+	}
+
+	changeAddress := usedUTXO[0].Address
+	outputAddrs = appendOutput(outputAddrs, changeAddress, childAmount)
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Parent Transaction: %s", parentTxID)
+	decoder.wm.Log.Std.Notice("Parent Fees: %v", parentFees.StringFixed(decimals))
+	decoder.wm.Log.Std.Notice("Child Fees: %v", childFees.StringFixed(decimals))
+	decoder.wm.Log.Std.Notice("Receive: %v", childAmount.StringFixed(decimals))
+	decoder.wm.Log.Std.Notice("Receive Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	rawTx.SetExtParam("parentTxID", parentTxID)
+	rawTx.To = map[string]string{changeAddress: childAmount.StringFixed(decimals)}
+	rawTx.FeeRate = targetRate.StringFixed(decimals)
+	rawTx.Fees = childFees.StringFixed(decimals)
+	rawTx.Signatures = nil
+	rawTx.TxID = ""
+	rawTx.IsCompleted = false
+	rawTx.IsSubmit = false
+
+	return decoder.createSimpleRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+}
+
+//getPreviousOutputs 查找交易单输入所花费的输出，返回UTXO和输入总额
+func (decoder *TransactionDecoder) getPreviousOutputs(tx *Transaction) ([]*Unspent, decimal.Decimal, error) {
+
+	var (
+		utxos      = make([]*Unspent, 0)
+		totalInput = decimal.Zero
+	)
+
+	for _, vin := range tx.Vins {
+		if len(vin.Coinbase) > 0 {
+			return nil, decimal.Zero, fmt.Errorf("coinbase transaction is not supported")
+		}
+
+		prevOut, err := decoder.previousOutput(vin.TxID, vin.Vout)
+		if err != nil {
+			return nil, decimal.Zero, err
+		}
+
+		amount, _ := decimal.NewFromString(prevOut.Value)
+		totalInput = totalInput.Add(amount)
+
+		utxos = append(utxos, &Unspent{
+			TxID:         vin.TxID,
+			Vout:         vin.Vout,
+			Address:      prevOut.Addr,
+			ScriptPubKey: prevOut.ScriptPubKey,
+			Amount:       prevOut.Value,
+			Spendable:    true,
+		})
+	}
+
+	return utxos, totalInput, nil
+}
+
+//previousOutput 从前置交易单获取输出，已花费的输出也能获取
+func (decoder *TransactionDecoder) previousOutput(txid string, n uint64) (*Vout, error) {
+	prevTx, err := decoder.wm.GetTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, out := range prevTx.Vouts {
+		if out.N == n {
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("previous output %s:%d not found", txid, n)
+}
+
 //createSimpleRawTransaction 创建原始交易单
 func (decoder *TransactionDecoder) createSimpleRawTransaction(
 	wrapper openwallet.WalletDAI,
@@ -1242,7 +1653,10 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 		in := btcLikeTxDriver.Vin{utxo.TxID, uint32(utxo.Vout)}
 		vins = append(vins, in)
 
-		txUnlock := btcLikeTxDriver.TxUnlock{LockScript: utxo.ScriptPubKey, Address: utxo.Address}
+		txUnlock, err := decoder.unspentTxUnlock(wrapper, rawTx.Account, utxo)
+		if err != nil {
+			return err
+		}
 		txUnlocks = append(txUnlocks, txUnlock)
 
 		txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
@@ -1260,13 +1674,9 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 	lockTime := uint32(0)
 
 	//追加手续费支持
-	replaceable := false
+	replaceable := decoder.wm.Config.EnableRBF || rawTx.GetExtParam().Get("replaceable").Bool()
 
-	if decoder.wm.Config.isTestNet {
-		addressPrefix = decoder.wm.Config.TestNetAddressPrefix
-	} else {
-		addressPrefix = decoder.wm.Config.MainNetAddressPrefix
-	}
+	addressPrefix = decoder.wm.Config.Network.AddressPrefix()
 
 	/////////构建空交易单
 	emptyTrans, err := btcLikeTxDriver.CreateEmptyRawTransaction(vins, vouts, lockTime, replaceable, addressPrefix)
@@ -1285,6 +1695,15 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 
 	rawTx.RawHex = emptyTrans
 
+	//按需导出PSBT，用于离线签名
+	if rawTx.GetExtParam().Get("exportPSBT").Bool() {
+		psbt, err := decoder.createPSBT(emptyTrans, txUnlocks)
+		if err != nil {
+			return fmt.Errorf("create psbt failed, unexpected error: %v", err)
+		}
+		rawTx.SetExtParam("psbt", psbt)
+	}
+
 	if rawTx.Signatures == nil {
 		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
 	}
@@ -1316,7 +1735,20 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 	accountTotalSent = accountTotalSent.Add(feesDec)
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+	if isMultiSigAccount(rawTx.Account) {
+		//多重签名，每个拥有者都有一份待签名数据
+		for _, ownerAccountID := range multiSigAccountIDs(rawTx.Account) {
+			ownerSigs := make([]*openwallet.KeySignature, 0, len(keySigs))
+			for _, keySig := range keySigs {
+				ownerSig := *keySig
+				ownerSigs = append(ownerSigs, &ownerSig)
+			}
+			rawTx.Signatures[ownerAccountID] = ownerSigs
+		}
+		rawTx.Required = rawTx.Account.Required
+	} else {
+		rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+	}
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
 	rawTx.TxFrom = txFrom
@@ -1325,6 +1757,85 @@ func (decoder *TransactionDecoder) createSimpleRawTransaction(
 	return nil
 }
 
+//createPSBT 根据空交易单创建base64编码的PSBT
+func (decoder *TransactionDecoder) createPSBT(emptyTrans string, txUnlocks []btcLikeTxDriver.TxUnlock) (string, error) {
+
+	psbt, err := btcLikeTxDriver.NewPSBT(emptyTrans, txUnlocks)
+	if err != nil {
+		return "", err
+	}
+
+	txBytes, err := hex.DecodeString(emptyTrans)
+	if err != nil {
+		return "", errors.New("Invalid transaction hex data!")
+	}
+
+	trx, err := btcLikeTxDriver.DecodeRawTransaction(txBytes)
+	if err != nil {
+		return "", err
+	}
+
+	//非隔离见证输入需要附带完整的前置交易单
+	for i, vin := range trx.Vins {
+		if psbt.Inputs[i].WitnessUtxo != nil {
+			continue
+		}
+		prevTx, err := decoder.wm.GetTransaction(vin.GetTxID())
+		if err != nil {
+			return "", err
+		}
+		if len(prevTx.Hex) == 0 {
+			return "", fmt.Errorf("the raw transaction of input: %s is not available", vin.GetTxID())
+		}
+		err = psbt.SetNonWitnessUtxo(i, prevTx.Hex)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return psbt.ToBase64()
+}
+
+//FinalizePSBT 合并PSBT中的签名，生成可广播的交易单
+func (decoder *TransactionDecoder) FinalizePSBT(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	b64 := rawTx.GetExtParam().Get("psbt").String()
+	if len(b64) == 0 {
+		return fmt.Errorf("psbt is empty")
+	}
+
+	psbt, err := btcLikeTxDriver.DecodePSBTFromBase64(b64)
+	if err != nil {
+		return err
+	}
+
+	//PSBT必须对应当前的交易单
+	if len(rawTx.RawHex) > 0 && psbt.UnsignedTxHex() != rawTx.RawHex {
+		return fmt.Errorf("psbt is not match the raw transaction")
+	}
+
+	err = psbt.Finalize()
+	if err != nil {
+		return fmt.Errorf("psbt finalize failed, unexpected error: %v", err)
+	}
+
+	signedTrans, err := psbt.ExtractTransaction()
+	if err != nil {
+		return err
+	}
+
+	b64, err = psbt.ToBase64()
+	if err != nil {
+		return err
+	}
+	rawTx.SetExtParam("psbt", b64)
+
+	rawTx.RawHex = signedTrans
+	rawTx.IsCompleted = true
+
+	return nil
+}
+
 //createQRC20RawTransaction 创建QRC20原始交易单
 func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 	wrapper openwallet.WalletDAI,
@@ -1332,6 +1843,7 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 	usedUTXO []*Unspent,
 	coinTo map[string]decimal.Decimal,
 	tokenTo map[string]string,
+	gas *contractGas,
 ) error {
 
 	var (
@@ -1360,8 +1872,6 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 		return fmt.Errorf("Receiver addresses is empty! ")
 	}
 
-	//合约地址
-	contractAddr := strings.TrimPrefix(rawTx.Coin.Contract.Address, "0x")
 	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
 
 	//记录输入输出明细
@@ -1390,7 +1900,12 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 		in := btcLikeTxDriver.Vin{utxo.TxID, uint32(utxo.Vout)}
 		vins = append(vins, in)
 
-		txUnlock := btcLikeTxDriver.TxUnlock{LockScript: utxo.ScriptPubKey, Address: utxo.Address}
+		utxoAmount, _ := decimal.NewFromString(utxo.Amount)
+		txUnlock := btcLikeTxDriver.TxUnlock{
+			LockScript: utxo.ScriptPubKey,
+			Address:    utxo.Address,
+			Amount:     uint64(utxoAmount.Shift(decoder.wm.Decimal()).IntPart()),
+		}
 		txUnlocks = append(txUnlocks, txUnlock)
 
 		//txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
@@ -1409,24 +1924,18 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 		return fmt.Errorf("the number of change addresses must be equal to one. ")
 	}
 
-	SotashiGasPriceDec := DEFAULT_GAS_PRICE.Shift(decoder.wm.Decimal())
-	gasPrice := SotashiGasPriceDec.String()
-	sendAmount := toAmount.Shift(tokenDecimals)
-
 	//装配合约
-	vcontract := btcLikeTxDriver.Vcontract{contractAddr, to, sendAmount, DEFAULT_GAS_LIMIT, gasPrice, 0}
+	vcontract := decoder.newQRC20Vcontract(rawTx.Coin.Contract, to, toAmount, gas)
+	rawTx.SetExtParam("gasLimit", gas.Limit)
+	rawTx.SetExtParam("gasPrice", gas.Price.String())
 
 	//锁定时间
 	lockTime := uint32(0)
 
 	//追加手续费支持
-	replaceable := false
+	replaceable := decoder.wm.Config.EnableRBF || rawTx.GetExtParam().Get("replaceable").Bool()
 
-	if decoder.wm.Config.isTestNet {
-		addressPrefix = decoder.wm.Config.TestNetAddressPrefix
-	} else {
-		addressPrefix = decoder.wm.Config.MainNetAddressPrefix
-	}
+	addressPrefix = decoder.wm.Config.Network.AddressPrefix()
 
 	/////////构建空交易单
 	emptyTrans, err := btcLikeTxDriver.CreateQRC20TokenEmptyRawTransaction(vins, vcontract, vouts, lockTime, replaceable, addressPrefix)
@@ -1485,9 +1994,23 @@ func (decoder *TransactionDecoder) createQRC2ORawTransaction(
 	return nil
 }
 
+//newQRC20Vcontract 装配QRC20转账的合约调用
+func (decoder *TransactionDecoder) newQRC20Vcontract(contract openwallet.SmartContract, to string, amount decimal.Decimal, gas *contractGas) btcLikeTxDriver.Vcontract {
+	return btcLikeTxDriver.Vcontract{
+		ContractAddr: strings.TrimPrefix(contract.Address, "0x"),
+		To:           to,
+		SendAmount:   amount.Shift(int32(contract.Decimals)),
+		GasLimit:     strconv.FormatUint(gas.Limit, 10),
+		GasPrice:     gas.Price.Shift(decoder.wm.Decimal()).String(),
+	}
+}
+
 //CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
-	if sumRawTx.Coin.IsContract {
+	if sumRawTx.Coin.IsContract && isQRC721Contract(sumRawTx.Coin.Contract) {
+		//QRC721每个tokenId单独转账，不支持汇总
+		return nil, fmt.Errorf("qrc721 token not support summary transaction")
+	} else if sumRawTx.Coin.IsContract {
 		return decoder.CreateQRC20SummaryRawTransaction(wrapper, sumRawTx)
 	} else {
 		return decoder.CreateSimpleSummaryRawTransaction(wrapper, sumRawTx)
@@ -1520,34 +2043,15 @@ func (decoder *TransactionDecoder) getAssetsAccountUnspents(wrapper openwallet.W
 	return unspents, nil
 }
 
-// getAssetsAccountUnspentSatisfyAmount
-func (decoder *TransactionDecoder) getUTXOSatisfyAmount(unspents []*Unspent, amount decimal.Decimal) ([]*Unspent, decimal.Decimal, *openwallet.Error) {
-
-	var (
-		usedUTXO = make([]*Unspent, 0)
-		balance  = decimal.New(0, 0)
-	)
-
-	if unspents != nil {
-		//计算一个可用于支付的余额
-		for _, u := range unspents {
-
-			if u.Spendable {
-				ua, _ := decimal.NewFromString(u.Amount)
-				balance = balance.Add(ua)
-				usedUTXO = append(usedUTXO, u)
-				if balance.GreaterThanOrEqual(amount) {
-					break
-				}
-			}
+//sumUnspentsBalance 合计可用utxo的余额
+func sumUnspentsBalance(unspents []*Unspent) decimal.Decimal {
+	balance := decimal.Zero
+	for _, u := range unspents {
+		if u.Spendable {
+			balance = balance.Add(unspentAmount(u))
 		}
 	}
-
-	if len(usedUTXO) == 0 {
-		return nil, decimal.Zero, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "account have not available utxo")
-	}
-
-	return usedUTXO, balance, nil
+	return balance
 }
 
 // removeUTXO
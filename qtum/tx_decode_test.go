@@ -0,0 +1,253 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
+)
+
+func TestBumpFeeRawTransaction_DustChange(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+
+	sender := newSimWallet(t, "sender")
+	account := sender.account(t, 1, 1)
+	from := sender.newAddress(t, wm, account, 0)
+	if _, err := chain.Fund(from.Address, decimal.New(1, 0)); err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+
+	receiver := newSimWallet(t, "receiver")
+	to := receiver.newAddress(t, wm, receiver.account(t, 1, 1), 0)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: account,
+		To:      map[string]string{to.Address: "0.989"},
+		FeeRate: "0.004",
+	}
+	rawTx.SetExtParam("replaceable", true)
+	if err := wm.TxDecoder.CreateRawTransaction(sender, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed: %v", err)
+	}
+	signAndSend := func(rawTx *openwallet.RawTransaction) string {
+		if err := wm.TxDecoder.SignRawTransaction(sender, rawTx); err != nil {
+			t.Fatalf("SignRawTransaction failed: %v", err)
+		}
+		if err := wm.TxDecoder.VerifyRawTransaction(sender, rawTx); err != nil {
+			t.Fatalf("VerifyRawTransaction failed: %v", err)
+		}
+		txid, err := chain.SendRawTransaction(rawTx.RawHex)
+		if err != nil {
+			t.Fatalf("SendRawTransaction failed: %v", err)
+		}
+		return txid
+	}
+	txid := signAndSend(rawTx)
+
+	old, err := chain.GetTransaction(txid)
+	if err != nil || len(old.Vouts) != 2 {
+		t.Fatalf("replaced transaction: %+v, err: %v", old, err)
+	}
+
+	//新费率下找零低于粉尘阈值，找零并入手续费
+	bump := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: account,
+		TxID:    txid,
+		To:      map[string]string{to.Address: "0.989"},
+	}
+	if err := wm.TxDecoder.(*TransactionDecoder).BumpFeeRawTransaction(sender, bump, "0.0465"); err != nil {
+		t.Fatalf("BumpFeeRawTransaction failed: %v", err)
+	}
+	if bump.Fees != "0.01100000" {
+		t.Fatalf("bump fees: %s, want: 0.011", bump.Fees)
+	}
+	newTxID := signAndSend(bump)
+
+	replaced, err := chain.GetTransaction(newTxID)
+	if err != nil || len(replaced.Vouts) != 1 || replaced.Vouts[0].Addr != to.Address {
+		t.Fatalf("replacement transaction: %+v, err: %v", replaced, err)
+	}
+	if _, err := chain.GetTransaction(txid); err == nil {
+		t.Fatalf("replaced transaction: %s should be evicted", txid)
+	}
+}
+
+func TestCreateRawTransaction_ExportPSBT(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+
+	sender := newSimWallet(t, "sender")
+	account := sender.account(t, 1, 1)
+	from := sender.newAddress(t, wm, account, 0)
+	if _, err := chain.Fund(from.Address, decimal.New(1, 0)); err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+
+	receiver := newSimWallet(t, "receiver")
+	to := receiver.newAddress(t, wm, receiver.account(t, 1, 1), 0)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: account,
+		To:      map[string]string{to.Address: "0.5"},
+	}
+	rawTx.SetExtParam("exportPSBT", true)
+	if err := wm.TxDecoder.CreateRawTransaction(sender, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed: %v", err)
+	}
+
+	//P2PKH输入只附带完整的前置交易单
+	psbt, err := btcLikeTxDriver.DecodePSBTFromBase64(rawTx.GetExtParam().Get("psbt").String())
+	if err != nil {
+		t.Fatalf("DecodePSBTFromBase64 failed: %v", err)
+	}
+	for i, in := range psbt.Inputs {
+		if in.WitnessUtxo != nil || len(in.NonWitnessUtxo) == 0 {
+			t.Fatalf("input %d of psbt should only have non witness utxo", i)
+		}
+	}
+	if _, err := psbt.GetHashesForSig(); err != nil {
+		t.Fatalf("GetHashesForSig failed: %v", err)
+	}
+}
+
+func TestCreateCPFPRawTransaction(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+
+	sender := newSimWallet(t, "sender")
+	senderAccount := sender.account(t, 1, 1)
+	from := sender.newAddress(t, wm, senderAccount, 0)
+	if _, err := chain.Fund(from.Address, decimal.New(1, 0)); err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+
+	receiver := newSimWallet(t, "receiver")
+	receiverAccount := receiver.account(t, 1, 1)
+	to := receiver.newAddress(t, wm, receiverAccount, 0)
+
+	//低费率的父交易单未确认
+	parentTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: senderAccount,
+		To:      map[string]string{to.Address: "0.5"},
+		FeeRate: "0.0004",
+	}
+	if err := wm.TxDecoder.CreateRawTransaction(sender, parentTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed: %v", err)
+	}
+	if err := wm.TxDecoder.SignRawTransaction(sender, parentTx); err != nil {
+		t.Fatalf("SignRawTransaction failed: %v", err)
+	}
+	parentTxID, err := chain.SendRawTransaction(parentTx.RawHex)
+	if err != nil {
+		t.Fatalf("SendRawTransaction failed: %v", err)
+	}
+	parentFees, _ := decimal.NewFromString(parentTx.Fees)
+	parentVSize, _ := btcLikeTxDriver.GetTransactionVSize(parentTx.RawHex)
+
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+	targetRate := decimal.RequireFromString("0.01")
+	child := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: receiverAccount,
+		TxID:    parentTxID,
+	}
+	if err := decoder.CreateCPFPRawTransaction(receiver, child, targetRate.String()); err != nil {
+		t.Fatalf("CreateCPFPRawTransaction failed: %v", err)
+	}
+	if err := wm.TxDecoder.SignRawTransaction(receiver, child); err != nil {
+		t.Fatalf("SignRawTransaction failed: %v", err)
+	}
+	if err := wm.TxDecoder.VerifyRawTransaction(receiver, child); err != nil {
+		t.Fatalf("VerifyRawTransaction failed: %v", err)
+	}
+	childTxID, err := chain.SendRawTransaction(child.RawHex)
+	if err != nil {
+		t.Fatalf("SendRawTransaction failed: %v", err)
+	}
+
+	//父子交易整体达到目标费率，签名按最大长度估算，最多略高于目标
+	childFees, _ := decimal.NewFromString(child.Fees)
+	childVSize, _ := btcLikeTxDriver.GetTransactionVSize(child.RawHex)
+	packageRate := parentFees.Add(childFees).Shift(3).Div(decimal.New(int64(parentVSize+childVSize), 0))
+	if packageRate.LessThan(targetRate) || packageRate.GreaterThan(targetRate.Mul(decimal.RequireFromString("1.02"))) {
+		t.Fatalf("package fee rate: %s, want: %s", packageRate, targetRate)
+	}
+
+	trx, err := chain.GetTransaction(childTxID)
+	if err != nil || len(trx.Vins) != 1 || trx.Vins[0].TxID != parentTxID || len(trx.Vouts) != 1 || trx.Vouts[0].Addr != to.Address {
+		t.Fatalf("child transaction: %+v, err: %v", trx, err)
+	}
+	if got, want := decimal.RequireFromString(trx.Vouts[0].Value), decimal.RequireFromString("0.5").Sub(childFees); !got.Equal(want) {
+		t.Fatalf("child output: %s, want: %s", got, want)
+	}
+}
+
+func TestCreateCPFPRawTransaction_DustOutput(t *testing.T) {
+
+	wm, chain := newSimWalletManager()
+
+	sender := newSimWallet(t, "sender")
+	senderAccount := sender.account(t, 1, 1)
+	from := sender.newAddress(t, wm, senderAccount, 0)
+	if _, err := chain.Fund(from.Address, decimal.New(1, 0)); err != nil {
+		t.Fatalf("Fund failed: %v", err)
+	}
+	chain.Mine(1)
+
+	receiver := newSimWallet(t, "receiver")
+	receiverAccount := receiver.account(t, 1, 1)
+	to := receiver.newAddress(t, wm, receiverAccount, 0)
+
+	parentTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: senderAccount,
+		To:      map[string]string{to.Address: "0.005"},
+		FeeRate: "0.0004",
+	}
+	if err := wm.TxDecoder.CreateRawTransaction(sender, parentTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed: %v", err)
+	}
+	if err := wm.TxDecoder.SignRawTransaction(sender, parentTx); err != nil {
+		t.Fatalf("SignRawTransaction failed: %v", err)
+	}
+	parentTxID, err := chain.SendRawTransaction(parentTx.RawHex)
+	if err != nil {
+		t.Fatalf("SendRawTransaction failed: %v", err)
+	}
+
+	//子交易支付手续费后的输出低于粉尘阈值
+	wm.Config.DustThreshold = decimal.RequireFromString("0.004")
+	child := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: receiverAccount,
+		TxID:    parentTxID,
+	}
+	err = wm.TxDecoder.(*TransactionDecoder).CreateCPFPRawTransaction(receiver, child, "0.004")
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Fatalf("dust child output should return ErrInsufficientFees, got: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/qtum-adapter/qtum/btcLikeTxDriver"
+	"github.com/shopspring/decimal"
+)
+
+//dummyChangeScript 还没有选择输入时，找零输出按P2PKH估算
+const dummyChangeScript = "76a914000000000000000000000000000000000000000088ac"
+
+//txSizeEstimator 按输入的锁定脚本和输出脚本估算签名后交易单的虚拟大小
+type txSizeEstimator struct {
+	decoder *TransactionDecoder
+	wrapper openwallet.WalletDAI
+	account *openwallet.AssetsAccount
+	outputs []string
+	unlocks map[*Unspent]btcLikeTxDriver.TxUnlock
+}
+
+//newTxSizeEstimator outputScripts为除找零外的输出锁定脚本
+func (decoder *TransactionDecoder) newTxSizeEstimator(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, outputScripts []string) *txSizeEstimator {
+	return &txSizeEstimator{
+		decoder: decoder,
+		wrapper: wrapper,
+		account: account,
+		outputs: outputScripts,
+		unlocks: make(map[*Unspent]btcLikeTxDriver.TxUnlock),
+	}
+}
+
+//VSize change为是否有找零输出，找零地址为第一个输入的地址
+func (e *txSizeEstimator) VSize(inputs []*Unspent, change bool) (uint64, error) {
+	unlockData := make([]btcLikeTxDriver.TxUnlock, 0, len(inputs))
+	for _, u := range inputs {
+		unlock, ok := e.unlocks[u]
+		if !ok {
+			var err error
+			unlock, err = e.decoder.unspentTxUnlock(e.wrapper, e.account, u)
+			if err != nil {
+				return 0, err
+			}
+			e.unlocks[u] = unlock
+		}
+		unlockData = append(unlockData, unlock)
+	}
+
+	outputs := e.outputs
+	if change {
+		changeScript := dummyChangeScript
+		if len(unlockData) > 0 {
+			changeScript = unlockData[0].LockScript
+		}
+		outputs = append(append(make([]string, 0, len(e.outputs)+1), e.outputs...), changeScript)
+	}
+
+	return btcLikeTxDriver.EstimateVSize(unlockData, outputs)
+}
+
+//Fees 按费率计算交易单的手续费
+func (e *txSizeEstimator) Fees(inputs []*Unspent, change bool, feeRate decimal.Decimal) (decimal.Decimal, error) {
+	vsize, err := e.VSize(inputs, change)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return e.decoder.wm.EstimateFeeByVSize(vsize, feeRate), nil
+}
+
+//unspentTxUnlock 构建utxo的解锁信息，多重签名账户需要赎回脚本
+func (decoder *TransactionDecoder) unspentTxUnlock(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, utxo *Unspent) (btcLikeTxDriver.TxUnlock, error) {
+	lockScript := utxo.ScriptPubKey
+	if len(lockScript) == 0 {
+		var err error
+		lockScript, err = btcLikeTxDriver.GetAddressLockScript(utxo.Address, decoder.addressPrefix())
+		if err != nil {
+			return btcLikeTxDriver.TxUnlock{}, err
+		}
+	}
+
+	//隔离见证输入的签名哈希需要UTXO金额
+	utxoAmount, _ := decimal.NewFromString(utxo.Amount)
+	txUnlock := btcLikeTxDriver.TxUnlock{
+		LockScript: lockScript,
+		Address:    utxo.Address,
+		Amount:     uint64(utxoAmount.Shift(decoder.wm.Decimal()).IntPart()),
+	}
+
+	//多重签名地址需要赎回脚本计算签名哈希
+	if isMultiSigAccount(account) {
+		addr, err := wrapper.GetAddress(utxo.Address)
+		if err != nil {
+			return btcLikeTxDriver.TxUnlock{}, err
+		}
+		txUnlock.RedeemScript, err = decoder.wm.MultiSigRedeemScript(account, addr)
+		if err != nil {
+			return btcLikeTxDriver.TxUnlock{}, err
+		}
+	}
+
+	return txUnlock, nil
+}
+
+//addressLockScripts 地址对应的锁定脚本
+func (decoder *TransactionDecoder) addressLockScripts(addresses ...string) ([]string, error) {
+	scripts := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		script, err := btcLikeTxDriver.GetAddressLockScript(address, decoder.addressPrefix())
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+//addressPrefix 当前网络的地址前缀
+func (decoder *TransactionDecoder) addressPrefix() btcLikeTxDriver.AddressPrefix {
+	return decoder.wm.Config.Network.AddressPrefix()
+}
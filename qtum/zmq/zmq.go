@@ -0,0 +1,510 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+//Package zmq 实现ZMTP 3.0协议的SUB和PUB套接字(NULL安全机制)，用于订阅核心钱包的zmqpubhashblock和zmqpubrawtx通知
+package zmq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+
+	//单帧的最大长度，避免异常数据占用过多内存
+	maxFrameSize = 64 << 20
+
+	greetingSize     = 64
+	handshakeTimeout = 10 * time.Second
+	writeTimeout     = 10 * time.Second
+)
+
+const (
+	SocketTypePUB = "PUB"
+	SocketTypeSUB = "SUB"
+)
+
+//Message 订阅收到的消息，核心钱包的通知为 主题|内容|序号 三帧
+type Message struct {
+	Topic    string
+	Body     []byte
+	Sequence uint32 //每个主题独立递增，小端4字节
+	HasSeq   bool
+}
+
+//frame ZMTP的帧
+type frame struct {
+	flags byte
+	body  []byte
+}
+
+func (f frame) isCommand() bool {
+	return f.flags&flagCommand != 0
+}
+
+func (f frame) hasMore() bool {
+	return f.flags&flagMore != 0
+}
+
+//parseEndpoint 只支持tcp://host:port
+func parseEndpoint(endpoint string) (string, error) {
+	if !strings.HasPrefix(endpoint, "tcp://") {
+		return "", fmt.Errorf("unsupported zmq endpoint: %s", endpoint)
+	}
+	return strings.TrimPrefix(endpoint, "tcp://"), nil
+}
+
+//greeting 握手问候，NULL机制
+func greeting(asServer bool) []byte {
+	g := make([]byte, greetingSize)
+	g[0] = 0xFF
+	g[9] = 0x7F
+	g[10] = 3 //major version
+	g[11] = 0 //minor version
+	copy(g[12:32], "NULL")
+	if asServer {
+		g[32] = 1
+	}
+	return g
+}
+
+func readGreeting(r io.Reader) error {
+	g := make([]byte, greetingSize)
+	if _, err := io.ReadFull(r, g); err != nil {
+		return err
+	}
+	if g[0] != 0xFF || g[9]&0x01 != 0x01 {
+		return fmt.Errorf("invalid zmtp greeting signature")
+	}
+	if g[10] < 3 {
+		return fmt.Errorf("unsupported zmtp version: %d.%d", g[10], g[11])
+	}
+	if mechanism := strings.TrimRight(string(g[12:32]), "\x00"); mechanism != "NULL" {
+		return fmt.Errorf("unsupported zmtp mechanism: %s", mechanism)
+	}
+	return nil
+}
+
+func writeFrame(w io.Writer, flags byte, body []byte) error {
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | flagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+	if _, err := w.Write(append(header, body...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var f frame
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return f, err
+	}
+	f.flags = header[0]
+
+	var size uint64
+	if f.flags&flagLong != 0 {
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return f, err
+		}
+		size = binary.BigEndian.Uint64(buf)
+	} else {
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return f, err
+		}
+		size = uint64(buf[0])
+	}
+	if size > maxFrameSize {
+		return f, fmt.Errorf("zmtp frame too large: %d", size)
+	}
+
+	f.body = make([]byte, size)
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+//command 命令帧的内容为 名称长度|名称|数据
+func command(name string, data []byte) []byte {
+	body := append([]byte{byte(len(name))}, name...)
+	return append(body, data...)
+}
+
+func parseCommand(body []byte) (string, []byte, error) {
+	if len(body) == 0 || int(body[0]) > len(body)-1 {
+		return "", nil, fmt.Errorf("invalid zmtp command")
+	}
+	n := int(body[0])
+	return string(body[1 : 1+n]), body[1+n:], nil
+}
+
+//readyCommand READY命令的属性为 名称长度(1字节)|名称|值长度(4字节)|值
+func readyCommand(socketType string) []byte {
+	name := "Socket-Type"
+	data := append([]byte{byte(len(name))}, name...)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(socketType)))
+	data = append(data, size...)
+	data = append(data, socketType...)
+	return command("READY", data)
+}
+
+func parseProperties(data []byte) (map[string]string, error) {
+	props := make(map[string]string)
+	for len(data) > 0 {
+		n := int(data[0])
+		if len(data) < 1+n+4 {
+			return nil, fmt.Errorf("invalid zmtp property")
+		}
+		name := string(data[1 : 1+n])
+		data = data[1+n:]
+		size := binary.BigEndian.Uint32(data[:4])
+		data = data[4:]
+		if uint64(len(data)) < uint64(size) {
+			return nil, fmt.Errorf("invalid zmtp property")
+		}
+		props[name] = string(data[:size])
+		data = data[size:]
+	}
+	return props, nil
+}
+
+//handshake 交换问候和READY命令，检查对方的套接字类型
+func handshake(conn net.Conn, asServer bool, socketType, peerType string) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(greeting(asServer)); err != nil {
+		return err
+	}
+	if err := readGreeting(conn); err != nil {
+		return err
+	}
+	if err := writeFrame(conn, flagCommand, readyCommand(socketType)); err != nil {
+		return err
+	}
+
+	f, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	if !f.isCommand() {
+		return fmt.Errorf("expected zmtp READY command")
+	}
+	name, data, err := parseCommand(f.body)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "READY":
+	case "ERROR":
+		if len(data) > 0 && int(data[0]) <= len(data)-1 {
+			data = data[1 : 1+int(data[0])]
+		}
+		return fmt.Errorf("zmtp handshake error: %s", data)
+	default:
+		return fmt.Errorf("expected zmtp READY command, got %s", name)
+	}
+	props, err := parseProperties(data)
+	if err != nil {
+		return err
+	}
+	if props["Socket-Type"] != peerType {
+		return fmt.Errorf("incompatible zmq socket type: %s", props["Socket-Type"])
+	}
+	return nil
+}
+
+//Subscriber SUB套接字，连接一个发布者
+type Subscriber struct {
+	conn net.Conn
+	wmu  sync.Mutex
+}
+
+//Dial 连接发布者并订阅主题，主题为前缀匹配，空字符串订阅全部
+func Dial(endpoint string, timeout time.Duration, topics ...string) (*Subscriber, error) {
+	addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := handshake(conn, false, SocketTypeSUB, SocketTypePUB); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &Subscriber{conn: conn}
+	for _, topic := range topics {
+		if err := s.Subscribe(topic); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+//Subscribe 订阅主题，ZMTP 3.0的订阅是首字节为1的消息
+func (s *Subscriber) Subscribe(topic string) error {
+	return s.write(0, append([]byte{1}, topic...))
+}
+
+//Unsubscribe 取消订阅
+func (s *Subscriber) Unsubscribe(topic string) error {
+	return s.write(0, append([]byte{0}, topic...))
+}
+
+func (s *Subscriber) write(flags byte, body []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return writeFrame(s.conn, flags, body)
+}
+
+//Recv 阻塞读取下一条消息，连接断开或关闭时返回错误
+func (s *Subscriber) Recv() (*Message, error) {
+	parts := make([][]byte, 0, 3)
+	for {
+		f, err := readFrame(s.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.isCommand() {
+			//ZMTP 3.1的心跳，回复PONG
+			name, data, err := parseCommand(f.body)
+			if err == nil && name == "PING" && len(data) >= 2 {
+				if err := s.write(flagCommand, command("PONG", data[2:])); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		parts = append(parts, f.body)
+		if f.hasMore() {
+			continue
+		}
+
+		msg := &Message{Topic: string(parts[0])}
+		if len(parts) > 1 {
+			msg.Body = parts[1]
+		}
+		if len(parts) > 2 && len(parts[2]) == 4 {
+			msg.Sequence = binary.LittleEndian.Uint32(parts[2])
+			msg.HasSeq = true
+		}
+		return msg, nil
+	}
+}
+
+//Close 关闭连接，阻塞中的Recv会返回错误
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
+
+//Publisher PUB套接字，监听连接并按订阅的主题前缀分发消息
+type Publisher struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	peers    map[*pubPeer]bool
+	sequence map[string]uint32
+	closed   bool
+}
+
+type pubPeer struct {
+	conn   net.Conn
+	wmu    sync.Mutex
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+//Listen 监听tcp地址，端口为0时随机分配
+func Listen(endpoint string) (*Publisher, error) {
+	addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Publisher{
+		ln:       ln,
+		peers:    make(map[*pubPeer]bool),
+		sequence: make(map[string]uint32),
+	}
+	go p.accept()
+	return p, nil
+}
+
+//Endpoint 实际监听的地址
+func (p *Publisher) Endpoint() string {
+	return "tcp://" + p.ln.Addr().String()
+}
+
+func (p *Publisher) accept() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(conn)
+	}
+}
+
+func (p *Publisher) serve(conn net.Conn) {
+	defer conn.Close()
+	if err := handshake(conn, true, SocketTypePUB, SocketTypeSUB); err != nil {
+		return
+	}
+
+	peer := &pubPeer{conn: conn, topics: make(map[string]bool)}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.peers[peer] = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.peers, peer)
+		p.mu.Unlock()
+	}()
+
+	//处理订阅，兼容ZMTP 3.1的SUBSCRIBE和CANCEL命令
+	for {
+		f, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		var (
+			subscribe bool
+			topic     string
+		)
+		if f.isCommand() {
+			name, data, err := parseCommand(f.body)
+			if err != nil || (name != "SUBSCRIBE" && name != "CANCEL") {
+				continue
+			}
+			subscribe, topic = name == "SUBSCRIBE", string(data)
+		} else {
+			if len(f.body) == 0 || f.body[0] > 1 {
+				continue
+			}
+			subscribe, topic = f.body[0] == 1, string(f.body[1:])
+		}
+
+		peer.mu.Lock()
+		if subscribe {
+			peer.topics[topic] = true
+		} else {
+			delete(peer.topics, topic)
+		}
+		peer.mu.Unlock()
+	}
+}
+
+func (peer *pubPeer) match(topic string) bool {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	for prefix := range peer.topics {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//Subscribed 会收到该主题的连接数量
+func (p *Publisher) Subscribed(topic string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for peer := range p.peers {
+		if peer.match(topic) {
+			count++
+		}
+	}
+	return count
+}
+
+//Publish 按核心钱包的格式发布 主题|内容|序号，写入失败的连接会被断开
+func (p *Publisher) Publish(topic string, body []byte) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return fmt.Errorf("zmq publisher has been closed")
+	}
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, p.sequence[topic])
+	p.sequence[topic]++
+	peers := make([]*pubPeer, 0, len(p.peers))
+	for peer := range p.peers {
+		if peer.match(topic) {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.wmu.Lock()
+		peer.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := writeFrame(peer.conn, flagMore, []byte(topic))
+		if err == nil {
+			err = writeFrame(peer.conn, flagMore, body)
+		}
+		if err == nil {
+			err = writeFrame(peer.conn, 0, seq)
+		}
+		peer.wmu.Unlock()
+		if err != nil {
+			peer.conn.Close()
+		}
+	}
+	return nil
+}
+
+//Close 停止监听并断开所有连接
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for peer := range p.peers {
+		peer.conn.Close()
+	}
+	p.mu.Unlock()
+	return p.ln.Close()
+}
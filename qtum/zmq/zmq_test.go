@@ -0,0 +1,141 @@
+package zmq
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func waitSubscribed(t *testing.T, p *Publisher, topic string, n int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for p.Subscribed(topic) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("topic %s subscribed = %d, want %d", topic, p.Subscribed(topic), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_publish_subscribe(t *testing.T) {
+	pub, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer pub.Close()
+
+	sub, err := Dial(pub.Endpoint(), time.Second, "hashblock", "rawtx")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	waitSubscribed(t, pub, "hashblock", 1)
+	waitSubscribed(t, pub, "rawtx", 1)
+
+	//未订阅的主题不会收到，长帧超过255字节
+	rawtx := bytes.Repeat([]byte{0xab}, 300)
+	pub.Publish("rawblock", []byte{0x01})
+	pub.Publish("rawtx", rawtx)
+	pub.Publish("hashblock", []byte{0x02})
+	pub.Publish("rawtx", []byte{0x03})
+
+	want := []Message{
+		{Topic: "rawtx", Body: rawtx, Sequence: 0, HasSeq: true},
+		{Topic: "hashblock", Body: []byte{0x02}, Sequence: 0, HasSeq: true},
+		{Topic: "rawtx", Body: []byte{0x03}, Sequence: 1, HasSeq: true},
+	}
+	for _, w := range want {
+		msg, err := sub.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if msg.Topic != w.Topic || !bytes.Equal(msg.Body, w.Body) || msg.Sequence != w.Sequence || !msg.HasSeq {
+			t.Errorf("message = %s %x %d, want %s %x %d", msg.Topic, msg.Body, msg.Sequence, w.Topic, w.Body, w.Sequence)
+		}
+	}
+
+	//取消订阅
+	sub.Unsubscribe("rawtx")
+	deadline := time.Now().Add(3 * time.Second)
+	for pub.Subscribed("rawtx") > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pub.Publish("rawtx", []byte{0x04})
+	pub.Publish("hashblock", []byte{0x05})
+	msg, err := sub.Recv()
+	if err != nil || msg.Topic != "hashblock" || msg.Sequence != 1 {
+		t.Errorf("message after unsubscribe = %+v, err: %v", msg, err)
+	}
+}
+
+func Test_subscriber_disconnected(t *testing.T) {
+	pub, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	sub, err := Dial(pub.Endpoint(), time.Second, "hashblock")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	waitSubscribed(t, pub, "hashblock", 1)
+
+	//发布者关闭后Recv返回错误
+	pub.Close()
+	if _, err := sub.Recv(); err == nil {
+		t.Errorf("Recv should fail after publisher closed")
+	}
+	if _, err := Dial(pub.Endpoint(), time.Second, "hashblock"); err == nil {
+		t.Errorf("Dial should fail after publisher closed")
+	}
+
+	//关闭订阅者时阻塞的Recv返回
+	pub2, _ := Listen("tcp://127.0.0.1:0")
+	defer pub2.Close()
+	sub2, err := Dial(pub2.Endpoint(), time.Second)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := sub2.Recv()
+		done <- err
+	}()
+	sub2.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Recv should fail after subscriber closed")
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Recv is still blocked after subscriber closed")
+	}
+}
+
+func Test_handshake_socket_type(t *testing.T) {
+	if _, err := Dial("ipc:///tmp/qtum.sock", time.Second); err == nil {
+		t.Errorf("ipc endpoint should not be supported")
+	}
+
+	props, err := parseProperties(readyCommand(SocketTypePUB)[len("READY")+1:])
+	if err != nil || props["Socket-Type"] != SocketTypePUB {
+		t.Errorf("READY properties = %v, err: %v", props, err)
+	}
+
+	//对方的套接字类型不匹配时握手失败
+	pub, err := Listen("tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer pub.Close()
+	addr, _ := parseEndpoint(pub.Endpoint())
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	if err := handshake(conn, false, SocketTypeSUB, SocketTypeSUB); err == nil {
+		t.Errorf("handshake with PUB should fail when expecting SUB")
+	}
+}
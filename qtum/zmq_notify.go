@@ -0,0 +1,218 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package qtum
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/blocktree/qtum-adapter/qtum/zmq"
+)
+
+const (
+	//核心钱包zmq通知的主题
+	ZMQTopicHashBlock = "hashblock"
+	ZMQTopicRawTx     = "rawtx"
+)
+
+const (
+	zmqDialTimeout   = 10 * time.Second
+	zmqReconnectWait = 5 * time.Second //断开后重连的等待时间
+)
+
+//zmqSubscriptions 需要订阅的地址和主题，相同地址共用一个连接
+func (bs *BTCBlockScanner) zmqSubscriptions() map[string][]string {
+	subs := make(map[string][]string)
+	if bs.wm.Config.RPCServerType != RPCServerCore {
+		return subs
+	}
+	if endpoint := bs.wm.Config.ZMQPubHashBlock; len(endpoint) > 0 {
+		subs[endpoint] = append(subs[endpoint], ZMQTopicHashBlock)
+	}
+	if endpoint := bs.wm.Config.ZMQPubRawTx; len(endpoint) > 0 && bs.IsScanMemPool {
+		subs[endpoint] = append(subs[endpoint], ZMQTopicRawTx)
+	}
+	return subs
+}
+
+//setupZMQ 启动zmq订阅，没有配置zmq地址时只使用定时轮询
+func (bs *BTCBlockScanner) setupZMQ() {
+	subs := bs.zmqSubscriptions()
+	if len(subs) == 0 || bs.zmqQuit != nil {
+		return
+	}
+
+	bs.wm.Log.Info("block scanner use zmq to listen new data")
+
+	quit := make(chan struct{})
+	newBlock := make(chan struct{}, 1)
+	bs.zmqQuit = quit
+	go bs.runBlockTask(newBlock, quit)
+	for endpoint, topics := range subs {
+		go bs.runZMQ(endpoint, topics, newBlock, quit)
+	}
+}
+
+//closeZMQ 停止zmq订阅
+func (bs *BTCBlockScanner) closeZMQ() {
+	if bs.zmqQuit != nil {
+		close(bs.zmqQuit)
+		bs.zmqQuit = nil
+	}
+}
+
+//runZMQ 订阅运行时，断开期间由定时任务轮询，等待后重新连接
+func (bs *BTCBlockScanner) runZMQ(endpoint string, topics []string, newBlock, quit chan struct{}) {
+	for {
+		sub, err := zmq.Dial(endpoint, zmqDialTimeout, topics...)
+		if err != nil {
+			bs.wm.Log.Errorf("Connect zmq %s failed unexpected error: %v", endpoint, err)
+		} else {
+			bs.wm.Log.Info("block scanner zmq connected:", endpoint, topics)
+
+			//停止时关闭连接，结束阻塞的读取
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-quit:
+					sub.Close()
+				case <-done:
+				}
+			}()
+			err = bs.receiveZMQ(sub, topics, newBlock)
+			close(done)
+			sub.Close()
+			bs.wm.Log.Info("block scanner zmq disconnected:", endpoint, err)
+		}
+
+		select {
+		case <-quit:
+			bs.wm.Log.Info("block scanner zmq has been stopped")
+			return
+		case <-time.After(zmqReconnectWait):
+		}
+	}
+}
+
+//receiveZMQ 处理推送的消息，订阅hashblock期间定时任务不再轮询区块
+func (bs *BTCBlockScanner) receiveZMQ(sub *zmq.Subscriber, topics []string, newBlock chan struct{}) error {
+
+	for _, topic := range topics {
+		if topic == ZMQTopicHashBlock {
+			atomic.AddInt32(&bs.blockNotify, 1)
+			defer atomic.AddInt32(&bs.blockNotify, -1)
+
+			//连接后扫描一次，补上轮询间隔内的新区块
+			notifyNewBlock(newBlock)
+		}
+	}
+
+	sequence := make(map[string]uint32)
+	for {
+		msg, err := sub.Recv()
+		if err != nil {
+			return err
+		}
+
+		//序号不连续说明有通知丢失，如超出节点的发送队列
+		last, ok := sequence[msg.Topic]
+		lost := ok && msg.HasSeq && msg.Sequence != last+1
+		if msg.HasSeq {
+			sequence[msg.Topic] = msg.Sequence
+		}
+
+		switch msg.Topic {
+		case ZMQTopicHashBlock:
+			//扫描任务会扫到最新高度，丢失的区块通知不需要处理
+			notifyNewBlock(newBlock)
+		case ZMQTopicRawTx:
+			if lost && bs.Scanning {
+				bs.wm.Log.Std.Info("block scanner zmq rawtx sequence %d -> %d, rescan mempool", last, msg.Sequence)
+				bs.scanTxMemPool()
+			}
+			bs.extractRawTx(msg.Body)
+		}
+	}
+}
+
+//notifyNewBlock 通知扫描新区块，扫描中收到的多个通知合并为一次
+func notifyNewBlock(newBlock chan struct{}) {
+	select {
+	case newBlock <- struct{}{}:
+	default:
+	}
+}
+
+//runBlockTask 收到新区块通知后执行扫描任务
+func (bs *BTCBlockScanner) runBlockTask(newBlock, quit chan struct{}) {
+	for {
+		select {
+		case <-quit:
+			return
+		case <-newBlock:
+			if bs.Scanning {
+				bs.scanBlockTask()
+			}
+		}
+	}
+}
+
+//extractRawTx 本地解码zmq推送的交易单并提取，不需要再通过RPC查询
+func (bs *BTCBlockScanner) extractRawTx(txBytes []byte) {
+
+	if !bs.Scanning {
+		return
+	}
+
+	addressPrefix := bs.wm.Config.Network.AddressPrefix()
+
+	trx, err := newTxByRaw(txBytes, addressPrefix)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not decode zmq raw transaction; unexpected error: %v", err)
+		return
+	}
+
+	//新区块的coinbase和coinstake也会推送，由区块扫描提取
+	if trx.IsCoinBase || trx.IsCoinstake {
+		return
+	}
+
+	bs.extractPendingRawTx(trx)
+}
+
+//scanTask 定时任务，推送新区块期间只扫描交易池，断开后恢复轮询区块
+func (bs *BTCBlockScanner) scanTask() {
+	if atomic.LoadInt32(&bs.blockNotify) > 0 {
+		if bs.IsScanMemPool {
+			bs.scanTxMemPool()
+		}
+		return
+	}
+	bs.scanBlockTask()
+}
+
+//scanBlockTask 定时任务和推送通知不能同时扫描
+func (bs *BTCBlockScanner) scanBlockTask() {
+	bs.scanMu.Lock()
+	defer bs.scanMu.Unlock()
+	bs.ScanBlockTask()
+}
+
+func (bs *BTCBlockScanner) scanTxMemPool() {
+	bs.scanMu.Lock()
+	defer bs.scanMu.Unlock()
+	bs.ScanTxMemPool()
+}